@@ -0,0 +1,86 @@
+package descriptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// descriptor checksum of BIP 380, a BCH code over groups of 5 bits that catches up to 4 errors in a descriptor
+// each character of the input charset maps to its position, whose low 5 bits are one symbol
+// and whose high bits of every 3 characters are merged into another symbol
+const (
+	INPUT_CHARSET    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	CHECKSUM_CHARSET = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	CHECKSUM_LENGTH  = 8
+)
+
+var checksumGenerator = [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+func polymod(chk uint64, value uint64) uint64 {
+	top := chk >> 35
+	chk = (chk&0x7ffffffff)<<5 ^ value
+	for i, gen := range checksumGenerator {
+		if (top>>i)&1 == 1 {
+			chk ^= gen
+		}
+	}
+
+	return chk
+}
+
+// checksum of a descriptor without its checksum
+func Checksum(desc string) (string, error) {
+	chk := uint64(1)
+	groups := make([]uint64, 0, 3)
+	for i, c := range desc {
+		pos := strings.IndexRune(INPUT_CHARSET, c)
+		if pos < 0 {
+			return "", fmt.Errorf("invalid character %q at %d", c, i)
+		}
+		chk = polymod(chk, uint64(pos&31))
+		groups = append(groups, uint64(pos>>5))
+		if len(groups) == 3 {
+			chk = polymod(chk, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+	switch len(groups) {
+	case 1:
+		chk = polymod(chk, groups[0])
+	case 2:
+		chk = polymod(chk, groups[0]*3+groups[1])
+	}
+	for i := 0; i < CHECKSUM_LENGTH; i++ {
+		chk = polymod(chk, 0)
+	}
+	chk ^= 1
+
+	checksum := make([]byte, CHECKSUM_LENGTH)
+	for i := range checksum {
+		checksum[i] = CHECKSUM_CHARSET[(chk>>(5*(CHECKSUM_LENGTH-1-i)))&31]
+	}
+
+	return string(checksum), nil
+}
+
+// split desc#checksum, and verify the checksum if there is one
+func splitChecksum(desc string) (string, error) {
+	pos := strings.LastIndexByte(desc, '#')
+	if pos < 0 {
+		return desc, nil
+	}
+
+	body, checksum := desc[:pos], desc[pos+1:]
+	if len(checksum) != CHECKSUM_LENGTH {
+		return "", fmt.Errorf("checksum %q is not %d characters", checksum, CHECKSUM_LENGTH)
+	}
+	expected, err := Checksum(body)
+	if err != nil {
+		return "", err
+	}
+	if checksum != expected {
+		return "", fmt.Errorf("checksum %q mismatches, expected %q", checksum, expected)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,568 @@
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+const (
+	// keys of CHECKMULTISIG
+	MAX_MULTI_KEYS = 20
+	// keys of a tapscript multi_a, so that the leaf stays standard
+	MAX_MULTI_A_KEYS       = 999
+	MAX_TAPROOT_DEPTH      = txscript.ControlBlockMaxNodeCount
+	MAX_REDEEM_SCRIPT_SIZE = txscript.MaxScriptElementSize
+)
+
+// an output descriptor, as of BIP 380 - 386 and 390
+//
+//	pk(KEY), pkh(KEY), wpkh(KEY)                   single key outputs
+//	sh(wpkh(KEY)), sh(wsh(SCRIPT)), sh(SCRIPT)     P2SH of an output or a script
+//	wsh(SCRIPT)                                    P2WSH of pk, pkh, multi or sortedmulti
+//	multi(K,KEY,...), sortedmulti(K,KEY,...)       K of the keys with CHECKMULTISIG
+//	tr(KEY), tr(KEY,TREE)                          taproot output of an internal key and a tree of leaves
+//	raw(HEX)                                       a script as is
+//
+// a TREE is a leaf or {TREE,TREE}, and a leaf is pk(KEY), multi_a(K,KEY,...) or sortedmulti_a(K,KEY,...)
+// a KEY is a hex key or an extended key with a derivation path, optionally ending with * for the range index, and
+// optionally prefixed by its origin [fingerprint/path]; in tr() it can also be an x-only key or musig(KEY,...)
+type Descriptor struct {
+	script *scriptExpr
+	params *chaincfg.Params
+}
+
+// scripts and address of a descriptor at a range index
+// the redeem and witness scripts are those of P2SH and P2WSH outputs, and taproot is set for tr()
+type Output struct {
+	PkScript      []byte
+	RedeemScript  []byte
+	WitnessScript []byte
+	// nil for scripts without an address, like pk(), multi() and raw()
+	Address btcutil.Address
+	Taproot *TaprootOutput
+}
+
+// leaves of a tr() output are in the order of the descriptor, with their control blocks
+type TaprootOutput struct {
+	InternalKey   *btcec.PublicKey
+	OutputKey     *btcec.PublicKey
+	MerkleRoot    []byte
+	Leaves        []txscript.TapLeaf
+	ControlBlocks [][]byte
+}
+
+// parse a descriptor with keys of params, its checksum is verified if it has one
+func Parse(desc string, params *chaincfg.Params) (*Descriptor, error) {
+	body, err := splitChecksum(desc)
+	if err != nil {
+		return nil, err
+	}
+	script, err := parseScript(body, ctxTop, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Descriptor{script: script, params: params}, nil
+}
+
+// descriptor with its checksum
+func (d *Descriptor) String() string {
+	body := d.script.String()
+	checksum, _ := Checksum(body)
+
+	return body + "#" + checksum
+}
+
+// whether the descriptor has a * wildcard, so that each range index derives a different output
+func (d *Descriptor) IsRange() bool {
+	return d.script.isRange()
+}
+
+// output at range index, which is ignored if the descriptor is not ranged
+func (d *Descriptor) Derive(index uint32) (*Output, error) {
+	return d.script.derive(index, d.params)
+}
+
+// a script expression, or a leaf of a tr() tree
+type scriptExpr struct {
+	fn   string
+	ctx  context
+	keys []keyExpr
+	// threshold of multi
+	k   int
+	sub *scriptExpr
+	// tree of tr(), nil without leaves
+	tree *treeExpr
+	raw  []byte
+}
+
+// a leaf, or a branch of two trees
+type treeExpr struct {
+	leaf        *scriptExpr
+	left, right *treeExpr
+}
+
+// name(arg,...) with arguments split at the top level commas
+func splitCall(s string) (string, []string, error) {
+	open := strings.IndexByte(s, '(')
+	if open <= 0 || !strings.HasSuffix(s, ")") {
+		return "", nil, fmt.Errorf("%q is not an expression", s)
+	}
+	args, err := splitArgs(s[open+1 : len(s)-1])
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s[:open], args, nil
+}
+
+// split at the commas that are not nested in brackets, and check that brackets are balanced
+func splitArgs(s string) ([]string, error) {
+	args := make([]string, 0)
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced %q at %d of %q", s[i], i, s)
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets in %q", s)
+	}
+
+	return append(args, s[start:]), nil
+}
+
+func parseScript(s string, ctx context, params *chaincfg.Params) (*scriptExpr, error) {
+	name, args, err := splitCall(s)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := map[string][]context{
+		"pk":            {ctxTop, ctxSh, ctxWsh, ctxTap},
+		"pkh":           {ctxTop, ctxSh, ctxWsh},
+		"wpkh":          {ctxTop, ctxSh},
+		"sh":            {ctxTop},
+		"wsh":           {ctxTop, ctxSh},
+		"multi":         {ctxTop, ctxSh, ctxWsh},
+		"sortedmulti":   {ctxTop, ctxSh, ctxWsh},
+		"multi_a":       {ctxTap},
+		"sortedmulti_a": {ctxTap},
+		"tr":            {ctxTop},
+		"raw":           {ctxTop},
+	}
+	contexts, ok := allowed[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown script expression %q", name)
+	}
+	in_context := false
+	for _, c := range contexts {
+		in_context = in_context || c == ctx
+	}
+	if !in_context {
+		return nil, fmt.Errorf("%s() is not allowed here", name)
+	}
+
+	e := &scriptExpr{fn: name, ctx: ctx}
+	switch name {
+	case "pk", "pkh", "wpkh":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes 1 key, got %d", name, len(args))
+		}
+		key_ctx := ctx
+		if name == "wpkh" {
+			key_ctx = ctxWsh
+		}
+		key, err := parseKey(args[0], key_ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		e.keys = []keyExpr{key}
+	case "sh", "wsh":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes 1 script, got %d", name, len(args))
+		}
+		sub_ctx := ctxSh
+		if name == "wsh" {
+			sub_ctx = ctxWsh
+		}
+		if e.sub, err = parseScript(args[0], sub_ctx, params); err != nil {
+			return nil, err
+		}
+	case "multi", "sortedmulti", "multi_a", "sortedmulti_a":
+		if err := e.parseMulti(args, params); err != nil {
+			return nil, err
+		}
+	case "tr":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("tr() takes a key and an optional tree, got %d arguments", len(args))
+		}
+		key, err := parseKey(args[0], ctxTap, params)
+		if err != nil {
+			return nil, err
+		}
+		e.keys = []keyExpr{key}
+		if len(args) == 2 {
+			if e.tree, err = parseTree(args[1], params, 0); err != nil {
+				return nil, err
+			}
+		}
+	case "raw":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("raw() takes 1 script, got %d", len(args))
+		}
+		if e.raw, err = hex.DecodeString(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid raw script %q: %w", args[0], err)
+		}
+	}
+
+	return e, nil
+}
+
+func (e *scriptExpr) parseMulti(args []string, params *chaincfg.Params) error {
+	if len(args) < 2 {
+		return fmt.Errorf("%s() takes a threshold and keys", e.fn)
+	}
+	max_keys := MAX_MULTI_KEYS
+	if e.ctx == ctxTap {
+		max_keys = MAX_MULTI_A_KEYS
+	}
+	if len(args)-1 > max_keys {
+		return fmt.Errorf("%s() takes at most %d keys, got %d", e.fn, max_keys, len(args)-1)
+	}
+
+	k, err := strconv.Atoi(args[0])
+	if err != nil || k < 1 || k > len(args)-1 {
+		return fmt.Errorf("threshold %q of %s() is out of range of %d keys", args[0], e.fn, len(args)-1)
+	}
+	e.k = k
+	e.keys = make([]keyExpr, len(args)-1)
+	for i, arg := range args[1:] {
+		if e.keys[i], err = parseKey(arg, e.ctx, params); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// {TREE,TREE} or a leaf
+func parseTree(s string, params *chaincfg.Params, depth int) (*treeExpr, error) {
+	if depth > MAX_TAPROOT_DEPTH {
+		return nil, fmt.Errorf("tree is deeper than %d", MAX_TAPROOT_DEPTH)
+	}
+	if !strings.HasPrefix(s, "{") {
+		leaf, err := parseScript(s, ctxTap, params)
+		if err != nil {
+			return nil, err
+		}
+		return &treeExpr{leaf: leaf}, nil
+	}
+
+	if !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("branch %q is not closed", s)
+	}
+	branches, err := splitArgs(s[1 : len(s)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(branches) != 2 {
+		return nil, fmt.Errorf("branch %q does not have 2 trees", s)
+	}
+	left, err := parseTree(branches[0], params, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseTree(branches[1], params, depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &treeExpr{left: left, right: right}, nil
+}
+
+func (e *scriptExpr) String() string {
+	args := make([]string, 0, len(e.keys)+2)
+	switch e.fn {
+	case "sh", "wsh":
+		args = append(args, e.sub.String())
+	case "multi", "sortedmulti", "multi_a", "sortedmulti_a":
+		args = append(args, strconv.Itoa(e.k))
+	case "raw":
+		args = append(args, hex.EncodeToString(e.raw))
+	}
+	for _, key := range e.keys {
+		args = append(args, key.String())
+	}
+	if e.tree != nil {
+		args = append(args, e.tree.String())
+	}
+
+	return fmt.Sprintf("%s(%s)", e.fn, strings.Join(args, ","))
+}
+
+func (t *treeExpr) String() string {
+	if t.leaf != nil {
+		return t.leaf.String()
+	}
+
+	return fmt.Sprintf("{%s,%s}", t.left, t.right)
+}
+
+func (e *scriptExpr) isRange() bool {
+	for _, key := range e.keys {
+		if key.isRange() {
+			return true
+		}
+	}
+	if e.sub != nil && e.sub.isRange() {
+		return true
+	}
+
+	return e.tree != nil && e.tree.isRange()
+}
+
+func (t *treeExpr) isRange() bool {
+	if t.leaf != nil {
+		return t.leaf.isRange()
+	}
+
+	return t.left.isRange() || t.right.isRange()
+}
+
+// keys at range index, serialized as x-only in tapscript
+func (e *scriptExpr) keyBytes(index uint32) ([][]byte, error) {
+	keys := make([][]byte, len(e.keys))
+	for i, key := range e.keys {
+		pub, err := key.derive(index)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case e.ctx == ctxTap:
+			keys[i] = schnorr.SerializePubKey(pub)
+		case key.compressed():
+			keys[i] = pub.SerializeCompressed()
+		default:
+			keys[i] = pub.SerializeUncompressed()
+		}
+	}
+	if strings.HasPrefix(e.fn, "sorted") {
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+	}
+
+	return keys, nil
+}
+
+// script of pk, pkh, multi and their tapscript forms, which are wrapped by sh(), wsh() and tr()
+func (e *scriptExpr) script(index uint32) ([]byte, error) {
+	keys, err := e.keyBytes(index)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := txscript.NewScriptBuilder()
+	switch e.fn {
+	case "pk":
+		builder.AddData(keys[0]).AddOp(txscript.OP_CHECKSIG)
+	case "pkh":
+		builder.AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(keys[0]))
+		builder.AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG)
+	case "multi", "sortedmulti":
+		builder.AddInt64(int64(e.k))
+		for _, key := range keys {
+			builder.AddData(key)
+		}
+		builder.AddInt64(int64(len(keys))).AddOp(txscript.OP_CHECKMULTISIG)
+	case "multi_a", "sortedmulti_a":
+		// <K_1> OP_CHECKSIG <K_2> OP_CHECKSIGADD ... <K_n> OP_CHECKSIGADD <k> OP_NUMEQUAL
+		builder.AddData(keys[0]).AddOp(txscript.OP_CHECKSIG)
+		for _, key := range keys[1:] {
+			builder.AddData(key).AddOp(txscript.OP_CHECKSIGADD)
+		}
+		builder.AddInt64(int64(e.k)).AddOp(txscript.OP_NUMEQUAL)
+	default:
+		return nil, fmt.Errorf("%s() is not a script", e.fn)
+	}
+
+	return builder.Script()
+}
+
+func (e *scriptExpr) derive(index uint32, params *chaincfg.Params) (*Output, error) {
+	var address btcutil.Address
+	switch e.fn {
+	case "pk", "multi", "sortedmulti":
+		script, err := e.script(index)
+		if err != nil {
+			return nil, err
+		}
+		return &Output{PkScript: script}, nil
+	case "raw":
+		return &Output{PkScript: e.raw}, nil
+	case "pkh", "wpkh":
+		keys, err := e.keyBytes(index)
+		if err != nil {
+			return nil, err
+		}
+		if e.fn == "pkh" {
+			address, err = btcutil.NewAddressPubKeyHash(btcutil.Hash160(keys[0]), params)
+		} else {
+			address, err = btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(keys[0]), params)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newOutput(address, nil, nil)
+	case "wsh":
+		witness_script, err := e.sub.script(index)
+		if err != nil {
+			return nil, err
+		}
+		script_hash := sha256.Sum256(witness_script)
+		if address, err = btcutil.NewAddressWitnessScriptHash(script_hash[:], params); err != nil {
+			return nil, err
+		}
+		return newOutput(address, nil, witness_script)
+	case "sh":
+		// the redeem script is the script of the inner output, or the pk script of a nested segwit output
+		inner, err := e.sub.derive(index, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(inner.PkScript) > MAX_REDEEM_SCRIPT_SIZE {
+			return nil, fmt.Errorf("redeem script of %d bytes is larger than %d", len(inner.PkScript), MAX_REDEEM_SCRIPT_SIZE)
+		}
+		if address, err = btcutil.NewAddressScriptHash(inner.PkScript, params); err != nil {
+			return nil, err
+		}
+		return newOutput(address, inner.PkScript, inner.WitnessScript)
+	case "tr":
+		return e.deriveTaproot(index, params)
+	}
+
+	return nil, fmt.Errorf("%s() is not an output", e.fn)
+}
+
+func newOutput(address btcutil.Address, redeem_script, witness_script []byte) (*Output, error) {
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Output{PkScript: pkScript, RedeemScript: redeem_script, WitnessScript: witness_script, Address: address}, nil
+}
+
+// Q = P + H(P || root) * G, where the internal key P is taken as its x-only key
+func (e *scriptExpr) deriveTaproot(index uint32, params *chaincfg.Params) (*Output, error) {
+	pub, err := e.keys[0].derive(index)
+	if err != nil {
+		return nil, err
+	}
+	internal_key, err := schnorr.ParsePubKey(schnorr.SerializePubKey(pub))
+	if err != nil {
+		return nil, err
+	}
+
+	taproot := &TaprootOutput{InternalKey: internal_key}
+	var proofs [][]byte
+	if e.tree == nil {
+		taproot.OutputKey = txscript.ComputeTaprootKeyNoScript(internal_key)
+	} else {
+		var root chainhash.Hash
+		root, taproot.Leaves, proofs, err = e.tree.hash(index)
+		if err != nil {
+			return nil, err
+		}
+		taproot.MerkleRoot = root[:]
+		taproot.OutputKey = txscript.ComputeTaprootOutputKey(internal_key, root[:])
+	}
+
+	// y parity of the output key is the first byte of its compressed form
+	odd := taproot.OutputKey.SerializeCompressed()[0] == 0x03
+	for _, proof := range proofs {
+		ctrl_block := txscript.ControlBlock{
+			InternalKey:     internal_key,
+			OutputKeyYIsOdd: odd,
+			LeafVersion:     txscript.BaseLeafVersion,
+			InclusionProof:  proof,
+		}
+		ctrl_block_bytes, err := ctrl_block.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		taproot.ControlBlocks = append(taproot.ControlBlocks, ctrl_block_bytes)
+	}
+
+	address, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(taproot.OutputKey), params)
+	if err != nil {
+		return nil, err
+	}
+	output, err := newOutput(address, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	output.Taproot = taproot
+
+	return output, nil
+}
+
+// hash of the tree, with its leaves in order and their inclusion proofs, each from the leaf sibling up to the root
+func (t *treeExpr) hash(index uint32) (chainhash.Hash, []txscript.TapLeaf, [][]byte, error) {
+	if t.leaf != nil {
+		script, err := t.leaf.script(index)
+		if err != nil {
+			return chainhash.Hash{}, nil, nil, err
+		}
+		leaf := txscript.NewBaseTapLeaf(script)
+		return leaf.TapHash(), []txscript.TapLeaf{leaf}, [][]byte{{}}, nil
+	}
+
+	left_hash, left_leaves, left_proofs, err := t.left.hash(index)
+	if err != nil {
+		return chainhash.Hash{}, nil, nil, err
+	}
+	right_hash, right_leaves, right_proofs, err := t.right.hash(index)
+	if err != nil {
+		return chainhash.Hash{}, nil, nil, err
+	}
+	for i := range left_proofs {
+		left_proofs[i] = append(left_proofs[i], right_hash[:]...)
+	}
+	for i := range right_proofs {
+		right_proofs[i] = append(right_proofs[i], left_hash[:]...)
+	}
+
+	// branch hash commits to its children in lexicographic order
+	first, second := left_hash, right_hash
+	if bytes.Compare(first[:], second[:]) > 0 {
+		first, second = second, first
+	}
+	branch_hash := chainhash.TaggedHash(chainhash.TagTapBranch, first[:], second[:])
+
+	return *branch_hash, append(left_leaves, right_leaves...), append(left_proofs, right_proofs...), nil
+}
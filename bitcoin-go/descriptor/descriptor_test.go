@@ -0,0 +1,604 @@
+package descriptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/taproot"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ALICE_WALLET_SEED   = "4b92958dbc301dce528bb8aff445d00445c828220c287ec7d19599e3c256ce0e"
+	BOB_WALLET_SEED     = "b8c646523dd3cbb5fecf3906604aa36bd0d556c7d81e8d138e56e62809a708c2"
+	OLIVIA_WALLET_SEED  = "f9fdc67f82e763423c10448b33ec755c348cce8b58bebb19fdd25af5c9b49952"
+	OMNIMAN_WALLET_SEED = "e7712cf15c5ae7e24ae85920abdd0fa11251096bfbe8bad2bfb0aacdd34f2c8a"
+)
+
+type testKeys struct {
+	pubs  map[string]*btcec.PublicKey
+	privs map[string]*btcec.PrivateKey
+	// hex compressed keys, to be put in descriptors
+	hexs map[string]string
+}
+
+func newTestKeys(s *testhelper.TestSuite) *testKeys {
+	k := &testKeys{
+		pubs:  make(map[string]*btcec.PublicKey),
+		privs: make(map[string]*btcec.PrivateKey),
+		hexs:  make(map[string]string),
+	}
+	for name, seed := range map[string]string{"A": ALICE_WALLET_SEED, "B": BOB_WALLET_SEED, "C": OLIVIA_WALLET_SEED, "K": OMNIMAN_WALLET_SEED} {
+		_, pair := s.NewHDKeyPairFromSeed(seed)
+		k.pubs[name] = pair.Pub
+		k.privs[name] = pair.GetTestPriv()
+		k.hexs[name] = hex.EncodeToString(pair.Pub.SerializeCompressed())
+	}
+
+	return k
+}
+
+// go test -v -run ^TestChecksum$ github.com/nghuyenthevinh2000/bitcoin-playground/descriptor
+func TestChecksum(t *testing.T) {
+	// test vector of BIP 380
+	checksum, err := Checksum("raw(deadbeef)")
+	assert.NoError(t, err)
+	assert.Equal(t, "89f8spxm", checksum)
+
+	desc, err := Parse("raw(deadbeef)#89f8spxm", &chaincfg.MainNetParams)
+	assert.NoError(t, err)
+	assert.Equal(t, "raw(deadbeef)#89f8spxm", desc.String())
+
+	// a descriptor without checksum gets one
+	desc, err = Parse("raw(deadbeef)", &chaincfg.MainNetParams)
+	assert.NoError(t, err)
+	assert.Equal(t, "raw(deadbeef)#89f8spxm", desc.String())
+
+	for _, invalid := range []string{
+		"raw(deadbeef)#89f8spxn",
+		"raw(deadbeef)#89f8spx",
+		"raw(deadbeef)#",
+		"raw(deadbeef)#89f8spxmx",
+		"raw(deedbeef)#89f8spxm",
+	} {
+		_, err := Parse(invalid, &chaincfg.MainNetParams)
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = Checksum("raw(deadbeef)\n")
+	assert.Error(t, err)
+}
+
+// go test -v -run ^TestSingleKeyDescriptors$ github.com/nghuyenthevinh2000/bitcoin-playground/descriptor
+func TestSingleKeyDescriptors(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+	k := newTestKeys(&s)
+
+	derive := func(desc string) *Output {
+		d, err := Parse(desc, s.BtcdChainConfig)
+		assert.NoError(t, err, desc)
+		assert.False(t, d.IsRange())
+		output, err := d.Derive(0)
+		assert.NoError(t, err, desc)
+		return output
+	}
+	pub_hash := btcutil.Hash160(k.pubs["A"].SerializeCompressed())
+
+	// wpkh matches the witness pubkey hash address of the same key
+	wif, err := btcutil.NewWIF(k.privs["A"], s.BtcdChainConfig, true)
+	assert.NoError(t, err)
+	output := derive(fmt.Sprintf("wpkh(%s)", k.hexs["A"]))
+	assert.Equal(t, s.DeriveWitnessPubkeyHash(wif), output.Address.EncodeAddress())
+
+	// pkh
+	output = derive(fmt.Sprintf("pkh(%s)", k.hexs["A"]))
+	address, err := btcutil.NewAddressPubKeyHash(pub_hash, s.BtcdChainConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, address.EncodeAddress(), output.Address.EncodeAddress())
+
+	// sh(wpkh) redeems with the wpkh pk script
+	output = derive(fmt.Sprintf("sh(wpkh(%s))", k.hexs["A"]))
+	redeem_script := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, pub_hash...)
+	assert.Equal(t, redeem_script, output.RedeemScript)
+	sh_address, err := btcutil.NewAddressScriptHash(redeem_script, s.BtcdChainConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, sh_address.EncodeAddress(), output.Address.EncodeAddress())
+
+	// bare pk has no address
+	output = derive(fmt.Sprintf("pk(%s)", k.hexs["A"]))
+	assert.Nil(t, output.Address)
+	assert.Equal(t, append(append([]byte{txscript.OP_DATA_33}, k.pubs["A"].SerializeCompressed()...), txscript.OP_CHECKSIG), output.PkScript)
+
+	// tr(K) is the key path only output of the taproot package, with a compressed or x-only key
+	tree, err := taproot.NewTree(k.pubs["K"])
+	assert.NoError(t, err)
+	tree_address, err := tree.Address(s.BtcdChainConfig)
+	assert.NoError(t, err)
+	output = derive(fmt.Sprintf("tr(%s)", k.hexs["K"]))
+	assert.Equal(t, tree_address.EncodeAddress(), output.Address.EncodeAddress())
+	assert.Nil(t, output.Taproot.MerkleRoot)
+	output = derive(fmt.Sprintf("tr(%x)", schnorr.SerializePubKey(k.pubs["K"])))
+	assert.Equal(t, tree_address.EncodeAddress(), output.Address.EncodeAddress())
+
+	// the tweaked output key, not the internal key
+	assert.NotEqual(t, s.ConvertPubKeyToTrAddress(k.pubs["K"]), output.Address.EncodeAddress())
+	assert.Equal(t, s.ConvertPubKeyToTrAddress(output.Taproot.OutputKey), output.Address.EncodeAddress())
+}
+
+// go test -v -run ^TestRangedDescriptors$ github.com/nghuyenthevinh2000/bitcoin-playground/descriptor
+func TestRangedDescriptors(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+
+	seed, err := hex.DecodeString(ALICE_WALLET_SEED)
+	assert.NoError(t, err)
+	master, err := hdkeychain.NewMaster(seed, s.BtcdChainConfig)
+	assert.NoError(t, err)
+	master_pub, err := master.ECPubKey()
+	assert.NoError(t, err)
+	fingerprint := btcutil.Hash160(master_pub.SerializeCompressed())[:4]
+
+	// account key m/84'/1'/0', its xpub derives external addresses m/84'/1'/0'/0/i
+	account := master
+	for _, child := range []uint32{84, 1, 0} {
+		account, err = account.Derive(child + hdkeychain.HardenedKeyStart)
+		assert.NoError(t, err)
+	}
+	xpub, err := account.Neuter()
+	assert.NoError(t, err)
+
+	desc_str := fmt.Sprintf("wpkh([%x/84h/1h/0h]%s/0/*)", fingerprint, xpub)
+	desc, err := Parse(desc_str, s.BtcdChainConfig)
+	assert.NoError(t, err)
+	assert.True(t, desc.IsRange())
+	checksum, err := Checksum(desc_str)
+	assert.NoError(t, err)
+	assert.Equal(t, desc_str+"#"+checksum, desc.String())
+
+	// the same descriptor from the master private key, through hardened steps
+	priv_desc, err := Parse(fmt.Sprintf("wpkh(%s/84'/1'/0'/0/*)", master), s.BtcdChainConfig)
+	assert.NoError(t, err)
+
+	for i := uint32(0); i < 3; i++ {
+		external, err := account.Derive(0)
+		assert.NoError(t, err)
+		child, err := external.Derive(i)
+		assert.NoError(t, err)
+		child_pub, err := child.ECPubKey()
+		assert.NoError(t, err)
+		address, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(child_pub.SerializeCompressed()), s.BtcdChainConfig)
+		assert.NoError(t, err)
+
+		output, err := desc.Derive(i)
+		assert.NoError(t, err)
+		assert.Equal(t, address.EncodeAddress(), output.Address.EncodeAddress())
+		priv_output, err := priv_desc.Derive(i)
+		assert.NoError(t, err)
+		assert.Equal(t, output.PkScript, priv_output.PkScript)
+	}
+
+	// each index is a different output
+	first, err := desc.Derive(0)
+	assert.NoError(t, err)
+	second, err := desc.Derive(1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.PkScript, second.PkScript)
+	_, err = desc.Derive(hdkeychain.HardenedKeyStart)
+	assert.Error(t, err)
+
+	for _, invalid := range []string{
+		// hardened derivation from an xpub
+		fmt.Sprintf("wpkh(%s/0h/*)", xpub),
+		fmt.Sprintf("wpkh(%s/*h)", xpub),
+		// wildcard not at the end
+		fmt.Sprintf("wpkh(%s/*/0)", xpub),
+		// invalid origin
+		fmt.Sprintf("wpkh([%x]%s/*)", fingerprint[:3], xpub),
+		fmt.Sprintf("wpkh([%x/84h%s/*)", fingerprint, xpub),
+	} {
+		_, err := Parse(invalid, s.BtcdChainConfig)
+		assert.Error(t, err, invalid)
+	}
+
+	// an xpub of another network
+	mainnet_master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	assert.NoError(t, err)
+	mainnet_xpub, err := mainnet_master.Neuter()
+	assert.NoError(t, err)
+	_, err = Parse(fmt.Sprintf("wpkh(%s/0/*)", mainnet_xpub), s.BtcdChainConfig)
+	assert.Error(t, err)
+}
+
+// go test -v -run ^TestWshMulti$ github.com/nghuyenthevinh2000/bitcoin-playground/descriptor
+func TestWshMulti(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+	k := newTestKeys(&s)
+
+	desc, err := Parse(fmt.Sprintf("wsh(sortedmulti(2,%s,%s,%s))", k.hexs["A"], k.hexs["B"], k.hexs["C"]), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	output, err := desc.Derive(0)
+	assert.NoError(t, err)
+
+	// sortedmulti is multi of the keys in lexicographic order
+	names := []string{"A", "B", "C"}
+	sort.Slice(names, func(i, j int) bool {
+		return k.hexs[names[i]] < k.hexs[names[j]]
+	})
+	multi_desc, err := Parse(fmt.Sprintf("wsh(multi(2,%s,%s,%s))", k.hexs[names[0]], k.hexs[names[1]], k.hexs[names[2]]), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	multi_output, err := multi_desc.Derive(0)
+	assert.NoError(t, err)
+	assert.Equal(t, multi_output.WitnessScript, output.WitnessScript)
+	assert.Equal(t, multi_output.Address.EncodeAddress(), output.Address.EncodeAddress())
+
+	// CHECKMULTISIG takes the signatures in the order of the keys, above a dummy element
+	s.ValidateScript(output.PkScript, 1, func(t assert.TestingT, prevOut *wire.TxOut, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int) wire.TxWitness {
+		witness := wire.TxWitness{{}}
+		for _, name := range names[:2] {
+			sig, err := txscript.RawTxInWitnessSignature(tx, sigHashes, idx, prevOut.Value, output.WitnessScript, txscript.SigHashAll, k.privs[name])
+			assert.NoError(t, err)
+			witness = append(witness, sig)
+		}
+		return append(witness, output.WitnessScript)
+	})
+
+	// nested in P2SH, the redeem script is the P2WSH pk script
+	nested, err := Parse(fmt.Sprintf("sh(wsh(sortedmulti(2,%s,%s,%s)))", k.hexs["A"], k.hexs["B"], k.hexs["C"]), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	nested_output, err := nested.Derive(0)
+	assert.NoError(t, err)
+	assert.Equal(t, output.PkScript, nested_output.RedeemScript)
+	assert.Equal(t, output.WitnessScript, nested_output.WitnessScript)
+
+	for _, invalid := range []string{
+		fmt.Sprintf("wsh(multi(3,%s,%s))", k.hexs["A"], k.hexs["B"]),
+		fmt.Sprintf("wsh(multi(0,%s))", k.hexs["A"]),
+		fmt.Sprintf("wsh(multi_a(1,%s))", k.hexs["A"]),
+		fmt.Sprintf("wsh(wpkh(%s))", k.hexs["A"]),
+		fmt.Sprintf("wsh(wsh(pk(%s)))", k.hexs["A"]),
+		fmt.Sprintf("sh(sh(pk(%s)))", k.hexs["A"]),
+		fmt.Sprintf("wsh(pk(%x))", k.pubs["A"].SerializeUncompressed()),
+		fmt.Sprintf("wpkh(%x)", schnorr.SerializePubKey(k.pubs["A"])),
+		fmt.Sprintf("wsh(pk(%s)", k.hexs["A"]),
+		fmt.Sprintf("wsh(pk(%s))pk(%s)", k.hexs["A"], k.hexs["B"]),
+	} {
+		_, err := Parse(invalid, s.BtcdChainConfig)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// go test -v -run ^TestTrTree$ github.com/nghuyenthevinh2000/bitcoin-playground/descriptor
+func TestTrTree(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+	k := newTestKeys(&s)
+
+	// a tree of 2 leaves is the same output as the taproot package
+	desc, err := Parse(fmt.Sprintf("tr(%s,{pk(%s),pk(%s)})", k.hexs["K"], k.hexs["A"], k.hexs["B"]), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	output, err := desc.Derive(0)
+	assert.NoError(t, err)
+	tree, err := taproot.NewTree(k.pubs["K"], &taproot.SingleKey{Key: k.pubs["A"]}, &taproot.SingleKey{Key: k.pubs["B"]})
+	assert.NoError(t, err)
+	pkScript, err := tree.PkScript()
+	assert.NoError(t, err)
+	assert.Equal(t, pkScript, output.PkScript)
+	assert.Equal(t, tree.MerkleRoot(), output.Taproot.MerkleRoot)
+	for i := range output.Taproot.Leaves {
+		ctrl_block, err := tree.ControlBlock(i)
+		assert.NoError(t, err)
+		assert.Equal(t, ctrl_block, output.Taproot.ControlBlocks[i])
+	}
+
+	// an unbalanced tree, each leaf is spent with its control block
+	desc, err = Parse(fmt.Sprintf("tr(%s,{pk(%s),{pk(%s),multi_a(2,%s,%s,%s)}})", k.hexs["K"], k.hexs["A"], k.hexs["B"], k.hexs["A"], k.hexs["B"], k.hexs["C"]), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	output, err = desc.Derive(0)
+	assert.NoError(t, err)
+	assert.Len(t, output.Taproot.Leaves, 3)
+	// the first leaf is next to a branch, the others are one level deeper
+	assert.Len(t, output.Taproot.ControlBlocks[0], 33+32)
+	assert.Len(t, output.Taproot.ControlBlocks[1], 33+2*32)
+	assert.Len(t, output.Taproot.ControlBlocks[2], 33+2*32)
+
+	// signers of each leaf, the first key of multi_a is checked first, so its signature is at the top of the stack
+	leaf_signers := [][]string{{"A"}, {"B"}, {"A", "B", ""}}
+	for i, signers := range leaf_signers {
+		leaf := output.Taproot.Leaves[i]
+		s.ValidateScript(output.PkScript, 1, func(t assert.TestingT, prevOut *wire.TxOut, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int) wire.TxWitness {
+			witness := wire.TxWitness{}
+			for j := len(signers) - 1; j >= 0; j-- {
+				if signers[j] == "" {
+					witness = append(witness, []byte{})
+					continue
+				}
+				sig, err := txscript.RawTxInTapscriptSignature(tx, sigHashes, idx, prevOut.Value, prevOut.PkScript, leaf, txscript.SigHashDefault, k.privs[signers[j]])
+				assert.NoError(t, err)
+				witness = append(witness, sig)
+			}
+			return append(witness, leaf.Script, output.Taproot.ControlBlocks[i])
+		})
+	}
+
+	// sortedmulti_a takes x-only keys in lexicographic order
+	sorted, err := Parse(fmt.Sprintf("tr(%s,sortedmulti_a(1,%s,%s))", k.hexs["K"], k.hexs["A"], k.hexs["B"]), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	sorted_output, err := sorted.Derive(0)
+	assert.NoError(t, err)
+	x_a, x_b := schnorr.SerializePubKey(k.pubs["A"]), schnorr.SerializePubKey(k.pubs["B"])
+	if bytes.Compare(x_a, x_b) > 0 {
+		x_a, x_b = x_b, x_a
+	}
+	assert.Equal(t, x_a, sorted_output.Taproot.Leaves[0].Script[1:33])
+	assert.Equal(t, x_b, sorted_output.Taproot.Leaves[0].Script[35:67])
+
+	for _, invalid := range []string{
+		fmt.Sprintf("tr(%s,{pk(%s)})", k.hexs["K"], k.hexs["A"]),
+		fmt.Sprintf("tr(%s,{pk(%s),pk(%s),pk(%s)})", k.hexs["K"], k.hexs["A"], k.hexs["B"], k.hexs["C"]),
+		fmt.Sprintf("tr(%s,multi(1,%s))", k.hexs["K"], k.hexs["A"]),
+		fmt.Sprintf("tr(%s,pkh(%s))", k.hexs["K"], k.hexs["A"]),
+		fmt.Sprintf("tr(%s,{pk(%s),pk(%s)}", k.hexs["K"], k.hexs["A"], k.hexs["B"]),
+		fmt.Sprintf("sh(tr(%s))", k.hexs["K"]),
+		fmt.Sprintf("wsh(tr(%s))", k.hexs["K"]),
+	} {
+		_, err := Parse(invalid, s.BtcdChainConfig)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// go test -v -run ^TestMusigDescriptor$ github.com/nghuyenthevinh2000/bitcoin-playground/descriptor
+func TestMusigDescriptor(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+	k := newTestKeys(&s)
+
+	// participants are sorted before aggregation, so their order does not matter
+	aggr_key, _, _, err := musig2.AggregateKeys([]*btcec.PublicKey{k.pubs["A"], k.pubs["B"], k.pubs["C"]}, true)
+	assert.NoError(t, err)
+	expected := txscript.ComputeTaprootKeyNoScript(aggr_key.FinalKey)
+	for _, order := range [][]string{{"A", "B", "C"}, {"C", "A", "B"}} {
+		desc, err := Parse(fmt.Sprintf("tr(musig(%s,%s,%s))", k.hexs[order[0]], k.hexs[order[1]], k.hexs[order[2]]), s.BtcdChainConfig)
+		assert.NoError(t, err)
+		output, err := desc.Derive(0)
+		assert.NoError(t, err)
+		assert.Equal(t, schnorr.SerializePubKey(expected), schnorr.SerializePubKey(output.Taproot.OutputKey))
+	}
+
+	// a musig leaf checks a signature of the aggregated key of a subset
+	desc, err := Parse(fmt.Sprintf("tr(%s,pk(musig(%s,%s)))", k.hexs["K"], k.hexs["A"], k.hexs["B"]), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	output, err := desc.Derive(0)
+	assert.NoError(t, err)
+	subset_key, _, _, err := musig2.AggregateKeys([]*btcec.PublicKey{k.pubs["A"], k.pubs["B"]}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, schnorr.SerializePubKey(subset_key.FinalKey), output.Taproot.Leaves[0].Script[1:33])
+
+	// ranged participants derive a different aggregated key at each index
+	seed, err := hex.DecodeString(BOB_WALLET_SEED)
+	assert.NoError(t, err)
+	master, err := hdkeychain.NewMaster(seed, s.BtcdChainConfig)
+	assert.NoError(t, err)
+	xpub, err := master.Neuter()
+	assert.NoError(t, err)
+	ranged, err := Parse(fmt.Sprintf("tr(musig(%s,%s/0/*))", k.hexs["A"], xpub), s.BtcdChainConfig)
+	assert.NoError(t, err)
+	assert.True(t, ranged.IsRange())
+	first, err := ranged.Derive(0)
+	assert.NoError(t, err)
+	second, err := ranged.Derive(1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.PkScript, second.PkScript)
+
+	for _, invalid := range []string{
+		fmt.Sprintf("wsh(pk(musig(%s,%s)))", k.hexs["A"], k.hexs["B"]),
+		fmt.Sprintf("tr(musig(%s,%x))", k.hexs["A"], schnorr.SerializePubKey(k.pubs["B"])),
+		fmt.Sprintf("tr(musig(%s,musig(%s,%s)))", k.hexs["A"], k.hexs["B"], k.hexs["C"]),
+		fmt.Sprintf("tr(musig(%s,%s)/0/*)", k.hexs["A"], xpub),
+	} {
+		_, err := Parse(invalid, s.BtcdChainConfig)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// go test -v -run ^TestBipVectors$ github.com/nghuyenthevinh2000/bitcoin-playground/descriptor
+func TestBipVectors(t *testing.T) {
+	// keys of the test vectors, WIF private keys are written as their public keys
+	const (
+		// L4rK1yDtCWekvXuE6oXD9jCYfFNV2cWRpVuPLBcCU2z8TrisoyY1
+		KEY = "03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd"
+		// 5KYZdUEo39z3FPrtuX2QbbwGnNP5zTd7yyr2SC1j299sBCnWjss
+		UNCOMPRESSED_KEY = "04a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd5b8dec5235a0fa8722476c7709c02559e3aa73aa03918ba2d492eea75abea235"
+		XPRV_1           = "xprv9s21ZrQH143K31xYSDQpPDxsXRTUcvj2iNHm5NUtrGiGG5e2DtALGdso3pGz6ssrdK4PFmM8NSpSBHNqPqm55Qn3LqFtT2emdEXVYsCzC2U"
+		XPRV_2           = "xprv9vHkqa6EV4sPZHYqZznhT2NPtPCjKuDKGY38FBWLvgaDx45zo9WQRUT3dKYnjwih2yJD9mkrocEZXo1ex8G81dwSM1fwqWpWkeS3v86pgKt"
+		XPRV_3           = "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"
+		XPRV_A           = "xprvA1RpRA33e1JQ7ifknakTFpgNXPmW2YvmhqLQYMmrj4xJXXWYpDPS3xz7iAxn8L39njGVyuoseXzU6rcxFLJ8HFsTjSyQbLYnMpCqE2VbFWc"
+		XPRV_B           = "xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L"
+		// root key of the BIP 39 mnemonic "abandon abandon ... about"
+		XPRV_BIP86 = "xprv9s21ZrQH143K3GJpoapnV8SFfukcVBSfeCficPSGfubmSFDxo1kuHnLisriDvSnRRuL2Qrg5ggqHKNVpxR86QEC8w35uxmGoggxtQTPvfUu"
+	)
+
+	tests := []struct {
+		bip  int
+		desc string
+		// pk scripts at range index 0, 1, 2 of a ranged descriptor
+		pkScripts []string
+		// addresses of the pk scripts, if the BIP publishes them
+		addresses []string
+	}{
+		{
+			bip:       381,
+			desc:      fmt.Sprintf("pk(%s)", KEY),
+			pkScripts: []string{"2103a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bdac"},
+		},
+		{
+			bip:       381,
+			desc:      fmt.Sprintf("pkh([deadbeef/1/2'/3/4']%s)", KEY),
+			pkScripts: []string{"76a9149a1c78a507689f6f54b847ad1cef1e614ee23f1e88ac"},
+		},
+		{
+			bip:       381,
+			desc:      fmt.Sprintf("pkh(%s)", UNCOMPRESSED_KEY),
+			pkScripts: []string{"76a914b5bd079c4d57cc7fc28ecf8213a6b791625b818388ac"},
+		},
+		{
+			bip:       381,
+			desc:      fmt.Sprintf("sh(pk(%s))", KEY),
+			pkScripts: []string{"a9141857af51a5e516552b3086430fd8ce55f7c1a52487"},
+		},
+		{
+			bip:       381,
+			desc:      fmt.Sprintf("sh(pkh(%s))", KEY),
+			pkScripts: []string{"a9141a31ad23bf49c247dd531a623c2ef57da3c400c587"},
+		},
+		{
+			bip:       381,
+			desc:      fmt.Sprintf("pkh(%s/2147483647'/0)", XPRV_1),
+			pkScripts: []string{"76a914ebdc90806a9c4356c1c88e42216611e1cb4c1c1788ac"},
+		},
+		{
+			bip:       381,
+			desc:      "pkh([bd16bee5/2147483647']xpub69H7F5dQzmVd3vPuLKtcXJziMEQByuDidnX3YdwgtNsecY5HRGtAAQC5mXTt4dsv9RzyjgDjAQs9VGVV6ydYCHnprc9vvaA5YtqWyL6hyds/0)",
+			pkScripts: []string{"76a914ebdc90806a9c4356c1c88e42216611e1cb4c1c1788ac"},
+		},
+		{
+			bip:       382,
+			desc:      fmt.Sprintf("wpkh(%s)", KEY),
+			pkScripts: []string{"00149a1c78a507689f6f54b847ad1cef1e614ee23f1e"},
+		},
+		{
+			bip:  382,
+			desc: fmt.Sprintf("wpkh([ffffffff/13']%s/1/2/*)", XPRV_2),
+			pkScripts: []string{
+				"0014326b2249e3a25d5dc60935f044ee835d090ba859",
+				"0014af0bd98abc2f2cae66e36896a39ffe2d32984fb7",
+				"00141fa798efd1cbf95cebf912c031b8a4a6e9fb9f27",
+			},
+		},
+		{
+			bip:  382,
+			desc: fmt.Sprintf("sh(wpkh(%s/10/20/30/40/*'))", XPRV_3),
+			pkScripts: []string{
+				"a9149a4d9901d6af519b2a23d4a2f51650fcba87ce7b87",
+				"a914bed59fc0024fae941d6e20a3b44a109ae740129287",
+				"a9148483aa1116eb9c05c482a72bada4b1db24af654387",
+			},
+		},
+		{
+			bip:       383,
+			desc:      fmt.Sprintf("multi(1,%s,%s)", KEY, UNCOMPRESSED_KEY),
+			pkScripts: []string{"512103a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd4104a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd5b8dec5235a0fa8722476c7709c02559e3aa73aa03918ba2d492eea75abea23552ae"},
+		},
+		{
+			bip:       383,
+			desc:      fmt.Sprintf("sh(multi(2,[00000000/111'/222]%s,%s/0))", XPRV_A, XPRV_B),
+			pkScripts: []string{"a91445a9a622a8b0a1269944be477640eedc447bbd8487"},
+		},
+		{
+			bip:  383,
+			desc: fmt.Sprintf("wsh(multi(2,%s/2147483647'/0,%s/1/2/*,%s/10/20/30/40/*'))", XPRV_1, XPRV_2, XPRV_3),
+			pkScripts: []string{
+				"0020b92623201f3bb7c3771d45b2ad1d0351ea8fbf8cfe0a0e570264e1075fa1948f",
+				"002036a08bbe4923af41cf4316817c93b8d37e2f635dd25cfff06bd50df6ae7ea203",
+				"0020a96e7ab4607ca6b261bfe3245ffda9c746b28d3f59e83d34820ec0e2b36c139c",
+			},
+		},
+		{
+			bip:       386,
+			desc:      "tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)",
+			pkScripts: []string{"512077aab6e066f8a7419c5ab714c12c67d25007ed55a43cadcacb4d7a970a093f11"},
+		},
+		{
+			bip:       386,
+			desc:      fmt.Sprintf("tr(%s)", KEY),
+			pkScripts: []string{"512077aab6e066f8a7419c5ab714c12c67d25007ed55a43cadcacb4d7a970a093f11"},
+		},
+		{
+			bip:       386,
+			desc:      "tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,pk(669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0))",
+			pkScripts: []string{"512017cf18db381d836d8923b1bdb246cfcd818da1a9f0e6e7907f187f0b2f937754"},
+		},
+		// key path only outputs of the first receiving and change addresses of account 0
+		{
+			bip:  86,
+			desc: fmt.Sprintf("tr(%s/86'/0'/0'/0/*)", XPRV_BIP86),
+			pkScripts: []string{
+				"5120a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c",
+				"5120a82f29944d65b86ae6b5e5cc75e294ead6c59391a1edc5e016e3498c67fc7bbb",
+			},
+			addresses: []string{
+				"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr",
+				"bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh",
+			},
+		},
+		{
+			bip:       86,
+			desc:      fmt.Sprintf("tr(%s/86'/0'/0'/1/*)", XPRV_BIP86),
+			pkScripts: []string{"5120882d74e5d0572d5a816cef0041a96b6c1de832f6f9676d9605c44d5e9a97d3dc"},
+			addresses: []string{"bc1p3qkhfews2uk44qtvauqyr2ttdsw7svhkl9nkm9s9c3x4ax5h60wqwruhk7"},
+		},
+	}
+	for _, test := range tests {
+		desc, err := Parse(test.desc, &chaincfg.MainNetParams)
+		assert.NoError(t, err, "BIP %d: %s", test.bip, test.desc)
+		for i, expected := range test.pkScripts {
+			output, err := desc.Derive(uint32(i))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, hex.EncodeToString(output.PkScript), "BIP %d: %s at %d", test.bip, test.desc, i)
+
+			// outputs with an address pay to the same pk script
+			if output.Address == nil {
+				continue
+			}
+			pkScript, err := txscript.PayToAddrScript(output.Address)
+			assert.NoError(t, err)
+			assert.Equal(t, output.PkScript, pkScript)
+			if i < len(test.addresses) {
+				assert.Equal(t, test.addresses[i], output.Address.EncodeAddress(), "BIP %d: %s at %d", test.bip, test.desc, i)
+			}
+		}
+	}
+
+	// the internal key of musig() is the key aggregation of BIP 327 over the participants in sorted order
+	// test vectors of KeyAgg whose participants are already sorted
+	const (
+		X_0 = "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+		X_1 = "03dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659"
+	)
+	for _, test := range []struct {
+		keys        []string
+		internalKey string
+	}{
+		{
+			keys:        []string{X_0, X_0, X_0},
+			internalKey: "b436e3bad62b8cd409969a224731c193d051162d8c5ae8b109306127da3aa935",
+		},
+		{
+			keys:        []string{X_0, X_0, X_1, X_1},
+			internalKey: "69bc22bfa5d106306e48a20679de1d7389386124d07571d0d872686028c26a3e",
+		},
+	} {
+		desc_str := fmt.Sprintf("tr(musig(%s))", strings.Join(test.keys, ","))
+		desc, err := Parse(desc_str, &chaincfg.MainNetParams)
+		assert.NoError(t, err, desc_str)
+		output, err := desc.Derive(0)
+		assert.NoError(t, err)
+		assert.Equal(t, test.internalKey, hex.EncodeToString(schnorr.SerializePubKey(output.Taproot.InternalKey)), desc_str)
+		assert.Equal(t, schnorr.SerializePubKey(txscript.ComputeTaprootKeyNoScript(output.Taproot.InternalKey)), schnorr.SerializePubKey(output.Taproot.OutputKey))
+	}
+}
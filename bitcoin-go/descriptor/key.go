@@ -0,0 +1,296 @@
+package descriptor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// where a script expression is, which decides the keys it can have
+type context int
+
+const (
+	ctxTop context = iota
+	ctxSh
+	ctxWsh
+	// tr internal key and tapscript leaves
+	ctxTap
+	// participants of musig()
+	ctxMusig
+)
+
+// a key expression of a descriptor, that derives a public key at a range index
+type keyExpr interface {
+	derive(index uint32) (*btcec.PublicKey, error)
+	isRange() bool
+	// whether the key is serialized compressed outside of tapscript
+	compressed() bool
+	String() string
+}
+
+// [fingerprint/path] before a key, only kept to print it back
+func parseOrigin(s string) (string, string, error) {
+	if !strings.HasPrefix(s, "[") {
+		return "", s, nil
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return "", "", fmt.Errorf("key origin of %q is not closed", s)
+	}
+
+	steps := strings.Split(s[1:end], "/")
+	if fingerprint, err := hex.DecodeString(steps[0]); err != nil || len(fingerprint) != 4 {
+		return "", "", fmt.Errorf("invalid fingerprint %q", steps[0])
+	}
+	for _, step := range steps[1:] {
+		if _, _, err := parseStep(step); err != nil {
+			return "", "", err
+		}
+	}
+
+	return s[:end+1], s[end+1:], nil
+}
+
+// a derivation step, hardened by ' or h
+func parseStep(step string) (uint32, bool, error) {
+	hardened := strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h")
+	if hardened {
+		step = step[:len(step)-1]
+	}
+	child, err := strconv.ParseUint(step, 10, 32)
+	if err != nil || child >= hdkeychain.HardenedKeyStart {
+		return 0, false, fmt.Errorf("invalid derivation step %q", step)
+	}
+	if hardened {
+		child += hdkeychain.HardenedKeyStart
+	}
+
+	return uint32(child), hardened, nil
+}
+
+func parseKey(s string, ctx context, params *chaincfg.Params) (keyExpr, error) {
+	if strings.HasPrefix(s, "musig(") {
+		return parseMusig(s, ctx, params)
+	}
+
+	origin, key, err := parseOrigin(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if key_bytes, err := hex.DecodeString(key); err == nil {
+		return parseHexKey(s, origin, key_bytes, ctx)
+	}
+
+	return parseExtendedKey(s, key, params)
+}
+
+// a fixed key in hex, x-only keys are only in tapscript and uncompressed keys are not in segwit
+type constKey struct {
+	raw          string
+	pub          *btcec.PublicKey
+	uncompressed bool
+}
+
+func parseHexKey(raw, origin string, key_bytes []byte, ctx context) (*constKey, error) {
+	var pub *btcec.PublicKey
+	var err error
+	switch {
+	case len(key_bytes) == 32 && ctx == ctxTap:
+		pub, err = schnorr.ParsePubKey(key_bytes)
+	case len(key_bytes) == 33:
+		pub, err = btcec.ParsePubKey(key_bytes)
+	case len(key_bytes) == 65 && (ctx == ctxTop || ctx == ctxSh):
+		pub, err = btcec.ParsePubKey(key_bytes)
+	default:
+		return nil, fmt.Errorf("key %q of %d bytes is not allowed here", raw, len(key_bytes))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %w", raw, err)
+	}
+
+	return &constKey{raw: raw, pub: pub, uncompressed: len(key_bytes) == 65}, nil
+}
+
+func (k *constKey) derive(index uint32) (*btcec.PublicKey, error) {
+	return k.pub, nil
+}
+
+func (k *constKey) isRange() bool {
+	return false
+}
+
+func (k *constKey) compressed() bool {
+	return !k.uncompressed
+}
+
+func (k *constKey) String() string {
+	return k.raw
+}
+
+type wildcard int
+
+const (
+	noWildcard wildcard = iota
+	unhardenedWildcard
+	hardenedWildcard
+)
+
+// an extended key of the network, with a derivation path that may end with a * wildcard for the range index
+// hardened steps need an extended private key
+type hdKey struct {
+	raw      string
+	key      *hdkeychain.ExtendedKey
+	path     []uint32
+	wildcard wildcard
+}
+
+func parseExtendedKey(raw, key string, params *chaincfg.Params) (*hdKey, error) {
+	steps := strings.Split(key, "/")
+	extended_key, err := hdkeychain.NewKeyFromString(steps[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %w", raw, err)
+	}
+	if !extended_key.IsForNet(params) {
+		return nil, fmt.Errorf("key %q is not for %s", raw, params.Name)
+	}
+
+	k := &hdKey{raw: raw, key: extended_key, path: make([]uint32, 0, len(steps)-1)}
+	for i, step := range steps[1:] {
+		// only the last step can be a wildcard
+		last := i == len(steps)-2
+		if last && step == "*" {
+			k.wildcard = unhardenedWildcard
+			continue
+		}
+		if last && (step == "*'" || step == "*h") {
+			k.wildcard = hardenedWildcard
+			continue
+		}
+
+		child, hardened, err := parseStep(step)
+		if err != nil {
+			return nil, err
+		}
+		if hardened && !extended_key.IsPrivate() {
+			return nil, fmt.Errorf("hardened step %q of %q needs a private key", step, raw)
+		}
+		k.path = append(k.path, child)
+	}
+	if k.wildcard == hardenedWildcard && !extended_key.IsPrivate() {
+		return nil, fmt.Errorf("hardened wildcard of %q needs a private key", raw)
+	}
+
+	return k, nil
+}
+
+func (k *hdKey) derive(index uint32) (*btcec.PublicKey, error) {
+	path := k.path
+	switch k.wildcard {
+	case unhardenedWildcard, hardenedWildcard:
+		if index >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("range index %d is out of range", index)
+		}
+		if k.wildcard == hardenedWildcard {
+			index += hdkeychain.HardenedKeyStart
+		}
+		path = append(path[:len(path):len(path)], index)
+	}
+
+	key := k.key
+	for _, child := range path {
+		var err error
+		key, err = key.Derive(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key.ECPubKey()
+}
+
+func (k *hdKey) isRange() bool {
+	return k.wildcard != noWildcard
+}
+
+func (k *hdKey) compressed() bool {
+	return true
+}
+
+func (k *hdKey) String() string {
+	return k.raw
+}
+
+// musig(KEY,...) of BIP 390, the MuSig2 aggregated key of its participants after sorting them
+// participants can be ranged, but derivation from the aggregated key is not supported
+type musigKey struct {
+	keys []keyExpr
+}
+
+func parseMusig(s string, ctx context, params *chaincfg.Params) (*musigKey, error) {
+	if ctx != ctxTap {
+		return nil, fmt.Errorf("musig() is only in tr()")
+	}
+	name, args, err := splitCall(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid musig() %q, derivation from a musig() key is not supported: %w", s, err)
+	}
+	if name != "musig" {
+		return nil, fmt.Errorf("invalid musig() %q", s)
+	}
+
+	k := &musigKey{keys: make([]keyExpr, len(args))}
+	for i, arg := range args {
+		if k.keys[i], err = parseKey(arg, ctxMusig, params); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+func (k *musigKey) derive(index uint32) (*btcec.PublicKey, error) {
+	keys := make([]*btcec.PublicKey, len(k.keys))
+	for i, key := range k.keys {
+		var err error
+		if keys[i], err = key.derive(index); err != nil {
+			return nil, err
+		}
+	}
+	aggr_key, _, _, err := musig2.AggregateKeys(keys, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggr_key.FinalKey, nil
+}
+
+func (k *musigKey) isRange() bool {
+	for _, key := range k.keys {
+		if key.isRange() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (k *musigKey) compressed() bool {
+	return true
+}
+
+func (k *musigKey) String() string {
+	keys := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		keys[i] = key.String()
+	}
+
+	return fmt.Sprintf("musig(%s)", strings.Join(keys, ","))
+}
@@ -0,0 +1,250 @@
+package miniscript
+
+import (
+	"fmt"
+)
+
+// compile a policy to a miniscript of type B in ctx
+// every sub policy is compiled to its cheapest non malleable form, then combined in the cheapest form that keeps the result non malleable
+// cost is script size plus the expected satisfaction size, weighted by the branch weights of or
+func Compile(policy *Policy, ctx Context) (*Node, error) {
+	node, err := compile(policy, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !node.typ.nonMalleable {
+		return nil, fmt.Errorf("%s has no non malleable compilation in %s", policy, ctx)
+	}
+
+	return node, nil
+}
+
+func compile(policy *Policy, ctx Context) (*Node, error) {
+	switch policy.Kind {
+	case "pk":
+		pk_k := &Node{Fragment: "pk_k", Keys: []*Key{policy.Key}, ctx: ctx}
+		if err := pk_k.check(); err != nil {
+			return nil, err
+		}
+		return newNode(ctx, "c", pk_k)
+	case "after", "older":
+		node := &Node{Fragment: policy.Kind, Value: policy.Value, ctx: ctx}
+		return node, node.check()
+	case "sha256":
+		node := &Node{Fragment: "sha256", Hash: policy.Hash, ctx: ctx}
+		return node, node.check()
+	case "and":
+		return compileAnd(policy, ctx)
+	case "or":
+		return compileOr(policy, ctx)
+	case "thresh":
+		return compileThresh(policy, ctx)
+	}
+
+	return nil, fmt.Errorf("unknown policy %q", policy.Kind)
+}
+
+func compileSubs(policy *Policy, ctx Context) ([]*Node, error) {
+	subs := make([]*Node, len(policy.Subs))
+	for i, sub := range policy.Subs {
+		var err error
+		subs[i], err = compile(sub, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return subs, nil
+}
+
+// and_v(v:X,Y), either order
+func compileAnd(policy *Policy, ctx Context) (*Node, error) {
+	subs, err := compileSubs(policy, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]*candidate, 0, 2)
+	for _, order := range [][2]int{{0, 1}, {1, 0}} {
+		var node *Node
+		if node, err = andV(subs[order[0]], subs[order[1]]); err == nil {
+			candidates = append(candidates, &candidate{node: node, satCost: float64(node.typ.sat)})
+		}
+	}
+	// both orders fail for the same reason, such as mixed timelocks
+	if len(candidates) == 0 {
+		return nil, err
+	}
+
+	return cheapest(policy, ctx, candidates)
+}
+
+func andV(x, y *Node) (*Node, error) {
+	v, err := newNode(x.ctx, "v", x)
+	if err != nil {
+		return nil, err
+	}
+
+	return newNode(x.ctx, "and_v", v, y)
+}
+
+// or_d(X,Z) for a dissatisfiable X, or_i(X,Z), or andor(X_1,X_2,Z) if X is and(X_1,X_2) with a dissatisfiable X_1, in both orders
+func compileOr(policy *Policy, ctx Context) (*Node, error) {
+	subs, err := compileSubs(policy, ctx)
+	if err != nil {
+		return nil, err
+	}
+	total := float64(policy.Weights[0] + policy.Weights[1])
+
+	candidates := make([]*candidate, 0)
+	for _, order := range [][2]int{{0, 1}, {1, 0}} {
+		x, z := subs[order[0]], subs[order[1]]
+		p_x, p_z := float64(policy.Weights[order[0]])/total, float64(policy.Weights[order[1]])/total
+
+		if node, err := newNode(ctx, "or_d", x, z); err == nil {
+			candidates = append(candidates, &candidate{
+				node:    node,
+				satCost: p_x*float64(x.typ.sat) + p_z*float64(x.typ.dissat+z.typ.sat),
+			})
+		}
+		if node, err := newNode(ctx, "or_i", x, z); err == nil {
+			candidates = append(candidates, &candidate{
+				node:    node,
+				satCost: p_x*float64(x.typ.sat+2) + p_z*float64(z.typ.sat+1),
+			})
+		}
+
+		// andor only fits if X is an and of two policies
+		and_policy := policy.Subs[order[0]]
+		if and_policy.Kind != "and" {
+			continue
+		}
+		and_subs, err := compileSubs(and_policy, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, and_order := range [][2]int{{0, 1}, {1, 0}} {
+			x_1, x_2 := and_subs[and_order[0]], and_subs[and_order[1]]
+			if node, err := newNode(ctx, "andor", x_1, x_2, z); err == nil {
+				candidates = append(candidates, &candidate{
+					node:    node,
+					satCost: p_x*float64(x_1.typ.sat+x_2.typ.sat) + p_z*float64(x_1.typ.dissat+z.typ.sat),
+				})
+			}
+		}
+	}
+
+	return cheapest(policy, ctx, candidates)
+}
+
+// multi in segwit v0 and multi_a in tapscript if all sub policies are keys
+// otherwise thresh(k,X_1,W_2,...,W_n) where each argument is made dissatisfiable with a unit result
+func compileThresh(policy *Policy, ctx Context) (*Node, error) {
+	all_keys := true
+	keys := make([]*Key, 0, len(policy.Subs))
+	for _, sub := range policy.Subs {
+		all_keys = all_keys && sub.Kind == "pk"
+		keys = append(keys, sub.Key)
+	}
+	if all_keys {
+		fragment := "multi"
+		if ctx == Tapscript {
+			fragment = "multi_a"
+		}
+		node := &Node{Fragment: fragment, Keys: keys, Value: policy.Value, ctx: ctx}
+		if err := node.check(); err == nil {
+			return node, nil
+		}
+	}
+
+	subs, err := compileSubs(policy, ctx)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]*Node, len(subs))
+	for i, sub := range subs {
+		arg, err := dissatisfiable(sub)
+		if err != nil {
+			return nil, fmt.Errorf("thresh: argument %d: %w", i, err)
+		}
+		if i > 0 {
+			wrapper := "a"
+			if arg.typ.o {
+				wrapper = "s"
+			}
+			arg, err = newNode(ctx, wrapper, arg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		args[i] = arg
+	}
+	node := &Node{Fragment: "thresh", Value: policy.Value, Args: args, ctx: ctx}
+	if err := node.check(); err != nil {
+		return nil, err
+	}
+
+	return cheapest(policy, ctx, []*candidate{{node: node, satCost: float64(node.typ.sat)}})
+}
+
+// a B node that can be dissatisfied and leaves exactly 1 when satisfied
+// timelocks consume nothing, so they are made dissatisfiable by d:v:X, which skips X for an empty input
+func dissatisfiable(node *Node) (*Node, error) {
+	var err error
+	if !node.typ.d {
+		if !node.typ.z {
+			return nil, fmt.Errorf("%s cannot be dissatisfied", node)
+		}
+		if node, err = newNode(node.ctx, "v", node); err != nil {
+			return nil, err
+		}
+		if node, err = newNode(node.ctx, "d", node); err != nil {
+			return nil, err
+		}
+	}
+	if !node.typ.u {
+		if node, err = newNode(node.ctx, "n", node); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+type candidate struct {
+	node    *Node
+	satCost float64
+}
+
+func (c *candidate) cost() float64 {
+	script, _ := c.node.Script()
+	return float64(len(script)) + c.satCost
+}
+
+// the cheapest non malleable candidate, or the cheapest one if all are malleable
+func cheapest(policy *Policy, ctx Context, candidates []*candidate) (*Node, error) {
+	var best *candidate
+	for _, c := range candidates {
+		if c.satCost < 0 {
+			continue
+		}
+		if best == nil {
+			best = c
+			continue
+		}
+		if c.node.typ.nonMalleable != best.node.typ.nonMalleable {
+			if c.node.typ.nonMalleable {
+				best = c
+			}
+			continue
+		}
+		if c.cost() < best.cost() {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%s cannot be compiled to %s", policy, ctx)
+	}
+
+	return best.node, nil
+}
@@ -0,0 +1,602 @@
+package miniscript
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// script context that a miniscript is compiled to
+type Context int
+
+const (
+	// P2WSH witness script
+	SegwitV0 Context = iota
+	// tapscript leaf
+	Tapscript
+)
+
+func (c Context) String() string {
+	if c == Tapscript {
+		return "tapscript"
+	}
+
+	return "segwit v0"
+}
+
+// witness size of a signature with its sighash type byte and length prefix
+func (c Context) sigSize() int {
+	if c == Tapscript {
+		return 1 + 65
+	}
+
+	return 1 + 73
+}
+
+// key as it is pushed in script
+func (c Context) keyBytes(key *Key) []byte {
+	if c == Tapscript {
+		return schnorr.SerializePubKey(key.Pub)
+	}
+
+	return key.Pub.SerializeCompressed()
+}
+
+// a miniscript fragment, wrappers are fragments of a single letter with one argument
+//
+//	pk_k(K)            <K>
+//	pk_h(K)            DUP HASH160 <HASH160(K)> EQUALVERIFY
+//	older(n)           <n> CHECKSEQUENCEVERIFY
+//	after(n)           <n> CHECKLOCKTIMEVERIFY
+//	sha256(h)          SIZE <32> EQUALVERIFY SHA256 <h> EQUAL
+//	multi(k,K_1..K_n)  <k> <K_1> ... <K_n> <n> CHECKMULTISIG, segwit v0 only
+//	multi_a(k,...)     <K_1> CHECKSIG <K_2> CHECKSIGADD ... <K_n> CHECKSIGADD <k> NUMEQUAL, tapscript only
+//	and_v(X,Y)         [X] [Y]
+//	or_d(X,Z)          [X] IFDUP NOTIF [Z] ENDIF
+//	or_i(X,Z)          IF [X] ELSE [Z] ENDIF
+//	andor(X,Y,Z)       [X] NOTIF [Z] ELSE [Y] ENDIF
+//	thresh(k,X_1..X_n) [X_1] [X_2] ADD ... [X_n] ADD <k> EQUAL
+//	a:X                TOALTSTACK [X] FROMALTSTACK
+//	s:X                SWAP [X]
+//	c:X                [X] CHECKSIG
+//	d:X                DUP IF [X] ENDIF
+//	v:X                [X] VERIFY, merged into the last opcode of X if it has a VERIFY form
+//	n:X                [X] 0NOTEQUAL
+type Node struct {
+	Fragment string
+	Keys     []*Key
+	Hash     *Hash
+	// lock value of a timelock, threshold of multi, multi_a and thresh
+	Value int64
+	Args  []*Node
+
+	ctx Context
+	typ nodeType
+}
+
+// type and properties of a fragment, as in the miniscript type system
+// sat and dissat are the largest witness sizes of a satisfaction and a dissatisfaction, -1 if there is none
+type nodeType struct {
+	base byte
+	// z: consumes no stack element, o: consumes exactly one, n: its top input is never empty
+	// d: can be dissatisfied, u: leaves exactly 1 when satisfied
+	z, o, n, d, u bool
+	// s: every satisfaction needs a signature, f: every dissatisfaction needs a signature
+	// e: the only dissatisfaction without a signature is the canonical one
+	s, f, e bool
+	// no third party can turn a satisfaction into another valid one
+	nonMalleable bool
+	// g, h: has a relative timelock in time or in blocks, i, j: has an absolute timelock in time or in blocks
+	g, h, i, j bool
+
+	sat, dissat int
+}
+
+// analysis of a compiled miniscript
+type Analysis struct {
+	// B for a top level script
+	Type         string
+	ScriptSize   int
+	MaxSatSize   int
+	NonMalleable bool
+	// every satisfaction needs at least one signature, so that a spend cannot be replaced by third parties
+	NeedsSignature bool
+}
+
+func newNode(ctx Context, fragment string, args ...*Node) (*Node, error) {
+	node := &Node{Fragment: fragment, Args: args, ctx: ctx}
+	if err := node.check(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (n *Node) Context() Context {
+	return n.ctx
+}
+
+func (n *Node) Analyze() Analysis {
+	script, _ := n.Script()
+	return Analysis{
+		Type:           string(n.typ.base),
+		ScriptSize:     len(script),
+		MaxSatSize:     n.typ.sat,
+		NonMalleable:   n.typ.nonMalleable,
+		NeedsSignature: n.typ.s,
+	}
+}
+
+// sum of witness sizes, -1 if any of them is impossible
+func addSize(sizes ...int) int {
+	total := 0
+	for _, size := range sizes {
+		if size < 0 {
+			return -1
+		}
+		total += size
+	}
+
+	return total
+}
+
+func maxSize(sizes ...int) int {
+	res := -1
+	for _, size := range sizes {
+		if size > res {
+			res = size
+		}
+	}
+
+	return res
+}
+
+// type of a node from the types of its arguments, an error if the arguments do not have the types that the fragment needs
+func (n *Node) check() error {
+	ctx := n.ctx
+	t := nodeType{nonMalleable: true}
+	x := func(i int) nodeType { return n.Args[i].typ }
+	expect := func(i int, base byte, props string) error {
+		arg := x(i)
+		ok := arg.base == base
+		for _, prop := range props {
+			switch prop {
+			case 'z':
+				ok = ok && arg.z
+			case 'o':
+				ok = ok && arg.o
+			case 'd':
+				ok = ok && arg.d
+			case 'u':
+				ok = ok && arg.u
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: argument %s is not of type %c%s", n.Fragment, n.Args[i], base, props)
+		}
+		return nil
+	}
+
+	switch n.Fragment {
+	case "pk_k":
+		t = nodeType{base: 'K', o: true, n: true, d: true, u: true, s: true, e: true, sat: ctx.sigSize(), dissat: 1}
+	case "pk_h":
+		key_size := 1 + len(ctx.keyBytes(n.Keys[0]))
+		t = nodeType{base: 'K', n: true, d: true, u: true, s: true, e: true, sat: ctx.sigSize() + key_size, dissat: 1 + key_size}
+	case "older", "after":
+		t = nodeType{base: 'B', z: true, f: true, sat: 0, dissat: -1}
+	case "sha256":
+		// any other 32 bytes dissatisfy it, so its dissatisfaction is malleable
+		t = nodeType{base: 'B', o: true, n: true, d: true, u: true, sat: 33, dissat: 33}
+	case "multi":
+		if ctx != SegwitV0 {
+			return fmt.Errorf("multi is only valid in segwit v0, use multi_a in tapscript")
+		}
+		if n.Value < 1 || n.Value > int64(len(n.Keys)) || len(n.Keys) > txscript.MaxPubKeysPerMultiSig {
+			return fmt.Errorf("multi: %d of %d keys", n.Value, len(n.Keys))
+		}
+		t = nodeType{base: 'B', n: true, d: true, u: true, s: true, e: true, sat: 1 + int(n.Value)*ctx.sigSize(), dissat: 1 + int(n.Value)}
+	case "multi_a":
+		if ctx != Tapscript {
+			return fmt.Errorf("multi_a is only valid in tapscript, use multi in segwit v0")
+		}
+		if n.Value < 1 || n.Value > int64(len(n.Keys)) {
+			return fmt.Errorf("multi_a: %d of %d keys", n.Value, len(n.Keys))
+		}
+		t = nodeType{base: 'B', d: true, u: true, s: true, e: true, sat: int(n.Value)*ctx.sigSize() + len(n.Keys) - int(n.Value), dissat: len(n.Keys)}
+	case "and_v":
+		if err := expect(0, 'V', ""); err != nil {
+			return err
+		}
+		X, Y := x(0), x(1)
+		if Y.base == 'W' {
+			return fmt.Errorf("and_v: argument %s is of type W", n.Args[1])
+		}
+		t = nodeType{
+			base: Y.base,
+			z:    X.z && Y.z, o: (X.z && Y.o) || (X.o && Y.z), n: X.n || (X.z && Y.n), u: Y.u,
+			s: X.s || Y.s, f: X.s || Y.f,
+			nonMalleable: X.nonMalleable && Y.nonMalleable,
+			sat:          addSize(X.sat, Y.sat), dissat: -1,
+		}
+	case "or_d":
+		if err := expect(0, 'B', "du"); err != nil {
+			return err
+		}
+		if err := expect(1, 'B', ""); err != nil {
+			return err
+		}
+		X, Z := x(0), x(1)
+		t = nodeType{
+			base: 'B',
+			z:    X.z && Z.z, o: X.o && Z.z, d: Z.d, u: Z.u,
+			s: X.s && Z.s, f: Z.f, e: X.e && Z.e,
+			nonMalleable: X.nonMalleable && Z.nonMalleable && X.e && (X.s || Z.s),
+			sat:          maxSize(X.sat, addSize(X.dissat, Z.sat)), dissat: addSize(X.dissat, Z.dissat),
+		}
+	case "or_i":
+		X, Z := x(0), x(1)
+		if X.base != Z.base || X.base == 'W' {
+			return fmt.Errorf("or_i: arguments %s and %s are not both of type B, K or V", n.Args[0], n.Args[1])
+		}
+		t = nodeType{
+			base: X.base,
+			o:    X.z && Z.z, d: X.d || Z.d, u: X.u && Z.u,
+			s: X.s && Z.s, f: X.f && Z.f, e: (X.e && Z.f) || (X.f && Z.e),
+			nonMalleable: X.nonMalleable && Z.nonMalleable && (X.s || Z.s),
+			sat:          maxSize(addSize(X.sat, 2), addSize(Z.sat, 1)),
+			dissat:       maxSize(addSize(X.dissat, 2), addSize(Z.dissat, 1)),
+		}
+	case "andor":
+		if err := expect(0, 'B', "du"); err != nil {
+			return err
+		}
+		X, Y, Z := x(0), x(1), x(2)
+		if Y.base != Z.base || Y.base == 'W' {
+			return fmt.Errorf("andor: arguments %s and %s are not both of type B, K or V", n.Args[1], n.Args[2])
+		}
+		t = nodeType{
+			base: Y.base,
+			z:    X.z && Y.z && Z.z, o: (X.z && Y.o && Z.o) || (X.o && Y.z && Z.z), d: Z.d, u: Y.u && Z.u,
+			s: Z.s && (X.s || Y.s), f: Z.f && (X.s || Y.f), e: Z.e && (X.s || Y.f),
+			nonMalleable: X.nonMalleable && Y.nonMalleable && Z.nonMalleable && X.e && (X.s || Y.s || Z.s),
+			sat:          maxSize(addSize(X.sat, Y.sat), addSize(X.dissat, Z.sat)),
+			dissat:       addSize(X.dissat, Z.dissat),
+		}
+	case "thresh":
+		if n.Value < 1 || n.Value > int64(len(n.Args)) {
+			return fmt.Errorf("thresh: %d of %d", n.Value, len(n.Args))
+		}
+		t = nodeType{base: 'B', z: true, d: true, u: true, e: true, nonMalleable: true}
+		o_num, s_num := 0, 0
+		sats := make([]int, 0, len(n.Args))
+		dissat := 0
+		for i, arg := range n.Args {
+			base := byte('W')
+			if i == 0 {
+				base = 'B'
+			}
+			if err := expect(i, base, "du"); err != nil {
+				return err
+			}
+			t.z = t.z && arg.typ.z
+			if arg.typ.o {
+				o_num++
+			} else if !arg.typ.z {
+				o_num = 2
+			}
+			if arg.typ.s {
+				s_num++
+			}
+			t.e = t.e && arg.typ.e
+			t.nonMalleable = t.nonMalleable && arg.typ.nonMalleable && arg.typ.e
+			sats = append(sats, arg.typ.sat-arg.typ.dissat)
+			dissat = addSize(dissat, arg.typ.dissat)
+		}
+		t.o = o_num == 1
+		t.s = s_num >= len(n.Args)-int(n.Value)+1
+		t.e = t.e && s_num == len(n.Args)
+		t.nonMalleable = t.nonMalleable && s_num >= len(n.Args)-int(n.Value)
+		// the largest satisfaction satisfies the k arguments that grow the most, and dissatisfies the others
+		t.dissat = dissat
+		t.sat = dissat
+		for i := int64(0); i < n.Value; i++ {
+			best := 0
+			for j := range sats {
+				if sats[j] > sats[best] {
+					best = j
+				}
+			}
+			if n.Args[best].typ.sat < 0 {
+				t.sat = -1
+				break
+			}
+			t.sat += sats[best]
+			sats[best] = -1 << 30
+		}
+	case "a", "s", "n":
+		X := x(0)
+		base := byte('W')
+		if err := expect(0, 'B', ""); err != nil {
+			return err
+		}
+		if n.Fragment == "s" && !X.o {
+			return fmt.Errorf("s: argument %s does not consume exactly one stack element", n.Args[0])
+		}
+		if n.Fragment == "n" {
+			base = 'B'
+		}
+		t = X
+		t.base = base
+		t.z, t.o, t.n = false, false, false
+		if n.Fragment == "n" {
+			t.n = X.n
+			t.z, t.o, t.u = X.z, X.o, true
+		}
+		if n.Fragment == "s" {
+			t.o = true
+		}
+	case "c":
+		if err := expect(0, 'K', ""); err != nil {
+			return err
+		}
+		t = x(0)
+		t.base = 'B'
+		t.s = true
+	case "d":
+		if err := expect(0, 'V', "z"); err != nil {
+			return err
+		}
+		X := x(0)
+		// MINIMALIF is only a consensus rule in tapscript, in segwit v0 DUP IF may leave any non zero value
+		t = nodeType{
+			base: 'B', o: true, n: true, d: true, u: ctx == Tapscript,
+			s: X.s, e: true,
+			nonMalleable: X.nonMalleable,
+			sat:          addSize(X.sat, 2), dissat: 1,
+		}
+	case "v":
+		if err := expect(0, 'B', ""); err != nil {
+			return err
+		}
+		X := x(0)
+		t = nodeType{
+			base: 'V', z: X.z, o: X.o, n: X.n,
+			s: X.s, f: true,
+			nonMalleable: X.nonMalleable,
+			sat:          X.sat, dissat: -1,
+		}
+	default:
+		return fmt.Errorf("unknown fragment %q", n.Fragment)
+	}
+	// a satisfaction of a leaf fragment is always unique
+	if len(n.Args) == 0 {
+		t.nonMalleable = true
+	}
+	if err := n.checkTimelocks(&t); err != nil {
+		return err
+	}
+	n.typ = t
+
+	return nil
+}
+
+// the k property of miniscript, no satisfaction needs timelocks in both blocks and time
+// a transaction has one lock time and one sequence, so it can never meet both, and such a fragment is rejected as the reference miniscript does
+func (n *Node) checkTimelocks(t *nodeType) error {
+	t.g, t.h, t.i, t.j = false, false, false, false
+	switch n.Fragment {
+	case "older":
+		t.g = uint32(n.Value)&wire.SequenceLockTimeIsSeconds != 0
+		t.h = !t.g
+	case "after":
+		t.i = n.Value >= txscript.LockTimeThreshold
+		t.j = !t.i
+	}
+
+	// arguments that are all met in a satisfaction, or_d and or_i only meet one of theirs
+	conjunct := 0
+	switch n.Fragment {
+	case "and_v", "andor":
+		conjunct = 2
+	case "thresh":
+		if n.Value > 1 {
+			conjunct = len(n.Args)
+		}
+	}
+	var met nodeType
+	for idx, arg := range n.Args {
+		X := arg.typ
+		if idx < conjunct {
+			if (met.g && X.h) || (met.h && X.g) || (met.i && X.j) || (met.j && X.i) {
+				return fmt.Errorf("%s: timelocks in blocks and in time must both be met, no transaction can satisfy it", n)
+			}
+			met.g, met.h, met.i, met.j = met.g || X.g, met.h || X.h, met.i || X.i, met.j || X.j
+		}
+		t.g, t.h, t.i, t.j = t.g || X.g, t.h || X.h, t.i || X.i, t.j || X.j
+	}
+
+	return nil
+}
+
+func (n *Node) Script() ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	n.encode(builder, false)
+	return builder.Script()
+}
+
+// fragments whose last opcode has a VERIFY form
+func (n *Node) mergesVerify() bool {
+	switch n.Fragment {
+	case "c", "sha256", "multi", "multi_a", "thresh":
+		return true
+	}
+
+	return false
+}
+
+// append the script of n to builder, the last opcode in its VERIFY form if verify is set
+func (n *Node) encode(builder *txscript.ScriptBuilder, verify bool) {
+	last := func(op, verify_op byte) {
+		if verify {
+			builder.AddOp(verify_op)
+		} else {
+			builder.AddOp(op)
+		}
+	}
+
+	switch n.Fragment {
+	case "pk_k":
+		builder.AddData(n.ctx.keyBytes(n.Keys[0]))
+	case "pk_h":
+		builder.AddOp(txscript.OP_DUP)
+		builder.AddOp(txscript.OP_HASH160)
+		builder.AddData(btcutil.Hash160(n.ctx.keyBytes(n.Keys[0])))
+		builder.AddOp(txscript.OP_EQUALVERIFY)
+	case "older":
+		builder.AddInt64(n.Value)
+		builder.AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+	case "after":
+		builder.AddInt64(n.Value)
+		builder.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+	case "sha256":
+		builder.AddOp(txscript.OP_SIZE)
+		builder.AddInt64(32)
+		builder.AddOp(txscript.OP_EQUALVERIFY)
+		builder.AddOp(txscript.OP_SHA256)
+		builder.AddData(n.Hash.Value[:])
+		last(txscript.OP_EQUAL, txscript.OP_EQUALVERIFY)
+	case "multi":
+		builder.AddInt64(n.Value)
+		for _, key := range n.Keys {
+			builder.AddData(n.ctx.keyBytes(key))
+		}
+		builder.AddInt64(int64(len(n.Keys)))
+		last(txscript.OP_CHECKMULTISIG, txscript.OP_CHECKMULTISIGVERIFY)
+	case "multi_a":
+		for i, key := range n.Keys {
+			builder.AddData(n.ctx.keyBytes(key))
+			if i == 0 {
+				builder.AddOp(txscript.OP_CHECKSIG)
+			} else {
+				builder.AddOp(txscript.OP_CHECKSIGADD)
+			}
+		}
+		builder.AddInt64(n.Value)
+		last(txscript.OP_NUMEQUAL, txscript.OP_NUMEQUALVERIFY)
+	case "and_v":
+		n.Args[0].encode(builder, false)
+		n.Args[1].encode(builder, verify)
+	case "or_d":
+		n.Args[0].encode(builder, false)
+		builder.AddOp(txscript.OP_IFDUP)
+		builder.AddOp(txscript.OP_NOTIF)
+		n.Args[1].encode(builder, false)
+		builder.AddOp(txscript.OP_ENDIF)
+		if verify {
+			builder.AddOp(txscript.OP_VERIFY)
+		}
+	case "or_i":
+		builder.AddOp(txscript.OP_IF)
+		n.Args[0].encode(builder, false)
+		builder.AddOp(txscript.OP_ELSE)
+		n.Args[1].encode(builder, false)
+		builder.AddOp(txscript.OP_ENDIF)
+		if verify {
+			builder.AddOp(txscript.OP_VERIFY)
+		}
+	case "andor":
+		n.Args[0].encode(builder, false)
+		builder.AddOp(txscript.OP_NOTIF)
+		n.Args[2].encode(builder, false)
+		builder.AddOp(txscript.OP_ELSE)
+		n.Args[1].encode(builder, false)
+		builder.AddOp(txscript.OP_ENDIF)
+		if verify {
+			builder.AddOp(txscript.OP_VERIFY)
+		}
+	case "thresh":
+		for i, arg := range n.Args {
+			arg.encode(builder, false)
+			if i > 0 {
+				builder.AddOp(txscript.OP_ADD)
+			}
+		}
+		builder.AddInt64(n.Value)
+		last(txscript.OP_EQUAL, txscript.OP_EQUALVERIFY)
+	case "a":
+		builder.AddOp(txscript.OP_TOALTSTACK)
+		n.Args[0].encode(builder, false)
+		builder.AddOp(txscript.OP_FROMALTSTACK)
+	case "s":
+		builder.AddOp(txscript.OP_SWAP)
+		n.Args[0].encode(builder, verify)
+	case "c":
+		n.Args[0].encode(builder, false)
+		last(txscript.OP_CHECKSIG, txscript.OP_CHECKSIGVERIFY)
+	case "d":
+		builder.AddOp(txscript.OP_DUP)
+		builder.AddOp(txscript.OP_IF)
+		n.Args[0].encode(builder, false)
+		builder.AddOp(txscript.OP_ENDIF)
+	case "v":
+		if n.Args[0].mergesVerify() {
+			n.Args[0].encode(builder, true)
+		} else {
+			n.Args[0].encode(builder, false)
+			builder.AddOp(txscript.OP_VERIFY)
+		}
+	case "n":
+		n.Args[0].encode(builder, false)
+		builder.AddOp(txscript.OP_0NOTEQUAL)
+	}
+}
+
+// miniscript notation, c:pk_k(K) is written as pk(K)
+func (n *Node) String() string {
+	wrappers := ""
+	node := n
+	for len(node.Fragment) == 1 {
+		if node.Fragment == "c" && node.Args[0].Fragment == "pk_k" {
+			break
+		}
+		wrappers += node.Fragment
+		node = node.Args[0]
+	}
+
+	var body string
+	switch node.Fragment {
+	case "c":
+		body = fmt.Sprintf("pk(%s)", node.Args[0].Keys[0])
+	case "pk_k", "pk_h":
+		body = fmt.Sprintf("%s(%s)", node.Fragment, node.Keys[0])
+	case "older", "after":
+		body = fmt.Sprintf("%s(%d)", node.Fragment, node.Value)
+	case "sha256":
+		body = fmt.Sprintf("sha256(%s)", node.Hash)
+	case "multi", "multi_a":
+		args := []string{fmt.Sprint(node.Value)}
+		for _, key := range node.Keys {
+			args = append(args, key.String())
+		}
+		body = fmt.Sprintf("%s(%s)", node.Fragment, strings.Join(args, ","))
+	default:
+		args := make([]string, 0, len(node.Args)+1)
+		if node.Fragment == "thresh" {
+			args = append(args, fmt.Sprint(node.Value))
+		}
+		for _, arg := range node.Args {
+			args = append(args, arg.String())
+		}
+		body = fmt.Sprintf("%s(%s)", node.Fragment, strings.Join(args, ","))
+	}
+	if wrappers != "" {
+		return wrappers + ":" + body
+	}
+
+	return body
+}
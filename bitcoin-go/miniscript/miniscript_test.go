@@ -0,0 +1,349 @@
+package miniscript
+
+import (
+	"crypto/sha256"
+	"log"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/taproot"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ALICE_WALLET_SEED   = "4b92958dbc301dce528bb8aff445d00445c828220c287ec7d19599e3c256ce0e"
+	BOB_WALLET_SEED     = "b8c646523dd3cbb5fecf3906604aa36bd0d556c7d81e8d138e56e62809a708c2"
+	OLIVIA_WALLET_SEED  = "f9fdc67f82e763423c10448b33ec755c348cce8b58bebb19fdd25af5c9b49952"
+	OMNIMAN_WALLET_SEED = "e7712cf15c5ae7e24ae85920abdd0fa11251096bfbe8bad2bfb0aacdd34f2c8a"
+)
+
+type testEnv struct {
+	env       *Env
+	privs     map[string]*btcec.PrivateKey
+	preimages map[string][]byte
+}
+
+func newTestEnv(s *testhelper.TestSuite) *testEnv {
+	e := &testEnv{
+		env: &Env{
+			Keys:   make(map[string]*btcec.PublicKey),
+			Hashes: make(map[string][32]byte),
+		},
+		privs:     make(map[string]*btcec.PrivateKey),
+		preimages: make(map[string][]byte),
+	}
+	for name, seed := range map[string]string{"A": ALICE_WALLET_SEED, "B": BOB_WALLET_SEED, "C": OLIVIA_WALLET_SEED} {
+		_, pair := s.NewHDKeyPairFromSeed(seed)
+		e.env.Keys[name] = pair.Pub
+		e.privs[name] = pair.GetTestPriv()
+	}
+	// result hashes of the ball game between VN and TL
+	for name, preimage := range map[string]string{"H": "VN wins", "G": "TL wins"} {
+		preimage_hash := sha256.Sum256([]byte(preimage))
+		e.preimages[name] = preimage_hash[:]
+		e.env.Hashes[name] = sha256.Sum256(preimage_hash[:])
+	}
+
+	return e
+}
+
+// spend a compiled miniscript with the signatures of signers and the preimages of hashes, at block height
+// a segwit v0 miniscript is spent as a P2WSH output, and a tapscript miniscript as the only leaf of a taproot output
+func (e *testEnv) validateSpend(s *testhelper.TestSuite, node *Node, blockHeight int32, signers []string, hashes []string) {
+	script, err := node.Script()
+	assert.NoError(s.T, err)
+
+	var pkScript []byte
+	var tree *taproot.Tree
+	if node.Context() == SegwitV0 {
+		script_hash := sha256.Sum256(script)
+		address, err := btcutil.NewAddressWitnessScriptHash(script_hash[:], s.BtcdChainConfig)
+		assert.NoError(s.T, err)
+		pkScript, err = txscript.PayToAddrScript(address)
+		assert.NoError(s.T, err)
+	} else {
+		_, internal_pair := s.NewHDKeyPairFromSeed(OMNIMAN_WALLET_SEED)
+		tree, err = taproot.NewTree(internal_pair.Pub, &taproot.RawScript{Script: script})
+		assert.NoError(s.T, err)
+		pkScript, err = tree.PkScript()
+		assert.NoError(s.T, err)
+	}
+
+	s.ValidateScript(pkScript, blockHeight, func(t assert.TestingT, prevOut *wire.TxOut, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int) wire.TxWitness {
+		satisfier := NewSatisfier(node.Context(), tx.LockTime, tx.TxIn[idx].Sequence)
+		for _, signer := range signers {
+			var sig []byte
+			var err error
+			if node.Context() == SegwitV0 {
+				sig, err = txscript.RawTxInWitnessSignature(tx, sigHashes, idx, prevOut.Value, script, txscript.SigHashAll, e.privs[signer])
+			} else {
+				sig, err = tree.SignLeaf(tx, sigHashes, idx, prevOut, 0, txscript.SigHashDefault, e.privs[signer])
+			}
+			assert.NoError(t, err)
+			satisfier.AddSignature(e.env.Keys[signer], sig)
+		}
+		for _, hash := range hashes {
+			satisfier.AddPreimage(e.preimages[hash])
+		}
+
+		witness, err := node.Satisfy(satisfier)
+		assert.NoError(t, err)
+		if node.Context() == SegwitV0 {
+			return append(witness, script)
+		}
+		ctrl_block, err := tree.ControlBlock(0)
+		assert.NoError(t, err)
+		return append(witness, script, ctrl_block)
+	})
+}
+
+// go test -v -run ^TestCompilePolicy$ github.com/nghuyenthevinh2000/bitcoin-playground/miniscript
+func TestCompilePolicy(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+	e := newTestEnv(&s)
+
+	tests := []struct {
+		policy    string
+		segwitV0  string
+		tapscript string
+		needsSig  bool
+	}{
+		{
+			policy:    "pk(A)",
+			segwitV0:  "pk(A)",
+			tapscript: "pk(A)",
+			needsSig:  true,
+		},
+		{
+			policy:    "or(and(pk(A),sha256(H)),and(pk(B),after(100)))",
+			segwitV0:  "andor(pk(A),sha256(H),and_v(v:pk(B),after(100)))",
+			tapscript: "andor(pk(A),sha256(H),and_v(v:pk(B),after(100)))",
+			needsSig:  true,
+		},
+		{
+			policy:    "or(99@pk(A),1@and(pk(B),older(144)))",
+			segwitV0:  "or_d(pk(A),and_v(v:pk(B),older(144)))",
+			tapscript: "or_d(pk(A),and_v(v:pk(B),older(144)))",
+			needsSig:  true,
+		},
+		{
+			policy:    "thresh(2,pk(A),pk(B),pk(C))",
+			segwitV0:  "multi(2,A,B,C)",
+			tapscript: "multi_a(2,A,B,C)",
+			needsSig:  true,
+		},
+		{
+			policy:    "thresh(2,pk(A),pk(B),after(50))",
+			segwitV0:  "thresh(2,pk(A),s:pk(B),sndv:after(50))",
+			tapscript: "thresh(2,pk(A),s:pk(B),sdv:after(50))",
+			needsSig:  true,
+		},
+	}
+	for _, test := range tests {
+		policy, err := ParsePolicy(test.policy, e.env)
+		assert.NoError(t, err)
+		assert.Equal(t, test.policy, policy.String())
+
+		for ctx, expected := range map[Context]string{SegwitV0: test.segwitV0, Tapscript: test.tapscript} {
+			node, err := Compile(policy, ctx)
+			assert.NoError(t, err, "%s in %s", test.policy, ctx)
+			assert.Equal(t, expected, node.String(), "%s in %s", test.policy, ctx)
+
+			analysis := node.Analyze()
+			assert.Equal(t, "B", analysis.Type)
+			assert.True(t, analysis.NonMalleable)
+			assert.Equal(t, test.needsSig, analysis.NeedsSignature, "%s in %s", test.policy, ctx)
+			assert.Positive(t, analysis.ScriptSize)
+			assert.Positive(t, analysis.MaxSatSize)
+		}
+	}
+
+	// keys are pushed as x-only keys in tapscript, so the same policy is smaller
+	policy, err := ParsePolicy("thresh(2,pk(A),pk(B),pk(C))", e.env)
+	assert.NoError(t, err)
+	v0, err := Compile(policy, SegwitV0)
+	assert.NoError(t, err)
+	tap, err := Compile(policy, Tapscript)
+	assert.NoError(t, err)
+	assert.Equal(t, 1+3*34+1+1, v0.Analyze().ScriptSize)
+	assert.Equal(t, 3*33+3+1+1, tap.Analyze().ScriptSize)
+	assert.Less(t, tap.Analyze().MaxSatSize, v0.Analyze().MaxSatSize)
+
+	// any other 32 bytes dissatisfy a hash, so a third party could swap the dissatisfaction of a hash in a threshold
+	// and a hash alone can be satisfied by anyone who sees its preimage
+	for _, malleable := range []string{"thresh(2,pk(A),sha256(H),after(50))", "or(sha256(H),sha256(G))"} {
+		policy, err = ParsePolicy(malleable, e.env)
+		assert.NoError(t, err)
+		_, err = Compile(policy, SegwitV0)
+		assert.Error(t, err, malleable)
+	}
+
+	// a transaction has one lock time and one sequence, so timelocks in blocks and in time that must both be met never are
+	for _, mixed := range []string{
+		"and(after(100),after(500000000))",
+		"and(pk(A),and(older(144),older(4194305)))",
+		"thresh(2,pk(A),after(100),after(500000000))",
+	} {
+		policy, err = ParsePolicy(mixed, e.env)
+		assert.NoError(t, err)
+		for _, ctx := range []Context{SegwitV0, Tapscript} {
+			_, err = Compile(policy, ctx)
+			assert.ErrorContains(t, err, "timelocks in blocks and in time", "%s in %s", mixed, ctx)
+		}
+	}
+	// only one branch of an or is met
+	policy, err = ParsePolicy("or(and(pk(A),after(100)),and(pk(B),after(500000000)))", e.env)
+	assert.NoError(t, err)
+	_, err = Compile(policy, SegwitV0)
+	assert.NoError(t, err)
+
+	for _, invalid := range []string{
+		"pk(D)",
+		"pk(A",
+		"and(pk(A))",
+		"thresh(3,pk(A),pk(B))",
+		"after(0)",
+		"sha256(00)",
+		"and(1@pk(A),pk(B))",
+		"xor(pk(A),pk(B))",
+		"pk(A)pk(B)",
+	} {
+		_, err := ParsePolicy(invalid, e.env)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// go test -v -run ^TestSatisfyPolicy$ github.com/nghuyenthevinh2000/bitcoin-playground/miniscript
+func TestSatisfyPolicy(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+	e := newTestEnv(&s)
+
+	for _, ctx := range []Context{SegwitV0, Tapscript} {
+		// the ball game: Alice wins with the hash of "VN wins", Bob with the hash of "TL wins"
+		policy, err := ParsePolicy("or(and(pk(A),sha256(H)),and(pk(B),sha256(G)))", e.env)
+		assert.NoError(t, err)
+		node, err := Compile(policy, ctx)
+		assert.NoError(t, err)
+		e.validateSpend(&s, node, 1, []string{"A"}, []string{"H"})
+		e.validateSpend(&s, node, 1, []string{"B"}, []string{"G"})
+		_, err = node.Satisfy(NewSatisfier(ctx, 1, 0).AddSignature(e.env.Keys["A"], make([]byte, 64)).AddPreimage(e.preimages["G"]))
+		assert.Error(t, err)
+
+		// Bob can only take the funds back after block 100
+		policy, err = ParsePolicy("or(and(pk(A),sha256(H)),and(pk(B),after(100)))", e.env)
+		assert.NoError(t, err)
+		node, err = Compile(policy, ctx)
+		assert.NoError(t, err)
+		e.validateSpend(&s, node, 1, []string{"A"}, []string{"H"})
+		e.validateSpend(&s, node, 100, []string{"B"}, nil)
+		_, err = node.Satisfy(NewSatisfier(ctx, 99, 0).AddSignature(e.env.Keys["B"], make([]byte, 64)))
+		assert.Error(t, err)
+		_, err = node.Satisfy(NewSatisfier(ctx, 100, wire.MaxTxInSequenceNum).AddSignature(e.env.Keys["B"], make([]byte, 64)))
+		assert.Error(t, err)
+
+		// 2 of 3 keys
+		policy, err = ParsePolicy("thresh(2,pk(A),pk(B),pk(C))", e.env)
+		assert.NoError(t, err)
+		node, err = Compile(policy, ctx)
+		assert.NoError(t, err)
+		e.validateSpend(&s, node, 1, []string{"A", "C"}, nil)
+		e.validateSpend(&s, node, 1, []string{"C", "B"}, nil)
+		e.validateSpend(&s, node, 1, []string{"A", "B", "C"}, nil)
+
+		// 2 of 2 keys, or either key after block 50
+		policy, err = ParsePolicy("thresh(2,pk(A),pk(B),after(50))", e.env)
+		assert.NoError(t, err)
+		node, err = Compile(policy, ctx)
+		assert.NoError(t, err)
+		e.validateSpend(&s, node, 1, []string{"A", "B"}, nil)
+		e.validateSpend(&s, node, 50, []string{"A"}, nil)
+		e.validateSpend(&s, node, 50, []string{"B"}, nil)
+		_, err = node.Satisfy(NewSatisfier(ctx, 49, 0).AddSignature(e.env.Keys["B"], make([]byte, 64)))
+		assert.Error(t, err)
+
+		// relative timelocks are checked against the sequence of the input
+		policy, err = ParsePolicy("or(99@pk(A),1@and(pk(B),older(144)))", e.env)
+		assert.NoError(t, err)
+		node, err = Compile(policy, ctx)
+		assert.NoError(t, err)
+		e.validateSpend(&s, node, 1, []string{"A"}, nil)
+		_, err = node.Satisfy(NewSatisfier(ctx, 0, 143).AddSignature(e.env.Keys["B"], make([]byte, 64)))
+		assert.Error(t, err)
+		witness, err := node.Satisfy(NewSatisfier(ctx, 0, 144).AddSignature(e.env.Keys["B"], make([]byte, 64)))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(witness))
+		// signatures of the other context do not fit
+		other_ctx := Tapscript
+		if ctx == Tapscript {
+			other_ctx = SegwitV0
+		}
+		_, err = node.Satisfy(NewSatisfier(other_ctx, 0, 144).AddSignature(e.env.Keys["B"], make([]byte, 64)))
+		assert.ErrorContains(t, err, "signatures are for")
+	}
+
+	// in segwit v0 a key and its negation are different keys, though they have the same x-only key
+	policy, err := ParsePolicy("pk(A)", e.env)
+	assert.NoError(t, err)
+	node, err := Compile(policy, SegwitV0)
+	assert.NoError(t, err)
+	negated_bytes := e.env.Keys["A"].SerializeCompressed()
+	negated_bytes[0] ^= 1
+	negated, err := btcec.ParsePubKey(negated_bytes)
+	assert.NoError(t, err)
+	_, err = node.Satisfy(NewSatisfier(SegwitV0, 0, 0).AddSignature(negated, make([]byte, 72)))
+	assert.Error(t, err)
+	e.validateSpend(&s, node, 1, []string{"A"}, nil)
+}
+
+// go test -v -run ^TestSatisfyNonMalleable$ github.com/nghuyenthevinh2000/bitcoin-playground/miniscript
+func TestSatisfyNonMalleable(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+	e := newTestEnv(&s)
+
+	sig := signed(make([]byte, 64))
+	small_sig := signed(make([]byte, 8))
+	empty := push([]byte{})
+
+	// a third party can always drop a signature for a solution without one
+	assert.Equal(t, empty, choose(sig, empty))
+	assert.Equal(t, empty, choose(empty, sig))
+	// between signed solutions, a non malleable one is taken before a smaller one
+	assert.Equal(t, sig, choose(malleable(small_sig), sig))
+	assert.Equal(t, small_sig, choose(small_sig, sig))
+	// without signatures, either solution can be swapped for the other
+	assert.True(t, choose(empty, push()).malleable)
+
+	// anyone who sees both preimages can take either branch, so such a satisfaction is refused
+	sha256Node := func(name string) *Node {
+		node := &Node{Fragment: "sha256", Hash: &Hash{Name: name, Value: e.env.Hashes[name]}, ctx: Tapscript}
+		assert.NoError(t, node.check())
+		return node
+	}
+	node, err := newNode(Tapscript, "or_i", sha256Node("H"), sha256Node("G"))
+	assert.NoError(t, err)
+	assert.False(t, node.Analyze().NonMalleable)
+	witness, err := node.Satisfy(NewSatisfier(Tapscript, 0, 0).AddPreimage(e.preimages["H"]))
+	assert.NoError(t, err)
+	assert.Equal(t, wire.TxWitness{e.preimages["H"], {1}}, witness)
+	_, err = node.Satisfy(NewSatisfier(Tapscript, 0, 0).AddPreimage(e.preimages["H"]).AddPreimage(e.preimages["G"]))
+	assert.ErrorContains(t, err, "malleable")
+
+	// with both keys and the timelock, the smallest satisfaction of 2 of 3 is taken, and it is not malleable
+	policy, err := ParsePolicy("thresh(2,pk(A),pk(B),after(50))", e.env)
+	assert.NoError(t, err)
+	node, err = Compile(policy, Tapscript)
+	assert.NoError(t, err)
+	a_sig, b_sig := make([]byte, 64), make([]byte, 64)
+	a_sig[0], b_sig[0] = 'A', 'B'
+	witness, err = node.Satisfy(NewSatisfier(Tapscript, 50, 0).AddSignature(e.env.Keys["A"], a_sig).AddSignature(e.env.Keys["B"], b_sig))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(witness))
+	assert.Equal(t, a_sig, witness[2])
+}
@@ -0,0 +1,276 @@
+package miniscript
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// spending policy, as written by people
+//
+//	pk(NAME)                       signature of a key
+//	after(N), older(N)             absolute and relative timelocks
+//	sha256(NAME)                   preimage of a hash
+//	and(X,Y)                       both
+//	or([W@]X,[W@]Y)                either, W is how likely each branch is spent, 1 by default
+//	thresh(K,X_1,...,X_n)          at least K of them
+//
+// names of keys and hashes are resolved against an environment, or parsed as hex
+type Policy struct {
+	Kind string
+	Key  *Key
+	Hash *Hash
+	// lock value of a timelock, threshold of thresh
+	Value   int64
+	Subs    []*Policy
+	Weights []int64
+}
+
+type Key struct {
+	Name string
+	Pub  *btcec.PublicKey
+}
+
+type Hash struct {
+	Name  string
+	Value [32]byte
+}
+
+// keys and hashes that a policy refers to by name
+type Env struct {
+	Keys   map[string]*btcec.PublicKey
+	Hashes map[string][32]byte
+}
+
+func ParsePolicy(policy string, env *Env) (*Policy, error) {
+	p := &parser{input: strings.Join(strings.Fields(policy), ""), env: env}
+	node, err := p.parsePolicy()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.input[p.pos:], p.pos)
+	}
+
+	return node, nil
+}
+
+func (p *Policy) String() string {
+	switch p.Kind {
+	case "pk":
+		return fmt.Sprintf("pk(%s)", p.Key)
+	case "after", "older":
+		return fmt.Sprintf("%s(%d)", p.Kind, p.Value)
+	case "sha256":
+		return fmt.Sprintf("sha256(%s)", p.Hash)
+	}
+
+	args := make([]string, 0, len(p.Subs)+1)
+	if p.Kind == "thresh" {
+		args = append(args, strconv.FormatInt(p.Value, 10))
+	}
+	weighted := p.Kind == "or" && (p.Weights[0] != 1 || p.Weights[1] != 1)
+	for i, sub := range p.Subs {
+		if weighted {
+			args = append(args, fmt.Sprintf("%d@%s", p.Weights[i], sub))
+			continue
+		}
+		args = append(args, sub.String())
+	}
+
+	return fmt.Sprintf("%s(%s)", p.Kind, strings.Join(args, ","))
+}
+
+func (k *Key) String() string {
+	if k.Name != "" {
+		return k.Name
+	}
+
+	return hex.EncodeToString(k.Pub.SerializeCompressed())
+}
+
+func (h *Hash) String() string {
+	if h.Name != "" {
+		return h.Name
+	}
+
+	return hex.EncodeToString(h.Value[:])
+}
+
+type parser struct {
+	input string
+	pos   int
+	env   *Env
+}
+
+// name(arg,...)
+func (p *parser) parsePolicy() (*Policy, error) {
+	kind := p.parseAtom()
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{Kind: kind}
+	var err error
+	switch kind {
+	case "pk":
+		policy.Key, err = p.parseKey(p.parseAtom())
+	case "after", "older":
+		policy.Value, err = p.parseLockValue(p.parseAtom())
+	case "sha256":
+		policy.Hash, err = p.parseHash(p.parseAtom())
+	case "and":
+		policy.Subs, err = p.parseSubs()
+		if err == nil && len(policy.Subs) != 2 {
+			err = fmt.Errorf("and takes 2 policies, got %d", len(policy.Subs))
+		}
+	case "or":
+		policy.Subs, policy.Weights, err = p.parseWeightedSubs()
+		if err == nil && len(policy.Subs) != 2 {
+			err = fmt.Errorf("or takes 2 policies, got %d", len(policy.Subs))
+		}
+		for i, weight := range policy.Weights {
+			if weight == 0 {
+				policy.Weights[i] = 1
+			}
+		}
+	case "thresh":
+		policy.Value, err = strconv.ParseInt(p.parseAtom(), 10, 64)
+		if err == nil {
+			err = p.expect(',')
+		}
+		if err == nil {
+			policy.Subs, err = p.parseSubs()
+		}
+		if err == nil && (policy.Value < 1 || policy.Value > int64(len(policy.Subs))) {
+			err = fmt.Errorf("thresh %d is out of range of %d policies", policy.Value, len(policy.Subs))
+		}
+	default:
+		err = fmt.Errorf("unknown policy %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (p *parser) parseSubs() ([]*Policy, error) {
+	subs, weights, err := p.parseWeightedSubs()
+	if err != nil {
+		return nil, err
+	}
+	for _, weight := range weights {
+		if weight != 0 {
+			return nil, fmt.Errorf("only or takes weights")
+		}
+	}
+
+	return subs, nil
+}
+
+// policies separated by commas, each with an optional W@ weight, 0 if not given
+func (p *parser) parseWeightedSubs() ([]*Policy, []int64, error) {
+	subs := make([]*Policy, 0)
+	weights := make([]int64, 0)
+	for {
+		weight := int64(0)
+		if at := strings.IndexByte(p.input[p.pos:], '@'); at > 0 && !strings.ContainsAny(p.input[p.pos:p.pos+at], "(),") {
+			var err error
+			weight, err = strconv.ParseInt(p.input[p.pos:p.pos+at], 10, 64)
+			if err != nil || weight < 1 {
+				return nil, nil, fmt.Errorf("invalid weight %q at %d", p.input[p.pos:p.pos+at], p.pos)
+			}
+			p.pos += at + 1
+		}
+		sub, err := p.parsePolicy()
+		if err != nil {
+			return nil, nil, err
+		}
+		subs = append(subs, sub)
+		weights = append(weights, weight)
+
+		if p.pos >= len(p.input) || p.input[p.pos] != ',' {
+			return subs, weights, nil
+		}
+		p.pos++
+	}
+}
+
+// characters up to the next delimiter
+func (p *parser) parseAtom() string {
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune("(),", rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	if p.pos >= len(p.input) || p.input[p.pos] != c {
+		return fmt.Errorf("expected %q at %d", c, p.pos)
+	}
+	p.pos++
+
+	return nil
+}
+
+// a name in the environment, or a hex compressed or x-only key
+func (p *parser) parseKey(name string) (*Key, error) {
+	if p.env != nil {
+		if pub, ok := p.env.Keys[name]; ok {
+			return &Key{Name: name, Pub: pub}, nil
+		}
+	}
+
+	key_bytes, err := hex.DecodeString(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown key %q", name)
+	}
+	var pub *btcec.PublicKey
+	if len(key_bytes) == 32 {
+		pub, err = schnorr.ParsePubKey(key_bytes)
+	} else {
+		pub, err = btcec.ParsePubKey(key_bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %w", name, err)
+	}
+
+	return &Key{Pub: pub}, nil
+}
+
+// a name in the environment, or a hex sha256 hash
+func (p *parser) parseHash(name string) (*Hash, error) {
+	if p.env != nil {
+		if value, ok := p.env.Hashes[name]; ok {
+			return &Hash{Name: name, Value: value}, nil
+		}
+	}
+
+	hash_bytes, err := hex.DecodeString(name)
+	if err != nil || len(hash_bytes) != 32 {
+		return nil, fmt.Errorf("unknown hash %q", name)
+	}
+
+	return &Hash{Value: ([32]byte)(hash_bytes)}, nil
+}
+
+// timelocks are positive and fit in 31 bits, so that they are never negative script numbers
+func (p *parser) parseLockValue(value string) (int64, error) {
+	lock, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || lock < 1 || lock >= 1<<31 {
+		return 0, fmt.Errorf("invalid timelock %q", value)
+	}
+
+	return lock, nil
+}
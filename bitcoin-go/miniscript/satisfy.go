@@ -0,0 +1,326 @@
+package miniscript
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// signatures, preimages and timelocks that a spender has
+// lock time and sequence are those of the spending transaction and input, so that only timelocks that they meet are used
+// signatures are of the script context, ECDSA in segwit v0 and schnorr in tapscript
+type Satisfier struct {
+	Context  Context
+	LockTime uint32
+	Sequence uint32
+
+	// by the key as it is pushed in script of the context
+	sigs      map[string][]byte
+	preimages map[[32]byte][]byte
+}
+
+func NewSatisfier(ctx Context, lock_time, sequence uint32) *Satisfier {
+	return &Satisfier{
+		Context:   ctx,
+		LockTime:  lock_time,
+		Sequence:  sequence,
+		sigs:      make(map[string][]byte),
+		preimages: make(map[[32]byte][]byte),
+	}
+}
+
+// a signature of key in the script context, with its sighash type byte
+func (s *Satisfier) AddSignature(key *btcec.PublicKey, sig []byte) *Satisfier {
+	s.sigs[string(s.Context.keyBytes(&Key{Pub: key}))] = sig
+	return s
+}
+
+func (s *Satisfier) AddPreimage(preimage []byte) *Satisfier {
+	s.preimages[sha256.Sum256(preimage)] = preimage
+	return s
+}
+
+func (s *Satisfier) signature(key *Key) ([]byte, bool) {
+	sig, ok := s.sigs[string(s.Context.keyBytes(key))]
+	return sig, ok
+}
+
+// lock time of the transaction is final from after, in the same unit
+func (s *Satisfier) checkAfter(after int64) bool {
+	if s.Sequence == wire.MaxTxInSequenceNum {
+		return false
+	}
+	if (after < txscript.LockTimeThreshold) != (int64(s.LockTime) < txscript.LockTimeThreshold) {
+		return false
+	}
+
+	return after <= int64(s.LockTime)
+}
+
+// relative lock time of the input is at least older, in the same unit
+func (s *Satisfier) checkOlder(older int64) bool {
+	if s.Sequence&wire.SequenceLockTimeDisabled != 0 {
+		return false
+	}
+	if uint32(older)&wire.SequenceLockTimeIsSeconds != s.Sequence&wire.SequenceLockTimeIsSeconds {
+		return false
+	}
+
+	return uint32(older)&wire.SequenceLockTimeMask <= s.Sequence&wire.SequenceLockTimeMask
+}
+
+// a witness stack, its bottom first
+// a solution without a signature can be replayed by anyone, and a malleable one can be changed by a third party into another valid one
+type solution struct {
+	stack     [][]byte
+	hasSig    bool
+	malleable bool
+}
+
+func (s *solution) size() int {
+	size := 0
+	for _, item := range s.stack {
+		size += 1 + len(item)
+	}
+
+	return size
+}
+
+// stack of a script whose first part runs on top of the stack, then its second part
+// so the items of the first part are above those of the second part
+func seq(first, second *solution) *solution {
+	if first == nil || second == nil {
+		return nil
+	}
+	stack := make([][]byte, 0, len(first.stack)+len(second.stack))
+	stack = append(stack, second.stack...)
+	stack = append(stack, first.stack...)
+
+	return &solution{stack: stack, hasSig: first.hasSig || second.hasSig, malleable: first.malleable || second.malleable}
+}
+
+func push(items ...[]byte) *solution {
+	return &solution{stack: items}
+}
+
+// a solution with the signature of a key
+func signed(items ...[]byte) *solution {
+	return &solution{stack: items, hasSig: true}
+}
+
+func malleable(s *solution) *solution {
+	if s == nil {
+		return nil
+	}
+	res := *s
+	res.malleable = true
+
+	return &res
+}
+
+// a solution for a choice of two, as the reference miniscript satisfier picks it
+// a third party can always swap a solution with a signature for one without, so the one without is taken
+// if neither has a signature, either can be swapped for the other, so the result is malleable
+// otherwise a non malleable solution is taken before a smaller one
+func choose(a, b *solution) *solution {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.hasSig != b.hasSig {
+		if b.hasSig {
+			return a
+		}
+		return b
+	}
+	if !a.hasSig {
+		a, b = malleable(a), malleable(b)
+	} else if a.malleable != b.malleable {
+		if b.malleable {
+			return a
+		}
+		return b
+	}
+	if b.size() < a.size() {
+		return b
+	}
+
+	return a
+}
+
+// witness stack that satisfies the script of n, without the script itself
+// of the available satisfactions, a non malleable one is taken before a smaller one
+// an error if the only satisfaction is malleable, since a third party could then change the spend
+func (n *Node) Satisfy(satisfier *Satisfier) (wire.TxWitness, error) {
+	if satisfier.Context != n.ctx {
+		return nil, fmt.Errorf("signatures are for %s, but %s is in %s", satisfier.Context, n, n.ctx)
+	}
+	sat, _ := n.satisfy(satisfier)
+	if sat == nil {
+		return nil, fmt.Errorf("%s cannot be satisfied with the available signatures, preimages and timelocks", n)
+	}
+	if sat.malleable {
+		return nil, fmt.Errorf("%s only has malleable satisfactions with the available signatures, preimages and timelocks", n)
+	}
+
+	return sat.stack, nil
+}
+
+// satisfaction and dissatisfaction of n, nil if unavailable
+func (n *Node) satisfy(satisfier *Satisfier) (*solution, *solution) {
+	args := make([][2]*solution, len(n.Args))
+	for i, arg := range n.Args {
+		sat, dissat := arg.satisfy(satisfier)
+		args[i] = [2]*solution{sat, dissat}
+	}
+
+	sat, dissat := n.satisfyFragment(satisfier, args)
+	// s: a satisfaction without a signature, and e: a dissatisfaction with one, are not the canonical ones
+	if n.typ.s && sat != nil && !sat.hasSig {
+		sat = malleable(sat)
+	}
+	if n.typ.e && dissat != nil && dissat.hasSig {
+		dissat = malleable(dissat)
+	}
+
+	return sat, dissat
+}
+
+func (n *Node) satisfyFragment(satisfier *Satisfier, args [][2]*solution) (*solution, *solution) {
+	ctx := n.ctx
+	switch n.Fragment {
+	case "pk_k":
+		if sig, ok := satisfier.signature(n.Keys[0]); ok {
+			return signed(sig), push([]byte{})
+		}
+		return nil, push([]byte{})
+	case "pk_h":
+		key := ctx.keyBytes(n.Keys[0])
+		if sig, ok := satisfier.signature(n.Keys[0]); ok {
+			return signed(sig, key), push([]byte{}, key)
+		}
+		return nil, push([]byte{}, key)
+	case "older":
+		if satisfier.checkOlder(n.Value) {
+			return push(), nil
+		}
+		return nil, nil
+	case "after":
+		if satisfier.checkAfter(n.Value) {
+			return push(), nil
+		}
+		return nil, nil
+	case "sha256":
+		// any other 32 bytes dissatisfy it as well
+		dissat := malleable(push(make([]byte, 32)))
+		if preimage, ok := satisfier.preimages[n.Hash.Value]; ok {
+			return push(preimage), dissat
+		}
+		return nil, dissat
+	case "multi":
+		// signatures in the order of their keys, above the dummy element of CHECKMULTISIG
+		sat := signed([]byte{})
+		for _, key := range n.Keys {
+			if int64(len(sat.stack)-1) == n.Value {
+				break
+			}
+			if sig, ok := satisfier.signature(key); ok {
+				sat.stack = append(sat.stack, sig)
+			}
+		}
+		dissat := push([]byte{})
+		for i := int64(0); i < n.Value; i++ {
+			dissat.stack = append(dissat.stack, []byte{})
+		}
+		if int64(len(sat.stack)-1) < n.Value {
+			sat = nil
+		}
+		return sat, dissat
+	case "multi_a":
+		// the first key is checked first, so its signature is at the top of the stack
+		sat, dissat := signed(), push()
+		signed_num := int64(0)
+		for range n.Keys {
+			dissat.stack = append(dissat.stack, []byte{})
+		}
+		sigs := make([][]byte, len(n.Keys))
+		for i, key := range n.Keys {
+			if signed_num == n.Value {
+				break
+			}
+			if sig, ok := satisfier.signature(key); ok {
+				sigs[i] = sig
+				signed_num++
+			}
+		}
+		for i := len(sigs) - 1; i >= 0; i-- {
+			if sigs[i] == nil {
+				sat.stack = append(sat.stack, []byte{})
+				continue
+			}
+			sat.stack = append(sat.stack, sigs[i])
+		}
+		if signed_num < n.Value {
+			sat = nil
+		}
+		return sat, dissat
+	case "and_v":
+		return seq(args[0][0], args[1][0]), nil
+	case "or_d":
+		sat := choose(args[0][0], seq(args[0][1], args[1][0]))
+		return sat, seq(args[0][1], args[1][1])
+	case "or_i":
+		// IF takes its condition from the top of the stack, above the branch
+		sat := choose(seq(push([]byte{1}), args[0][0]), seq(push([]byte{}), args[1][0]))
+		dissat := choose(seq(push([]byte{1}), args[0][1]), seq(push([]byte{}), args[1][1]))
+		return sat, dissat
+	case "andor":
+		sat := choose(seq(args[0][0], args[1][0]), seq(args[0][1], args[2][0]))
+		return sat, seq(args[0][1], args[2][1])
+	case "thresh":
+		return n.satisfyThresh(args)
+	case "a", "s", "c", "n":
+		return args[0][0], args[0][1]
+	case "d":
+		var sat *solution
+		if args[0][0] != nil {
+			sat = seq(push([]byte{1}), args[0][0])
+		}
+		return sat, push([]byte{})
+	case "v":
+		return args[0][0], nil
+	}
+
+	return nil, nil
+}
+
+// satisfy exactly k arguments, and dissatisfy the others
+// the first argument runs first, so its items are at the top of the stack
+// sats[j] is the best solution of the arguments so far with j of them satisfied
+func (n *Node) satisfyThresh(args [][2]*solution) (*solution, *solution) {
+	sats := []*solution{push()}
+	for _, arg := range args {
+		next := make([]*solution, len(sats)+1)
+		next[0] = seq(sats[0], arg[1])
+		for j := 1; j < len(sats); j++ {
+			next[j] = choose(seq(sats[j], arg[1]), seq(sats[j-1], arg[0]))
+		}
+		next[len(sats)] = seq(sats[len(sats)-1], arg[0])
+		sats = next
+	}
+
+	// only dissatisfying every argument is canonical, a third party can swap any other dissatisfaction for it
+	dissat := sats[0]
+	for j := 1; j < len(sats); j++ {
+		if int64(j) != n.Value {
+			dissat = choose(dissat, malleable(sats[j]))
+		}
+	}
+
+	return sats[n.Value], dissat
+}
@@ -10,10 +10,10 @@ import (
 	btcec "github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
-	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btclog"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/taproot"
 	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
 	"github.com/stretchr/testify/assert"
 )
@@ -95,70 +95,51 @@ func TestLinearTaprootMuSig(t *testing.T) {
 
 	// STEP 1: BUILDING LINEAR MULTISIG SCRIPT
 	// OP_CHECKSIGADD is only available in Taproot
-	builder := txscript.NewScriptBuilder()
-	// sig_1 will be verified against pub_1, if valid then 1, else 0
+	// the taproot.Multi leaf builds <pub_1> OP_CHECKSIG <pub_2> OP_CHECKSIGADD <pub_3> OP_CHECKSIGADD OP_2 OP_NUMEQUAL
 	// use schnorr pubkey for taproot OP_CHECKSIG
+	// sig_1 will be verified against pub_1, if valid then 1, else 0
 	// pub hex: 2bc2cfa7264c496a23e3f735b2bb586ea7ace3953fb5556206a68f744406fc45
 	// priv hex: 4ec4caf5e470d93f2b76847543052200909762bd547b82454751193968992f52
-	builder.AddData(schnorr.SerializePubKey(pair_1.Pub))
-	builder.AddOp(txscript.OP_CHECKSIG)
 	// sig_2 will be verified against pub_2, if valid then +1, else +0
 	// pub hex: d9b98ef2c580416ca828801f5c3d0afb81b10639f5b5a1abe81dad89af7779af
 	// priv hex: 5cb063214fff61270093e7959f2778255dd6c633425be5977c2347ecb7515938
-	builder.AddData(schnorr.SerializePubKey(pair_2.Pub))
-	builder.AddOp(txscript.OP_CHECKSIGADD)
 	// sig_3 will be verified against pub_3, if valid then +1, else +0
 	// pub hex: e0fce228941493bb4dbab3c38e9b762dc0c500b0d4f5485734efd39a37dfd067
 	// priv hex: 9bfa621ce43f4a0ba1961b01508fc8d17a83f36aa94a6ef5bb98cd1387dacad4
-	builder.AddData(schnorr.SerializePubKey(pair_3.Pub))
-	builder.AddOp(txscript.OP_CHECKSIGADD)
 	// it will check if the sum of the results is equal to 2 thus satisfying 2/3 multisig
-	builder.AddOp(txscript.OP_2)
-	builder.AddOp(txscript.OP_EQUAL)
-	pkScript, err := builder.Script()
-	assert.Nil(t, err)
+	// the script used to end with OP_2 OP_EQUAL, OP_NUMEQUAL is the BIP 342 form of multi_a and compares the sum as a number
+	multi := &taproot.Multi{
+		Keys:      []*btcec.PublicKey{pair_1.Pub, pair_2.Pub, pair_3.Pub},
+		Threshold: 2,
+	}
 
 	// STEP 2: CALCULATE TWEAKED PUBLIC KEY
 	// calculate tweak: create a taproot tree
-	// TODO: I am curious how btcd handle tree construction, should test with more tapleafs in another test
-	tapleaf := txscript.NewBaseTapLeaf(pkScript)
-	taptree := txscript.AssembleTaprootScriptTree(tapleaf)
-
-	// 202bc2cfa7264c496a23e3f735b2bb586ea7ace3953fb5556206a68f744406fc45ac7c20d9b98ef2c580416ca828801f5c3d0afb81b10639f5b5a1abe81dad89af7779afba7c20e0fce228941493bb4dbab3c38e9b762dc0c500b0d4f5485734efd39a37dfd067ba0287
-	// 202bc2cfa7264c496a23e3f735b2bb586ea7ace3953fb5556206a68f744406fc45ac7c20d9b98ef2c580416ca828801f5c3d0afb81b10639f5b5a1abe81dad89af7779afba7c20e0fce228941493bb4dbab3c38e9b762dc0c500b0d4f5485734efd39a37dfd067ba5287
-	fmt.Printf("tap script = %s\n", hex.EncodeToString(tapleaf.Script))
-
 	// get internal private key P
 	// pub hex: 7dec1d4eb66497d20ad3ce1a8f7e99d207e5dadf4a093a3dede664dd89d9ac10
 	_, internal_pair := s.NewHDKeyPairFromSeed(OMNIMAN_WALLET_SEED)
 	fmt.Printf("pub_internal: %s\n", s.BytesToHexStr(schnorr.SerializePubKey(internal_pair.Pub)))
 
 	// tr pub Q = P + t*G
-	taptreeRootCommitment := taptree.RootNode.TapHash()
-	fmt.Printf("Taproot tree: %s\n", hex.EncodeToString(taptreeRootCommitment[:]))
+	// TODO: I am curious how btcd handle tree construction, should test with more tapleafs in another test
+	// taproot.NewTree assembles its leaves with txscript.AssembleTaprootScriptTree, trees of more leaves are tested in taproot/tree_test.go
+	taptree, err := taproot.NewTree(internal_pair.Pub, multi)
+	assert.Nil(t, err)
 
-	q := txscript.ComputeTaprootOutputKey(internal_pair.Pub, taptreeRootCommitment[:])
-	assert.NotNil(t, q)
-	taproot, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(q), s.BtcdChainConfig)
+	// earlier dumps of this script, before the script ended with OP_2 OP_EQUAL and with OP_2 OP_EQUAL
+	// 202bc2cfa7264c496a23e3f735b2bb586ea7ace3953fb5556206a68f744406fc45ac7c20d9b98ef2c580416ca828801f5c3d0afb81b10639f5b5a1abe81dad89af7779afba7c20e0fce228941493bb4dbab3c38e9b762dc0c500b0d4f5485734efd39a37dfd067ba0287
+	// 202bc2cfa7264c496a23e3f735b2bb586ea7ace3953fb5556206a68f744406fc45ac7c20d9b98ef2c580416ca828801f5c3d0afb81b10639f5b5a1abe81dad89af7779afba7c20e0fce228941493bb4dbab3c38e9b762dc0c500b0d4f5485734efd39a37dfd067ba5287
+	// current script, ending with OP_2 OP_NUMEQUAL
+	// 202bc2cfa7264c496a23e3f735b2bb586ea7ace3953fb5556206a68f744406fc45ac20d9b98ef2c580416ca828801f5c3d0afb81b10639f5b5a1abe81dad89af7779afba20e0fce228941493bb4dbab3c38e9b762dc0c500b0d4f5485734efd39a37dfd067ba529c
+	fmt.Printf("tap script = %s\n", hex.EncodeToString(taptree.TapLeaf(0).Script))
+	fmt.Printf("Taproot tree: %s\n", hex.EncodeToString(taptree.MerkleRoot()))
+	address, err := taptree.Address(s.BtcdChainConfig)
 	assert.Nil(t, err)
-	fmt.Printf("Taproot address: %s\n", taproot.String())
+	fmt.Printf("Taproot address: %s\n", address.String())
 
 	// STEP 3: CREATE WITNESS FOR EVALUATION
 	// OP_1 to signify SegWit v1: Taproot
-	p2trScript, err := txscript.
-		NewScriptBuilder().
-		AddOp(txscript.OP_1).
-		AddData(schnorr.SerializePubKey(q)).
-		Script()
-	assert.Nil(t, err)
-
-	// create control block
-	// get inclusion proof of tapleaf at position 0
-	inclusionProof := taptree.LeafMerkleProofs[0]
-	controlBlock := inclusionProof.ToControlBlock(
-		internal_pair.Pub,
-	)
-	controlBlockBytes, err := controlBlock.ToBytes()
+	p2trScript, err := taptree.PkScript()
 	assert.Nil(t, err)
 
 	// enable tracing to see how the script is executed
@@ -168,21 +149,20 @@ func TestLinearTaprootMuSig(t *testing.T) {
 	txscript.UseLogger(testLog)
 
 	s.ValidateScript(p2trScript, 1, func(t assert.TestingT, prevOut *wire.TxOut, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int) wire.TxWitness {
-		sig_1 := []byte{}
-
-		sig_2, err := txscript.RawTxInTapscriptSignature(tx, sigHashes, idx, prevOut.Value, p2trScript, tapleaf, txscript.SigHashDefault, pair_2.GetTestPriv())
+		sig_2, err := taptree.SignLeaf(tx, sigHashes, idx, prevOut, 0, txscript.SigHashDefault, pair_2.GetTestPriv())
 		assert.Nil(t, err)
 
-		sig_3, err := txscript.RawTxInTapscriptSignature(tx, sigHashes, idx, prevOut.Value, p2trScript, tapleaf, txscript.SigHashDefault, pair_3.GetTestPriv())
+		sig_3, err := taptree.SignLeaf(tx, sigHashes, idx, prevOut, 0, txscript.SigHashDefault, pair_3.GetTestPriv())
 		assert.Nil(t, err)
 
 		// first participant should be nil
 		// to satisfy 2/3 multisig
 		// the witness data will be pushed onto a stack
 		// sig_3 will be at bottom, then sig_2, then sig_1 on top
-		witness := wire.TxWitness{
-			sig_3, sig_2, sig_1, pkScript, controlBlockBytes,
-		}
+		// Witness appends the script and the control block, the inclusion proof of tapleaf at position 0
+		satisfier := taproot.NewSatisfier().AddSignature(pair_2.Pub, sig_2).AddSignature(pair_3.Pub, sig_3)
+		witness, err := taptree.Witness(0, satisfier)
+		assert.Nil(t, err)
 
 		return witness
 	})
@@ -207,56 +187,29 @@ func TestSubsetTaprootMuSig(t *testing.T) {
 		{pair_2.Pub, pair_3.Pub},
 	}
 
-	var tapLeaf []txscript.TapLeaf
-
 	// STEP 1: BUILDING SUBSET MULTISIG SCRIPT
+	// each subset of 2/3 multisig is a leaf of its MuSig2 aggregated key
+	var tapLeaf []taproot.Leaf
 	for i := 0; i < len(subset); i++ {
-		// create subset of 2/3 multisig
-		aggrPub, _, _, err := musig2.AggregateKeys(subset[i], false)
-		assert.Nil(s.T, err)
-
-		builder := txscript.NewScriptBuilder()
-		builder.AddData(schnorr.SerializePubKey(aggrPub.FinalKey))
-		//
-		builder.AddOp(txscript.OP_CHECKSIG)
-		pkScript, err := builder.Script()
-		assert.Nil(t, err)
-		tapLeaf = append(tapLeaf, txscript.NewBaseTapLeaf(pkScript))
+		tapLeaf = append(tapLeaf, &taproot.MuSig2Subset{Keys: subset[i]})
 	}
 
 	// STEP 2: CALCULATE TWEAKED PUBLIC KEY Q
 	// calculate tweak: create a taproot tree
-	taptree := txscript.AssembleTaprootScriptTree(tapLeaf...)
-
 	// get internal private key P
 	_, internal_pair := s.NewHDKeyPairFromSeed(OMNIMAN_WALLET_SEED)
 
 	// tr pub Q = P + t*G
-	taptreeRootCommitment := taptree.RootNode.TapHash()
-	fmt.Printf("Taproot tree: %s\n", hex.EncodeToString(taptreeRootCommitment[:]))
-
-	q := txscript.ComputeTaprootOutputKey(internal_pair.Pub, taptreeRootCommitment[:])
-	assert.NotNil(t, q)
-	taproot, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(q), s.BtcdChainConfig)
+	taptree, err := taproot.NewTree(internal_pair.Pub, tapLeaf...)
+	assert.Nil(t, err)
+	fmt.Printf("Taproot tree: %s\n", hex.EncodeToString(taptree.MerkleRoot()))
+	address, err := taptree.Address(s.BtcdChainConfig)
 	assert.Nil(t, err)
-	fmt.Printf("Taproot address: %s\n", taproot.String())
+	fmt.Printf("Taproot address: %s\n", address.String())
 
 	// STEP 3: CREATE WITNESS FOR EVALUATION
 	// OP_1 to signify SegWit v1: Taproot
-	p2trScript, err := txscript.
-		NewScriptBuilder().
-		AddOp(txscript.OP_1).
-		AddData(schnorr.SerializePubKey(q)).
-		Script()
-	assert.Nil(t, err)
-
-	// create control block
-	// get inclusion proof of tapleaf at position 1
-	inclusionProof := taptree.LeafMerkleProofs[1]
-	controlBlock := inclusionProof.ToControlBlock(
-		internal_pair.Pub,
-	)
-	controlBlockBytes, err := controlBlock.ToBytes()
+	p2trScript, err := taptree.PkScript()
 	assert.Nil(t, err)
 
 	// enable tracing to see how the script is executed
@@ -275,22 +228,18 @@ func TestSubsetTaprootMuSig(t *testing.T) {
 		assert.Nil(s.T, err)
 
 		// construct MuSig2 aggregated signature
-		// calculating sighash
-		inputFetcher := txscript.NewCannedPrevOutputFetcher(
-			prevOut.PkScript,
-			prevOut.Value,
-		)
+		// calculating sighash of tapleaf at position 1
 		hType := txscript.SigHashDefault
-		sigHash, err := txscript.CalcTapscriptSignaturehash(sigHashes, hType, tx, idx, inputFetcher, tapLeaf[1])
+		sigHash, err := taptree.LeafSigHash(tx, sigHashes, idx, prevOut, 1, hType)
 		assert.Nil(t, err)
 
 		// generate partial signatures for each participant
 		// sign already negates nonce with odd y - value
 		// s1, R
-		ps_1, err := musig2.Sign(nonce_1.SecNonce, pair_1.GetTestPriv(), aggrNonces, subset[1], ([32]byte)(sigHash))
+		ps_1, err := musig2.Sign(nonce_1.SecNonce, pair_1.GetTestPriv(), aggrNonces, subset[1], sigHash)
 		assert.Nil(t, err)
 		// s2, R
-		ps_2, err := musig2.Sign(nonce_2.SecNonce, pair_3.GetTestPriv(), aggrNonces, subset[1], ([32]byte)(sigHash))
+		ps_2, err := musig2.Sign(nonce_2.SecNonce, pair_3.GetTestPriv(), aggrNonces, subset[1], sigHash)
 		assert.Nil(t, err)
 
 		// aggregate partial signatures
@@ -302,14 +251,14 @@ func TestSubsetTaprootMuSig(t *testing.T) {
 		}
 
 		// basic check
-		aggrPub, _, _, err := musig2.AggregateKeys(subset[1], false)
+		aggrPub, err := tapLeaf[1].(*taproot.MuSig2Subset).AggregatedKey()
 		assert.Nil(t, err)
-		res := schnorrSig.Verify(sigHash[:], aggrPub.FinalKey)
+		res := schnorrSig.Verify(sigHash[:], aggrPub)
 		assert.True(t, res)
 
-		witness := wire.TxWitness{
-			schnorrSigBytes, tapLeaf[1].Script, controlBlockBytes,
-		}
+		// the combined signature is the signature of the aggregated key
+		witness, err := taptree.Witness(1, taproot.NewSatisfier().AddSignature(aggrPub, schnorrSigBytes))
+		assert.Nil(t, err)
 		return witness
 	})
 }
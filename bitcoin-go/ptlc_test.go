@@ -8,11 +8,11 @@ import (
 	"testing"
 
 	secp "github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/taproot"
 	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
-	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
@@ -147,41 +147,27 @@ func TestPTLC(t *testing.T) {
 	aggrKey := btcec.NewPublicKey(&aggr_key_point.X, &aggr_key_point.Y)
 
 	// B challenge to A
-	var tapLeafs []txscript.TapLeaf
 	// how the verification of secret a and point x works in script?
 	// in a way that she has to reveal a, but also not public?
 	// I don't fully understand PTLC yet
-	builder_1 := txscript.NewScriptBuilder()
-	// the challenge that B sets out for A
-	builder_1.AddData(schnorr.SerializePubKey(aggrKey))
-	builder_1.AddOp(txscript.OP_CHECKSIG)
-	pkScript_1, err := builder_1.Script()
-	assert.Nil(t, err)
-	tapLeafs = append(tapLeafs, txscript.NewBaseTapLeaf(pkScript_1))
-
+	// leaf 0 is the challenge that B sets out for A
+	// leaf 1 is the timeout of B, the spending tx must be locked until block 5
 	// how to set time value here?
 	// need to understand how OP_CHECKLOCKTIMEVERIFY works?
-	builder_2 := txscript.NewScriptBuilder()
-	builder_2.AddInt64(5)
-	builder_2.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
-	builder_2.AddOp(txscript.OP_DROP)
-	builder_2.AddData(schnorr.SerializePubKey(pair_2.Pub))
-	builder_2.AddOp(txscript.OP_CHECKSIG)
-	pkScript_2, err := builder_2.Script()
+	// leaf 1 is 5 OP_CHECKLOCKTIMEVERIFY OP_DROP <pub_2> OP_CHECKSIG
+	_, internal_pair := s.NewHDKeyPairFromSeed(OMNIMAN_WALLET_SEED)
+	tapTree, err := taproot.NewTree(internal_pair.Pub,
+		&taproot.SingleKey{Key: aggrKey},
+		&taproot.AbsoluteLock{LockTime: 5, Then: &taproot.SingleKey{Key: pair_2.Pub}},
+	)
 	assert.Nil(t, err)
-	tapLeafs = append(tapLeafs, txscript.NewBaseTapLeaf(pkScript_2))
-
-	tapTree := txscript.AssembleTaprootScriptTree(tapLeafs...)
 
 	// calculate tweaked public key
-	tapTreeCommitment := tapTree.RootNode.TapHash()
-	_, internal_pair := s.NewHDKeyPairFromSeed(OMNIMAN_WALLET_SEED)
-	q := txscript.ComputeTaprootOutputKey(internal_pair.Pub, tapTreeCommitment[:])
-	taproot, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(q), s.BtcdChainConfig)
+	taproot_address, err := tapTree.Address(s.BtcdChainConfig)
 	assert.Nil(t, err)
-	fmt.Printf("Taproot address: %s\n", taproot.String())
+	fmt.Printf("Taproot address: %s\n", taproot_address.String())
 
-	p2tr, err := txscript.NewScriptBuilder().AddOp(txscript.OP_1).AddData(schnorr.SerializePubKey(q)).Script()
+	p2tr, err := tapTree.PkScript()
 	assert.Nil(t, err)
 
 	// SCENARIO 1: Alice reveals the secret t to Bob, and claims the funds
@@ -195,24 +181,14 @@ func TestPTLC(t *testing.T) {
 	testLog.SetLevel(btclog.LevelTrace)
 	txscript.UseLogger(testLog)
 
-	// control block bytes for leaf 0
-	inclusionProof_0 := tapTree.LeafMerkleProofs[0]
-	controlBlock_0 := inclusionProof_0.ToControlBlock(internal_pair.Pub)
-	ctrlBlockBytes_0, err := controlBlock_0.ToBytes()
-	assert.Nil(t, err)
-
 	var alice_adaptor_sig []byte
 	var bob_adaptor_sig []byte
 	var alice_combined_sig []byte
 	var alice_secret []byte
 	s.ValidateScript(p2tr, 1, func(t assert.TestingT, prevOut *wire.TxOut, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int) wire.TxWitness {
-		// calculating sighash
-		inputFetcher := txscript.NewCannedPrevOutputFetcher(
-			prevOut.PkScript,
-			prevOut.Value,
-		)
+		// calculating sighash of leaf 0
 		hType := txscript.SigHashDefault
-		sigHash, err := txscript.CalcTapscriptSignaturehash(sigHashes, hType, tx, idx, inputFetcher, tapLeafs[0])
+		sigHash, err := tapTree.LeafSigHash(tx, sigHashes, idx, prevOut, 0, hType)
 		assert.Nil(t, err)
 
 		// STEP 1: Alice gives her adaptor signature to Bob (s_A', R, T)
@@ -259,9 +235,9 @@ func TestPTLC(t *testing.T) {
 		// precheck the signature
 		assert.True(t, combined_sig.Verify(sigHash[:], aggrKey))
 
-		witness := wire.TxWitness{
-			alice_combined_sig, tapLeafs[0].Script, ctrlBlockBytes_0,
-		}
+		// the combined signature is a signature of the aggregated key, followed by leaf 0 and its control block
+		witness, err := tapTree.Witness(0, taproot.NewSatisfier().AddSignature(aggrKey, alice_combined_sig))
+		assert.Nil(t, err)
 
 		return witness
 	})
@@ -288,20 +264,14 @@ func TestPTLC(t *testing.T) {
 	assert.Equal(t, alice_secret, recovered_secret.Serialize())
 
 	// SCENARIO 2: timeout and Bob retrieves his funds
-	// control block bytes for leaf 1
-	inclusionProof_1 := tapTree.LeafMerkleProofs[1]
-	controlBlock_1 := inclusionProof_1.ToControlBlock(internal_pair.Pub)
-	ctrlBlockBytes_1, err := controlBlock_1.ToBytes()
-	assert.Nil(t, err)
-
 	s.ValidateScript(p2tr, 10, func(t assert.TestingT, prevOut *wire.TxOut, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int) wire.TxWitness {
 		// Bob signature
-		sig, err := txscript.RawTxInTapscriptSignature(tx, sigHashes, idx, prevOut.Value, p2tr, tapLeafs[1], txscript.SigHashDefault, pair_2.GetTestPriv())
+		sig, err := tapTree.SignLeaf(tx, sigHashes, idx, prevOut, 1, txscript.SigHashDefault, pair_2.GetTestPriv())
 		assert.Nil(t, err)
 
-		witness := wire.TxWitness{
-			sig, tapLeafs[1].Script, ctrlBlockBytes_1,
-		}
+		// Witness appends leaf 1 and its control block
+		witness, err := tapTree.Witness(1, taproot.NewSatisfier().AddSignature(pair_2.Pub, sig))
+		assert.Nil(t, err)
 
 		return witness
 	})
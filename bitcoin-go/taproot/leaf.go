@@ -0,0 +1,279 @@
+package taproot
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// a leaf template compiles to a tapscript, and orders the witness stack that satisfies it
+// templates that take another leaf prefix its script with their own condition, so that they can be combined
+type Leaf interface {
+	// append the script of this leaf to builder
+	build(builder *txscript.ScriptBuilder) error
+	// witness stack of this leaf, the top of the stack last
+	witness(satisfier *Satisfier) (wire.TxWitness, error)
+}
+
+// signatures and preimages that a spender has, a leaf takes what it needs from them
+type Satisfier struct {
+	sigs      map[[32]byte][]byte
+	preimages map[[32]byte][]byte
+}
+
+func NewSatisfier() *Satisfier {
+	return &Satisfier{
+		sigs:      make(map[[32]byte][]byte),
+		preimages: make(map[[32]byte][]byte),
+	}
+}
+
+// a tapscript signature of key, with the sighash type byte if it is not SigHashDefault
+func (s *Satisfier) AddSignature(key *btcec.PublicKey, sig []byte) *Satisfier {
+	s.sigs[xOnlyKey(key)] = sig
+	return s
+}
+
+func (s *Satisfier) AddPreimage(preimage []byte) *Satisfier {
+	s.preimages[sha256.Sum256(preimage)] = preimage
+	return s
+}
+
+func (s *Satisfier) signature(key *btcec.PublicKey) ([]byte, bool) {
+	sig, ok := s.sigs[xOnlyKey(key)]
+	return sig, ok
+}
+
+func xOnlyKey(key *btcec.PublicKey) [32]byte {
+	return ([32]byte)(schnorr.SerializePubKey(key))
+}
+
+// compile a leaf to its tapscript
+func LeafScript(leaf Leaf) ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	if err := leaf.build(builder); err != nil {
+		return nil, err
+	}
+
+	return builder.Script()
+}
+
+// a tapscript compiled elsewhere, such as by miniscript, which also orders the witness stack that satisfies it
+type RawScript struct {
+	Script []byte
+}
+
+func (l *RawScript) build(builder *txscript.ScriptBuilder) error {
+	builder.AddOps(l.Script)
+	return nil
+}
+
+func (l *RawScript) witness(satisfier *Satisfier) (wire.TxWitness, error) {
+	return nil, fmt.Errorf("a raw script is satisfied by the compiler of its script")
+}
+
+// <key> OP_CHECKSIG
+type SingleKey struct {
+	Key *btcec.PublicKey
+}
+
+func (l *SingleKey) build(builder *txscript.ScriptBuilder) error {
+	builder.AddData(schnorr.SerializePubKey(l.Key))
+	builder.AddOp(txscript.OP_CHECKSIG)
+	return nil
+}
+
+func (l *SingleKey) witness(satisfier *Satisfier) (wire.TxWitness, error) {
+	sig, ok := satisfier.signature(l.Key)
+	if !ok {
+		return nil, fmt.Errorf("missing signature of key %x", schnorr.SerializePubKey(l.Key))
+	}
+
+	return wire.TxWitness{sig}, nil
+}
+
+// <key_1> OP_CHECKSIG <key_2> OP_CHECKSIGADD ... <key_n> OP_CHECKSIGADD <threshold> OP_NUMEQUAL
+type Multi struct {
+	Keys      []*btcec.PublicKey
+	Threshold int64
+}
+
+func (l *Multi) build(builder *txscript.ScriptBuilder) error {
+	if l.Threshold < 1 || l.Threshold > int64(len(l.Keys)) {
+		return fmt.Errorf("threshold %d is out of range of %d keys", l.Threshold, len(l.Keys))
+	}
+	for i, key := range l.Keys {
+		builder.AddData(schnorr.SerializePubKey(key))
+		if i == 0 {
+			builder.AddOp(txscript.OP_CHECKSIG)
+		} else {
+			builder.AddOp(txscript.OP_CHECKSIGADD)
+		}
+	}
+	builder.AddInt64(l.Threshold)
+	builder.AddOp(txscript.OP_NUMEQUAL)
+	return nil
+}
+
+// exactly threshold signatures are counted, so only the signatures of the first threshold signers in key order are used
+// the first key is checked first, so its signature is at the top of the stack
+func (l *Multi) witness(satisfier *Satisfier) (wire.TxWitness, error) {
+	sigs := make([][]byte, len(l.Keys))
+	signed := int64(0)
+	for i, key := range l.Keys {
+		if signed == l.Threshold {
+			break
+		}
+		if sig, ok := satisfier.signature(key); ok {
+			sigs[i] = sig
+			signed++
+		}
+	}
+	if signed < l.Threshold {
+		return nil, fmt.Errorf("%d of %d signatures", signed, l.Threshold)
+	}
+
+	witness := wire.TxWitness{}
+	for i := len(sigs) - 1; i >= 0; i-- {
+		if sigs[i] == nil {
+			witness = append(witness, []byte{})
+			continue
+		}
+		witness = append(witness, sigs[i])
+	}
+
+	return witness, nil
+}
+
+// a subset of keys that sign together with MuSig2, the leaf checks a single signature of their aggregated key
+// keys are aggregated in the given order
+type MuSig2Subset struct {
+	Keys []*btcec.PublicKey
+}
+
+func (l *MuSig2Subset) AggregatedKey() (*btcec.PublicKey, error) {
+	if len(l.Keys) == 0 {
+		return nil, fmt.Errorf("musig2 subset has no key")
+	}
+	aggr_key, _, _, err := musig2.AggregateKeys(l.Keys, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggr_key.FinalKey, nil
+}
+
+func (l *MuSig2Subset) build(builder *txscript.ScriptBuilder) error {
+	aggr_key, err := l.AggregatedKey()
+	if err != nil {
+		return err
+	}
+
+	return (&SingleKey{Key: aggr_key}).build(builder)
+}
+
+// the combined MuSig2 signature is added as a signature of the aggregated key
+func (l *MuSig2Subset) witness(satisfier *Satisfier) (wire.TxWitness, error) {
+	aggr_key, err := l.AggregatedKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return (&SingleKey{Key: aggr_key}).witness(satisfier)
+}
+
+// OP_SHA256 <hash> OP_EQUALVERIFY <then>
+// a hashlock without a following leaf ends with OP_EQUAL, anyone with the preimage can spend
+type HashLock struct {
+	Hash [32]byte
+	Then Leaf
+}
+
+func (l *HashLock) build(builder *txscript.ScriptBuilder) error {
+	builder.AddOp(txscript.OP_SHA256)
+	builder.AddData(l.Hash[:])
+	if l.Then == nil {
+		builder.AddOp(txscript.OP_EQUAL)
+		return nil
+	}
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	return l.Then.build(builder)
+}
+
+// the preimage is checked first, so it is at the top of the stack
+func (l *HashLock) witness(satisfier *Satisfier) (wire.TxWitness, error) {
+	preimage, ok := satisfier.preimages[l.Hash]
+	if !ok {
+		return nil, fmt.Errorf("missing preimage of %x", l.Hash)
+	}
+	if l.Then == nil {
+		return wire.TxWitness{preimage}, nil
+	}
+
+	witness, err := l.Then.witness(satisfier)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(witness, preimage), nil
+}
+
+// <lock_time> OP_CHECKLOCKTIMEVERIFY OP_DROP <then>
+// the spending transaction must set its lock time to at least lock time, and a non final sequence
+// a lock without a following leaf keeps the lock time on the stack, anyone can spend once it has passed
+type AbsoluteLock struct {
+	LockTime uint32
+	Then     Leaf
+}
+
+func (l *AbsoluteLock) build(builder *txscript.ScriptBuilder) error {
+	return buildLock(builder, int64(l.LockTime), txscript.OP_CHECKLOCKTIMEVERIFY, l.Then)
+}
+
+func (l *AbsoluteLock) witness(satisfier *Satisfier) (wire.TxWitness, error) {
+	if l.Then == nil {
+		return wire.TxWitness{}, nil
+	}
+
+	return l.Then.witness(satisfier)
+}
+
+// <sequence> OP_CHECKSEQUENCEVERIFY OP_DROP <then>
+// the spending input must set its sequence to at least sequence, in a version 2 transaction
+// a lock without a following leaf keeps the sequence on the stack, anyone can spend once it has passed
+type RelativeLock struct {
+	Sequence uint32
+	Then     Leaf
+}
+
+func (l *RelativeLock) build(builder *txscript.ScriptBuilder) error {
+	return buildLock(builder, int64(l.Sequence), txscript.OP_CHECKSEQUENCEVERIFY, l.Then)
+}
+
+func (l *RelativeLock) witness(satisfier *Satisfier) (wire.TxWitness, error) {
+	if l.Then == nil {
+		return wire.TxWitness{}, nil
+	}
+
+	return l.Then.witness(satisfier)
+}
+
+// a lock of 0 without a following leaf would leave false on the stack, so it could never be spent
+func buildLock(builder *txscript.ScriptBuilder, lock int64, op byte, then Leaf) error {
+	if then == nil && lock == 0 {
+		return fmt.Errorf("lock of 0 without a following leaf can never be spent")
+	}
+
+	builder.AddInt64(lock)
+	builder.AddOp(op)
+	if then == nil {
+		return nil
+	}
+	builder.AddOp(txscript.OP_DROP)
+	return then.build(builder)
+}
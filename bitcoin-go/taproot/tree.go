@@ -0,0 +1,116 @@
+package taproot
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// a taproot output of an internal key committing to a tree of leaf templates
+// leaves are assembled by txscript in the given order, and are referred to by their index
+// a tree without leaves can only be spent through key path
+type Tree struct {
+	InternalKey *btcec.PublicKey
+	Leaves      []Leaf
+
+	tapLeaves []txscript.TapLeaf
+	indexed   *txscript.IndexedTapScriptTree
+}
+
+func NewTree(internal_key *btcec.PublicKey, leaves ...Leaf) (*Tree, error) {
+	tree := &Tree{
+		InternalKey: internal_key,
+		Leaves:      leaves,
+		tapLeaves:   make([]txscript.TapLeaf, len(leaves)),
+	}
+	for i, leaf := range leaves {
+		script, err := LeafScript(leaf)
+		if err != nil {
+			return nil, fmt.Errorf("leaf %d: %w", i, err)
+		}
+		tree.tapLeaves[i] = txscript.NewBaseTapLeaf(script)
+	}
+	if len(leaves) > 0 {
+		tree.indexed = txscript.AssembleTaprootScriptTree(tree.tapLeaves...)
+	}
+
+	return tree, nil
+}
+
+// merkle root of the leaves, nil without leaves
+func (t *Tree) MerkleRoot() []byte {
+	if t.indexed == nil {
+		return nil
+	}
+	root := t.indexed.RootNode.TapHash()
+
+	return root[:]
+}
+
+// Q = P + H(P || root) * G
+func (t *Tree) OutputKey() *btcec.PublicKey {
+	if t.indexed == nil {
+		return txscript.ComputeTaprootKeyNoScript(t.InternalKey)
+	}
+
+	return txscript.ComputeTaprootOutputKey(t.InternalKey, t.MerkleRoot())
+}
+
+// OP_1 <output key>
+func (t *Tree) PkScript() ([]byte, error) {
+	return txscript.PayToTaprootScript(t.OutputKey())
+}
+
+func (t *Tree) Address(params *chaincfg.Params) (*btcutil.AddressTaproot, error) {
+	return btcutil.NewAddressTaproot(schnorr.SerializePubKey(t.OutputKey()), params)
+}
+
+// tap leaf at leaf index, used to compute its tapscript sighash
+func (t *Tree) TapLeaf(leaf_index int) txscript.TapLeaf {
+	return t.tapLeaves[leaf_index]
+}
+
+// serialized control block proving the leaf at leaf index against the output key
+func (t *Tree) ControlBlock(leaf_index int) ([]byte, error) {
+	if leaf_index < 0 || leaf_index >= len(t.tapLeaves) {
+		return nil, fmt.Errorf("leaf %d is out of range of %d leaves", leaf_index, len(t.tapLeaves))
+	}
+	ctrl_block := t.indexed.LeafMerkleProofs[leaf_index].ToControlBlock(t.InternalKey)
+
+	return ctrl_block.ToBytes()
+}
+
+// script path witness of the leaf at leaf index: its satisfaction, the leaf script and the control block
+func (t *Tree) Witness(leaf_index int, satisfier *Satisfier) (wire.TxWitness, error) {
+	ctrl_block, err := t.ControlBlock(leaf_index)
+	if err != nil {
+		return nil, err
+	}
+	witness, err := t.Leaves[leaf_index].witness(satisfier)
+	if err != nil {
+		return nil, fmt.Errorf("leaf %d: %w", leaf_index, err)
+	}
+
+	return append(witness, t.tapLeaves[leaf_index].Script, ctrl_block), nil
+}
+
+// tapscript signature of priv over input idx of tx spending through the leaf at leaf index
+func (t *Tree) SignLeaf(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, prev_out *wire.TxOut, leaf_index int, hType txscript.SigHashType, priv *btcec.PrivateKey) ([]byte, error) {
+	return txscript.RawTxInTapscriptSignature(tx, sigHashes, idx, prev_out.Value, prev_out.PkScript, t.tapLeaves[leaf_index], hType, priv)
+}
+
+// tapscript sighash of input idx of tx spending through the leaf at leaf index, for signers that sign outside of txscript
+func (t *Tree) LeafSigHash(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, prev_out *wire.TxOut, leaf_index int, hType txscript.SigHashType) ([32]byte, error) {
+	inputFetcher := txscript.NewCannedPrevOutputFetcher(prev_out.PkScript, prev_out.Value)
+	sigHash, err := txscript.CalcTapscriptSignaturehash(sigHashes, hType, tx, idx, inputFetcher, t.tapLeaves[leaf_index])
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return ([32]byte)(sigHash), nil
+}
@@ -0,0 +1,213 @@
+package taproot
+
+import (
+	"crypto/sha256"
+	"log"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ALICE_WALLET_SEED   = "4b92958dbc301dce528bb8aff445d00445c828220c287ec7d19599e3c256ce0e"
+	BOB_WALLET_SEED     = "b8c646523dd3cbb5fecf3906604aa36bd0d556c7d81e8d138e56e62809a708c2"
+	OLIVIA_WALLET_SEED  = "f9fdc67f82e763423c10448b33ec755c348cce8b58bebb19fdd25af5c9b49952"
+	OMNIMAN_WALLET_SEED = "e7712cf15c5ae7e24ae85920abdd0fa11251096bfbe8bad2bfb0aacdd34f2c8a"
+)
+
+// spend the tree output with lock time and sequence, and validate the witness that witnessFunc returns
+func validateTreeSpend(t *testing.T, tree *Tree, lock_time, sequence uint32, witnessFunc func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness) error {
+	pkScript, err := tree.PkScript()
+	assert.NoError(t, err)
+
+	tx_1 := wire.NewMsgTx(2)
+	tx_1.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{}, Index: 0},
+	})
+	tx_1.AddTxOut(wire.NewTxOut(1000000000, pkScript))
+
+	tx_2 := wire.NewMsgTx(2)
+	tx_2.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: tx_1.TxHash(), Index: 0},
+		Sequence:         sequence,
+	})
+	tx_2.AddTxOut(wire.NewTxOut(1000000000, nil))
+	tx_2.LockTime = lock_time
+
+	inputFetcher := txscript.NewCannedPrevOutputFetcher(tx_1.TxOut[0].PkScript, tx_1.TxOut[0].Value)
+	sigHashes := txscript.NewTxSigHashes(tx_2, inputFetcher)
+	tx_2.TxIn[0].Witness = witnessFunc(tx_2, sigHashes, tx_1.TxOut[0])
+
+	utxo_view := blockchain.NewUtxoViewpoint()
+	utxo_view.AddTxOut(btcutil.NewTx(tx_1), 0, 1)
+	hashCache := txscript.NewHashCache(1)
+	hashCache.AddSigHashes(tx_2, inputFetcher)
+
+	return blockchain.ValidateTransactionScripts(
+		btcutil.NewTx(tx_2), utxo_view, txscript.StandardVerifyFlags, txscript.NewSigCache(1), hashCache,
+	)
+}
+
+// go test -v -run ^TestTreeLeafTemplates$ github.com/nghuyenthevinh2000/bitcoin-playground/taproot
+func TestTreeLeafTemplates(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+
+	_, pair_1 := s.NewHDKeyPairFromSeed(ALICE_WALLET_SEED)
+	_, pair_2 := s.NewHDKeyPairFromSeed(BOB_WALLET_SEED)
+	_, pair_3 := s.NewHDKeyPairFromSeed(OLIVIA_WALLET_SEED)
+	_, internal_pair := s.NewHDKeyPairFromSeed(OMNIMAN_WALLET_SEED)
+
+	preimage := s.Generate32BSeed()
+	musig_keys := []*btcec.PublicKey{pair_1.Pub, pair_3.Pub}
+	tree, err := NewTree(internal_pair.Pub,
+		&SingleKey{Key: pair_1.Pub},
+		&Multi{Keys: []*btcec.PublicKey{pair_1.Pub, pair_2.Pub, pair_3.Pub}, Threshold: 2},
+		&MuSig2Subset{Keys: musig_keys},
+		&HashLock{Hash: sha256.Sum256(preimage[:]), Then: &SingleKey{Key: pair_2.Pub}},
+		&AbsoluteLock{LockTime: 5, Then: &SingleKey{Key: pair_2.Pub}},
+		&RelativeLock{Sequence: 10, Then: &Multi{Keys: []*btcec.PublicKey{pair_1.Pub, pair_2.Pub}, Threshold: 2}},
+	)
+	assert.NoError(t, err)
+	address, err := tree.Address(s.BtcdChainConfig)
+	assert.NoError(t, err)
+	pkScript, err := tree.PkScript()
+	assert.NoError(t, err)
+	assert.Equal(t, pkScript[2:], address.ScriptAddress())
+
+	// key path still works with the tweak of the merkle root
+	err = validateTreeSpend(t, tree, 0, wire.MaxTxInSequenceNum, func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness {
+		sig, err := txscript.RawTxInTaprootSignature(tx, sigHashes, 0, prev_out.Value, prev_out.PkScript, tree.MerkleRoot(), txscript.SigHashDefault, internal_pair.GetTestPriv())
+		assert.NoError(t, err)
+		return wire.TxWitness{sig}
+	})
+	assert.NoError(t, err)
+
+	// each leaf is spent with the signatures and preimages that it needs, in the order of its script
+	signers := map[int][]*btcec.PrivateKey{
+		0: {pair_1.GetTestPriv()},
+		1: {pair_3.GetTestPriv(), pair_2.GetTestPriv()},
+		3: {pair_2.GetTestPriv()},
+		4: {pair_2.GetTestPriv()},
+		5: {pair_1.GetTestPriv(), pair_2.GetTestPriv()},
+	}
+	for leaf_index, privs := range signers {
+		err = validateTreeSpend(t, tree, 5, 10, func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness {
+			satisfier := NewSatisfier().AddPreimage(preimage[:])
+			for _, priv := range privs {
+				sig, err := tree.SignLeaf(tx, sigHashes, 0, prev_out, leaf_index, txscript.SigHashDefault, priv)
+				assert.NoError(t, err)
+				satisfier.AddSignature(priv.PubKey(), sig)
+			}
+			witness, err := tree.Witness(leaf_index, satisfier)
+			assert.NoError(t, err)
+			return witness
+		})
+		assert.NoError(t, err, "leaf %d", leaf_index)
+	}
+
+	// the musig2 subset leaf takes the combined signature of the aggregated key
+	err = validateTreeSpend(t, tree, 0, wire.MaxTxInSequenceNum, func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness {
+		sigHash, err := tree.LeafSigHash(tx, sigHashes, 0, prev_out, 2, txscript.SigHashDefault)
+		assert.NoError(t, err)
+		nonce_1, err := musig2.GenNonces(musig2.WithPublicKey(pair_1.Pub))
+		assert.NoError(t, err)
+		nonce_2, err := musig2.GenNonces(musig2.WithPublicKey(pair_3.Pub))
+		assert.NoError(t, err)
+		aggrNonces, err := musig2.AggregateNonces([][66]byte{nonce_1.PubNonce, nonce_2.PubNonce})
+		assert.NoError(t, err)
+		ps_1, err := musig2.Sign(nonce_1.SecNonce, pair_1.GetTestPriv(), aggrNonces, musig_keys, sigHash)
+		assert.NoError(t, err)
+		ps_2, err := musig2.Sign(nonce_2.SecNonce, pair_3.GetTestPriv(), aggrNonces, musig_keys, sigHash)
+		assert.NoError(t, err)
+		sig := musig2.CombineSigs(ps_2.R, []*musig2.PartialSignature{ps_1, ps_2})
+
+		aggr_key, err := tree.Leaves[2].(*MuSig2Subset).AggregatedKey()
+		assert.NoError(t, err)
+		witness, err := tree.Witness(2, NewSatisfier().AddSignature(aggr_key, sig.Serialize()))
+		assert.NoError(t, err)
+		return witness
+	})
+	assert.NoError(t, err)
+
+	// timelocks are enforced against the spending transaction
+	err = validateTreeSpend(t, tree, 4, 10, func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness {
+		sig, err := tree.SignLeaf(tx, sigHashes, 0, prev_out, 4, txscript.SigHashDefault, pair_2.GetTestPriv())
+		assert.NoError(t, err)
+		witness, err := tree.Witness(4, NewSatisfier().AddSignature(pair_2.Pub, sig))
+		assert.NoError(t, err)
+		return witness
+	})
+	assert.Error(t, err)
+	err = validateTreeSpend(t, tree, 5, 9, func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness {
+		satisfier := NewSatisfier()
+		for _, priv := range signers[5] {
+			sig, err := tree.SignLeaf(tx, sigHashes, 0, prev_out, 5, txscript.SigHashDefault, priv)
+			assert.NoError(t, err)
+			satisfier.AddSignature(priv.PubKey(), sig)
+		}
+		witness, err := tree.Witness(5, satisfier)
+		assert.NoError(t, err)
+		return witness
+	})
+	assert.Error(t, err)
+
+	// a witness is not assembled without enough signatures or the preimage
+	_, err = tree.Witness(1, NewSatisfier().AddSignature(pair_1.Pub, make([]byte, 64)))
+	assert.Error(t, err)
+	_, err = tree.Witness(3, NewSatisfier().AddSignature(pair_2.Pub, make([]byte, 64)))
+	assert.Error(t, err)
+	_, err = tree.Witness(len(tree.Leaves), NewSatisfier())
+	assert.Error(t, err)
+	_, err = NewTree(internal_pair.Pub, &Multi{Keys: []*btcec.PublicKey{pair_1.Pub}, Threshold: 2})
+	assert.Error(t, err)
+
+	// a raw script is committed as it is, and its witness is left to the compiler of its script
+	script, err := LeafScript(&SingleKey{Key: pair_1.Pub})
+	assert.NoError(t, err)
+	raw_tree, err := NewTree(internal_pair.Pub, &RawScript{Script: script})
+	assert.NoError(t, err)
+	assert.Equal(t, script, raw_tree.TapLeaf(0).Script)
+	_, err = raw_tree.Witness(0, NewSatisfier())
+	assert.Error(t, err)
+}
+
+// go test -v -run ^TestTreeBareTimelocks$ github.com/nghuyenthevinh2000/bitcoin-playground/taproot
+func TestTreeBareTimelocks(t *testing.T) {
+	s := testhelper.TestSuite{}
+	s.SetupStaticSimNetSuite(t, log.Default())
+
+	_, internal_pair := s.NewHDKeyPairFromSeed(OMNIMAN_WALLET_SEED)
+
+	// timelocks without a following leaf are spent with an empty witness once they have passed
+	tree, err := NewTree(internal_pair.Pub,
+		&AbsoluteLock{LockTime: 5},
+		&RelativeLock{Sequence: 10},
+	)
+	assert.NoError(t, err)
+	spendLeaf := func(leaf_index int) func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness {
+		return func(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, prev_out *wire.TxOut) wire.TxWitness {
+			witness, err := tree.Witness(leaf_index, NewSatisfier())
+			assert.NoError(t, err)
+			return witness
+		}
+	}
+	assert.NoError(t, validateTreeSpend(t, tree, 5, 10, spendLeaf(0)))
+	assert.Error(t, validateTreeSpend(t, tree, 4, 10, spendLeaf(0)))
+	assert.NoError(t, validateTreeSpend(t, tree, 5, 10, spendLeaf(1)))
+	assert.Error(t, validateTreeSpend(t, tree, 5, 9, spendLeaf(1)))
+
+	// a lock of 0 would leave false on the stack
+	_, err = NewTree(internal_pair.Pub, &AbsoluteLock{LockTime: 0})
+	assert.Error(t, err)
+	_, err = NewTree(internal_pair.Pub, &RelativeLock{Sequence: 0})
+	assert.Error(t, err)
+}
@@ -0,0 +1,287 @@
+package testhelper
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// apportionment of WSTS keys to parties by their weight, such as voting power
+// weights are integers so that every validator derives the same keys, ties go to the larger weight, then to the lower index
+
+type ApportionMethod int
+
+const (
+	// each party gets the floor of its quota, remaining seats go to the largest remainders (Hamilton)
+	// every party is within one seat of its quota
+	LargestRemainder ApportionMethod = iota
+	// each next seat goes to the largest weight / (2 * seats + 1) (Webster)
+	// it does not favor large or small parties, but can move more than one seat away from the quota
+	SainteLague
+)
+
+func (m ApportionMethod) String() string {
+	switch m {
+	case LargestRemainder:
+		return "largest remainder"
+	case SainteLague:
+		return "Sainte-Laguë"
+	default:
+		return fmt.Sprintf("unknown apportion method %d", int(m))
+	}
+}
+
+type Apportionment struct {
+	Method  ApportionMethod
+	Weights []*big.Int
+	Seats   []int64
+	// largest |seats_i / total seats - weight_i / total weight| over all parties, and the party it is at
+	MaxDeviation      *big.Rat
+	MaxDeviationParty int
+}
+
+// apportion total seats to parties by weights, each party gets at least min seats
+// sum of seats is always total seats
+func Apportion(weights []*big.Int, total_seats, min_seats int64, method ApportionMethod) (*Apportionment, error) {
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("no party to apportion seats to")
+	}
+	total_weight := new(big.Int)
+	for i, weight := range weights {
+		if weight == nil || weight.Sign() < 0 {
+			return nil, fmt.Errorf("party %d has invalid weight %v", i, weight)
+		}
+		total_weight.Add(total_weight, weight)
+	}
+	if total_weight.Sign() == 0 {
+		return nil, fmt.Errorf("total weight is zero")
+	}
+	if min_seats < 0 || min_seats*int64(len(weights)) > total_seats {
+		return nil, fmt.Errorf("%d seats cannot give %d parties at least %d seats each", total_seats, len(weights), min_seats)
+	}
+
+	var seats []int64
+	switch method {
+	case LargestRemainder:
+		seats = apportionLargestRemainder(weights, total_seats, min_seats)
+	case SainteLague:
+		seats = apportionSainteLague(weights, total_seats, min_seats)
+	default:
+		return nil, fmt.Errorf("unknown apportion method %d", int(method))
+	}
+
+	a := &Apportionment{
+		Method:  method,
+		Weights: weights,
+		Seats:   seats,
+	}
+	a.MaxDeviation, a.MaxDeviationParty = a.deviation(total_weight, total_seats)
+
+	return a, nil
+}
+
+// parties whose quota falls below min seats are fixed at min seats, and the rest is apportioned again without them
+// until every remaining party has a quota of at least min seats
+func apportionLargestRemainder(weights []*big.Int, total_seats, min_seats int64) []int64 {
+	seats := make([]int64, len(weights))
+	fixed := make([]bool, len(weights))
+	for {
+		free_seats := total_seats
+		free_weight := new(big.Int)
+		for i, weight := range weights {
+			if fixed[i] {
+				free_seats -= min_seats
+				continue
+			}
+			free_weight.Add(free_weight, weight)
+		}
+
+		// quota_i = weight_i * free seats / free weight, remainders share the denominator free weight
+		refixed := false
+		assigned := int64(0)
+		remainders := make([]*big.Int, len(weights))
+		for i, weight := range weights {
+			if fixed[i] {
+				seats[i] = min_seats
+				continue
+			}
+			if free_weight.Sign() == 0 {
+				seats[i], remainders[i] = 0, new(big.Int)
+			} else {
+				quota := new(big.Int).Mul(weight, big.NewInt(free_seats))
+				floor, remainder := new(big.Int).QuoRem(quota, free_weight, new(big.Int))
+				seats[i], remainders[i] = floor.Int64(), remainder
+			}
+			// a party that cannot reach min seats even with one more seat for its remainder is fixed
+			if seats[i]+1 < min_seats || (seats[i] < min_seats && remainders[i].Sign() == 0) {
+				fixed[i] = true
+				refixed = true
+			}
+			assigned += seats[i]
+		}
+		if refixed {
+			continue
+		}
+
+		order := make([]int, 0, len(weights))
+		for i := range weights {
+			if !fixed[i] {
+				order = append(order, i)
+			}
+		}
+		sortParties(order, func(i, j int) int {
+			if c := remainders[i].Cmp(remainders[j]); c != 0 {
+				return c
+			}
+			return weights[i].Cmp(weights[j])
+		})
+		for k := int64(0); k < free_seats-assigned; k++ {
+			seats[order[k]]++
+		}
+
+		// remainder seats can still leave a party below min seats, it is fixed as well
+		for _, i := range order {
+			if seats[i] < min_seats {
+				fixed[i] = true
+				refixed = true
+			}
+		}
+		if !refixed {
+			return seats
+		}
+	}
+}
+
+// every party starts with min seats, the seats held count in its next divisor
+func apportionSainteLague(weights []*big.Int, total_seats, min_seats int64) []int64 {
+	seats := make([]int64, len(weights))
+	for i := range seats {
+		seats[i] = min_seats
+	}
+
+	// weight_i / (2 * seats_i + 1) is compared as weight_i * (2 * seats_j + 1) against weight_j * (2 * seats_i + 1)
+	priority := func(i, j int) int {
+		left := new(big.Int).Mul(weights[i], big.NewInt(2*seats[j]+1))
+		right := new(big.Int).Mul(weights[j], big.NewInt(2*seats[i]+1))
+		if c := left.Cmp(right); c != 0 {
+			return c
+		}
+		return weights[i].Cmp(weights[j])
+	}
+	for assigned := min_seats * int64(len(weights)); assigned < total_seats; assigned++ {
+		next := 0
+		for i := 1; i < len(weights); i++ {
+			if priority(i, next) > 0 {
+				next = i
+			}
+		}
+		seats[next]++
+	}
+
+	return seats
+}
+
+// sort parties in descending order of cmp, ties go to the lower index
+func sortParties(parties []int, cmp func(i, j int) int) {
+	for i := 1; i < len(parties); i++ {
+		for j := i; j > 0; j-- {
+			c := cmp(parties[j], parties[j-1])
+			if c < 0 || (c == 0 && parties[j] > parties[j-1]) {
+				break
+			}
+			parties[j], parties[j-1] = parties[j-1], parties[j]
+		}
+	}
+}
+
+func (a *Apportionment) deviation(total_weight *big.Int, total_seats int64) (*big.Rat, int) {
+	max_deviation := new(big.Rat)
+	max_party := 0
+	for i, weight := range a.Weights {
+		deviation := new(big.Rat).Sub(big.NewRat(a.Seats[i], total_seats), new(big.Rat).SetFrac(weight, total_weight))
+		deviation.Abs(deviation)
+		if deviation.Cmp(max_deviation) > 0 {
+			max_deviation = deviation
+			max_party = i
+		}
+	}
+
+	return max_deviation, max_party
+}
+
+// coalition with the least weight that holds at least key threshold seats, nil if no coalition does
+// it is a knapsack over parties, where seats are capped at key threshold
+func (a *Apportionment) WeakestCoalition(key_threshold int64) (*big.Int, []int) {
+	if key_threshold <= 0 {
+		return new(big.Int), []int{}
+	}
+
+	// least weight of the first i parties reaching s seats, nil if they cannot
+	least := make([][]*big.Int, len(a.Weights)+1)
+	least[0] = make([]*big.Int, key_threshold+1)
+	least[0][0] = new(big.Int)
+	for i, weight := range a.Weights {
+		least[i+1] = make([]*big.Int, key_threshold+1)
+		copy(least[i+1], least[i])
+		if a.Seats[i] == 0 {
+			continue
+		}
+		for s := int64(0); s <= key_threshold; s++ {
+			if least[i][s] == nil {
+				continue
+			}
+			next := min(s+a.Seats[i], key_threshold)
+			with := new(big.Int).Add(least[i][s], weight)
+			if least[i+1][next] == nil || with.Cmp(least[i+1][next]) < 0 {
+				least[i+1][next] = with
+			}
+		}
+	}
+	if least[len(a.Weights)][key_threshold] == nil {
+		return nil, nil
+	}
+
+	// walk back through the parties that the least weight is made of
+	coalition := make([]int, 0)
+	s := key_threshold
+	for i := len(a.Weights) - 1; i >= 0; i-- {
+		if least[i][s] != nil && least[i][s].Cmp(least[i+1][s]) == 0 {
+			continue
+		}
+		coalition = append(coalition, i)
+		for prev := int64(0); prev <= s; prev++ {
+			if least[i][prev] == nil || min(prev+a.Seats[i], key_threshold) != s {
+				continue
+			}
+			if new(big.Int).Add(least[i][prev], a.Weights[i]).Cmp(least[i+1][s]) == 0 {
+				s = prev
+				break
+			}
+		}
+	}
+	for i, j := 0, len(coalition)-1; i < j; i, j = i+1, j-1 {
+		coalition[i], coalition[j] = coalition[j], coalition[i]
+	}
+
+	return least[len(a.Weights)][key_threshold], coalition
+}
+
+// a coalition with less than vp threshold of the total weight that holds at least key threshold seats
+// strict is for a protocol that needs more than vp threshold, then a coalition with exactly vp threshold is also unsafe
+// nil if the apportionment is safe, as no such coalition exists
+func (a *Apportionment) UnsafeCoalition(key_threshold int64, vp_threshold *big.Rat, strict bool) []int {
+	weight, coalition := a.WeakestCoalition(key_threshold)
+	if weight == nil {
+		return nil
+	}
+
+	total_weight := new(big.Int)
+	for _, w := range a.Weights {
+		total_weight.Add(total_weight, w)
+	}
+	cmp := new(big.Rat).SetFrac(weight, total_weight).Cmp(vp_threshold)
+	if cmp > 0 || (cmp == 0 && !strict) {
+		return nil
+	}
+
+	return coalition
+}
@@ -1,6 +1,9 @@
 package testhelper
 
 import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/txscript"
@@ -26,3 +29,71 @@ func (suite *TestSuite) CreateAccount(acc *btcec.PublicKey) {
 
 	suite.UtxoViewpoint.AddTxOut(btcutil.NewTx(tx), 0, 0)
 }
+
+// mock mining a transaction into the static utxo viewpoint at block height
+// spent outputs are marked as spent so that they cannot be spent again
+func (suite *TestSuite) MockMineTx(tx *wire.MsgTx, blockHeight int32) {
+	suite.utxoLock.Lock()
+	defer suite.utxoLock.Unlock()
+
+	for _, txIn := range tx.TxIn {
+		entry := suite.UtxoViewpoint.LookupEntry(txIn.PreviousOutPoint)
+		assert.NotNil(suite.T, entry, "mock mine tx: missing prevout %v", txIn.PreviousOutPoint)
+		if entry == nil {
+			continue
+		}
+		assert.False(suite.T, entry.IsSpent(), "mock mine tx: prevout %v is already spent", txIn.PreviousOutPoint)
+		entry.Spend()
+	}
+
+	suite.UtxoViewpoint.AddTxOuts(btcutil.NewTx(tx), blockHeight)
+}
+
+// read the static utxo viewpoint, no transaction is mock mined meanwhile
+func (suite *TestSuite) ReadUtxoViewpoint(fn func(view *blockchain.UtxoViewpoint)) {
+	suite.utxoLock.RLock()
+	defer suite.utxoLock.RUnlock()
+
+	fn(suite.UtxoViewpoint)
+}
+
+// fetch a prevout from the static utxo viewpoint
+func (suite *TestSuite) FetchUtxo(outpoint wire.OutPoint) *wire.TxOut {
+	var txOut *wire.TxOut
+	suite.ReadUtxoViewpoint(func(view *blockchain.UtxoViewpoint) {
+		txOut = view.FetchPrevOutput(outpoint)
+	})
+
+	return txOut
+}
+
+// check relative locktimes of tx inputs against the static utxo viewpoint, as if tx is mined at block height
+// only block based relative locktimes are supported
+func (suite *TestSuite) CheckSequenceLocks(tx *wire.MsgTx, blockHeight int32) error {
+	// relative locktimes are only enforced from tx version 2
+	if tx.Version < 2 {
+		return nil
+	}
+
+	suite.utxoLock.RLock()
+	defer suite.utxoLock.RUnlock()
+
+	for i, txIn := range tx.TxIn {
+		if txIn.Sequence&wire.SequenceLockTimeDisabled != 0 {
+			continue
+		}
+		if txIn.Sequence&wire.SequenceLockTimeIsSeconds != 0 {
+			return fmt.Errorf("input %d: time based relative locktime is not supported", i)
+		}
+		entry := suite.UtxoViewpoint.LookupEntry(txIn.PreviousOutPoint)
+		if entry == nil {
+			return fmt.Errorf("input %d: missing prevout %v", i, txIn.PreviousOutPoint)
+		}
+		unlock_height := entry.BlockHeight() + int32(txIn.Sequence&wire.SequenceLockTimeMask)
+		if blockHeight < unlock_height {
+			return fmt.Errorf("input %d: prevout %v is locked until block %d", i, txIn.PreviousOutPoint, unlock_height)
+		}
+	}
+
+	return nil
+}
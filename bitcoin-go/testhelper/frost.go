@@ -1,12 +1,15 @@
 package testhelper
 
 import (
+	"fmt"
 	"log"
+	"math/bits"
 	"sync"
 
 	btcec "github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	secp "github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -42,6 +45,8 @@ type FrostParticipant struct {
 	PolynomialCommitments map[int64][]*btcec.PublicKey
 	PublicSigningShares   sync.Map
 	GroupPublicKey        *btcec.PublicKey
+	// merkle root of the tapscript tree committed in the taproot output key, nil if the output key is the group key itself
+	TapScriptRoot []byte
 	// contains the nonce commitments for multiple signing usages
 	NonceCommitments       [][2]*btcec.PublicKey
 	PublicNonceCommitments map[int64][][2]*btcec.PublicKey
@@ -293,7 +298,12 @@ func (p *FrostParticipant) updateSecretShares(posi int64, val *btcec.ModNScalar)
 
 // verify secret shares
 func (p *FrostParticipant) VerifyPublicSecretShares(secretShares *btcec.ModNScalar, which_participant_poly int64, posi uint32) {
-	posi_scalar := new(btcec.ModNScalar).SetInt(posi)
+	assert.True(p.suite.T, p.IsValidPublicSecretShares(secretShares, which_participant_poly, posi))
+}
+
+// check secret shares against polynomial commitments of the participant that sent them
+// without failing, so that the receiver can decide how to handle an invalid share
+func (p *FrostParticipant) IsValidPublicSecretShares(secretShares *btcec.ModNScalar, which_participant_poly int64, posi uint32) bool {
 	polynomialCommitments := p.PolynomialCommitments[which_participant_poly]
 
 	// calculate A(i) = g^f(i)
@@ -301,18 +311,12 @@ func (p *FrostParticipant) VerifyPublicSecretShares(secretShares *btcec.ModNScal
 	btcec.ScalarBaseMultNonConst(secretShares, expected_a)
 
 	// calculate prod(A_k^i^k)
-	i_power := new(btcec.ModNScalar)
-	i_power.SetInt(1)
-	calculated_a := new(btcec.JacobianPoint)
-	for i := 0; i < len(polynomialCommitments); i++ {
-		term := new(btcec.JacobianPoint)
-		polynomialCommitments[i].AsJacobian(term)
-		// calculate term = A_k^i^k = g^(a_k*i^k)
-		btcec.ScalarMultNonConst(i_power, term, term)
-		// calculate prod(A_k^i^k)
-		btcec.AddNonConst(calculated_a, term, calculated_a)
-		i_power.Mul(posi_scalar)
+	commitments := make([]*btcec.JacobianPoint, len(polynomialCommitments))
+	for k, commitment := range polynomialCommitments {
+		commitments[k] = new(btcec.JacobianPoint)
+		commitment.AsJacobian(commitments[k])
 	}
+	calculated_a := evaluatePolyCommitments(commitments, posi)
 
 	calculated_a.ToAffine()
 	expected_a.ToAffine()
@@ -321,7 +325,7 @@ func (p *FrostParticipant) VerifyPublicSecretShares(secretShares *btcec.ModNScal
 	// should I check for a specific Y coordinate?
 
 	// check if the calculated commitment is equal to the expected commitment
-	assert.Equal(p.suite.T, expected_a.X, calculated_a.X)
+	return expected_a.X.Equals(&calculated_a.X)
 }
 
 func (p *FrostParticipant) CalculateInternalPublicSigningShares(signingShares *btcec.ModNScalar, posi int64) *btcec.PublicKey {
@@ -358,61 +362,61 @@ func (p *FrostParticipant) CalculateInternalPublicSigningShares(signingShares *b
 //
 // Y_i = \prod_{m=1}^{n_p} \prod_{j=0}^{t} A_mj^i^j
 //
-// intense computation: 0(n*m)
+// Y_i = \prod_{j=0}^{t} (\prod_{m=1}^{n_p} A_mj)^i^j = \prod_{j=0}^{t} Q_j^i^j
+//
+// intense computation: 0(n*m), use AggregatePolyCommitments once for many keys instead
 func (p *FrostParticipant) CalculatePublicSigningShares(party_num, posi int64) *btcec.PublicKey {
-	posi_scalar := new(btcec.ModNScalar)
-	posi_scalar.SetInt(uint32(posi))
+	return p.CalculatePublicSigningSharesFromAggr(p.AggregatePolyCommitments(party_num), posi)
+}
 
-	Y := new(btcec.JacobianPoint)
-	i_power_map := make([]*btcec.ModNScalar, p.Threshold+1)
-	i_power := new(btcec.ModNScalar)
-	i_power.SetInt(1)
+// Q_j = \prod_{m=1}^{n_p} A_mj, j \in {0, \ldots, t}
+func (p *FrostParticipant) AggregatePolyCommitments(party_num int64) []*btcec.JacobianPoint {
+	aggr := make([]*btcec.JacobianPoint, p.Threshold+1)
 	for j := int64(0); j <= p.Threshold; j++ {
-		i_power_map[j] = new(btcec.ModNScalar).Set(i_power)
-		i_power.Mul(posi_scalar)
+		aggr[j] = new(btcec.JacobianPoint)
+		for m := int64(1); m <= party_num; m++ {
+			A_mj := new(btcec.JacobianPoint)
+			p.PolynomialCommitments[m][j].AsJacobian(A_mj)
+			btcec.AddNonConst(aggr[j], A_mj, aggr[j])
+		}
 	}
 
-	for i := int64(1); i <= party_num; i++ {
-		// parallel computation
-		// \prod_{j=0}^{t} A_mj^i^j
-		term_1_arr := make([]*btcec.JacobianPoint, p.Threshold+1)
-		var wg sync.WaitGroup
-		for j := int64(0); j <= p.Threshold; j++ {
-			wg.Add(1)
-			go func(j int64) {
-				// A_mj
-				A_ij := new(btcec.JacobianPoint)
-				p.PolynomialCommitments[i][j].AsJacobian(A_ij)
-
-				// calculate A_mj^i^j
-				term1 := new(btcec.JacobianPoint)
-				btcec.ScalarMultNonConst(i_power_map[j], A_ij, term1)
+	return aggr
+}
 
-				term_1_arr[j] = term1
+// Y_i = \prod_{j=0}^{t} Q_j^i^j
+func (p *FrostParticipant) CalculatePublicSigningSharesFromAggr(aggr []*btcec.JacobianPoint, posi int64) *btcec.PublicKey {
+	Y := evaluatePolyCommitments(aggr, uint32(posi))
+	Y.ToAffine()
 
-				// p.suite.Logger.Printf("Participant %d: public signing shares for %d: A_%d%d%d=%v\n", p.Position, posi, posi, i, j, term1)
-				// p.suite.Logger.Printf("A_ij: %v, i_power_map[j]: %v\n", A_ij, i_power_map[j])
+	p.StorePublicSigningShares(posi, btcec.NewPublicKey(&Y.X, &Y.Y))
 
-				wg.Done()
-			}(j)
-		}
-		wg.Wait()
+	return p.GetPublicSigningShares(posi)
+}
 
-		term := new(btcec.JacobianPoint)
-		for j := int64(0); j <= p.Threshold; j++ {
-			btcec.AddNonConst(term, term_1_arr[j], term)
-			// p.suite.Logger.Printf("Calculating w for (participant, external, m) = (%d, %d, %d), A_%d%d%d=%v\n\n", p.Position, posi, i, posi, i, j, term_1_arr[j])
-		}
+// \prod_{j=0}^{t} C_j^i^j with Horner's rule, ((C_t^i * C_{t-1})^i * \ldots)^i * C_0
+// i is a key index, so raising to i takes a few doublings instead of a full scalar multiplication
+func evaluatePolyCommitments(commitments []*btcec.JacobianPoint, posi uint32) *btcec.JacobianPoint {
+	result := new(btcec.JacobianPoint)
+	for j := len(commitments) - 1; j >= 0; j-- {
+		result = multSmallScalar(posi, result)
+		btcec.AddNonConst(result, commitments[j], result)
+	}
 
-		// p.suite.Logger.Printf("Calculating w for (participant, external, m) = (%d, %d, %d): %v\n", p.Position, posi, i, term)
+	return result
+}
 
-		btcec.AddNonConst(Y, term, Y)
+// double and add over the bits of a small scalar
+func multSmallScalar(k uint32, point *btcec.JacobianPoint) *btcec.JacobianPoint {
+	result := new(btcec.JacobianPoint)
+	for bit := bits.Len32(k) - 1; bit >= 0; bit-- {
+		btcec.DoubleNonConst(result, result)
+		if k&(1<<bit) != 0 {
+			btcec.AddNonConst(result, point, result)
+		}
 	}
-	Y.ToAffine()
-
-	p.StorePublicSigningShares(posi, btcec.NewPublicKey(&Y.X, &Y.Y))
 
-	return p.GetPublicSigningShares(posi)
+	return result
 }
 
 func (p *FrostParticipant) CalculateGroupPublicKey() *btcec.PublicKey {
@@ -429,9 +433,66 @@ func (p *FrostParticipant) CalculateGroupPublicKey() *btcec.PublicKey {
 	return p.GroupPublicKey
 }
 
+// taproot output key that weighted signatures are valid for
+// the group key is the internal key, tweaked with the tapscript root if there is one
+func (p *FrostParticipant) OutputKey() *btcec.PublicKey {
+	if p.TapScriptRoot == nil {
+		return p.GroupPublicKey
+	}
+
+	return txscript.ComputeTaprootOutputKey(p.GroupPublicKey, p.TapScriptRoot)
+}
+
+// signing shares are negated so that they sum up to the secret of an even Y output key
+// the tweak is added to the even Y group key, and the tweaked output key can have odd Y again
+func (p *FrostParticipant) isSigningSharesNegated() bool {
+	negated := p.GroupPublicKey.SerializeCompressed()[0] == secp.PubKeyFormatCompressedOdd
+	if p.TapScriptRoot == nil {
+		return negated
+	}
+
+	return negated != (p.OutputKey().SerializeCompressed()[0] == secp.PubKeyFormatCompressedOdd)
+}
+
+// partial signatures only sum up to the signature of the group key
+// the tweak t of the output key is added as z + c * t, negated if the output key has odd Y
+func (p *FrostParticipant) TweakWeightedSignature(signing_index int64, message_hash [32]byte, z *btcec.ModNScalar) {
+	if p.TapScriptRoot == nil {
+		return
+	}
+
+	// calculate c
+	commitment_data := make([]byte, 0)
+	commitment_data = append(commitment_data, p.AggrNonceCommitment[signing_index].X.Bytes()[:]...)
+	commitment_data = append(commitment_data, schnorr.SerializePubKey(p.OutputKey())...)
+	commitment_data = append(commitment_data, message_hash[:]...)
+	commitment_hash := chainhash.TaggedHash(chainhash.TagBIP0340Challenge, commitment_data)
+	c := new(btcec.ModNScalar)
+	c.SetByteSlice(commitment_hash[:])
+
+	// t = H_TapTweak(P || root)
+	tweak_hash := chainhash.TaggedHash(chainhash.TagTapTweak, schnorr.SerializePubKey(p.GroupPublicKey), p.TapScriptRoot)
+	t := new(btcec.ModNScalar)
+	t.SetByteSlice(tweak_hash[:])
+
+	term := new(btcec.ModNScalar).Mul2(c, t)
+	if p.OutputKey().SerializeCompressed()[0] == secp.PubKeyFormatCompressedOdd {
+		term.Negate()
+	}
+	z.Add(term)
+}
+
 func (p *FrostParticipant) GenerateSigningNonces(signing_time int64) [][2]*btcec.PublicKey {
-	p.nonces = make([][2]*btcec.ModNScalar, signing_time)
-	p.NonceCommitments = make([][2]*btcec.PublicKey, signing_time)
+	p.nonces = make([][2]*btcec.ModNScalar, 0, signing_time)
+	p.NonceCommitments = make([][2]*btcec.PublicKey, 0, signing_time)
+
+	return p.ExtendSigningNonces(signing_time)
+}
+
+// append nonces after the ones already generated, so that nonces not yet used keep their signing index
+// return the nonce commitments of the appended nonces only
+func (p *FrostParticipant) ExtendSigningNonces(signing_time int64) [][2]*btcec.PublicKey {
+	start := len(p.nonces)
 	for i := int64(0); i < signing_time; i++ {
 		// generate nonces (d, e) for each signing
 		// for pi = 1 number of pairs
@@ -440,25 +501,79 @@ func (p *FrostParticipant) GenerateSigningNonces(signing_time int64) [][2]*btcec
 
 		d := new(btcec.ModNScalar)
 		d.SetBytes(&d_seed)
-		D := new(btcec.JacobianPoint)
-		btcec.ScalarBaseMultNonConst(d, D)
-
 		e := new(btcec.ModNScalar)
 		e.SetBytes(&e_seed)
-		E := new(btcec.JacobianPoint)
-		btcec.ScalarBaseMultNonConst(e, E)
 
-		// normalize Z before shipping off (D, E) to other participants
-		D.ToAffine()
-		E.ToAffine()
+		p.nonces = append(p.nonces, [2]*btcec.ModNScalar{d, e})
+		p.NonceCommitments = append(p.NonceCommitments, signingNonceCommitments(d, e))
+	}
+
+	return p.NonceCommitments[start:]
+}
+
+func signingNonceCommitments(d, e *btcec.ModNScalar) [2]*btcec.PublicKey {
+	D := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(d, D)
+	E := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(e, E)
+
+	// normalize Z before shipping off (D, E) to other participants
+	D.ToAffine()
+	E.ToAffine()
 
-		p.nonces[i] = [2]*btcec.ModNScalar{d, e}
-		D_Pub := btcec.NewPublicKey(&D.X, &D.Y)
-		E_Pub := btcec.NewPublicKey(&E.X, &E.Y)
-		p.NonceCommitments[i] = [2]*btcec.PublicKey{D_Pub, E_Pub}
+	return [2]*btcec.PublicKey{btcec.NewPublicKey(&D.X, &D.Y), btcec.NewPublicKey(&E.X, &E.Y)}
+}
+
+// number of nonces generated, including the ones that have been used
+func (p *FrostParticipant) SigningNoncesNum() int64 {
+	return int64(len(p.nonces))
+}
+
+// false if the nonce has not been generated, or has been erased
+func (p *FrostParticipant) HasSigningNonce(signing_index int64) bool {
+	return signing_index >= 0 && signing_index < int64(len(p.nonces)) && p.nonces[signing_index][0] != nil
+}
+
+// secret nonce is zeroed and dropped, so that it can never sign again
+func (p *FrostParticipant) EraseSigningNonce(signing_index int64) {
+	if !p.HasSigningNonce(signing_index) {
+		return
 	}
+	p.nonces[signing_index][0].Zero()
+	p.nonces[signing_index][1].Zero()
+	p.nonces[signing_index] = [2]*btcec.ModNScalar{}
+}
+
+// secret nonce serialized as d || e, nil if it is not available
+func (p *FrostParticipant) SigningNonceBytes(signing_index int64) []byte {
+	if !p.HasSigningNonce(signing_index) {
+		return nil
+	}
+	d_bytes := p.nonces[signing_index][0].Bytes()
+	e_bytes := p.nonces[signing_index][1].Bytes()
 
-	return p.NonceCommitments
+	return append(d_bytes[:], e_bytes[:]...)
+}
+
+// restore a secret nonce serialized by SigningNonceBytes, e.g. after a restart
+// return the nonce commitments of the restored nonce
+func (p *FrostParticipant) RestoreSigningNonce(signing_index int64, nonce_bytes []byte) ([2]*btcec.PublicKey, error) {
+	if len(nonce_bytes) != 64 {
+		return [2]*btcec.PublicKey{}, fmt.Errorf("invalid nonce length: %d", len(nonce_bytes))
+	}
+	d := new(btcec.ModNScalar)
+	d.SetByteSlice(nonce_bytes[:32])
+	e := new(btcec.ModNScalar)
+	e.SetByteSlice(nonce_bytes[32:])
+
+	for int64(len(p.nonces)) <= signing_index {
+		p.nonces = append(p.nonces, [2]*btcec.ModNScalar{})
+		p.NonceCommitments = append(p.NonceCommitments, [2]*btcec.PublicKey{})
+	}
+	p.nonces[signing_index] = [2]*btcec.ModNScalar{d, e}
+	p.NonceCommitments[signing_index] = signingNonceCommitments(d, e)
+
+	return p.NonceCommitments[signing_index], nil
 }
 
 // with provided public nonces from other participants, calculate the aggregated public nonce commitments
@@ -604,13 +719,13 @@ func (p *FrostParticipant) WeightedPartialSign(position, signing_index int64, ho
 	// calculate c
 	commitment_data := make([]byte, 0)
 	commitment_data = append(commitment_data, p.AggrNonceCommitment[signing_index].X.Bytes()[:]...)
-	commitment_data = append(commitment_data, schnorr.SerializePubKey(p.GroupPublicKey)...)
+	commitment_data = append(commitment_data, schnorr.SerializePubKey(p.OutputKey())...)
 	commitment_data = append(commitment_data, message_hash[:]...)
 	commitment_hash := chainhash.TaggedHash(chainhash.TagBIP0340Challenge, commitment_data)
 	c := new(btcec.ModNScalar)
 	c.SetByteSlice(commitment_hash[:])
 
-	p.logger.Printf("sign c: %v, group pubkey: %v, aggr nonce commitments: %v\n", c, p.GroupPublicKey, p.AggrNonceCommitment[signing_index].X)
+	p.logger.Printf("sign c: %v, output key: %v, aggr nonce commitments: %v\n", c, p.OutputKey(), p.AggrNonceCommitment[signing_index].X)
 
 	// calculate p_i
 	p_i_data := make([]byte, 0)
@@ -658,7 +773,7 @@ func (p *FrostParticipant) WeightedPartialSign(position, signing_index int64, ho
 	term3 := new(btcec.ModNScalar).SetInt(0)
 	for key_index, shares := range signing_shares {
 		s_i := new(btcec.ModNScalar).Set(shares)
-		if p.GroupPublicKey.SerializeCompressed()[0] == secp.PubKeyFormatCompressedOdd {
+		if p.isSigningSharesNegated() {
 			s_i.Negate()
 		}
 
@@ -702,7 +817,7 @@ func (p *FrostParticipant) WeightedPartialVerification(sig *schnorr.Signature, s
 	// calculate c = H(R, Y, m)
 	commitment_data := make([]byte, 0)
 	commitment_data = append(commitment_data, p.AggrNonceCommitment[signing_index].X.Bytes()[:]...)
-	commitment_data = append(commitment_data, schnorr.SerializePubKey(p.GroupPublicKey)...)
+	commitment_data = append(commitment_data, schnorr.SerializePubKey(p.OutputKey())...)
 	commitment_data = append(commitment_data, message_hash[:]...)
 	commitment_hash := chainhash.TaggedHash(chainhash.TagBIP0340Challenge, commitment_data)
 	c := new(btcec.ModNScalar)
@@ -718,7 +833,7 @@ func (p *FrostParticipant) WeightedPartialVerification(sig *schnorr.Signature, s
 	for key_index, shares := range signing_verification_shares {
 		Y_i := new(btcec.JacobianPoint)
 		shares.AsJacobian(Y_i)
-		if p.GroupPublicKey.SerializeCompressed()[0] == secp.PubKeyFormatCompressedOdd {
+		if p.isSigningSharesNegated() {
 			Y_i.Y.Negate(1)
 			Y_i.Y.Normalize()
 		}
@@ -751,16 +866,20 @@ func (p *FrostParticipant) WeightedPartialVerification(sig *schnorr.Signature, s
 	if (R.X.IsZero() && R.Y.IsZero()) || R.Z.IsZero() {
 		is_infinity = true
 	}
-	assert.False(p.suite.T, is_infinity, "verify partial sig proof: R is the point at infinity")
+	// an invalid partial sig is reported to the caller, which decides how to handle the signer
 	if is_infinity {
+		p.logger.Printf("verify partial sig proof: R is the point at infinity\n")
 		return false
 	}
 
 	R.ToAffine()
 
 	// verify R point equals provided R_X
-	assert.Equal(p.suite.T, &R.X, R_X, "verify partial sig proof: R.X does not match provided R_X")
-	return R.X.Equals(R_X)
+	if !R.X.Equals(R_X) {
+		p.logger.Printf("verify partial sig proof: R.X does not match provided R_X\n")
+		return false
+	}
+	return true
 }
 
 // BATCH CALCULATION
@@ -874,52 +993,23 @@ func (p *FrostParticipant) VerifyBatchPublicSecretShares(secret_shares map[int64
 		all_expected_A[index] = expected_A
 	}
 
-	i_power_arr := p.GetPowerMapItem(int64(posi))
-
-	// calculate map of A_k^i^k for all parties
-	// make(map[int64][]*btcec.JacobianPoint)
-	all_term := sync.Map{}
-	for posi, poly_commitments := range p.PolynomialCommitments {
-		wg.Add(1)
-		go func(posi int64, poly_commitments []*btcec.PublicKey) {
-			term_arr := make([]*secp.JacobianPoint, p.Threshold+1)
-			var wg1 sync.WaitGroup
-			for i := int64(0); i <= p.Threshold; i++ {
-				wg1.Add(1)
-				go func(i int64) {
-					term := new(btcec.JacobianPoint)
-					poly_commitments[i].AsJacobian(term)
-					btcec.ScalarMultNonConst(i_power_arr[i], term, term)
-					term_arr[i] = term
-					wg1.Done()
-				}(i)
-			}
-			wg1.Wait()
-			all_term.Store(posi, term_arr)
-			wg.Done()
-		}(posi, poly_commitments)
-	}
-	wg.Wait()
-
 	// calculate prod(A_k^i^k) for all parties
 	// then verify against expected values
-	all_term.Range(func(key any, value any) bool {
-		index := key.(int64)
-		term := value.([]*btcec.JacobianPoint)
+	for index, poly_commitments := range p.PolynomialCommitments {
 		wg.Add(1)
-		go func(index int64, term []*btcec.JacobianPoint) {
-			calculated_A := new(btcec.JacobianPoint)
-			for _, val := range term {
-				btcec.AddNonConst(calculated_A, val, calculated_A)
+		go func(index int64, poly_commitments []*btcec.PublicKey) {
+			commitments := make([]*btcec.JacobianPoint, len(poly_commitments))
+			for k, commitment := range poly_commitments {
+				commitments[k] = new(btcec.JacobianPoint)
+				commitment.AsJacobian(commitments[k])
 			}
+			calculated_A := evaluatePolyCommitments(commitments, posi)
 			calculated_A.ToAffine()
 			expected_A := all_expected_A[index]
 			assert.Equal(p.suite.T, expected_A, calculated_A)
 			wg.Done()
-		}(index, term)
-
-		return true
-	})
+		}(index, poly_commitments)
+	}
 	wg.Wait()
 	if p.suite.B != nil {
 		// p.suite.LogBenchmarkThreadSafeReport(fmt.Sprintf("ms/verify-batch-secret-shares-%d", p.Position), float64(time.Since(time_now).Milliseconds()), true)
@@ -3,11 +3,19 @@ package testhelper
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"io"
 
 	btcec "github.com/btcsuite/btcd/btcec/v2"
 	"github.com/stretchr/testify/assert"
 )
 
+func (s *TestSuite) randReader() io.Reader {
+	if s.Rand == nil {
+		return rand.Reader
+	}
+	return s.Rand
+}
+
 // a polynomial of degree t-1
 // f(x) = a_0 + a_1*x + a_2*x^2 + ... + a_t*x^t
 // we store the coefficients in the form of a slice
@@ -17,7 +25,7 @@ func (s *TestSuite) GeneratePolynomial(degree int64) []*btcec.ModNScalar {
 	// the value a_0 is the secret, others should be able to retrieve the secret
 	for i := int64(0); i <= degree; i++ {
 		var coeff btcec.ModNScalar
-		int_secp256k1_rand, err := rand.Int(rand.Reader, btcec.S256().N)
+		int_secp256k1_rand, err := rand.Int(s.randReader(), btcec.S256().N)
 		assert.Nil(s.T, err)
 		coeff.SetByteSlice(int_secp256k1_rand.Bytes())
 		polynomial[i] = &coeff
@@ -51,19 +59,19 @@ func (s *TestSuite) EvaluatePolynomial(polynomial []*btcec.ModNScalar, x *btcec.
 // calculate the Lagrange coefficient at i over a set
 // requires exact position, all values start with 1
 func (s *TestSuite) CalculateLagrangeCoeff(i int64, set []int64) *btcec.ModNScalar {
-	mul_j := new(btcec.ModNScalar).SetInt(1)
+	// numerators and denominators are multiplied separately, so that there is only one inversion
+	numerator := new(btcec.ModNScalar).SetInt(1)
+	denominator := new(btcec.ModNScalar).SetInt(1)
+	x_i := new(btcec.ModNScalar).SetInt(uint32(i))
 	for _, j := range set {
 		if j != i {
 			x_j := new(btcec.ModNScalar).SetInt(uint32(j))
-			x_i := new(btcec.ModNScalar).SetInt(uint32(i))
-			numerator := new(btcec.ModNScalar).NegateVal(x_j)
-			denominator := new(btcec.ModNScalar).NegateVal(x_j).Add(x_i)
-			mul_j.Mul(numerator)
-			mul_j.Mul(denominator.InverseNonConst())
+			numerator.Mul(new(btcec.ModNScalar).NegateVal(x_j))
+			denominator.Mul(new(btcec.ModNScalar).NegateVal(x_j).Add(x_i))
 		}
 	}
 
-	return mul_j
+	return numerator.Mul(denominator.InverseNonConst())
 }
 
 func Int64ToBytes(num int64) []byte {
@@ -0,0 +1,123 @@
+package testhelper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// proof of reserves as in BIP-127
+// a transaction that spends the reserves together with a commitment input to a message, so that it can never be mined
+// the commitment input spends index 0 of a txid which is sha256("Proof-of-Reserves: " || message)
+// its prevout is taken as 0 sats locked to the script of the first reserve, so that it is signed by the same keys
+// the only output pays the total reserves to OP_TRUE
+
+const RESERVES_COMMITMENT_PREFIX = "Proof-of-Reserves: "
+
+var RESERVES_OUTPUT_SCRIPT = []byte{txscript.OP_TRUE}
+
+func ReservesCommitment(message []byte) wire.OutPoint {
+	return wire.OutPoint{
+		Hash:  chainhash.Hash(sha256.Sum256(append([]byte(RESERVES_COMMITMENT_PREFIX), message...))),
+		Index: 0,
+	}
+}
+
+// unsigned proof of reserves over reserves with prevouts reserve_outs
+func NewReservesProofTx(message []byte, reserves []wire.OutPoint, reserve_outs []*wire.TxOut) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: ReservesCommitment(message),
+	})
+	total := int64(0)
+	for i, reserve := range reserves {
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: reserve,
+		})
+		total += reserve_outs[i].Value
+	}
+	tx.AddTxOut(&wire.TxOut{
+		Value:    total,
+		PkScript: RESERVES_OUTPUT_SCRIPT,
+	})
+
+	return tx
+}
+
+// prevouts of a proof of reserves, including the commitment input
+func ReservesPrevOutFetcher(tx *wire.MsgTx, reserve_outs []*wire.TxOut) *txscript.MultiPrevOutFetcher {
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	fetcher.AddPrevOut(tx.TxIn[0].PreviousOutPoint, &wire.TxOut{
+		Value:    0,
+		PkScript: reserve_outs[0].PkScript,
+	})
+	for i, reserve_out := range reserve_outs {
+		fetcher.AddPrevOut(tx.TxIn[i+1].PreviousOutPoint, reserve_out)
+	}
+
+	return fetcher
+}
+
+// verify a proof of reserves over message against the static utxo viewpoint
+// reserves must be unspent, and every input including the commitment input must be signed
+// return the total reserves proven
+func (suite *TestSuite) VerifyReservesProof(tx *wire.MsgTx, message []byte) (int64, error) {
+	if len(tx.TxIn) < 2 {
+		return 0, fmt.Errorf("proof of reserves has no reserves")
+	}
+	// a reserve spent twice would be counted twice, a second commitment input is a duplicate as well
+	if err := blockchain.CheckTransactionSanity(btcutil.NewTx(tx)); err != nil {
+		return 0, fmt.Errorf("proof of reserves is malformed: %v", err)
+	}
+	if tx.TxIn[0].PreviousOutPoint != ReservesCommitment(message) {
+		return 0, fmt.Errorf("commitment input %v does not commit to the message", tx.TxIn[0].PreviousOutPoint)
+	}
+	if len(tx.TxOut) != 1 || !bytes.Equal(tx.TxOut[0].PkScript, RESERVES_OUTPUT_SCRIPT) {
+		return 0, fmt.Errorf("proof of reserves must have a single OP_TRUE output")
+	}
+
+	reserve_outs := make([]*wire.TxOut, 0, len(tx.TxIn)-1)
+	total := int64(0)
+	var err error
+	suite.ReadUtxoViewpoint(func(view *blockchain.UtxoViewpoint) {
+		for _, txIn := range tx.TxIn[1:] {
+			entry := view.LookupEntry(txIn.PreviousOutPoint)
+			if entry == nil || entry.IsSpent() {
+				err = fmt.Errorf("reserve %v is not unspent", txIn.PreviousOutPoint)
+				return
+			}
+			reserve_outs = append(reserve_outs, &wire.TxOut{
+				Value:    entry.Amount(),
+				PkScript: entry.PkScript(),
+			})
+			total += entry.Amount()
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if tx.TxOut[0].Value != total {
+		return 0, fmt.Errorf("proof of reserves claims %d sats, reserves hold %d sats", tx.TxOut[0].Value, total)
+	}
+
+	fetcher := ReservesPrevOutFetcher(tx, reserve_outs)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i := range tx.TxIn {
+		prev_out := fetcher.FetchPrevOutput(tx.TxIn[i].PreviousOutPoint)
+		vm, err := txscript.NewEngine(prev_out.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prev_out.Value, fetcher)
+		if err != nil {
+			return 0, err
+		}
+		if err := vm.Execute(); err != nil {
+			return 0, fmt.Errorf("input %d of proof of reserves is not signed: %v", i, err)
+		}
+	}
+
+	return total, nil
+}
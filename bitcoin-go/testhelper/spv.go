@@ -0,0 +1,420 @@
+package testhelper
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// timestamp of a header must be after the median timestamp of this many previous headers
+	MEDIAN_TIME_BLOCKS = 11
+)
+
+type headerNode struct {
+	header wire.BlockHeader
+	height int64
+	// total work of the chain ending at this header
+	work   *big.Int
+	parent *headerNode
+}
+
+// bitcoin header chain of a light client, starting at the genesis block of chain params
+// every header is checked for proof of work and for the difficulty required by chain params
+// headers of all branches are kept, and the branch with the most work is the best chain
+// index of a header in the best chain is its block height
+type HeaderChain struct {
+	params *chaincfg.Params
+	nodes  map[chainhash.Hash]*headerNode
+	best   []*headerNode
+}
+
+func NewHeaderChain(params *chaincfg.Params) *HeaderChain {
+	genesis := &headerNode{
+		header: params.GenesisBlock.Header,
+		work:   blockchain.CalcWork(params.GenesisBlock.Header.Bits),
+	}
+
+	return &HeaderChain{
+		params: params,
+		nodes:  map[chainhash.Hash]*headerNode{params.GenesisBlock.Header.BlockHash(): genesis},
+		best:   []*headerNode{genesis},
+	}
+}
+
+func (c *HeaderChain) Height() int64 {
+	return int64(len(c.best) - 1)
+}
+
+func (c *HeaderChain) TipHash() chainhash.Hash {
+	return c.best[len(c.best)-1].header.BlockHash()
+}
+
+// header at block height of the best chain, nil if the chain is not that long
+func (c *HeaderChain) Header(height int64) *wire.BlockHeader {
+	if height < 0 || height > c.Height() {
+		return nil
+	}
+	header := c.best[height].header
+	return &header
+}
+
+// add a header on top of any known header
+// the best chain switches to the branch of the header once that branch has more work
+func (c *HeaderChain) AddHeader(header *wire.BlockHeader) error {
+	hash := header.BlockHash()
+	if _, ok := c.nodes[hash]; ok {
+		return nil
+	}
+	parent, ok := c.nodes[header.PrevBlock]
+	if !ok {
+		return fmt.Errorf("header %s: prev block %s is unknown", hash, header.PrevBlock)
+	}
+	height := parent.height + 1
+	if median_time := medianTime(parent); !header.Timestamp.After(median_time) {
+		return fmt.Errorf("header %d: timestamp %v is not after median time %v", height, header.Timestamp, median_time)
+	}
+	if bits := c.nextBits(parent, header.Timestamp); header.Bits != bits {
+		return fmt.Errorf("header %d: bits %08x, expected %08x", height, header.Bits, bits)
+	}
+	if err := checkProofOfWork(header, c.params.PowLimit); err != nil {
+		return fmt.Errorf("header %d: %v", height, err)
+	}
+
+	node := &headerNode{
+		header: *header,
+		height: height,
+		work:   new(big.Int).Add(parent.work, blockchain.CalcWork(header.Bits)),
+		parent: parent,
+	}
+	c.nodes[hash] = node
+	if node.work.Cmp(c.best[len(c.best)-1].work) <= 0 {
+		return nil
+	}
+
+	// switch the best chain down to the last header it shares with the new branch
+	c.best = c.best[:min(int64(len(c.best)), height+1)]
+	for len(c.best) <= int(height) {
+		c.best = append(c.best, nil)
+	}
+	for ; node != nil && c.best[node.height] != node; node = node.parent {
+		c.best[node.height] = node
+	}
+
+	return nil
+}
+
+// height of the last header that the best chain shares with the chain ending at hash, -1 if hash is unknown
+// it is below the height of hash when hash has been reorganized out of the best chain
+func (c *HeaderChain) ForkHeight(hash chainhash.Hash) int64 {
+	node, ok := c.nodes[hash]
+	if !ok {
+		return -1
+	}
+	for node.height > c.Height() || c.best[node.height] != node {
+		node = node.parent
+	}
+
+	return node.height
+}
+
+// median timestamp of the last headers ending at node
+func medianTime(node *headerNode) time.Time {
+	timestamps := make([]int64, 0, MEDIAN_TIME_BLOCKS)
+	for ; node != nil && len(timestamps) < MEDIAN_TIME_BLOCKS; node = node.parent {
+		timestamps = append(timestamps, node.header.Timestamp.Unix())
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return time.Unix(timestamps[len(timestamps)/2], 0)
+}
+
+// difficulty bits required for a header at timestamp on top of prev block, following the retarget rules of btcd
+func (c *HeaderChain) NextBits(prev_block chainhash.Hash, timestamp time.Time) (uint32, error) {
+	parent, ok := c.nodes[prev_block]
+	if !ok {
+		return 0, fmt.Errorf("prev block %s is unknown", prev_block)
+	}
+
+	return c.nextBits(parent, timestamp), nil
+}
+
+func (c *HeaderChain) nextBits(parent *headerNode, timestamp time.Time) uint32 {
+	params := c.params
+	if params.PoWNoRetargeting {
+		return params.PowLimitBits
+	}
+
+	blocks_per_retarget := int64(params.TargetTimespan / params.TargetTimePerBlock)
+	if (parent.height+1)%blocks_per_retarget != 0 {
+		if !params.ReduceMinDifficulty {
+			return parent.header.Bits
+		}
+		// minimum difficulty is allowed when no block has been mined for too long
+		if timestamp.Unix() > parent.header.Timestamp.Unix()+int64(params.MinDiffReductionTime/time.Second) {
+			return params.PowLimitBits
+		}
+		// otherwise the difficulty of the last block without the minimum difficulty rule applied
+		node := parent
+		for node.parent != nil && node.height%blocks_per_retarget != 0 && node.header.Bits == params.PowLimitBits {
+			node = node.parent
+		}
+		return node.header.Bits
+	}
+
+	// retarget by the time the last blocks have taken, limited by the adjustment factor
+	first := parent
+	for i := int64(0); i < blocks_per_retarget-1; i++ {
+		first = first.parent
+	}
+	target_timespan := int64(params.TargetTimespan / time.Second)
+	actual_timespan := parent.header.Timestamp.Unix() - first.header.Timestamp.Unix()
+	if min_timespan := target_timespan / params.RetargetAdjustmentFactor; actual_timespan < min_timespan {
+		actual_timespan = min_timespan
+	} else if max_timespan := target_timespan * params.RetargetAdjustmentFactor; actual_timespan > max_timespan {
+		actual_timespan = max_timespan
+	}
+	target := new(big.Int).Mul(blockchain.CompactToBig(parent.header.Bits), big.NewInt(actual_timespan))
+	target.Div(target, big.NewInt(target_timespan))
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+
+	return blockchain.BigToCompact(target)
+}
+
+// confirmations of a block at height of the best chain, including the block itself
+func (c *HeaderChain) Confirmations(height int64) int64 {
+	if height < 0 || height > c.Height() {
+		return 0
+	}
+	return c.Height() - height + 1
+}
+
+// verify that a transaction is included in the block at height of the best chain
+func (c *HeaderChain) VerifyTxInclusion(tx_hash chainhash.Hash, height int64, proof *MerkleProof) error {
+	header := c.Header(height)
+	if header == nil {
+		return fmt.Errorf("no header at height %d, tip is %d", height, c.Height())
+	}
+	root := proof.Root(tx_hash)
+	if root != header.MerkleRoot {
+		return fmt.Errorf("tx %s is not included in block %d: merkle root %s, expected %s", tx_hash, height, root, header.MerkleRoot)
+	}
+
+	return nil
+}
+
+func checkProofOfWork(header *wire.BlockHeader, pow_limit *big.Int) error {
+	target := blockchain.CompactToBig(header.Bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("target %064x is too low", target)
+	}
+	if target.Cmp(pow_limit) > 0 {
+		return fmt.Errorf("target %064x is higher than pow limit %064x", target, pow_limit)
+	}
+	hash := header.BlockHash()
+	if blockchain.HashToBig(&hash).Cmp(target) > 0 {
+		return fmt.Errorf("block hash %s is higher than target %064x", hash, target)
+	}
+
+	return nil
+}
+
+// merkle branch of a transaction at index in its block
+// each hash is the sibling on the way from the transaction to the merkle root
+type MerkleProof struct {
+	Index  uint32
+	Hashes []chainhash.Hash
+}
+
+func NewMerkleProof(txs []*wire.MsgTx, index int) *MerkleProof {
+	hashes := make([]chainhash.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.TxHash()
+	}
+
+	proof := &MerkleProof{Index: uint32(index)}
+	for len(hashes) > 1 {
+		// the last hash of an odd level is paired with itself
+		if len(hashes)%2 == 1 {
+			hashes = append(hashes, hashes[len(hashes)-1])
+		}
+		proof.Hashes = append(proof.Hashes, hashes[index^1])
+
+		next := make([]chainhash.Hash, len(hashes)/2)
+		for i := range next {
+			next[i] = blockchain.HashMerkleBranches(&hashes[2*i], &hashes[2*i+1])
+		}
+		hashes = next
+		index /= 2
+	}
+
+	return proof
+}
+
+// merkle root that the branch leads to from tx hash
+func (p *MerkleProof) Root(tx_hash chainhash.Hash) chainhash.Hash {
+	root := tx_hash
+	index := p.Index
+	for _, sibling := range p.Hashes {
+		if index%2 == 0 {
+			root = blockchain.HashMerkleBranches(&root, &sibling)
+		} else {
+			root = blockchain.HashMerkleBranches(&sibling, &root)
+		}
+		index /= 2
+	}
+
+	return root
+}
+
+// height of the last mock mined block
+func (suite *TestSuite) MockTipHeight() int64 {
+	return int64(len(suite.MockBlocks))
+}
+
+// mock mining a block of txs on top of the last mock mined block
+// the block pays a coinbase, solves proof of work, and its txs are mock mined into the utxo viewpoint at its height
+func (suite *TestSuite) MockMineBlock(txs []*wire.MsgTx) *wire.MsgBlock {
+	height := suite.MockTipHeight() + 1
+	prev := suite.BtcdChainConfig.GenesisBlock.Header
+	if height > 1 {
+		prev = suite.MockBlocks[height-2].Header
+	}
+
+	// coinbase commits to the block height and to the number of blocks mined so far
+	// so that blocks of different branches never share a hash
+	coinbase_script, err := txscript.NewScriptBuilder().AddInt64(height).AddInt64(suite.mockMinedNum).Script()
+	assert.NoError(suite.T, err)
+	suite.mockMinedNum++
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex),
+		SignatureScript:  coinbase_script,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_TRUE}))
+
+	block_txs := append([]*wire.MsgTx{coinbase}, txs...)
+	utxs := make([]*btcutil.Tx, len(block_txs))
+	for i, tx := range block_txs {
+		utxs[i] = btcutil.NewTx(tx)
+	}
+
+	timestamp := prev.Timestamp.Add(suite.BtcdChainConfig.TargetTimePerBlock)
+	bits, err := suite.HeaderChain.NextBits(prev.BlockHash(), timestamp)
+	assert.NoError(suite.T, err)
+	header := wire.BlockHeader{
+		Version:    4,
+		PrevBlock:  prev.BlockHash(),
+		MerkleRoot: blockchain.CalcMerkleRoot(utxs, false),
+		Timestamp:  timestamp,
+		Bits:       bits,
+	}
+	for checkProofOfWork(&header, suite.BtcdChainConfig.PowLimit) != nil {
+		header.Nonce++
+	}
+	err = suite.HeaderChain.AddHeader(&header)
+	assert.NoError(suite.T, err)
+
+	for _, tx := range txs {
+		// mock first txs have no prevout to spend
+		if isMockFirstTx(tx) {
+			suite.utxoLock.Lock()
+			suite.UtxoViewpoint.AddTxOuts(btcutil.NewTx(tx), int32(height))
+			suite.utxoLock.Unlock()
+			continue
+		}
+		suite.MockMineTx(tx, int32(height))
+	}
+
+	block := wire.NewMsgBlock(&header)
+	block.Transactions = block_txs
+	suite.MockBlocks = append(suite.MockBlocks, block)
+	return block
+}
+
+// broadcast a tx to the mock mempool, every validator broadcasts the same finalized txs
+// a tx that is already in the mempool or already mined is ignored
+func (suite *TestSuite) BroadcastTx(tx *wire.MsgTx) {
+	suite.mempoolLock.Lock()
+	defer suite.mempoolLock.Unlock()
+
+	tx_hash := tx.TxHash()
+	for _, pending := range suite.mockMempool {
+		if pending.TxHash() == tx_hash {
+			return
+		}
+	}
+	if suite.FetchUtxo(wire.OutPoint{Hash: tx_hash, Index: 0}) != nil {
+		return
+	}
+	suite.mockMempool = append(suite.mockMempool, tx)
+}
+
+// txs of the mock mempool, in the order they were broadcasted
+func (suite *TestSuite) MempoolTxs() []*wire.MsgTx {
+	suite.mempoolLock.Lock()
+	defer suite.mempoolLock.Unlock()
+
+	return append([]*wire.MsgTx{}, suite.mockMempool...)
+}
+
+// mock mining a block of all txs in the mock mempool, in the order they were broadcasted so that parents come before children
+func (suite *TestSuite) MockMineMempool() *wire.MsgBlock {
+	suite.mempoolLock.Lock()
+	txs := suite.mockMempool
+	suite.mockMempool = nil
+	suite.mempoolLock.Unlock()
+
+	return suite.MockMineBlock(txs)
+}
+
+// mock a reorg by disconnecting mock mined blocks above fork height, the last block first
+// outputs of their txs are removed from the utxo viewpoint and prevouts they spent are unspent
+// next mock mined blocks build a competing branch from fork height
+func (suite *TestSuite) MockReorg(fork_height int64) []*wire.MsgBlock {
+	suite.utxoLock.Lock()
+	defer suite.utxoLock.Unlock()
+
+	disconnected := suite.MockBlocks[fork_height:]
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		txs := disconnected[i].Transactions[1:]
+		for j := len(txs) - 1; j >= 0; j-- {
+			tx := txs[j]
+			tx_hash := tx.TxHash()
+			for out_index := range tx.TxOut {
+				delete(suite.UtxoViewpoint.Entries(), wire.OutPoint{Hash: tx_hash, Index: uint32(out_index)})
+			}
+			if isMockFirstTx(tx) {
+				continue
+			}
+			for _, txIn := range tx.TxIn {
+				entry := suite.UtxoViewpoint.LookupEntry(txIn.PreviousOutPoint)
+				if entry == nil {
+					continue
+				}
+				suite.UtxoViewpoint.Entries()[txIn.PreviousOutPoint] = blockchain.NewUtxoEntry(
+					wire.NewTxOut(entry.Amount(), entry.PkScript()), entry.BlockHeight(), entry.IsCoinBase(),
+				)
+			}
+		}
+	}
+	suite.MockBlocks = suite.MockBlocks[:fork_height]
+
+	return disconnected
+}
+
+func isMockFirstTx(tx *wire.MsgTx) bool {
+	return len(tx.TxIn) == 1 && tx.TxIn[0].PreviousOutPoint.Hash == (chainhash.Hash{})
+}
@@ -2,6 +2,7 @@ package testhelper
 
 import (
 	"encoding/hex"
+	"io"
 	"log"
 	"sync"
 	"testing"
@@ -15,6 +16,7 @@ import (
 	_ "github.com/btcsuite/btcd/database/ffldb"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcwallet/chain"
 	"github.com/stretchr/testify/assert"
 )
@@ -37,6 +39,19 @@ type TestSuite struct {
 	UtxoViewpoint *blockchain.UtxoViewpoint
 	SigCache      *txscript.SigCache
 	HashCache     *txscript.HashCache
+	// headers of all blocks mock mined by MockMineBlock, and blocks of the branch being mined on
+	HeaderChain  *HeaderChain
+	MockBlocks   []*wire.MsgBlock
+	mockMinedNum int64
+	// utxo viewpoint is read by validators while the test mines transactions into it
+	utxoLock sync.RWMutex
+	// txs broadcasted by validators, waiting for the next block of MockMineMempool
+	mockMempool []*wire.MsgTx
+	mempoolLock sync.Mutex
+
+	// source of generated seeds, keys, polynomials and nonces, crypto/rand if nil
+	// a seeded source makes a run reproducible, it must only be used from a single goroutine
+	Rand io.Reader
 }
 
 func (s *TestSuite) SetupRegNetSuite(t assert.TestingT, log *log.Logger) {
@@ -54,6 +69,7 @@ func (s *TestSuite) SetupStaticSimNetSuite(t assert.TestingT, log *log.Logger) {
 	s.UtxoViewpoint = blockchain.NewUtxoViewpoint()
 	s.SigCache = txscript.NewSigCache(50000)
 	s.HashCache = txscript.NewHashCache(50000)
+	s.HeaderChain = NewHeaderChain(s.BtcdChainConfig)
 }
 
 func (s *TestSuite) SetupBenchmarkStaticSimNetSuite(b *testing.B, log *log.Logger) {
@@ -135,16 +151,21 @@ func (s *TestSuite) GenerateBlocks(num uint32) {
 }
 
 func (s *TestSuite) GenerateSeed() []byte {
-	seed, err := hdkeychain.GenerateSeed(hdkeychain.RecommendedSeedLen)
+	if s.Rand == nil {
+		seed, err := hdkeychain.GenerateSeed(hdkeychain.RecommendedSeedLen)
+		assert.Nil(s.T, err)
+		return seed
+	}
+
+	seed := make([]byte, hdkeychain.RecommendedSeedLen)
+	_, err := io.ReadFull(s.Rand, seed)
 	assert.Nil(s.T, err)
 	return seed
 }
 
 func (s *TestSuite) Generate32BSeed() [hdkeychain.RecommendedSeedLen]byte {
 	var res [hdkeychain.RecommendedSeedLen]byte
-	seed, err := hdkeychain.GenerateSeed(hdkeychain.RecommendedSeedLen)
-	assert.Nil(s.T, err)
-	copy(res[:], seed)
+	copy(res[:], s.GenerateSeed())
 	return res
 }
 
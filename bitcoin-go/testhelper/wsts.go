@@ -2,6 +2,7 @@ package testhelper
 
 import (
 	"math"
+	"math/big"
 	"math/rand"
 	"sync"
 	"time"
@@ -310,33 +311,18 @@ func (s *TestSuite) RandomHonestSet(n_p, n_keys int64, keys []int64) []int64 {
 	return honest_set
 }
 
+// randomly distribute shares of keys to participants, each participant has at least one key
 func (s *TestSuite) DeriveSharesOfKeys(n_p, n_keys int64) []int64 {
 	randsource := rand.New(rand.NewSource(time.Now().UnixNano()))
-	total := int64(0)
-	shares := make([]float64, n_p)
-	keys := make([]int64, n_p)
-	// randomly distribute shares of keys to participants
+	weights := make([]*big.Int, n_p)
 	for i := int64(0); i < n_p; i++ {
-		rand_vp := randsource.Int63n(1000000)
-		total += rand_vp
-		shares[i] = float64(rand_vp)
+		weights[i] = big.NewInt(randsource.Int63n(1000000))
 	}
 
-	total_keys := int64(0)
-	for i := int64(1); i < n_p; i++ {
-		term := (shares[i] / float64(total)) * float64(n_keys)
-		keys[i] = int64(math.Floor(term))
+	allocation, err := Apportion(weights, n_keys, 1, LargestRemainder)
+	assert.NoError(s.T, err)
 
-		if keys[i] == 0 {
-			keys[i] = 1
-		}
-
-		total_keys += keys[i]
-	}
-	keys[0] = n_keys - total_keys
-	assert.Greater(s.T, keys[0], int64(0))
-
-	return keys
+	return allocation.Seats
 }
 
 func (s *TestSuite) DeriveRangeOfKeys(keys []int64) map[int64][2]int64 {
@@ -0,0 +1,464 @@
+package wsts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"cosmossdk.io/math"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// misbehaviour of a byzantine validator
+type Fault string
+
+const (
+	FAULT_CORRUPT_SECRET_SHARES  Fault = "corrupt_secret_shares"
+	FAULT_WRONG_POLY_COMMITMENTS Fault = "wrong_poly_commitments"
+	FAULT_INVALID_ADAPT_SIG      Fault = "invalid_adapt_sig"
+	FAULT_EQUIVOCATE_ADAPT_SIG   Fault = "equivocate_adapt_sig"
+	FAULT_DUPLICATE_NONCES       Fault = "duplicate_nonces"
+	FAULT_WITHHOLD_NONCES        Fault = "withhold_nonces"
+	FAULT_DELAY_MESSAGES         Fault = "delay_messages"
+	FAULT_DROP_MESSAGES          Fault = "drop_messages"
+
+	// time for honest validators to process messages of byzantine validators before acting
+	BYZANTINE_SETTLE_TIME = 1000 * time.Millisecond
+	// time for honest validators to finalize a signing session before timing out
+	BYZANTINE_SIGNING_TIMEOUT = 5 * time.Second
+)
+
+type FaultSpec struct {
+	Validator int64 `json:"validator"`
+	Fault     Fault `json:"fault"`
+	// message types that delay and drop faults apply to, all message types if empty
+	// named as in envelopeType
+	MsgTypes []string `json:"msg_types"`
+	DelayMs  int64    `json:"delay_ms"`
+}
+
+// scenario files in testdata/byzantine describe which validators misbehave how
+// and which validators honest validators are expected to exclude
+type ByzantineScenario struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Vps         []string    `json:"vps"`
+	Keys        int64       `json:"keys"`
+	Threshold   int64       `json:"threshold"`
+	Faults      []FaultSpec `json:"faults"`
+	// validators that all honest validators end up excluding
+	ExpectExcluded []int64 `json:"expect_excluded"`
+	// honest validators cannot derive the group key with a faulty DKG
+	ExpectDKGAborted bool `json:"expect_dkg_aborted"`
+	// number of checkpoints honest validators sign after DKG
+	Checkpoints int64 `json:"checkpoints"`
+	// kinds of evidence that every honest validator holds, named as in evidenceKind
+	ExpectEvidence []string `json:"expect_evidence"`
+}
+
+func loadByzantineScenario(t *testing.T, path string) *ByzantineScenario {
+	scenarioBytes, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	scenario := &ByzantineScenario{}
+	err = json.Unmarshal(scenarioBytes, scenario)
+	assert.NoError(t, err)
+
+	return scenario
+}
+
+func (f *FaultSpec) appliesTo(msgType string) bool {
+	switch f.Fault {
+	case FAULT_CORRUPT_SECRET_SHARES:
+		return msgType == "secret_shares"
+	case FAULT_WRONG_POLY_COMMITMENTS:
+		return msgType == "proofs"
+	case FAULT_INVALID_ADAPT_SIG, FAULT_EQUIVOCATE_ADAPT_SIG:
+		return msgType == "adapt_sig"
+	case FAULT_DUPLICATE_NONCES, FAULT_WITHHOLD_NONCES:
+		return msgType == "nonce_commitments"
+	}
+
+	if len(f.MsgTypes) == 0 {
+		return true
+	}
+	for _, name := range f.MsgTypes {
+		if name == msgType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// byzantinePeer sits between a byzantine validator and one of its peers
+// and tampers with messages on the way, so that the byzantine validator itself runs the honest code
+// tampered messages are sealed again with the key of the byzantine validator
+type byzantinePeer struct {
+	ReceivableValidator
+
+	suite  *testhelper.TestSuite
+	signer *MockValidator
+	faults []FaultSpec
+}
+
+func (p *byzantinePeer) SendMessageOnChain(msg []byte) {
+	p.relay(msg, p.ReceivableValidator.SendMessageOnChain)
+}
+
+func (p *byzantinePeer) SendMessageOffChain(msg []byte) {
+	p.relay(msg, p.ReceivableValidator.SendMessageOffChain)
+}
+
+func (p *byzantinePeer) relay(msg []byte, send func([]byte)) {
+	msgs := [][]byte{msg}
+	delay := time.Duration(0)
+	msgType := envelopeType(p.unmarshal(msg))
+	for _, fault := range p.faults {
+		if !fault.appliesTo(msgType) {
+			continue
+		}
+
+		switch fault.Fault {
+		case FAULT_CORRUPT_SECRET_SHARES:
+			msgs = [][]byte{p.corruptSecretShares(msg)}
+		case FAULT_WRONG_POLY_COMMITMENTS:
+			msgs = [][]byte{p.wrongPolyCommitments(msg)}
+		case FAULT_INVALID_ADAPT_SIG:
+			msgs = [][]byte{p.invalidAdaptSig(msg)}
+		case FAULT_EQUIVOCATE_ADAPT_SIG:
+			go p.equivocateAdaptSig(msg, send)
+		case FAULT_DUPLICATE_NONCES:
+			msgs = [][]byte{p.duplicateNonces(msg)}
+		case FAULT_WITHHOLD_NONCES, FAULT_DROP_MESSAGES:
+			msgs = nil
+		case FAULT_DELAY_MESSAGES:
+			delay = time.Duration(fault.DelayMs) * time.Millisecond
+		}
+	}
+
+	for _, out := range msgs {
+		if delay > 0 {
+			go func(out []byte) {
+				time.Sleep(delay)
+				send(out)
+			}(out)
+			continue
+		}
+		send(out)
+	}
+}
+
+// each secret share is shifted by one
+func (p *byzantinePeer) corruptSecretShares(msg []byte) []byte {
+	env := p.unmarshal(msg)
+	msgStruct := env.GetSecretShares()
+	one := new(btcec.ModNScalar).SetInt(1)
+	for _, secretShare := range msgStruct.SecretShares {
+		share := new(btcec.ModNScalar)
+		share.SetByteSlice(secretShare.SecretShares)
+		share.Add(one)
+		shareBytes := share.Bytes()
+		secretShare.SecretShares = shareBytes[:]
+	}
+
+	return p.signer.sealEnvelope(env.Payload)
+}
+
+// the highest degree commitment is replaced, so that the secret proof over the constant term still verifies
+func (p *byzantinePeer) wrongPolyCommitments(msg []byte) []byte {
+	env := p.unmarshal(msg)
+	msgStruct := env.GetUpdateProofs()
+	_, generator := btcec.PrivKeyFromBytes([]byte{1})
+	msgStruct.PolynomialCommitments[len(msgStruct.PolynomialCommitments)-1] = generator.SerializeCompressed()
+
+	return p.signer.sealEnvelope(env.Payload)
+}
+
+// a conflicting adapt sig is sent once the peer has finalized the checkpoint with the original one
+// so that every honest peer holds the original adapt sig as evidence, whatever order messages arrive in
+func (p *byzantinePeer) equivocateAdaptSig(msg []byte, send func([]byte)) {
+	msgStruct := p.unmarshal(msg).GetUpdateAdaptSig()
+
+	peer, ok := unwrapValidator(p.ReceivableValidator)
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(BYZANTINE_SIGNING_TIMEOUT)
+	for query(peer, peer.GetCheckPointHeight) <= msgStruct.CheckpointHeight {
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	send(p.invalidAdaptSig(msg))
+}
+
+// s of the adapt sig is shifted by one
+func (p *byzantinePeer) invalidAdaptSig(msg []byte) []byte {
+	env := p.unmarshal(msg)
+	msgStruct := env.GetUpdateAdaptSig()
+	s := new(btcec.ModNScalar)
+	s.SetByteSlice(msgStruct.AdaptSig[32:64])
+	s.Add(new(btcec.ModNScalar).SetInt(1))
+	sBytes := s.Bytes()
+	msgStruct.AdaptSig = append(append([]byte{}, msgStruct.AdaptSig[:32]...), sBytes[:]...)
+
+	return p.signer.sealEnvelope(env.Payload)
+}
+
+// the first nonce commitments are repeated for every signing index
+func (p *byzantinePeer) duplicateNonces(msg []byte) []byte {
+	env := p.unmarshal(msg)
+	msgStruct := env.GetUpdateNonceCommitments()
+	for i := range msgStruct.NonceCommitments {
+		msgStruct.NonceCommitments[i] = msgStruct.NonceCommitments[0]
+	}
+
+	return p.signer.sealEnvelope(env.Payload)
+}
+
+// a byzantine validator reads its own envelopes without verifying them
+func (p *byzantinePeer) unmarshal(msg []byte) *Envelope {
+	env := &Envelope{}
+	err := proto.Unmarshal(msg, env)
+	assert.NoError(p.suite.T, err)
+
+	return env
+}
+
+// setup validators with vp of the scenario, and exchange vp before any fault is installed
+func newScenarioValidatorSet(t *testing.T, suite *testhelper.TestSuite, scenario *ByzantineScenario) []*MockValidator {
+	n := int64(len(scenario.Vps))
+	validators := make([]*MockValidator, n)
+	for i := int64(0); i < n; i++ {
+		path := fmt.Sprintf("../debug/validator_%d.log", i+1)
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		logger := log.New(file, "", log.LstdFlags)
+
+		frost := testhelper.NewFrostParticipant(suite, logger, scenario.Keys, scenario.Threshold, i+1, nil)
+		validators[i] = NewMockValidator(suite, logger, file, frost, n, i+1)
+		vp := math.LegacyMustNewDecFromStr(scenario.Vps[i])
+		validators[i].protocolStorage.store[VP_STORE_KEY][fmt.Sprint(i+1)] = vpToBytes(suite, vp)
+	}
+
+	connectValidators(validators)
+	recordTranscripts(t, validators, wallClock())
+
+	for _, validator := range validators {
+		validator.Do(validator.SendVPToAll)
+	}
+	waitVPPropagated(validators)
+
+	return validators
+}
+
+// wrap all peers of byzantine validators with their faults
+// return positions of byzantine validators
+func installFaults(suite *testhelper.TestSuite, validators []*MockValidator, faults []FaultSpec) map[int64]bool {
+	validator_faults := make(map[int64][]FaultSpec)
+	for _, fault := range faults {
+		validator_faults[fault.Validator] = append(validator_faults[fault.Validator], fault)
+	}
+
+	byzantine := make(map[int64]bool)
+	for posi, faults := range validator_faults {
+		byzantine[posi] = true
+		validator := validators[posi-1]
+		for j, peer := range validator.otherVals {
+			validator.otherVals[j] = &byzantinePeer{
+				ReceivableValidator: peer,
+				suite:               suite,
+				signer:              validator,
+				faults:              faults,
+			}
+		}
+	}
+
+	return byzantine
+}
+
+// honest validators finish DKG when they have the group key, or abort it when they have excluded a validator
+func waitDKGOrExclusion(t *testing.T, honest []*MockValidator) {
+	timeout := time.After(30 * time.Second)
+	for _, validator := range honest {
+		for !query(validator, func() bool { return validator.frost.GroupPublicKey != nil || len(validator.dishonestVals) > 0 }) {
+			select {
+			case <-timeout:
+				t.Fatalf("validator %d has neither finished nor aborted DKG", validator.GetPosition())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	time.Sleep(BYZANTINE_SETTLE_TIME)
+}
+
+func waitCheckPointFinalized(validators []*MockValidator, checkpoint_heights []int64, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for i, validator := range validators {
+		for query(validator, validator.GetCheckPointHeight) == checkpoint_heights[i] {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	return true
+}
+
+// byzantine validators sign first, so that honest validators observe the misbehaviour before signing
+// honest validators that time out on missing adapt sigs exclude the silent validators and sign again
+func signCheckPointWithFaults(t *testing.T, honest, byzantine []*MockValidator) {
+	checkpoint_heights := make([]int64, len(honest))
+	for i, validator := range honest {
+		checkpoint_heights[i] = query(validator, validator.GetCheckPointHeight)
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		for _, validator := range byzantine {
+			// a byzantine validator can finalize a checkpoint on its own view, and no longer follows honest validators
+			if query(validator, validator.GetCheckPointHeight) != checkpoint_heights[0] {
+				continue
+			}
+			if err := query(validator, validator.DeriveTxAndSign); err != nil {
+				t.Logf("byzantine validator %d cannot sign: %v", validator.GetPosition(), err)
+			}
+		}
+		time.Sleep(BYZANTINE_SETTLE_TIME)
+
+		var wgGroup sync.WaitGroup
+		for _, validator := range honest {
+			wgGroup.Add(1)
+			go func(validator *MockValidator) {
+				if err := query(validator, validator.DeriveTxAndSign); err != nil {
+					t.Logf("validator %d cannot sign: %v", validator.GetPosition(), err)
+				}
+				wgGroup.Done()
+			}(validator)
+		}
+		wgGroup.Wait()
+
+		if waitCheckPointFinalized(honest, checkpoint_heights, BYZANTINE_SIGNING_TIMEOUT) {
+			return
+		}
+		t.Logf("signing attempt %d has timed out", attempt)
+		for _, validator := range honest {
+			validator.Do(validator.ExcludeMissingAdaptSigs)
+		}
+	}
+
+	t.Fatalf("honest validators have not finalized checkpoint %d", checkpoint_heights[0])
+}
+
+func runByzantineScenario(t *testing.T, scenario *ByzantineScenario) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validators := newScenarioValidatorSet(t, &suite, scenario)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+
+	byzantine_posi := installFaults(&suite, validators, scenario.Faults)
+	honest := make([]*MockValidator, 0)
+	byzantine := make([]*MockValidator, 0)
+	for _, validator := range validators {
+		if byzantine_posi[validator.GetPosition()] {
+			byzantine = append(byzantine, validator)
+		} else {
+			honest = append(honest, validator)
+		}
+	}
+
+	runDKG(t, validators)
+	waitDKGOrExclusion(t, honest)
+	if scenario.ExpectDKGAborted {
+		for _, validator := range honest {
+			assert.Nil(t, validator.frost.GroupPublicKey, "validator %d derived a group key with a faulty DKG", validator.GetPosition())
+			assert.Equal(t, scenario.ExpectExcluded, validator.GetExcludedValidators(), "validator %d", validator.GetPosition())
+		}
+		assertScenarioEvidence(t, scenario, honest)
+		return
+	}
+
+	for _, validator := range honest {
+		assert.NotNil(t, validator.frost.GroupPublicKey)
+		assert.True(t, validator.frost.GroupPublicKey.IsEqual(honest[0].frost.GroupPublicKey))
+	}
+
+	mockGenesisCheckPoint(&suite, append(honest, byzantine...), 1000000000)
+	sendNonces(validators, 30)
+	time.Sleep(BYZANTINE_SETTLE_TIME)
+	for _, validator := range honest {
+		validator.Do(validator.ExcludeWithheldNonces)
+	}
+
+	for checkpoint := int64(1); checkpoint <= scenario.Checkpoints; checkpoint++ {
+		signCheckPointWithFaults(t, honest, byzantine)
+
+		signed_txs := honest[0].GetSignedTxs(checkpoint)
+		for _, validator := range honest[1:] {
+			other_txs := validator.GetSignedTxs(checkpoint)
+			assert.Equal(t, len(signed_txs), len(other_txs))
+			for i := range signed_txs {
+				assert.Equal(t, signed_txs[i].TxHash(), other_txs[i].TxHash())
+			}
+		}
+		for _, signed_tx := range signed_txs {
+			suite.MockMineTx(signed_tx, int32(checkpoint))
+		}
+	}
+
+	// late evidence, such as an equivocating adapt sig, can still arrive after the last checkpoint
+	time.Sleep(BYZANTINE_SETTLE_TIME)
+	for _, validator := range honest {
+		assert.Equal(t, scenario.ExpectExcluded, validator.GetExcludedValidators(), "validator %d", validator.GetPosition())
+	}
+	assertScenarioEvidence(t, scenario, honest)
+}
+
+// evidence held by an honest validator is against an excluded validator, and every other honest validator verifies it
+func assertScenarioEvidence(t *testing.T, scenario *ByzantineScenario, honest []*MockValidator) {
+	for _, validator := range honest {
+		kinds := make([]string, 0)
+		for _, evidence := range validator.GetEvidence() {
+			kinds = append(kinds, evidenceKind(evidence))
+			assert.Contains(t, scenario.ExpectExcluded, evidence.Accused)
+			for _, other := range honest {
+				assert.NoError(t, other.VerifyEvidence(evidence), "validator %d verifies evidence of validator %d", other.GetPosition(), validator.GetPosition())
+			}
+		}
+		if len(scenario.ExpectEvidence) == 0 {
+			assert.Empty(t, kinds, "validator %d", validator.GetPosition())
+			continue
+		}
+		assert.Equal(t, scenario.ExpectEvidence, kinds, "validator %d", validator.GetPosition())
+	}
+}
+
+// go test -v -run ^TestByzantineScenarios$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestByzantineScenarios(t *testing.T) {
+	paths, err := filepath.Glob("testdata/byzantine/*.json")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		scenario := loadByzantineScenario(t, path)
+		t.Run(scenario.Name, func(t *testing.T) {
+			t.Log(scenario.Description)
+			runByzantineScenario(t, scenario)
+		})
+	}
+}
@@ -0,0 +1,777 @@
+package wsts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	sdklog "cosmossdk.io/log"
+	"cosmossdk.io/math"
+	storetypes "cosmossdk.io/store/types"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/wire"
+	abci "github.com/cometbft/cometbft/abci/types"
+	cmtprotocrypto "github.com/cometbft/cometbft/proto/tendermint/crypto"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/cosmos/cosmos-sdk/baseapp"
+	cosmossecp "github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	WSTS_MODULE_NAME = "wsts"
+	COSMOS_CHAIN_ID  = "wsts-1"
+	// vote extensions of a height are included in the proposal of the next height
+	VOTE_EXTENSIONS_ENABLE_HEIGHT = int64(1)
+	// nonces each validator commits for signing
+	COSMOS_SIGNING_NUM = int64(10)
+
+	// module state
+	MODULE_GENESIS_STORE_KEY           = "genesis"
+	MODULE_VALIDATOR_STORE_KEY         = "validator/"
+	MODULE_VALIDATOR_ADDRESS_STORE_KEY = "validator_address/"
+	MODULE_GROUP_COMMITMENT_STORE_KEY  = "group_commitment/"
+	MODULE_GROUP_KEY_STORE_KEY         = "group_key"
+	MODULE_SIGNED_TX_STORE_KEY         = "signed_tx/"
+	MODULE_SIGNED_HEIGHT_STORE_KEY     = "signed_height"
+)
+
+// wstsModule is a cosmos app module that drives the validator of this node
+// on - chain messages of the validator are carried in its vote extensions, and applied to all validators in PreBlocker
+// the validator set and its voting power, the group key and finalized bitcoin transactions are kept in module state
+type wstsModule struct {
+	suite    *testhelper.TestSuite
+	storeKey *storetypes.KVStoreKey
+	// validator key of this node, also its consensus key
+	priv    *cosmossecp.PrivKey
+	network *cosmosNetwork
+
+	// validator is created from genesis
+	validator  *MockValidator
+	file       *os.File
+	dkgStarted bool
+	// nonces are committed once, they are enough for COSMOS_SIGNING_NUM signatures
+	noncesSent bool
+	// signing session this validator has signed for, it signs again after a session is aborted
+	signedSession string
+}
+
+func (m *wstsModule) Name() string {
+	return WSTS_MODULE_NAME
+}
+
+func (m *wstsModule) registerHandlers(app *baseapp.BaseApp) {
+	app.SetInitChainer(m.InitChainer)
+	app.SetPreBlocker(m.PreBlocker)
+	app.SetPrepareProposal(m.PrepareProposal)
+	app.SetProcessProposal(m.ProcessProposal)
+	app.SetExtendVoteHandler(m.ExtendVote)
+	app.SetVerifyVoteExtensionHandler(m.VerifyVoteExtension)
+}
+
+// validators of the genesis validator set are numbered in order, their voting power feeds key ranges
+func (m *wstsModule) InitChainer(ctx sdk.Context, req *abci.RequestInitChain) (*abci.ResponseInitChain, error) {
+	genesis := &GenesisState{}
+	if err := proto.Unmarshal(req.AppStateBytes, genesis); err != nil {
+		return nil, err
+	}
+	store := ctx.KVStore(m.storeKey)
+	store.Set([]byte(MODULE_GENESIS_STORE_KEY), req.AppStateBytes)
+
+	party_num := int64(len(req.Validators))
+	total_power := int64(0)
+	position := int64(0)
+	validators := make([]*ModuleValidator, party_num)
+	for i, update := range req.Validators {
+		pub_key := update.PubKey.GetSecp256K1()
+		if pub_key == nil {
+			return nil, fmt.Errorf("validator %d does not have a secp256k1 key", i+1)
+		}
+		validators[i] = &ModuleValidator{
+			Position: int64(i + 1),
+			PubKey:   pub_key,
+			Power:    update.Power,
+		}
+		total_power += update.Power
+		if bytes.Equal(pub_key, m.priv.PubKey().Bytes()) {
+			position = int64(i + 1)
+		}
+
+		validatorBytes, err := proto.Marshal(validators[i])
+		if err != nil {
+			return nil, err
+		}
+		store.Set(moduleValidatorKey(validators[i].Position), validatorBytes)
+		address := (&cosmossecp.PubKey{Key: pub_key}).Address()
+		store.Set(append([]byte(MODULE_VALIDATOR_ADDRESS_STORE_KEY), address...), sdk.Uint64ToBigEndian(uint64(validators[i].Position)))
+	}
+	if position == 0 {
+		return nil, fmt.Errorf("this node is not in the genesis validator set")
+	}
+
+	path := fmt.Sprintf("../debug/validator_%d.log", position)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New(file, "", log.LstdFlags)
+	frost := testhelper.NewFrostParticipant(m.suite, logger, genesis.NKeys, genesis.Threshold, position, nil)
+	m.validator = newMockValidator(m.suite, logger, file, frost, party_num, position)
+	m.validator.keyPair = m.suite.NewKeyPairFromBytes(m.priv.Bytes())
+	m.file = file
+
+	// vp of a validator is its share of the total voting power
+	for _, validator := range validators {
+		pub_key, err := btcec.ParsePubKey(validator.PubKey)
+		if err != nil {
+			return nil, err
+		}
+		m.validator.SetValidatorPubKey(validator.Position, pub_key)
+		vp := math.LegacyNewDec(validator.Power).QuoInt64(total_power)
+		m.validator.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(validator.Position, 10)] = vpToBytes(m.suite, vp)
+	}
+
+	return &abci.ResponseInitChain{Validators: req.Validators}, nil
+}
+
+// the next step of the validator is decided locally, its on - chain messages go into the vote extension
+func (m *wstsModule) ExtendVote(ctx sdk.Context, req *abci.RequestExtendVote) (*abci.ResponseExtendVote, error) {
+	v := m.validator
+	switch {
+	case !m.dkgStarted:
+		// DKG starts at the first height, secret shares wait off - chain until polynomial commitments are committed
+		v.DeriveAndSendProofs()
+		v.DeriveAndSendSecretShares()
+		m.dkgStarted = true
+	case v.frost.GroupPublicKey != nil && !m.noncesSent:
+		v.DeriveAndSendNonces(COSMOS_SIGNING_NUM)
+		m.noncesSent = true
+	case m.isSigningReady():
+		// nonces of other validators might not have been committed yet, sign again at the next height
+		if err := v.DeriveTxAndSign(); err != nil {
+			v.logger.Printf("cannot sign at height %d: %v\n", req.Height, err)
+			break
+		}
+		m.signedSession = m.signingSession()
+	}
+
+	extBytes, err := proto.Marshal(&VoteExtension{
+		Height:    req.Height,
+		Envelopes: m.network.takeOutbox(v.position),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &abci.ResponseExtendVote{VoteExtension: extBytes}, nil
+}
+
+func (m *wstsModule) VerifyVoteExtension(ctx sdk.Context, req *abci.RequestVerifyVoteExtension) (*abci.ResponseVerifyVoteExtension, error) {
+	if _, err := m.verifyVoteExtension(ctx, req.ValidatorAddress, req.VoteExtension, req.Height); err != nil {
+		m.validator.logger.Printf("reject vote extension at height %d: %v\n", req.Height, err)
+		return &abci.ResponseVerifyVoteExtension{Status: abci.ResponseVerifyVoteExtension_REJECT}, nil
+	}
+
+	return &abci.ResponseVerifyVoteExtension{Status: abci.ResponseVerifyVoteExtension_ACCEPT}, nil
+}
+
+// a vote extension only carries envelopes sealed by the validator that extends the vote
+// vote extensions are not signed by consensus keys in the in - process network, envelopes are signed by validator keys instead
+func (m *wstsModule) verifyVoteExtension(ctx sdk.Context, address []byte, extBytes []byte, height int64) (*VoteExtension, error) {
+	position := m.getValidatorPosition(ctx, address)
+	if position == 0 {
+		return nil, fmt.Errorf("unknown validator address %X", address)
+	}
+	ext := &VoteExtension{}
+	if err := proto.Unmarshal(extBytes, ext); err != nil {
+		return nil, fmt.Errorf("malformed vote extension from validator %d: %v", position, err)
+	}
+	if ext.Height != height {
+		return nil, fmt.Errorf("vote extension of validator %d is for height %d, expected %d", position, ext.Height, height)
+	}
+	for _, envBytes := range ext.Envelopes {
+		env, err := m.validator.verifyEnvelope(envBytes)
+		if err != nil {
+			return nil, err
+		}
+		if env.Source != position {
+			return nil, fmt.Errorf("vote extension of validator %d carries envelope of source %d", position, env.Source)
+		}
+	}
+
+	return ext, nil
+}
+
+// vote extensions of the last height are injected as the first tx of the proposal
+func (m *wstsModule) PrepareProposal(ctx sdk.Context, req *abci.RequestPrepareProposal) (*abci.ResponsePrepareProposal, error) {
+	txs := make([][]byte, 0, len(req.Txs)+1)
+	if req.Height > VOTE_EXTENSIONS_ENABLE_HEIGHT {
+		commitBytes, err := req.LocalLastCommit.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, commitBytes)
+	}
+	txs = append(txs, req.Txs...)
+
+	return &abci.ResponsePrepareProposal{Txs: txs}, nil
+}
+
+func (m *wstsModule) ProcessProposal(ctx sdk.Context, req *abci.RequestProcessProposal) (*abci.ResponseProcessProposal, error) {
+	if _, _, err := m.decodeProposal(ctx, req.Txs, req.Height); err != nil {
+		m.validator.logger.Printf("reject proposal at height %d: %v\n", req.Height, err)
+		return &abci.ResponseProcessProposal{Status: abci.ResponseProcessProposal_REJECT}, nil
+	}
+
+	return &abci.ResponseProcessProposal{Status: abci.ResponseProcessProposal_ACCEPT}, nil
+}
+
+// return vote extensions of the last height in validator order, and withdraw batches submitted as txs
+func (m *wstsModule) decodeProposal(ctx sdk.Context, txs [][]byte, height int64) ([]*VoteExtension, []*MsgBatchWithdraw, error) {
+	exts := make([]*VoteExtension, 0)
+	if height > VOTE_EXTENSIONS_ENABLE_HEIGHT {
+		if len(txs) == 0 {
+			return nil, nil, fmt.Errorf("proposal at height %d does not carry vote extensions", height)
+		}
+		commit := abci.ExtendedCommitInfo{}
+		if err := commit.Unmarshal(txs[0]); err != nil {
+			return nil, nil, err
+		}
+		for _, vote := range commit.Votes {
+			ext, err := m.verifyVoteExtension(ctx, vote.Validator.Address, vote.VoteExtension, height-1)
+			if err != nil {
+				return nil, nil, err
+			}
+			exts = append(exts, ext)
+		}
+		txs = txs[1:]
+	}
+
+	batches := make([]*MsgBatchWithdraw, len(txs))
+	for i, tx := range txs {
+		batches[i] = &MsgBatchWithdraw{}
+		if err := proto.Unmarshal(tx, batches[i]); err != nil {
+			return nil, nil, fmt.Errorf("malformed withdraw batch: %v", err)
+		}
+	}
+
+	return exts, batches, nil
+}
+
+// all validators apply the same on - chain messages in the same order
+// module state only changes with what has been committed, so that all nodes reach the same app hash
+func (m *wstsModule) PreBlocker(ctx sdk.Context, req *abci.RequestFinalizeBlock) (*sdk.ResponsePreBlock, error) {
+	v := m.validator
+
+	// messages that arrived too early for this validator are handled first
+	m.network.handleDeferred(v.position)
+
+	exts, batches, err := m.decodeProposal(ctx, req.Txs, req.Height)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range exts {
+		for _, envBytes := range ext.Envelopes {
+			m.commitEnvelope(ctx, envBytes)
+			v.handleMessageOnChain(envBytes)
+		}
+	}
+	for _, batch := range batches {
+		v.handleWithdrawBatch(batch)
+	}
+
+	m.updateGroupKey(ctx)
+	m.updateSignedTxs(ctx)
+
+	return &sdk.ResponsePreBlock{}, nil
+}
+
+// group key is the sum of committed constant terms of all validators
+func (m *wstsModule) commitEnvelope(ctx sdk.Context, envBytes []byte) {
+	env := &Envelope{}
+	err := proto.Unmarshal(envBytes, env)
+	assert.NoError(m.suite.T, err)
+	if proofs := env.GetUpdateProofs(); proofs != nil {
+		ctx.KVStore(m.storeKey).Set(moduleGroupCommitmentKey(env.Source), proofs.PolynomialCommitments[0])
+	}
+}
+
+func (m *wstsModule) updateGroupKey(ctx sdk.Context) {
+	store := ctx.KVStore(m.storeKey)
+	if store.Has([]byte(MODULE_GROUP_KEY_STORE_KEY)) {
+		return
+	}
+
+	group_key := new(btcec.JacobianPoint)
+	for i := int64(1); i <= m.validator.partyNum; i++ {
+		commitment_bytes := store.Get(moduleGroupCommitmentKey(i))
+		if commitment_bytes == nil {
+			return
+		}
+		commitment, err := btcec.ParsePubKey(commitment_bytes)
+		assert.NoError(m.suite.T, err)
+		commitment_point := new(btcec.JacobianPoint)
+		commitment.AsJacobian(commitment_point)
+		btcec.AddNonConst(group_key, commitment_point, group_key)
+	}
+	group_key.ToAffine()
+	store.Set([]byte(MODULE_GROUP_KEY_STORE_KEY), btcec.NewPublicKey(&group_key.X, &group_key.Y).SerializeCompressed())
+}
+
+// a checkpoint is finalized by the committed adapt sig that completes it, at the same height on all nodes
+func (m *wstsModule) updateSignedTxs(ctx sdk.Context) {
+	store := ctx.KVStore(m.storeKey)
+	checkpoint := int64(1)
+	if height_bytes := store.Get([]byte(MODULE_SIGNED_HEIGHT_STORE_KEY)); height_bytes != nil {
+		checkpoint = int64(binary.BigEndian.Uint64(height_bytes)) + 1
+	}
+
+	for signed_txs := m.validator.GetSignedTxs(checkpoint); signed_txs != nil; signed_txs = m.validator.GetSignedTxs(checkpoint) {
+		var buf bytes.Buffer
+		for _, signed_tx := range signed_txs {
+			err := signed_tx.Serialize(&buf)
+			assert.NoError(m.suite.T, err)
+		}
+		store.Set(moduleSignedTxKey(checkpoint), buf.Bytes())
+		store.Set([]byte(MODULE_SIGNED_HEIGHT_STORE_KEY), sdk.Uint64ToBigEndian(uint64(checkpoint)))
+		checkpoint++
+	}
+}
+
+// this validator signs once per signing session, when there are withdrawals to sign for
+func (m *wstsModule) isSigningReady() bool {
+	v := m.validator
+	return v.frost.GroupPublicKey != nil && v.btcCheckpointheight != 0 && v.GetPendingTxsNum() > 0 && m.signedSession != m.signingSession()
+}
+
+func (m *wstsModule) signingSession() string {
+	return fmt.Sprintf("%d/%d", m.validator.btcCheckpointheight, m.validator.nextSigningIndex)
+}
+
+func (m *wstsModule) getValidatorPosition(ctx sdk.Context, address []byte) int64 {
+	position_bytes := ctx.KVStore(m.storeKey).Get(append([]byte(MODULE_VALIDATOR_ADDRESS_STORE_KEY), address...))
+	if position_bytes == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(position_bytes))
+}
+
+func (m *wstsModule) GetGroupPublicKey(ctx sdk.Context) *btcec.PublicKey {
+	group_key_bytes := ctx.KVStore(m.storeKey).Get([]byte(MODULE_GROUP_KEY_STORE_KEY))
+	if group_key_bytes == nil {
+		return nil
+	}
+	group_key, err := btcec.ParsePubKey(group_key_bytes)
+	assert.NoError(m.suite.T, err)
+
+	return group_key
+}
+
+// finalized bitcoin transactions that create the checkpoint at checkpoint_height
+func (m *wstsModule) GetSignedTxs(ctx sdk.Context, checkpoint_height int64) []*wire.MsgTx {
+	tx_bytes := ctx.KVStore(m.storeKey).Get(moduleSignedTxKey(checkpoint_height))
+	if tx_bytes == nil {
+		return nil
+	}
+
+	signed_txs := make([]*wire.MsgTx, 0)
+	reader := bytes.NewReader(tx_bytes)
+	for reader.Len() > 0 {
+		signed_tx := wire.NewMsgTx(2)
+		err := signed_tx.Deserialize(reader)
+		assert.NoError(m.suite.T, err)
+		signed_txs = append(signed_txs, signed_tx)
+	}
+
+	return signed_txs
+}
+
+func moduleValidatorKey(posi int64) []byte {
+	return append([]byte(MODULE_VALIDATOR_STORE_KEY), sdk.Uint64ToBigEndian(uint64(posi))...)
+}
+
+func moduleGroupCommitmentKey(posi int64) []byte {
+	return append([]byte(MODULE_GROUP_COMMITMENT_STORE_KEY), sdk.Uint64ToBigEndian(uint64(posi))...)
+}
+
+func moduleSignedTxKey(checkpoint_height int64) []byte {
+	return append([]byte(MODULE_SIGNED_TX_STORE_KEY), sdk.Uint64ToBigEndian(uint64(checkpoint_height))...)
+}
+
+// consensus params are kept in memory, vote extensions are enabled from genesis
+type memParamStore struct {
+	params *cmtproto.ConsensusParams
+}
+
+func (s *memParamStore) Get(ctx context.Context) (cmtproto.ConsensusParams, error) {
+	if s.params == nil {
+		return cmtproto.ConsensusParams{}, nil
+	}
+
+	return *s.params, nil
+}
+
+func (s *memParamStore) Has(ctx context.Context) (bool, error) {
+	return s.params != nil, nil
+}
+
+func (s *memParamStore) Set(ctx context.Context, params cmtproto.ConsensusParams) error {
+	s.params = &params
+	return nil
+}
+
+// wsts txs are applied by the module in PreBlocker, there is no msg to deliver
+func cosmosTxDecoder(txBytes []byte) (sdk.Tx, error) {
+	return nil, fmt.Errorf("wsts txs are applied in PreBlocker")
+}
+
+// cosmosNode is an in - memory cosmos app with the wsts module
+type cosmosNode struct {
+	app     *baseapp.BaseApp
+	module  *wstsModule
+	address []byte
+	power   int64
+}
+
+func newCosmosNode(suite *testhelper.TestSuite, network *cosmosNetwork, power int64) *cosmosNode {
+	storeKey := storetypes.NewKVStoreKey(WSTS_MODULE_NAME)
+	module := &wstsModule{
+		suite:    suite,
+		storeKey: storeKey,
+		priv:     cosmossecp.GenPrivKey(),
+		network:  network,
+	}
+
+	app := baseapp.NewBaseApp(WSTS_MODULE_NAME, sdklog.NewNopLogger(), dbm.NewMemDB(), cosmosTxDecoder, baseapp.SetChainID(COSMOS_CHAIN_ID))
+	app.SetParamStore(&memParamStore{})
+	app.MountKVStores(map[string]*storetypes.KVStoreKey{WSTS_MODULE_NAME: storeKey})
+	module.registerHandlers(app)
+	err := app.LoadLatestVersion()
+	assert.NoError(suite.T, err)
+
+	return &cosmosNode{
+		app:     app,
+		module:  module,
+		address: module.priv.PubKey().Address(),
+		power:   power,
+	}
+}
+
+// committed state of the node
+func (node *cosmosNode) queryContext() sdk.Context {
+	return node.app.NewUncachedContext(false, cmtproto.Header{ChainID: COSMOS_CHAIN_ID})
+}
+
+// cosmosNetwork is an in - process network of cosmos nodes, it plays the consensus engine for all of them
+// off - chain messages are delivered directly, on - chain messages wait for the next vote extension of their source
+type cosmosNetwork struct {
+	suite *testhelper.TestSuite
+	nodes []*cosmosNode
+
+	validators map[int64]*MockValidator
+	outbox     map[int64][][]byte
+	outboxSeen map[int64]map[string]bool
+	// envelopes that arrived too early, handled again in the next block
+	deferred map[int64][]func()
+	// withdraw batches waiting for the next proposal
+	mempool    [][]byte
+	height     int64
+	lastCommit abci.ExtendedCommitInfo
+}
+
+func newCosmosNetwork(suite *testhelper.TestSuite, powers []int64, n_keys, threshold int64) *cosmosNetwork {
+	network := &cosmosNetwork{
+		suite:      suite,
+		validators: make(map[int64]*MockValidator),
+		outbox:     make(map[int64][][]byte),
+		outboxSeen: make(map[int64]map[string]bool),
+		deferred:   make(map[int64][]func()),
+	}
+
+	validator_updates := make([]abci.ValidatorUpdate, len(powers))
+	for i, power := range powers {
+		node := newCosmosNode(suite, network, power)
+		network.nodes = append(network.nodes, node)
+		validator_updates[i] = abci.ValidatorUpdate{
+			PubKey: cmtprotocrypto.PublicKey{Sum: &cmtprotocrypto.PublicKey_Secp256K1{Secp256K1: node.module.priv.PubKey().Bytes()}},
+			Power:  power,
+		}
+	}
+
+	genesisBytes, err := proto.Marshal(&GenesisState{NKeys: n_keys, Threshold: threshold})
+	assert.NoError(suite.T, err)
+	validators := make([]*MockValidator, len(powers))
+	for i, node := range network.nodes {
+		_, err := node.app.InitChain(&abci.RequestInitChain{
+			ChainId: COSMOS_CHAIN_ID,
+			ConsensusParams: &cmtproto.ConsensusParams{
+				Block:     &cmtproto.BlockParams{MaxBytes: 22020096, MaxGas: -1},
+				Evidence:  &cmtproto.EvidenceParams{MaxAgeNumBlocks: 100000, MaxBytes: 1048576},
+				Validator: &cmtproto.ValidatorParams{PubKeyTypes: []string{"secp256k1"}},
+				Abci:      &cmtproto.ABCIParams{VoteExtensionsEnableHeight: VOTE_EXTENSIONS_ENABLE_HEIGHT},
+			},
+			// InitChain sorts validator updates in place
+			Validators:    append([]abci.ValidatorUpdate{}, validator_updates...),
+			AppStateBytes: genesisBytes,
+		})
+		assert.NoError(suite.T, err)
+		validators[i] = node.module.validator
+		network.validators[validators[i].position] = validators[i]
+	}
+
+	for _, validator := range validators {
+		for _, other := range validators {
+			if validator != other {
+				validator.otherVals[other.position] = other
+			}
+		}
+	}
+	joinTransport(network, validators)
+
+	return network
+}
+
+func (n *cosmosNetwork) Validators() []*MockValidator {
+	validators := make([]*MockValidator, len(n.nodes))
+	for i, node := range n.nodes {
+		validators[i] = node.module.validator
+	}
+
+	return validators
+}
+
+func (n *cosmosNetwork) send(from, to int64, msg []byte, on_chain bool) {
+	if !on_chain {
+		n.validators[to].handleMessageOffChain(msg)
+		return
+	}
+
+	// the same envelope is sent to every peer, it is committed once
+	if _, ok := n.outboxSeen[from]; !ok {
+		n.outboxSeen[from] = make(map[string]bool)
+	}
+	if n.outboxSeen[from][string(msg)] {
+		return
+	}
+	n.outboxSeen[from][string(msg)] = true
+	n.outbox[from] = append(n.outbox[from], msg)
+}
+
+func (n *cosmosNetwork) requeue(posi int64, env *Envelope, on_chain bool, delay time.Duration) {
+	validator := n.validators[posi]
+	n.deferred[posi] = append(n.deferred[posi], func() {
+		validator.handleRequeuedEnvelope(env, on_chain)
+	})
+}
+
+func (n *cosmosNetwork) takeOutbox(posi int64) [][]byte {
+	envelopes := n.outbox[posi]
+	delete(n.outbox, posi)
+	delete(n.outboxSeen, posi)
+
+	return envelopes
+}
+
+func (n *cosmosNetwork) handleDeferred(posi int64) {
+	deferred := n.deferred[posi]
+	delete(n.deferred, posi)
+	for _, handle := range deferred {
+		handle()
+	}
+}
+
+// a withdraw batch submitted as a tx for the next proposal
+func (n *cosmosNetwork) SubmitWithdrawBatch(batch *MsgBatchWithdraw) {
+	txBytes, err := proto.Marshal(batch)
+	assert.NoError(n.suite.T, err)
+	n.mempool = append(n.mempool, txBytes)
+}
+
+// one round of consensus at the next height
+// proposers take turns, every validator extends its vote and verifies vote extensions of all others
+func (n *cosmosNetwork) produceBlock() {
+	t := n.suite.T
+	height := n.height + 1
+	block_time := time.Unix(1700000000+height, 0)
+	proposer := n.nodes[(height-1)%int64(len(n.nodes))]
+
+	proposal, err := proposer.app.PrepareProposal(&abci.RequestPrepareProposal{
+		MaxTxBytes:      22020096,
+		Txs:             n.mempool,
+		LocalLastCommit: n.lastCommit,
+		Height:          height,
+		Time:            block_time,
+		ProposerAddress: proposer.address,
+	})
+	assert.NoError(t, err)
+	n.mempool = nil
+
+	block_hash := sha256.New()
+	for _, tx := range proposal.Txs {
+		block_hash.Write(tx)
+	}
+	hash := block_hash.Sum(nil)
+
+	for _, node := range n.nodes {
+		res, err := node.app.ProcessProposal(&abci.RequestProcessProposal{
+			Txs:             proposal.Txs,
+			Hash:            hash,
+			Height:          height,
+			Time:            block_time,
+			ProposerAddress: proposer.address,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, abci.ResponseProcessProposal_ACCEPT, res.Status, "proposal at height %d is rejected", height)
+	}
+
+	votes := make([]abci.ExtendedVoteInfo, len(n.nodes))
+	for i, node := range n.nodes {
+		res, err := node.app.ExtendVote(context.Background(), &abci.RequestExtendVote{
+			Hash:            hash,
+			Height:          height,
+			Time:            block_time,
+			Txs:             proposal.Txs,
+			ProposerAddress: proposer.address,
+		})
+		assert.NoError(t, err)
+		votes[i] = abci.ExtendedVoteInfo{
+			Validator:     abci.Validator{Address: node.address, Power: node.power},
+			VoteExtension: res.VoteExtension,
+			BlockIdFlag:   cmtproto.BlockIDFlagCommit,
+		}
+	}
+	for _, node := range n.nodes {
+		for _, vote := range votes {
+			res, err := node.app.VerifyVoteExtension(&abci.RequestVerifyVoteExtension{
+				Hash:             hash,
+				ValidatorAddress: vote.Validator.Address,
+				Height:           height,
+				VoteExtension:    vote.VoteExtension,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, abci.ResponseVerifyVoteExtension_ACCEPT, res.Status, "vote extension at height %d is rejected", height)
+		}
+	}
+
+	var app_hash []byte
+	for i, node := range n.nodes {
+		res, err := node.app.FinalizeBlock(&abci.RequestFinalizeBlock{
+			Txs:             proposal.Txs,
+			Hash:            hash,
+			Height:          height,
+			Time:            block_time,
+			ProposerAddress: proposer.address,
+		})
+		assert.NoError(t, err)
+		if i == 0 {
+			app_hash = res.AppHash
+		}
+		assert.Equal(t, app_hash, res.AppHash, "node %d has diverged at height %d", i+1, height)
+
+		_, err = node.app.Commit()
+		assert.NoError(t, err)
+	}
+
+	n.lastCommit = abci.ExtendedCommitInfo{Votes: votes}
+	n.height = height
+}
+
+// produce blocks until done returns true, return false after max_blocks
+func (n *cosmosNetwork) RunUntil(done func() bool, max_blocks int64) bool {
+	for i := int64(0); i < max_blocks; i++ {
+		if done() {
+			return true
+		}
+		n.produceBlock()
+	}
+
+	return done()
+}
+
+func (n *cosmosNetwork) Stop() {
+	for _, node := range n.nodes {
+		node.module.validator.Stop()
+	}
+}
+
+func runCosmosModule(t *testing.T, powers []int64, n_keys, threshold int64) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	network := newCosmosNetwork(&suite, powers, n_keys, threshold)
+	defer network.Stop()
+	validators := network.Validators()
+
+	// key ranges follow voting power
+	total_power := int64(0)
+	for _, power := range powers {
+		total_power += power
+	}
+	range_keys, err := validators[0].DeriveRangeOfKeys()
+	assert.NoError(t, err)
+	for i, power := range powers {
+		assert.Equal(t, power*n_keys/total_power, range_keys[int64(i+1)][1]-range_keys[int64(i+1)][0])
+		validator_range_keys, err := validators[i].DeriveRangeOfKeys()
+		assert.NoError(t, err)
+		assert.Equal(t, range_keys, validator_range_keys)
+	}
+
+	// DKG runs through vote extensions until the group key is in module state
+	node := network.nodes[0]
+	assert.True(t, network.RunUntil(func() bool {
+		return node.module.GetGroupPublicKey(node.queryContext()) != nil
+	}, 10), "group key has not been committed")
+	group_key := node.module.GetGroupPublicKey(node.queryContext())
+	t.Logf("group key has been committed at height %d", network.height)
+
+	assert.True(t, network.RunUntil(func() bool {
+		for _, validator := range validators {
+			if validator.frost.GroupPublicKey == nil {
+				return false
+			}
+		}
+		return true
+	}, 10), "validators have not derived the group key")
+	for _, validator := range validators {
+		assert.Equal(t, schnorr.SerializePubKey(group_key), schnorr.SerializePubKey(validator.frost.GroupPublicKey))
+	}
+
+	// fund the vault, and withdraw from it through a tx
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+	network.SubmitWithdrawBatch(&MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 10), Sequence: 1})
+
+	// nonce commitments and adapt sigs run through vote extensions until the bitcoin transaction is in module state
+	assert.True(t, network.RunUntil(func() bool {
+		return node.module.GetSignedTxs(node.queryContext(), 1) != nil
+	}, 20), "checkpoint has not been finalized")
+	t.Logf("checkpoint has been finalized at height %d", network.height)
+
+	for _, other := range network.nodes {
+		signed_txs := other.module.GetSignedTxs(other.queryContext(), 1)
+		assert.Equal(t, 1, len(signed_txs))
+		assert.Equal(t, validators[0].GetSignedTxs(1)[0].TxHash(), signed_txs[0].TxHash())
+	}
+}
+
+// go test -v -run ^TestCosmosModuleSingleNode$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestCosmosModuleSingleNode(t *testing.T) {
+	runCosmosModule(t, []int64{100}, 10, 7)
+}
+
+// go test -v -run ^TestCosmosModuleNetwork$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestCosmosModuleNetwork(t *testing.T) {
+	runCosmosModule(t, []int64{40, 30, 20, 10}, 10, 7)
+}
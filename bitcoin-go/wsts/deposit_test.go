@@ -0,0 +1,416 @@
+package wsts
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"cosmossdk.io/math"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// btc blocks, including the block of the deposit
+	DEFAULT_DEPOSIT_CONFIRMATIONS = int64(6)
+)
+
+func (v *MockValidator) SetDepositConfirmations(confirmations int64) {
+	v.depositConfirmations = confirmations
+}
+
+// scan a btc block for deposits paying to the group taproot address
+// deposits are attested to all validators once they reach enough confirmations
+func (v *MockValidator) ScanBlock(block_height int64, txs []*wire.MsgTx) {
+	if block_height > v.btcTipHeight {
+		v.btcTipHeight = block_height
+	}
+
+	trScript := v.vaultPkScript(v.frost.GroupPublicKey)
+	for _, tx := range txs {
+		// outputs of vault transactions also pay to the group key, but they are not deposits
+		if v.isVaultTx(tx) {
+			continue
+		}
+
+		for i, txOut := range tx.TxOut {
+			if !bytes.Equal(txOut.PkScript, trScript) {
+				continue
+			}
+			// dust deposits cost more to consolidate than they are worth
+			if mempool.IsDust(txOut, mempool.DefaultMinRelayTxFee) {
+				v.logger.Printf("ignore dust deposit %s:%d\n", tx.TxHash(), i)
+				continue
+			}
+
+			deposit := &Deposit{
+				TxHash:      tx.TxHash().String(),
+				OutIndex:    uint32(i),
+				Amount:      txOut.Value,
+				BlockHeight: block_height,
+			}
+			key := depositOutPoint(v.suite, deposit).String()
+			if v.isKnownDeposit(key) {
+				continue
+			}
+			depositBytes, err := proto.Marshal(deposit)
+			assert.NoError(v.suite.T, err)
+			v.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY][key] = depositBytes
+		}
+	}
+
+	v.attestConfirmedDeposits()
+}
+
+// vault transactions and fan-out transactions of payout trees pay to the vault key, but are not deposits
+func (v *MockValidator) isVaultTx(tx *wire.MsgTx) bool {
+	tx_hash := tx.TxHash()
+	for _, store_key := range []string{SIGNED_TX_STORE_KEY, PAYOUT_TX_STORE_KEY} {
+		for height := range v.protocolStorage.store[store_key] {
+			checkpoint_height, err := strconv.ParseInt(height, 10, 64)
+			assert.NoError(v.suite.T, err)
+			for _, signed_tx := range v.getTxList(store_key, checkpoint_height) {
+				if signed_tx.TxHash() == tx_hash {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (v *MockValidator) isKnownDeposit(key string) bool {
+	_, credited := v.protocolStorage.store[DEPOSIT_STORE_KEY][key]
+	_, consolidated := v.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY][key]
+	return credited || consolidated
+}
+
+// send attestations of confirmed deposit candidates to all validators
+func (v *MockValidator) attestConfirmedDeposits() {
+	confirmed := make([]*Deposit, 0)
+	for key, depositBytes := range v.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY] {
+		deposit := &Deposit{}
+		err := proto.Unmarshal(depositBytes, deposit)
+		assert.NoError(v.suite.T, err)
+		if v.btcTipHeight-deposit.BlockHeight+1 < v.depositConfirmations {
+			continue
+		}
+		confirmed = append(confirmed, deposit)
+		delete(v.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY], key)
+	}
+	if len(confirmed) == 0 {
+		return
+	}
+	sortDeposits(confirmed)
+
+	msg := &MsgDepositAttest{
+		Source:   v.position,
+		Deposits: confirmed,
+	}
+	envBytes := v.sealEnvelope(&Envelope_DepositAttest{DepositAttest: msg})
+	for _, otherVal := range v.otherVals {
+		otherVal.SendMessageOnChain(envBytes)
+	}
+
+	// self - sending so that the attestation of this validator is counted in the same loop as others
+	v.SendMessageOnChain(envBytes)
+}
+
+// a deposit is credited once honest validators with more than 2/3 vp attest to the same deposit
+func (v *MockValidator) handleDepositAttest(source int64, deposit *Deposit) {
+	key := depositOutPoint(v.suite, deposit).String()
+	if v.isKnownDeposit(key) {
+		return
+	}
+
+	depositBytes, err := proto.Marshal(deposit)
+	assert.NoError(v.suite.T, err)
+	substore_key := DEPOSIT_ATTEST_STORE_KEY + key
+	if _, ok := v.protocolStorage.store[substore_key]; !ok {
+		v.protocolStorage.store[substore_key] = make(map[string][]byte)
+	}
+	v.protocolStorage.store[substore_key][strconv.FormatInt(source, 10)] = depositBytes
+
+	attested_vp := math.LegacyZeroDec()
+	for posi, attested := range v.protocolStorage.store[substore_key] {
+		attester, err := strconv.ParseInt(posi, 10, 64)
+		assert.NoError(v.suite.T, err)
+		if _, ok := v.dishonestVals[attester]; ok {
+			continue
+		}
+		if !bytes.Equal(attested, depositBytes) {
+			continue
+		}
+		vp := bytesToVp(v.suite, v.protocolStorage.store[VP_STORE_KEY][posi])
+		attested_vp = attested_vp.Add(*vp)
+	}
+
+	if attested_vp.MulInt64(3).GT(math.LegacyNewDec(2)) {
+		v.logger.Printf("deposit %s of %d sats has been credited\n", key, deposit.Amount)
+		v.protocolStorage.store[DEPOSIT_STORE_KEY][key] = depositBytes
+		delete(v.protocolStorage.store, substore_key)
+	}
+}
+
+// credited deposits in the order they are consolidated
+func (v *MockValidator) getCreditedDeposits() []*Deposit {
+	deposits := make([]*Deposit, 0, len(v.protocolStorage.store[DEPOSIT_STORE_KEY]))
+	for _, depositBytes := range v.protocolStorage.store[DEPOSIT_STORE_KEY] {
+		deposit := &Deposit{}
+		err := proto.Unmarshal(depositBytes, deposit)
+		assert.NoError(v.suite.T, err)
+		deposits = append(deposits, deposit)
+	}
+	sortDeposits(deposits)
+
+	return deposits
+}
+
+func (v *MockValidator) GetCreditedDepositsNum() int {
+	return len(v.protocolStorage.store[DEPOSIT_STORE_KEY])
+}
+
+// deposit has been spent into the vault, it can never be credited again
+func (v *MockValidator) consolidateDeposit(out_point wire.OutPoint) {
+	key := out_point.String()
+	depositBytes, ok := v.protocolStorage.store[DEPOSIT_STORE_KEY][key]
+	assert.True(v.suite.T, ok, "consolidated deposit %s has not been credited", key)
+	v.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY][key] = depositBytes
+	delete(v.protocolStorage.store[DEPOSIT_STORE_KEY], key)
+}
+
+func depositOutPoint(suite *testhelper.TestSuite, deposit *Deposit) wire.OutPoint {
+	tx_hash, err := chainhash.NewHashFromStr(deposit.TxHash)
+	assert.NoError(suite.T, err)
+
+	return wire.OutPoint{
+		Hash:  *tx_hash,
+		Index: deposit.OutIndex,
+	}
+}
+
+// deposits are ordered by block height, then by outpoint
+func sortDeposits(deposits []*Deposit) {
+	sort.Slice(deposits, func(i, j int) bool {
+		if deposits[i].BlockHeight != deposits[j].BlockHeight {
+			return deposits[i].BlockHeight < deposits[j].BlockHeight
+		}
+		if deposits[i].TxHash != deposits[j].TxHash {
+			return deposits[i].TxHash < deposits[j].TxHash
+		}
+		return deposits[i].OutIndex < deposits[j].OutIndex
+	})
+}
+
+// scan the same btc block on all validators
+func scanBlock(validators []*MockValidator, block_height int64, txs []*wire.MsgTx) {
+	for _, validator := range validators {
+		validator.Do(func() {
+			validator.ScanBlock(block_height, txs)
+		})
+	}
+}
+
+// go test -v -run ^TestDepositAttestation$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestDepositAttestation(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+	vps := []string{"0.4", "0.3", "0.2", "0.1"}
+	for i, vp := range vps {
+		validator.protocolStorage.store[VP_STORE_KEY][strconv.Itoa(i+1)] = vpToBytes(&suite, math.LegacyMustNewDecFromStr(vp))
+	}
+
+	deposit := &Deposit{
+		TxHash:      chainhash.Hash{1}.String(),
+		OutIndex:    1,
+		Amount:      500000,
+		BlockHeight: 10,
+	}
+	conflicting := proto.Clone(deposit).(*Deposit)
+	conflicting.Amount = 600000
+
+	// 0.4 vp is not enough
+	validator.handleDepositAttest(1, deposit)
+	assert.Equal(t, 0, validator.GetCreditedDepositsNum())
+
+	// conflicting attestations are not counted together
+	validator.handleDepositAttest(2, conflicting)
+	assert.Equal(t, 0, validator.GetCreditedDepositsNum())
+
+	// attestations from dishonest validators are not counted
+	validator.dishonestVals[3] = true
+	validator.handleDepositAttest(3, deposit)
+	assert.Equal(t, 0, validator.GetCreditedDepositsNum())
+
+	// changing attestation to the same deposit, 0.4 + 0.3 vp is more than 2/3
+	validator.handleDepositAttest(2, deposit)
+	assert.Equal(t, 1, validator.GetCreditedDepositsNum())
+	assert.True(t, proto.Equal(deposit, validator.getCreditedDeposits()[0]))
+
+	// a credited deposit is never credited twice
+	validator.handleDepositAttest(4, deposit)
+	assert.Equal(t, 1, validator.GetCreditedDepositsNum())
+
+	// a consolidated deposit is never credited again
+	validator.consolidateDeposit(depositOutPoint(&suite, deposit))
+	assert.Equal(t, 0, validator.GetCreditedDepositsNum())
+	validator.handleDepositAttest(1, deposit)
+	validator.handleDepositAttest(2, deposit)
+	assert.Equal(t, 0, validator.GetCreditedDepositsNum())
+}
+
+// go test -v -run ^TestDepositConsolidation$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestDepositConsolidation(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	for _, validator := range validators {
+		validator.SetDepositConfirmations(3)
+	}
+
+	checkpoint_amount := int64(1000000000)
+	mockGenesisCheckPoint(&suite, validators, checkpoint_amount)
+	sendNonces(validators, 10)
+
+	// deposit tx pays to the vault, to another address, and a dust amount to the vault
+	trScript, err := txscript.PayToTaprootScript(validators[0].frost.GroupPublicKey)
+	assert.NoError(t, err)
+	deposit_amount := int64(25000000)
+	deposit_tx := suite.NewMockFirstTx(trScript, deposit_amount)
+	_, other_key := suite.NewHDKeyPairFromSeed("")
+	other_script, err := txscript.PayToTaprootScript(other_key.Pub)
+	assert.NoError(t, err)
+	deposit_tx.AddTxOut(&wire.TxOut{Value: 700000, PkScript: other_script})
+	deposit_tx.AddTxOut(&wire.TxOut{Value: 100, PkScript: trScript})
+	suite.UtxoViewpoint.AddTxOuts(btcutil.NewTx(deposit_tx), 1)
+
+	// deposit is not attested before enough confirmations
+	scanBlock(validators, 1, []*wire.MsgTx{deposit_tx})
+	scanBlock(validators, 2, nil)
+	for _, validator := range validators {
+		assert.Equal(t, 1, len(validator.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY]))
+		assert.Equal(t, 0, validator.GetCreditedDepositsNum())
+	}
+
+	// probing to see if all validators have credited the deposit
+	scanBlock(validators, 3, nil)
+	for _, validator := range validators {
+		for query(validator, validator.GetCreditedDepositsNum) != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// deposit is consolidated into the next checkpoint
+	signCheckPoint(t, validators)
+	signed_tx := validators[0].GetSignedTxs(1)[0]
+	assert.Equal(t, 2, len(signed_tx.TxIn))
+	assert.Equal(t, deposit_tx.TxHash(), signed_tx.TxIn[1].PreviousOutPoint.Hash)
+	assert.Equal(t, uint32(0), signed_tx.TxIn[1].PreviousOutPoint.Index)
+	fee := validators[0].estimateVaultTxFee(2, signed_tx.TxOut[0], nil)
+	assert.Equal(t, checkpoint_amount+deposit_amount-fee, signed_tx.TxOut[0].Value)
+	for i := int64(0); i < n; i++ {
+		assert.Equal(t, signed_tx.TxHash(), validators[i].GetSignedTxs(1)[0].TxHash())
+		assert.Equal(t, 0, validators[i].GetCreditedDepositsNum())
+	}
+	suite.MockMineTx(signed_tx, 4)
+
+	// vault transaction and rescanned deposit are not credited again
+	scanBlock(validators, 4, []*wire.MsgTx{signed_tx})
+	scanBlock(validators, 6, []*wire.MsgTx{deposit_tx})
+	for _, validator := range validators {
+		assert.Equal(t, 0, len(validator.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY]))
+	}
+
+	// next checkpoint only spends the vault
+	signCheckPoint(t, validators)
+	signed_tx = validators[0].GetSignedTxs(2)[0]
+	assert.Equal(t, 1, len(signed_tx.TxIn))
+}
+
+// go test -v -run ^TestMultiDepositConsolidation$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestMultiDepositConsolidation(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	// the checkpoint and 2 deposits at most are spent by one vault transaction
+	for _, validator := range validators {
+		validator.SetDepositConfirmations(1)
+		validator.SetBatchingPolicy(BatchingPolicy{MaxTxInputs: 3})
+	}
+
+	checkpoint_amount := int64(1000000000)
+	mockGenesisCheckPoint(&suite, validators, checkpoint_amount)
+	sendNonces(validators, 10)
+
+	// a deposit in each block
+	trScript, err := txscript.PayToTaprootScript(validators[0].frost.GroupPublicKey)
+	assert.NoError(t, err)
+	deposit_txs := make([]*wire.MsgTx, 3)
+	for i := range deposit_txs {
+		deposit_txs[i] = suite.NewMockFirstTx(trScript, int64(10000000*(i+1)))
+		suite.UtxoViewpoint.AddTxOuts(btcutil.NewTx(deposit_txs[i]), int32(i+1))
+		scanBlock(validators, int64(i+1), []*wire.MsgTx{deposit_txs[i]})
+	}
+	for _, validator := range validators {
+		for query(validator, validator.GetCreditedDepositsNum) != len(deposit_txs) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the 2 earliest deposits are consolidated, each input is signed in its own session over its own sighash
+	signing_index := query(validators[0], func() int64 { return validators[0].nextSigningIndex })
+	signCheckPoint(t, validators)
+	signed_tx := validators[0].GetSignedTxs(1)[0]
+	assert.Equal(t, 3, len(signed_tx.TxIn))
+	for i, deposit_tx := range deposit_txs[:2] {
+		assert.Equal(t, deposit_tx.TxHash(), signed_tx.TxIn[i+1].PreviousOutPoint.Hash)
+	}
+	assert.Equal(t, signing_index+3, query(validators[0], func() int64 { return validators[0].nextSigningIndex }))
+	signatures := make(map[string]struct{})
+	for _, txIn := range signed_tx.TxIn {
+		assert.Equal(t, 1, len(txIn.Witness))
+		signatures[string(txIn.Witness[0][:32])] = struct{}{}
+	}
+	assert.Equal(t, 3, len(signatures))
+	for i := int64(0); i < n; i++ {
+		assert.Equal(t, signed_tx.TxHash(), validators[i].GetSignedTxs(1)[0].TxHash())
+		assert.Equal(t, 1, query(validators[i], validators[i].GetCreditedDepositsNum))
+	}
+	suite.MockMineTx(signed_tx, 4)
+
+	// the last deposit is consolidated at the next checkpoint
+	signCheckPoint(t, validators)
+	signed_tx = validators[0].GetSignedTxs(2)[0]
+	assert.Equal(t, 2, len(signed_tx.TxIn))
+	assert.Equal(t, deposit_txs[2].TxHash(), signed_tx.TxIn[1].PreviousOutPoint.Hash)
+	for i := int64(0); i < n; i++ {
+		assert.Equal(t, 0, query(validators[i], validators[i].GetCreditedDepositsNum))
+	}
+}
@@ -0,0 +1,351 @@
+package wsts
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// envelopes of any other version are rejected
+	ENVELOPE_VERSION = uint32(1)
+)
+
+var (
+	ENVELOPE_TAG = []byte("WSTS/envelope")
+)
+
+// wrap payload in an envelope signed by the validator key of this validator
+func (v *MockValidator) sealEnvelope(payload isEnvelope_Payload) []byte {
+	env := &Envelope{
+		Version:  ENVELOPE_VERSION,
+		Source:   v.position,
+		Epoch:    v.epoch,
+		Sequence: v.sequence.Add(1),
+		Payload:  payload,
+	}
+	sigHash, err := envelopeSigHash(env)
+	assert.NoError(v.suite.T, err)
+	sig, err := schnorr.Sign(v.keyPair.GetTestPriv(), sigHash[:])
+	assert.NoError(v.suite.T, err)
+	env.Signature = sig.Serialize()
+
+	envBytes, err := proto.Marshal(env)
+	assert.NoError(v.suite.T, err)
+
+	return envBytes
+}
+
+// verify an envelope received from the network
+// an envelope is rejected if it does not verify, or it has already been received
+func (v *MockValidator) openEnvelope(msg []byte) (*Envelope, error) {
+	env, err := v.verifyEnvelope(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.markSequenceSeen(env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// an envelope is handled once, whether it is received directly or relayed in a state response
+func (v *MockValidator) markSequenceSeen(env *Envelope) error {
+	v.envelopeMu.Lock()
+	defer v.envelopeMu.Unlock()
+	if _, ok := v.seenSequences[env.Source]; !ok {
+		v.seenSequences[env.Source] = make(map[uint64]bool)
+	}
+	if v.seenSequences[env.Source][env.Sequence] {
+		return fmt.Errorf("replayed sequence %d from source %d", env.Sequence, env.Source)
+	}
+	v.seenSequences[env.Source][env.Sequence] = true
+
+	return nil
+}
+
+// an envelope does not verify if its version is unknown, its signature is not from the validator key of its source,
+// or its payload claims another source
+func (v *MockValidator) verifyEnvelope(msg []byte) (*Envelope, error) {
+	env := &Envelope{}
+	if err := proto.Unmarshal(msg, env); err != nil {
+		return nil, fmt.Errorf("malformed envelope: %v", err)
+	}
+	if env.Version != ENVELOPE_VERSION {
+		return nil, fmt.Errorf("unknown envelope version %d from source %d", env.Version, env.Source)
+	}
+	if env.Payload == nil {
+		return nil, fmt.Errorf("envelope without payload from source %d", env.Source)
+	}
+
+	pub_key := v.getValidatorPubKey(env.Source)
+	if pub_key == nil {
+		return nil, fmt.Errorf("unknown source %d", env.Source)
+	}
+	sig, err := schnorr.ParseSignature(env.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature from source %d: %v", env.Source, err)
+	}
+	sigHash, err := envelopeSigHash(env)
+	if err != nil {
+		return nil, err
+	}
+	if !sig.Verify(sigHash[:], pub_key) {
+		return nil, fmt.Errorf("invalid signature from source %d", env.Source)
+	}
+
+	// source inside payload is self - declared, it must be the signer
+	if source, ok := envelopePayloadSource(env); ok && source != env.Source {
+		return nil, fmt.Errorf("forged source %d in %s message from source %d", source, envelopeType(env), env.Source)
+	}
+
+	return env, nil
+}
+
+// signature commits to the envelope without signature
+func envelopeSigHash(env *Envelope) ([32]byte, error) {
+	unsigned := proto.Clone(env).(*Envelope)
+	unsigned.Signature = nil
+	unsignedBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return *chainhash.TaggedHash(ENVELOPE_TAG, unsignedBytes), nil
+}
+
+// source declared inside payload, false if payload has no source
+func envelopePayloadSource(env *Envelope) (int64, bool) {
+	switch payload := env.Payload.(type) {
+	case *Envelope_UpdateVp:
+		return payload.UpdateVp.GetSource(), true
+	case *Envelope_UpdateProofs:
+		return payload.UpdateProofs.GetSource(), true
+	case *Envelope_SecretShares:
+		return payload.SecretShares.GetSource(), true
+	case *Envelope_UpdateNonceCommitments:
+		return payload.UpdateNonceCommitments.GetSource(), true
+	case *Envelope_UpdateAdaptSig:
+		return payload.UpdateAdaptSig.GetSource(), true
+	case *Envelope_DepositAttest:
+		return payload.DepositAttest.GetSource(), true
+	case *Envelope_StateRequest:
+		return payload.StateRequest.GetSource(), true
+	case *Envelope_StateResponse:
+		return payload.StateResponse.GetSource(), true
+	case *Envelope_PauseVote:
+		return payload.PauseVote.GetSource(), true
+	}
+
+	return 0, false
+}
+
+// name of payload type, also used in scenario files
+func envelopeType(env *Envelope) string {
+	switch env.Payload.(type) {
+	case *Envelope_UpdateVp:
+		return "vp"
+	case *Envelope_UpdateProofs:
+		return "proofs"
+	case *Envelope_SecretShares:
+		return "secret_shares"
+	case *Envelope_UpdateNonceCommitments:
+		return "nonce_commitments"
+	case *Envelope_BatchWithdraw:
+		return "withdraw_batch"
+	case *Envelope_UpdateAdaptSig:
+		return "adapt_sig"
+	case *Envelope_DepositAttest:
+		return "deposit_attest"
+	case *Envelope_StateRequest:
+		return "state_request"
+	case *Envelope_StateResponse:
+		return "state_response"
+	case *Envelope_PauseVote:
+		return "pause_vote"
+	}
+
+	return "unknown"
+}
+
+// validator keys of all validators are known from the validator set on - chain
+func (v *MockValidator) SetValidatorPubKey(posi int64, pub_key *btcec.PublicKey) {
+	v.protocolStorage.store[VALIDATOR_PUB_KEY_STORE_KEY][strconv.FormatInt(posi, 10)] = pub_key.SerializeCompressed()
+}
+
+func (v *MockValidator) getValidatorPubKey(posi int64) *btcec.PublicKey {
+	pub_key_bytes, ok := v.protocolStorage.store[VALIDATOR_PUB_KEY_STORE_KEY][strconv.FormatInt(posi, 10)]
+	if !ok {
+		return nil
+	}
+	pub_key, err := btcec.ParsePubKey(pub_key_bytes)
+	assert.NoError(v.suite.T, err)
+
+	return pub_key
+}
+
+func newEnvelopeTestValidators(t *testing.T, suite *testhelper.TestSuite, n int64) []*MockValidator {
+	logger := log.New(io.Discard, "", 0)
+	validators := make([]*MockValidator, n)
+	for i := int64(0); i < n; i++ {
+		frost := testhelper.NewFrostParticipant(suite, logger, 10, 5, i+1, nil)
+		validators[i] = newMockValidator(suite, logger, nil, frost, n, i+1)
+	}
+	connectValidators(validators)
+
+	return validators
+}
+
+// go test -v -run ^TestEnvelope$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestEnvelope(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validators := newEnvelopeTestValidators(t, &suite, 3)
+	sender := validators[0]
+	receiver := validators[1]
+	payload := &Envelope_UpdateVp{UpdateVp: &MsgUpdateVP{Source: sender.position, Vp: []byte{1}}}
+
+	// an envelope signed by its source is accepted once
+	msg := sender.sealEnvelope(payload)
+	env, err := receiver.openEnvelope(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "vp", envelopeType(env))
+	assert.Equal(t, sender.position, env.Source)
+	assert.Equal(t, []byte{1}, env.GetUpdateVp().Vp)
+
+	_, err = receiver.openEnvelope(msg)
+	assert.ErrorContains(t, err, "replayed sequence")
+
+	// another receiver has not seen it yet
+	_, err = validators[2].openEnvelope(msg)
+	assert.NoError(t, err)
+
+	// reseal an envelope after tampering with it, signed by signer
+	tamper := func(signer *MockValidator, fn func(env *Envelope)) []byte {
+		env := &Envelope{}
+		err := proto.Unmarshal(sender.sealEnvelope(payload), env)
+		assert.NoError(t, err)
+		fn(env)
+		if signer != nil {
+			sigHash, err := envelopeSigHash(env)
+			assert.NoError(t, err)
+			sig, err := schnorr.Sign(signer.keyPair.GetTestPriv(), sigHash[:])
+			assert.NoError(t, err)
+			env.Signature = sig.Serialize()
+		}
+		envBytes, err := proto.Marshal(env)
+		assert.NoError(t, err)
+
+		return envBytes
+	}
+
+	testCases := []struct {
+		name string
+		msg  []byte
+		err  string
+	}{
+		{
+			name: "unknown version",
+			msg: tamper(sender, func(env *Envelope) {
+				env.Version = ENVELOPE_VERSION + 1
+			}),
+			err: "unknown envelope version",
+		},
+		{
+			name: "unknown source",
+			msg: tamper(sender, func(env *Envelope) {
+				env.Source = 10
+			}),
+			err: "unknown source",
+		},
+		{
+			name: "tampered payload",
+			msg: tamper(nil, func(env *Envelope) {
+				env.GetUpdateVp().Vp = []byte{2}
+			}),
+			err: "invalid signature",
+		},
+		{
+			name: "signed by another validator",
+			msg:  tamper(validators[2], func(env *Envelope) {}),
+			err:  "invalid signature",
+		},
+		{
+			name: "forged source",
+			msg: tamper(sender, func(env *Envelope) {
+				env.GetUpdateVp().Source = validators[2].position
+			}),
+			err: "forged source",
+		},
+		{
+			name: "no payload",
+			msg: tamper(sender, func(env *Envelope) {
+				env.Payload = nil
+			}),
+			err: "envelope without payload",
+		},
+		{
+			name: "malformed",
+			msg:  []byte{0xff, 0xff},
+			err:  "malformed envelope",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := receiver.openEnvelope(tc.msg)
+			assert.ErrorContains(t, err, tc.err)
+
+			// rejected envelopes never reach the protocol
+			receiver.handleMessageOnChain(tc.msg)
+			assert.Nil(t, receiver.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(sender.position, 10)])
+		})
+	}
+}
+
+// go test -v -run ^TestEnvelopeEpoch$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestEnvelopeEpoch(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validators := newEnvelopeTestValidators(t, &suite, 2)
+	sender := validators[0]
+	receiver := validators[1]
+	network := NewSimNetwork(SimNetConfig{Seed: 1, Latency: UniformLatency(SIM_MIN_LATENCY, SIM_MIN_LATENCY)})
+	network.Join(validators)
+
+	vp := func(vp byte) *Envelope_UpdateVp {
+		return &Envelope_UpdateVp{UpdateVp: &MsgUpdateVP{Source: sender.position, Vp: []byte{vp}}}
+	}
+	receiver_vp := func() []byte {
+		return receiver.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(sender.position, 10)]
+	}
+
+	// a message of a later epoch waits until the receiver begins that epoch
+	sender.epoch = 1
+	sender.otherVals[receiver.position].SendMessageOnChain(sender.sealEnvelope(vp(1)))
+	assert.True(t, network.Run(func() bool { return network.Now() >= time.Second }, 2*time.Second))
+	assert.Nil(t, receiver_vp())
+
+	receiver.epoch = 1
+	assert.True(t, network.Run(func() bool { return receiver_vp() != nil }, 2*time.Second))
+	assert.Equal(t, []byte{1}, receiver_vp())
+
+	// a message of an earlier epoch is dropped
+	sender.epoch = 0
+	sender.otherVals[receiver.position].SendMessageOnChain(sender.sealEnvelope(vp(0)))
+	assert.True(t, network.RunUntilIdle(time.Second))
+	assert.Equal(t, []byte{1}, receiver_vp())
+}
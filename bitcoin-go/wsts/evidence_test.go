@@ -0,0 +1,496 @@
+package wsts
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// evidence of a misbehaving validator is only kept if it is provable to a third party
+// it is kept until it is submitted on - chain, e.g. to a slashing module
+func (v *MockValidator) recordEvidence(evidence *Evidence) {
+	if evidence == nil {
+		return
+	}
+	if err := v.VerifyEvidence(evidence); err != nil {
+		v.logger.Printf("misbehaviour of validator %d is not provable: %v\n", evidence.Accused, err)
+		return
+	}
+
+	evidenceBytes, err := proto.Marshal(evidence)
+	assert.NoError(v.suite.T, err)
+	v.localStorage.store[EVIDENCE_STORE_KEY][fmt.Sprintf("%d/%s", evidence.Accused, evidenceKind(evidence))] = evidenceBytes
+}
+
+// evidence held by this validator, ordered by accused validator then by kind
+func (v *MockValidator) GetEvidence() []*Evidence {
+	keys := make([]string, 0, len(v.localStorage.store[EVIDENCE_STORE_KEY]))
+	for key := range v.localStorage.store[EVIDENCE_STORE_KEY] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	evidence := make([]*Evidence, len(keys))
+	for i, key := range keys {
+		evidence[i] = &Evidence{}
+		err := proto.Unmarshal(v.localStorage.store[EVIDENCE_STORE_KEY][key], evidence[i])
+		assert.NoError(v.suite.T, err)
+	}
+
+	return evidence
+}
+
+// name of evidence kind, also used in scenario files
+func evidenceKind(evidence *Evidence) string {
+	switch evidence.Kind.(type) {
+	case *Evidence_InvalidAdaptSig:
+		return "invalid_adapt_sig"
+	case *Evidence_InvalidSecretShare:
+		return "invalid_secret_share"
+	}
+
+	return "unknown"
+}
+
+func (v *MockValidator) storeProofsEnvelope(env *Envelope) {
+	envBytes, err := proto.Marshal(env)
+	assert.NoError(v.suite.T, err)
+	v.protocolStorage.store[PROOFS_ENVELOPE_STORE_KEY][strconv.FormatInt(env.Source, 10)] = envBytes
+}
+
+// the dealer has signed both its secret shares and its polynomial commitments
+func (v *MockValidator) newInvalidSecretShareEvidence(env *Envelope, key int64) *Evidence {
+	proofsEnvelope, ok := v.protocolStorage.store[PROOFS_ENVELOPE_STORE_KEY][strconv.FormatInt(env.Source, 10)]
+	if !ok {
+		v.logger.Printf("polynomial commitments envelope of validator %d has not been received\n", env.Source)
+		return nil
+	}
+	secretSharesEnvelope, err := proto.Marshal(env)
+	assert.NoError(v.suite.T, err)
+
+	return &Evidence{
+		Accused:  env.Source,
+		Reporter: v.position,
+		Kind: &Evidence_InvalidSecretShare{InvalidSecretShare: &InvalidSecretShareEvidence{
+			SecretSharesEnvelope: secretSharesEnvelope,
+			ProofsEnvelope:       proofsEnvelope,
+			Key:                  key,
+		}},
+	}
+}
+
+// the signer has signed its adapt sig together with the message and signers it is computed over
+// nonces and public signing shares are taken from the public protocol state
+func (v *MockValidator) newInvalidAdaptSigEvidence(env *Envelope) *Evidence {
+	msg := env.GetUpdateAdaptSig()
+	nonceCommitments := make([]*NonceCommitments, len(msg.Signers))
+	for i, signer := range msg.Signers {
+		nonceBytes := v.protocolStorage.store[NONCE_COMMITMENTS_STORE_KEY+strconv.FormatInt(msg.SigningIndex, 10)][strconv.FormatInt(signer, 10)]
+		if nonceBytes == nil {
+			v.logger.Printf("nonce commitments of validator %d at signing index %d have not been received\n", signer, msg.SigningIndex)
+			return nil
+		}
+		nonceCommitments[i] = &NonceCommitments{}
+		err := proto.Unmarshal(nonceBytes, nonceCommitments[i])
+		assert.NoError(v.suite.T, err)
+	}
+
+	key_range := v.protocolStorage.GetKeyRange(strconv.FormatInt(msg.Source, 10))
+	publicSigningShares := make([]*PublicSigningShare, 0, key_range[1]-key_range[0])
+	for key := key_range[0]; key < key_range[1]; key++ {
+		publicSigningShares = append(publicSigningShares, &PublicSigningShare{
+			Key:   key,
+			Share: v.frost.GetPublicSigningShares(key).SerializeCompressed(),
+		})
+	}
+	adaptSigEnvelope, err := proto.Marshal(env)
+	assert.NoError(v.suite.T, err)
+
+	return &Evidence{
+		Accused:  msg.Source,
+		Reporter: v.position,
+		Kind: &Evidence_InvalidAdaptSig{InvalidAdaptSig: &InvalidAdaptSigEvidence{
+			AdaptSigEnvelope:    adaptSigEnvelope,
+			NonceCommitments:    nonceCommitments,
+			PublicSigningShares: publicSigningShares,
+			GroupPublicKey:      v.frost.GroupPublicKey.SerializeCompressed(),
+		}},
+	}
+}
+
+// verify evidence with the public protocol state only: validator keys, polynomial commitments, nonce commitments and key ranges
+// so that any validator, or an observer that follows the chain, can verify it
+func (v *MockValidator) VerifyEvidence(evidence *Evidence) error {
+	switch kind := evidence.Kind.(type) {
+	case *Evidence_InvalidSecretShare:
+		return v.verifyInvalidSecretShareEvidence(evidence.Accused, kind.InvalidSecretShare)
+	case *Evidence_InvalidAdaptSig:
+		return v.verifyInvalidAdaptSigEvidence(evidence.Accused, kind.InvalidAdaptSig)
+	}
+
+	return fmt.Errorf("unknown evidence kind against validator %d", evidence.Accused)
+}
+
+func (v *MockValidator) verifyInvalidSecretShareEvidence(accused int64, evidence *InvalidSecretShareEvidence) error {
+	sharesEnv, err := v.verifyEnvelope(evidence.SecretSharesEnvelope)
+	if err != nil {
+		return err
+	}
+	proofsEnv, err := v.verifyEnvelope(evidence.ProofsEnvelope)
+	if err != nil {
+		return err
+	}
+	if sharesEnv.GetSecretShares() == nil || proofsEnv.GetUpdateProofs() == nil {
+		return fmt.Errorf("evidence carries %s and %s messages", envelopeType(sharesEnv), envelopeType(proofsEnv))
+	}
+	if sharesEnv.Source != accused || proofsEnv.Source != accused {
+		return fmt.Errorf("evidence against validator %d is signed by validators %d and %d", accused, sharesEnv.Source, proofsEnv.Source)
+	}
+	if sharesEnv.Epoch != proofsEnv.Epoch {
+		return fmt.Errorf("secret shares of epoch %d and polynomial commitments of epoch %d", sharesEnv.Epoch, proofsEnv.Epoch)
+	}
+
+	// the signed polynomial commitments are the ones committed on - chain
+	commitments := proofsEnv.GetUpdateProofs().PolynomialCommitments
+	committed := v.getPolyCommitments(accused)
+	if len(committed) != len(commitments) {
+		return fmt.Errorf("polynomial commitments of validator %d have not been committed", accused)
+	}
+	for i, commitment := range committed {
+		if !bytes.Equal(commitment.SerializeCompressed(), commitments[i]) {
+			return fmt.Errorf("polynomial commitments of validator %d differ from committed ones", accused)
+		}
+	}
+
+	for _, secretShare := range sharesEnv.GetSecretShares().SecretShares {
+		if secretShare.Posi != evidence.Key {
+			continue
+		}
+		if isValidSecretShare(v.newObserverFrost(), accused, secretShare) {
+			return fmt.Errorf("secret share %d of validator %d matches its polynomial commitments", evidence.Key, accused)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("secret shares of validator %d do not include key %d", accused, evidence.Key)
+}
+
+func (v *MockValidator) verifyInvalidAdaptSigEvidence(accused int64, evidence *InvalidAdaptSigEvidence) error {
+	env, err := v.verifyEnvelope(evidence.AdaptSigEnvelope)
+	if err != nil {
+		return err
+	}
+	msg := env.GetUpdateAdaptSig()
+	if msg == nil {
+		return fmt.Errorf("evidence carries %s message", envelopeType(env))
+	}
+	if env.Source != accused {
+		return fmt.Errorf("evidence against validator %d is signed by validator %d", accused, env.Source)
+	}
+	if len(msg.SigHash) != 32 {
+		return fmt.Errorf("adapt sig of validator %d does not declare its message", accused)
+	}
+	if len(evidence.NonceCommitments) != len(msg.Signers) {
+		return fmt.Errorf("evidence carries nonce commitments of %d signers, expected %d", len(evidence.NonceCommitments), len(msg.Signers))
+	}
+
+	observer := v.newObserverFrost()
+	group_key := observer.CalculateGroupPublicKey()
+	if !bytes.Equal(group_key.SerializeCompressed(), evidence.GroupPublicKey) {
+		return fmt.Errorf("group key of evidence differs from the committed one")
+	}
+
+	// nonces of signers are the ones committed on - chain
+	is_signer := false
+	honest_keys := make([]int64, 0)
+	public_nonces := make(map[int64][2]*btcec.PublicKey)
+	for i, signer := range msg.Signers {
+		is_signer = is_signer || signer == accused
+		committed, err := v.getNonceCommitments(signer, msg.SigningIndex)
+		if err != nil {
+			return fmt.Errorf("nonce commitments of validator %d at signing index %d have not been committed", signer, msg.SigningIndex)
+		}
+		nonces := evidence.NonceCommitments[i]
+		if !bytes.Equal(committed[0].SerializeCompressed(), nonces.D) || !bytes.Equal(committed[1].SerializeCompressed(), nonces.E) {
+			return fmt.Errorf("nonce commitments of validator %d differ from committed ones", signer)
+		}
+		public_nonces[signer] = committed
+
+		key_range := v.protocolStorage.GetKeyRange(strconv.FormatInt(signer, 10))
+		for key := key_range[0]; key < key_range[1]; key++ {
+			honest_keys = append(honest_keys, key)
+		}
+	}
+	if !is_signer {
+		return fmt.Errorf("validator %d is not a signer of its adapt sig", accused)
+	}
+
+	// public signing shares of the accused are derived from committed polynomial commitments
+	key_range := v.protocolStorage.GetKeyRange(strconv.FormatInt(accused, 10))
+	if int64(len(evidence.PublicSigningShares)) != key_range[1]-key_range[0] {
+		return fmt.Errorf("evidence carries %d public signing shares, validator %d has keys %v", len(evidence.PublicSigningShares), accused, key_range)
+	}
+	aggr := observer.AggregatePolyCommitments(v.partyNum)
+	public_signing_shares := make(map[int64]*btcec.PublicKey)
+	for i, share := range evidence.PublicSigningShares {
+		key := key_range[0] + int64(i)
+		expected := observer.CalculatePublicSigningSharesFromAggr(aggr, key)
+		if share.Key != key || !bytes.Equal(expected.SerializeCompressed(), share.Share) {
+			return fmt.Errorf("public signing share of key %d differs from the committed one", key)
+		}
+		public_signing_shares[key] = expected
+	}
+
+	// an adapt sig that cannot be parsed is invalid as well
+	adapt_sig, err := schnorr.ParseSignature(msg.AdaptSig)
+	if err != nil {
+		return nil
+	}
+	var sigHash [32]byte
+	copy(sigHash[:], msg.SigHash)
+	observer.CalculatePublicNonceCommitments(msg.SigningIndex, msg.Signers, sigHash, public_nonces)
+	if observer.WeightedPartialVerification(adapt_sig, msg.SigningIndex, accused, sigHash, honest_keys, public_signing_shares) {
+		return fmt.Errorf("adapt sig of validator %d at signing index %d is valid", accused, msg.SigningIndex)
+	}
+
+	return nil
+}
+
+// frost participant of an observer, it only holds polynomial commitments committed on - chain
+func (v *MockValidator) newObserverFrost() *testhelper.FrostParticipant {
+	observer := testhelper.NewFrostParticipant(v.suite, v.logger, v.frost.N, v.frost.Threshold, 0, nil)
+	delete(observer.PolynomialCommitments, 0)
+	observer.TapScriptRoot = v.frost.TapScriptRoot
+	for posi := int64(1); posi <= v.partyNum; posi++ {
+		if commitments := v.getPolyCommitments(posi); commitments != nil {
+			observer.UpdatePolynomialCommitments(posi, commitments)
+		}
+	}
+
+	return observer
+}
+
+// go test -v -run ^TestEvidence$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestEvidence(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validators := setupMockValidatorSet(t, &suite, 3, 10, 5)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	// keys are derived from random vp, the observer is the validator with the fewest keys, as it needs none
+	num_keys := func(validator *MockValidator) int64 {
+		key_range := query(validator, func() [2]int64 {
+			return validator.protocolStorage.GetKeyRange(strconv.FormatInt(validator.position, 10))
+		})
+		return key_range[1] - key_range[0]
+	}
+	ordered := append([]*MockValidator{}, validators...)
+	sort.SliceStable(ordered, func(i, j int) bool { return num_keys(ordered[i]) > num_keys(ordered[j]) })
+	reporter := ordered[0]
+	accused := ordered[1]
+	observer := ordered[2]
+	verifyEvidence := func(evidence *Evidence) error {
+		return query(observer, func() error { return observer.VerifyEvidence(evidence) })
+	}
+
+	// secret shares of the accused for keys of the reporter, the first one shifted by one
+	key_range := query(reporter, func() [2]int64 {
+		return reporter.protocolStorage.GetKeyRange(strconv.FormatInt(reporter.position, 10))
+	})
+	sharesEnv := sealSecretShares(t, accused, key_range, true)
+	var key int64
+	var ok bool
+	reporter.Do(func() {
+		key, ok = reporter.findInvalidSecretShare(sharesEnv.GetSecretShares())
+	})
+	assert.True(t, ok)
+	assert.Equal(t, key_range[0], key)
+
+	// evidence is verified by a validator that never received the secret shares
+	shareEvidence := query(reporter, func() *Evidence { return reporter.newInvalidSecretShareEvidence(sharesEnv, key) })
+	assert.NoError(t, verifyEvidence(shareEvidence))
+	reporter.Do(func() {
+		reporter.recordEvidence(shareEvidence)
+	})
+	recorded := query(reporter, reporter.GetEvidence)
+	assert.Equal(t, 1, len(recorded))
+	assert.Equal(t, "invalid_secret_share", evidenceKind(recorded[0]))
+
+	// an honest secret share is not evidence
+	honestEnv := sealSecretShares(t, accused, key_range, false)
+	reporter.Do(func() {
+		_, ok = reporter.findInvalidSecretShare(honestEnv.GetSecretShares())
+	})
+	assert.False(t, ok)
+	honestEvidence := query(reporter, func() *Evidence { return reporter.newInvalidSecretShareEvidence(honestEnv, key_range[0]) })
+	assert.ErrorContains(t, verifyEvidence(honestEvidence), "matches its polynomial commitments")
+
+	// an adapt sig of the accused over a message it has declared, with s shifted by one
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+	sendNonces(validators, 10)
+	time.Sleep(100 * time.Millisecond)
+	signers := []int64{1, 2, 3}
+	var sigHash [32]byte
+	copy(sigHash[:], []byte("evidence of an invalid adapt sig"))
+	adaptSigEnv := signAdaptSig(t, accused, signers, 0, sigHash, true)
+
+	sigEvidence := query(reporter, func() *Evidence { return reporter.newInvalidAdaptSigEvidence(adaptSigEnv) })
+	assert.NoError(t, verifyEvidence(sigEvidence))
+
+	// a valid adapt sig is not evidence
+	validEnv := signAdaptSig(t, accused, signers, 1, sigHash, false)
+	validEvidence := query(reporter, func() *Evidence { return reporter.newInvalidAdaptSigEvidence(validEnv) })
+	assert.ErrorContains(t, verifyEvidence(validEvidence), "is valid")
+	reporter_group_key := query(reporter, func() []byte { return reporter.frost.GroupPublicKey.SerializeCompressed() })
+
+	testCases := []struct {
+		name   string
+		tamper func(evidence *Evidence)
+		err    string
+	}{
+		{
+			name: "framed validator",
+			tamper: func(evidence *Evidence) {
+				evidence.Accused = observer.position
+			},
+			err: "is signed by validator",
+		},
+		{
+			name: "envelope not signed by the accused",
+			tamper: func(evidence *Evidence) {
+				env := &Envelope{}
+				err := proto.Unmarshal(evidence.GetInvalidAdaptSig().AdaptSigEnvelope, env)
+				assert.NoError(t, err)
+				env.GetUpdateAdaptSig().SigHash = make([]byte, 32)
+				evidence.GetInvalidAdaptSig().AdaptSigEnvelope, err = proto.Marshal(env)
+				assert.NoError(t, err)
+			},
+			err: "invalid signature",
+		},
+		{
+			name: "nonces not committed",
+			tamper: func(evidence *Evidence) {
+				nonces := evidence.GetInvalidAdaptSig().NonceCommitments
+				nonces[0], nonces[1] = nonces[1], nonces[0]
+			},
+			err: "differ from committed ones",
+		},
+		{
+			name: "public signing share not committed",
+			tamper: func(evidence *Evidence) {
+				shares := evidence.GetInvalidAdaptSig().PublicSigningShares
+				shares[0].Share = reporter_group_key
+			},
+			err: "public signing share of key",
+		},
+		{
+			name: "another group key",
+			tamper: func(evidence *Evidence) {
+				evidence.GetInvalidAdaptSig().GroupPublicKey = accused.keyPair.Pub.SerializeCompressed()
+			},
+			err: "group key of evidence",
+		},
+		{
+			name: "no evidence",
+			tamper: func(evidence *Evidence) {
+				evidence.Kind = nil
+			},
+			err: "unknown evidence kind",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			evidence := proto.Clone(sigEvidence).(*Evidence)
+			tc.tamper(evidence)
+			assert.ErrorContains(t, verifyEvidence(evidence), tc.err)
+		})
+	}
+}
+
+// secret shares of the dealer for keys in key_range, sealed by the dealer
+func sealSecretShares(t *testing.T, dealer *MockValidator, key_range [2]int64, invalid bool) *Envelope {
+	return query(dealer, func() *Envelope {
+		return dealer.sealSecretShares(t, key_range, invalid)
+	})
+}
+
+func (v *MockValidator) sealSecretShares(t *testing.T, key_range [2]int64, invalid bool) *Envelope {
+	secretShares := make([]*SecretShares, 0)
+	for key := key_range[0]; key < key_range[1]; key++ {
+		share := v.frost.GetSecretShares(key)
+		if invalid && key == key_range[0] {
+			share = new(btcec.ModNScalar).Add2(share, new(btcec.ModNScalar).SetInt(1))
+		}
+		shareBytes := share.Bytes()
+		secretShares = append(secretShares, &SecretShares{Posi: key, SecretShares: shareBytes[:]})
+	}
+
+	env := &Envelope{}
+	err := proto.Unmarshal(v.sealEnvelope(&Envelope_SecretShares{SecretShares: &MsgSecretShares{Source: v.position, SecretShares: secretShares}}), env)
+	assert.NoError(t, err)
+
+	return env
+}
+
+// partial sign sigHash with nonces at signing_index, and seal it as an adapt sig of the signer
+func signAdaptSig(t *testing.T, signer *MockValidator, signers []int64, signing_index int64, sigHash [32]byte, invalid bool) *Envelope {
+	return query(signer, func() *Envelope {
+		return signer.signAdaptSig(t, signers, signing_index, sigHash, invalid)
+	})
+}
+
+func (v *MockValidator) signAdaptSig(t *testing.T, signers []int64, signing_index int64, sigHash [32]byte, invalid bool) *Envelope {
+	public_nonces := make(map[int64][2]*btcec.PublicKey)
+	honest_keys := make([]int64, 0)
+	for _, posi := range signers {
+		nonces, err := v.getNonceCommitments(posi, signing_index)
+		assert.NoError(t, err)
+		public_nonces[posi] = nonces
+		key_range := v.protocolStorage.GetKeyRange(strconv.FormatInt(posi, 10))
+		for key := key_range[0]; key < key_range[1]; key++ {
+			honest_keys = append(honest_keys, key)
+		}
+	}
+	key_range := v.protocolStorage.GetKeyRange(strconv.FormatInt(v.position, 10))
+	signing_shares := make(map[int64]*btcec.ModNScalar)
+	for key := key_range[0]; key < key_range[1]; key++ {
+		signing_shares[key] = v.GetLongTermSecretShares(key)
+	}
+
+	v.frost.CalculatePublicNonceCommitments(signing_index, signers, sigHash, public_nonces)
+	adapt_sig := v.frost.WeightedPartialSign(v.position, signing_index, signers, honest_keys, sigHash, public_nonces, signing_shares).Serialize()
+	if invalid {
+		s := new(btcec.ModNScalar)
+		s.SetByteSlice(adapt_sig[32:64])
+		s.Add(new(btcec.ModNScalar).SetInt(1))
+		sBytes := s.Bytes()
+		adapt_sig = append(adapt_sig[:32], sBytes[:]...)
+	}
+
+	env := &Envelope{}
+	err := proto.Unmarshal(v.sealEnvelope(&Envelope_UpdateAdaptSig{UpdateAdaptSig: &MsgUpdateAdaptSig{
+		Source:       v.position,
+		AdaptSig:     adapt_sig,
+		SigningIndex: signing_index,
+		SigHash:      sigHash[:],
+		Signers:      signers,
+	}}), env)
+	assert.NoError(t, err)
+
+	return env
+}
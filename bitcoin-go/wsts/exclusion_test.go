@@ -0,0 +1,143 @@
+package wsts
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+)
+
+// exclude a misbehaving validator from the protocol
+// an excluded validator is no longer counted as a signer, so the current signing session is aborted
+func (v *MockValidator) excludeValidator(posi int64, reason string) {
+	if _, ok := v.dishonestVals[posi]; ok {
+		return
+	}
+
+	v.logger.Printf("validator %d is dishonest: %s\n", posi, reason)
+	v.dishonestVals[posi] = true
+
+	// nonces of a session that this validator has not signed yet are aggregated over the new honest set
+	if !v.hasStartedSigningSession() {
+		return
+	}
+	v.abortSigningSession()
+}
+
+// signing has not started before the genesis checkpoint
+// a session starts when this validator aggregates its nonces to sign
+func (v *MockValidator) hasStartedSigningSession() bool {
+	return v.btcCheckpointheight != 0 && v.frost.AggrNonceCommitment[v.nextSigningIndex] != nil
+}
+
+// adapt sigs of the current session are computed over the previous honest set and can never be aggregated
+// nonces of the session are burnt, so that the next session signs with new nonces
+func (v *MockValidator) abortSigningSession() {
+	sessions_num := signingSessionsNum(v.signingTxs(v.reservesMessage))
+	for i := int64(0); i < sessions_num; i++ {
+		delete(v.protocolStorage.store, ADAPT_SIG_STORE_KEY+strconv.FormatInt(v.nextSigningIndex+i, 10))
+		v.invalidateNonce(v.nextSigningIndex + i)
+	}
+
+	v.logger.Printf("abort signing session %d of checkpoint %d\n", v.nextSigningIndex, v.btcCheckpointheight)
+	v.nextSigningIndex += sessions_num
+	v.ReplenishNonces()
+}
+
+// validators that have been excluded, in ascending order
+func (v *MockValidator) GetExcludedValidators() []int64 {
+	excluded := make([]int64, 0, len(v.dishonestVals))
+	for posi := range v.dishonestVals {
+		excluded = append(excluded, posi)
+	}
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i] < excluded[j] })
+
+	return excluded
+}
+
+func hasDuplicatedNonces(nonce_commitments []*NonceCommitments) bool {
+	seen := make(map[string]bool)
+	for _, nonce_commitment := range nonce_commitments {
+		for _, nonce := range [][]byte{nonce_commitment.D, nonce_commitment.E} {
+			if seen[string(nonce)] {
+				return true
+			}
+			seen[string(nonce)] = true
+		}
+	}
+
+	return false
+}
+
+// check secret shares for keys of this validator against polynomial commitments of the source
+// return the first key whose secret share does not match
+func (v *MockValidator) findInvalidSecretShare(msg *MsgSecretShares) (int64, bool) {
+	for _, secretShare := range msg.SecretShares {
+		if !v.protocolStorage.IsKeyInRange(strconv.FormatInt(v.position, 10), secretShare.Posi) {
+			continue
+		}
+
+		if !isValidSecretShare(v.frost, msg.Source, secretShare) {
+			return secretShare.Posi, true
+		}
+	}
+
+	return 0, false
+}
+
+func isValidSecretShare(frost *testhelper.FrostParticipant, source int64, secretShare *SecretShares) bool {
+	share := new(btcec.ModNScalar)
+	if overflow := share.SetByteSlice(secretShare.SecretShares); overflow {
+		return false
+	}
+
+	return frost.IsValidPublicSecretShares(share, source, uint32(secretShare.Posi))
+}
+
+// after the nonce round times out, validators that have not sent nonces for the current signing sessions are excluded
+func (v *MockValidator) ExcludeWithheldNonces() {
+	sessions_num := signingSessionsNum(v.signingTxs(v.reservesMessage))
+	withheld := make([]int64, 0)
+	for posi := int64(1); posi <= v.partyNum; posi++ {
+		if _, ok := v.dishonestVals[posi]; ok {
+			continue
+		}
+		for i := int64(0); i < sessions_num; i++ {
+			if _, err := v.getNonceCommitments(posi, v.nextSigningIndex+i); err != nil {
+				withheld = append(withheld, posi)
+				break
+			}
+		}
+	}
+
+	for _, posi := range withheld {
+		v.excludeValidator(posi, "withheld nonce commitments")
+	}
+}
+
+// after the signing round times out, validators that have not sent adapt sigs for all current signing sessions are excluded
+// a session that this validator has not signed yet, e.g. right after an abort, has not started and nobody is missing
+func (v *MockValidator) ExcludeMissingAdaptSigs() {
+	if !v.hasStartedSigningSession() {
+		return
+	}
+
+	sessions_num := signingSessionsNum(v.signingTxs(v.reservesMessage))
+	missing := make([]int64, 0)
+	for posi := int64(1); posi <= v.partyNum; posi++ {
+		if _, ok := v.dishonestVals[posi]; ok {
+			continue
+		}
+		for i := int64(0); i < sessions_num; i++ {
+			if !v.hasAdaptSig(v.nextSigningIndex+i, posi) {
+				missing = append(missing, posi)
+				break
+			}
+		}
+	}
+
+	for _, posi := range missing {
+		v.excludeValidator(posi, "missing adapt sigs")
+	}
+}
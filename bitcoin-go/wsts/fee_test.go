@@ -0,0 +1,188 @@
+package wsts
+
+import (
+	"log"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// sat/vbyte
+	DEFAULT_BTC_FEE_RATE = int64(10)
+)
+
+func (v *MockValidator) SetFeeRate(fee_rate int64) {
+	v.btcFeeRate = fee_rate
+}
+
+// estimate virtual size of a vault transaction
+// the vault transaction has input_num taproot key - path inputs spending the previous checkpoint and deposits
+// and outputs are the next checkpoint output followed by withdrawals
+//
+// the key - path witness is a single 64 bytes schnorr signature with SigHashDefault
+// so the estimation is exact for the signed transaction
+func (v *MockValidator) estimateVaultTxVSize(input_num int, checkpoint_out *wire.TxOut, outputs []*wire.TxOut) int64 {
+	weight := v.estimateVaultTxWeight(input_num, checkpoint_out, outputs)
+	return (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+}
+
+func (v *MockValidator) estimateVaultTxFee(input_num int, checkpoint_out *wire.TxOut, outputs []*wire.TxOut) int64 {
+	return v.estimateVaultTxVSize(input_num, checkpoint_out, outputs) * v.btcFeeRate
+}
+
+func (v *MockValidator) withdrawPkScript(tx *MsgWithdraw) []byte {
+	addr, err := btcutil.DecodeAddress(tx.Receiver, v.suite.BtcdChainConfig)
+	assert.NoError(v.suite.T, err)
+
+	pkScript, err := txscript.PayToAddrScript(addr)
+	assert.NoError(v.suite.T, err)
+
+	return pkScript
+}
+
+// dust withdrawals are rejected before being stored
+// all validators apply the same rule, so they keep the same withdrawals
+func (v *MockValidator) filterDustWithdrawals(txs []*MsgWithdraw) []*MsgWithdraw {
+	accepted := make([]*MsgWithdraw, 0, len(txs))
+	for _, tx := range txs {
+		if v.isDustWithdrawal(tx) {
+			v.logger.Printf("reject dust withdrawal: %v\n", tx)
+			continue
+		}
+		accepted = append(accepted, tx)
+	}
+
+	return accepted
+}
+
+func (v *MockValidator) isDustWithdrawal(tx *MsgWithdraw) bool {
+	txOut := &wire.TxOut{
+		Value:    tx.Amount,
+		PkScript: v.withdrawPkScript(tx),
+	}
+	return mempool.IsDust(txOut, mempool.DefaultMinRelayTxFee)
+}
+
+// a validator with a random group key and a genesis checkpoint of amount
+// it is enough for constructing vault transactions without running DKG
+func newMockVaultValidator(suite *testhelper.TestSuite, amount int64) *MockValidator {
+	frost := testhelper.NewFrostParticipant(suite, log.Default(), 10, 7, 1, nil)
+	_, key_pair := suite.NewHDKeyPairFromSeed("")
+	frost.GroupPublicKey = key_pair.Pub
+
+	validator := NewMockValidator(suite, log.Default(), nil, frost, 1, 1)
+	trScript, err := txscript.PayToTaprootScript(frost.GroupPublicKey)
+	assert.NoError(suite.T, err)
+	first_tx := suite.NewMockFirstTx(trScript, amount)
+	suite.UtxoViewpoint.AddTxOut(btcutil.NewTx(first_tx), 0, 0)
+	validator.MockSetGenesisCheckPoint(first_tx, 0)
+
+	return validator
+}
+
+// go test -v -run ^TestVaultTxVSize$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestVaultTxVSize(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	// 1 taproot key - path input, 1 taproot output
+	trScript, err := txscript.PayToTaprootScript(validator.frost.GroupPublicKey)
+	assert.NoError(t, err)
+	checkpoint_out := &wire.TxOut{PkScript: trScript}
+	assert.Equal(t, int64(111), validator.estimateVaultTxVSize(1, checkpoint_out, nil))
+
+	// each taproot withdrawal adds 43 vbytes
+	withdrawals := generateMsgWithdrawList(&suite, 3)
+	outputs := make([]*wire.TxOut, 0)
+	for _, tx := range withdrawals {
+		outputs = append(outputs, &wire.TxOut{Value: tx.Amount, PkScript: validator.withdrawPkScript(tx)})
+	}
+	assert.Equal(t, int64(111+3*43), validator.estimateVaultTxVSize(1, checkpoint_out, outputs))
+
+	// fee follows the configured fee rate
+	validator.SetFeeRate(25)
+	assert.Equal(t, int64(111+3*43)*25, validator.estimateVaultTxFee(1, checkpoint_out, outputs))
+}
+
+// go test -v -run ^TestRejectDustWithdrawal$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestRejectDustWithdrawal(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	withdrawals := generateMsgWithdrawList(&suite, 3)
+	withdrawals[0].Amount = 100000
+	withdrawals[1].Amount = 100
+	withdrawals[2].Amount = 200000
+
+	accepted := validator.filterDustWithdrawals(withdrawals)
+	assert.Equal(t, []*MsgWithdraw{withdrawals[0], withdrawals[2]}, accepted)
+}
+
+// go test -v -run ^TestDeferWithdrawalsOnLowBalance$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestDeferWithdrawalsOnLowBalance(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000)
+	defer validator.Stop()
+
+	// vault can cover the first two withdrawals, but not the third one
+	withdrawals := generateMsgWithdrawList(&suite, 4)
+	withdrawals[0].Amount = 400000
+	withdrawals[1].Amount = 400000
+	withdrawals[2].Amount = 400000
+	withdrawals[3].Amount = 10000
+	validator.storeTxs(1, withdrawals)
+
+	btc_tx := validator.handleTxs(txscript.SigHashDefault)[0].tx
+	assert.Equal(t, 3, len(btc_tx.TxOut))
+	assert.Equal(t, withdrawals[0].Amount, btc_tx.TxOut[1].Value)
+	assert.Equal(t, withdrawals[1].Amount, btc_tx.TxOut[2].Value)
+
+	// remaining vault balance pays exactly the fee
+	fee := validator.estimateVaultTxFee(1, btc_tx.TxOut[0], btc_tx.TxOut[1:])
+	assert.Equal(t, int64(1000000)-800000-fee, btc_tx.TxOut[0].Value)
+
+	// deferred withdrawals stay pending in order after the included ones are cleared
+	assert.NoError(t, validator.clearTxs(len(btc_tx.TxOut)-1))
+	pending := validator.getAllTxs()
+	assert.Equal(t, 2, len(pending))
+	assert.True(t, proto.Equal(withdrawals[2], pending[0]))
+	assert.True(t, proto.Equal(withdrawals[3], pending[1]))
+}
+
+// go test -v -run ^TestDeferCheckpointOnLowBalance$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestDeferCheckpointOnLowBalance(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	// vault balance is below the fee of a checkpoint transaction
+	validator := newMockVaultValidator(&suite, 1000)
+	defer validator.Stop()
+	validator.storeTxs(1, generateMsgWithdrawList(&suite, 2))
+
+	assert.Empty(t, validator.handleTxs(txscript.SigHashDefault))
+	assert.ErrorContains(t, validator.DeriveTxAndSign(), "checkpoint is deferred")
+
+	// vault balance covers the fee, but would leave a dust vault output
+	trScript, err := txscript.PayToTaprootScript(validator.frost.GroupPublicKey)
+	assert.NoError(t, err)
+	fee := validator.estimateVaultTxFee(1, &wire.TxOut{PkScript: trScript}, nil)
+	dust_validator := newMockVaultValidator(&suite, fee+100)
+	defer dust_validator.Stop()
+	assert.Empty(t, dust_validator.handleTxs(txscript.SigHashDefault))
+}
@@ -0,0 +1,215 @@
+package wsts
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"cosmossdk.io/math"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+// keys are apportioned to validators by largest remainder, so that no validator is more than one key away from its vp
+const KEY_APPORTION_METHOD = testhelper.LargestRemainder
+
+func (v *MockValidator) SetRejectUnsafeKeys(reject bool) {
+	v.rejectUnsafeKeys = reject
+}
+
+// keys of validators apportioned by their latest vp, every validator has at least one key
+// vp is apportioned as the integer behind its decimal, so that all validators derive the same keys
+// also return the positions of validators that reach the signing threshold of keys without more than 2/3 of vp, nil if there are none
+func (v *MockValidator) KeyAllocation() (*testhelper.Apportionment, []int64, error) {
+	weights := make([]*big.Int, v.partyNum)
+	for i := int64(1); i <= v.partyNum; i++ {
+		vp_bytes, ok := v.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(i, 10)]
+		if !ok {
+			return nil, nil, fmt.Errorf("vp of validator %d is unknown", i)
+		}
+		weights[i-1] = bytesToVp(v.suite, vp_bytes).BigInt()
+	}
+
+	allocation, err := testhelper.Apportion(weights, v.frost.N, 1, KEY_APPORTION_METHOD)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	max_deviation, _ := allocation.MaxDeviation.Float64()
+	v.logger.Printf("keys are apportioned by %v: %v, max deviation between vp and keys is %.4f at validator %d\n", allocation.Method, allocation.Seats, max_deviation, allocation.MaxDeviationParty+1)
+
+	// threshold + 1 keys sign, so signing needs the same vp as deposits and pauses, more than 2/3
+	coalition := allocation.UnsafeCoalition(v.frost.Threshold+1, big.NewRat(2, 3), true)
+	if coalition == nil {
+		return allocation, nil, nil
+	}
+	positions := make([]int64, len(coalition))
+	for i, party := range coalition {
+		positions[i] = int64(party + 1)
+	}
+
+	return allocation, positions, nil
+}
+
+func decWeights(vps ...string) []*big.Int {
+	weights := make([]*big.Int, len(vps))
+	for i, vp := range vps {
+		weights[i] = math.LegacyMustNewDecFromStr(vp).BigInt()
+	}
+
+	return weights
+}
+
+// go test -v -run ^TestKeyApportionment$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestKeyApportionment(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	// rounding each vp on its own gives 0, 4, 4, 3 keys, the first validator was left with -1 keys
+	for _, method := range []testhelper.ApportionMethod{testhelper.LargestRemainder, testhelper.SainteLague} {
+		allocation, err := testhelper.Apportion(decWeights("0.02", "0.36", "0.36", "0.26"), 10, 1, method)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1, 3, 3, 3}, allocation.Seats, method.String())
+		assert.Equal(t, 0, allocation.MaxDeviationParty)
+		assert.Equal(t, big.NewRat(2, 25), allocation.MaxDeviation)
+	}
+
+	// largest remainder gives the keys left after quotas to the largest remainders, here 0.7 and then 0.6 of the first equal validator
+	// Sainte-Laguë gives each next key to the largest vp / (2 * keys + 1), which keeps equal validators equal here
+	allocation, err := testhelper.Apportion(decWeights("0.47", "0.16", "0.16", "0.21"), 10, 0, testhelper.LargestRemainder)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5, 2, 1, 2}, allocation.Seats)
+	allocation, err = testhelper.Apportion(decWeights("0.47", "0.16", "0.16", "0.21"), 10, 0, testhelper.SainteLague)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4, 2, 2, 2}, allocation.Seats)
+
+	// more validators than keys
+	_, err = testhelper.Apportion(decWeights("0.5", "0.3", "0.2"), 2, 1, KEY_APPORTION_METHOD)
+	assert.Error(t, err)
+
+	// keys always add up, and every validator has at least one key
+	randsource := rand.New(rand.NewSource(41))
+	for round := 0; round < 200; round++ {
+		n_p := randsource.Int63n(50) + 1
+		n_keys := n_p + randsource.Int63n(200)
+		weights := make([]*big.Int, n_p)
+		total_weight := new(big.Int)
+		for i := range weights {
+			// some validators have vp orders of magnitude below others
+			weights[i] = big.NewInt(randsource.Int63n(1000000) >> uint(randsource.Intn(20)))
+			total_weight.Add(total_weight, weights[i])
+		}
+		if total_weight.Sign() == 0 {
+			continue
+		}
+
+		for _, method := range []testhelper.ApportionMethod{testhelper.LargestRemainder, testhelper.SainteLague} {
+			allocation, err := testhelper.Apportion(weights, n_keys, 1, method)
+			assert.NoError(t, err)
+			sum := int64(0)
+			for _, seats := range allocation.Seats {
+				assert.GreaterOrEqual(t, seats, int64(1))
+				sum += seats
+			}
+			assert.Equal(t, n_keys, sum)
+
+			// without validators lifted to one key, largest remainder keeps every validator within one key of its quota
+			quota_above_one := true
+			for _, weight := range weights {
+				quota_above_one = quota_above_one && new(big.Int).Mul(weight, big.NewInt(n_keys)).Cmp(total_weight) >= 0
+			}
+			if method == testhelper.LargestRemainder && quota_above_one {
+				assert.Equal(t, -1, allocation.MaxDeviation.Cmp(big.NewRat(1, n_keys)), "round %d", round)
+			}
+		}
+	}
+}
+
+// go test -v -run ^TestKeyAllocationSafety$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestKeyAllocationSafety(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	// the leftover key goes to the first validator, which then reaches 3 of 5 keys with a validator of 20%
+	allocation, err := testhelper.Apportion(decWeights("0.3", "0.3", "0.2", "0.2"), 5, 1, KEY_APPORTION_METHOD)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 1, 1, 1}, allocation.Seats)
+	weight, coalition := allocation.WeakestCoalition(3)
+	assert.Equal(t, []int{0, 2}, coalition)
+	assert.Equal(t, math.LegacyMustNewDecFromStr("0.5").BigInt(), weight)
+	assert.Equal(t, []int{0, 2}, allocation.UnsafeCoalition(3, big.NewRat(3, 5), false))
+	assert.Nil(t, allocation.UnsafeCoalition(3, big.NewRat(1, 2), false))
+	// no coalition reaches more keys than there are
+	weight, coalition = allocation.WeakestCoalition(6)
+	assert.Nil(t, weight)
+	assert.Nil(t, coalition)
+
+	// with finer keys, every coalition needs 60% of vp for 60% of keys
+	allocation, err = testhelper.Apportion(decWeights("0.3", "0.3", "0.2", "0.2"), 10, 1, KEY_APPORTION_METHOD)
+	assert.NoError(t, err)
+	assert.Nil(t, allocation.UnsafeCoalition(6, big.NewRat(3, 5), false))
+
+	// signing needs more than 2/3 of vp, 6 of 10 keys are reached with 60% of vp, while 7 keys need 70%
+	assert.Equal(t, []int{0, 1}, allocation.UnsafeCoalition(6, big.NewRat(2, 3), true))
+	assert.Nil(t, allocation.UnsafeCoalition(7, big.NewRat(2, 3), true))
+	// exactly 2/3 of vp is only enough when the threshold is inclusive
+	allocation, err = testhelper.Apportion([]*big.Int{big.NewInt(1), big.NewInt(1), big.NewInt(1)}, 3, 1, KEY_APPORTION_METHOD)
+	assert.NoError(t, err)
+	assert.Len(t, allocation.UnsafeCoalition(2, big.NewRat(2, 3), true), 2)
+	assert.Nil(t, allocation.UnsafeCoalition(2, big.NewRat(2, 3), false))
+	assert.Nil(t, allocation.UnsafeCoalition(3, big.NewRat(2, 3), true))
+
+	// validators derive key ranges from the same allocation
+	validators := make([]*MockValidator, 4)
+	for i := range validators {
+		frost := testhelper.NewFrostParticipant(&suite, log.Default(), 10, 5, int64(i+1), nil)
+		validators[i] = NewMockValidator(&suite, log.Default(), nil, frost, 4, int64(i+1))
+		defer validators[i].Stop()
+	}
+	for _, validator := range validators {
+		for i, vp := range []string{"0.02", "0.36", "0.36", "0.26"} {
+			validator.protocolStorage.store[VP_STORE_KEY][strconv.Itoa(i+1)] = vpToBytes(&suite, math.LegacyMustNewDecFromStr(vp))
+		}
+	}
+	deriveRangeOfKeys := func(validator *MockValidator) (range_keys map[int64][2]int64, err error) {
+		validator.Do(func() {
+			range_keys, err = validator.DeriveRangeOfKeys()
+		})
+		return range_keys, err
+	}
+	range_keys, err := deriveRangeOfKeys(validators[0])
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64][2]int64{1: {1, 2}, 2: {2, 5}, 3: {5, 8}, 4: {8, 11}}, range_keys)
+	for _, validator := range validators[1:] {
+		validator_range_keys, err := deriveRangeOfKeys(validator)
+		assert.NoError(t, err)
+		assert.Equal(t, range_keys, validator_range_keys)
+	}
+
+	// 6 of 10 keys sign, two validators of 0.36 and 0.26 vp reach them
+	var unsafe_coalition []int64
+	validators[0].Do(func() {
+		_, unsafe_coalition, err = validators[0].KeyAllocation()
+	})
+	assert.NoError(t, err)
+	assert.Len(t, unsafe_coalition, 2)
+	assert.Contains(t, unsafe_coalition, int64(4))
+	validators[0].Do(func() {
+		validators[0].SetRejectUnsafeKeys(true)
+	})
+	_, err = deriveRangeOfKeys(validators[0])
+	assert.ErrorContains(t, err, "without more than 2/3 of vp")
+	validators[0].Do(func() {
+		validators[0].SetRejectUnsafeKeys(false)
+	})
+
+	// vp of a validator has not been received
+	validators[0].Do(func() {
+		delete(validators[0].protocolStorage.store[VP_STORE_KEY], "4")
+	})
+	_, err = deriveRangeOfKeys(validators[0])
+	assert.Error(t, err)
+}
@@ -0,0 +1,242 @@
+package wsts
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+// key material produced by one DKG round
+// during vault migration, the old and the new group key states are swapped in and out of the validator
+// so that DKG and signing code can keep working on the active state
+type groupKeyState struct {
+	frost                *testhelper.FrostParticipant
+	groupVP              map[string][]byte
+	keyRanges            map[string][]byte
+	secretShares         map[string][]byte
+	longTermSecretShares map[string][]byte
+}
+
+func newGroupKeyState(frost *testhelper.FrostParticipant) *groupKeyState {
+	return &groupKeyState{
+		frost:                frost,
+		groupVP:              make(map[string][]byte),
+		keyRanges:            make(map[string][]byte),
+		secretShares:         make(map[string][]byte),
+		longTermSecretShares: make(map[string][]byte),
+	}
+}
+
+// activate next state, and return the previously active state
+func (v *MockValidator) swapGroupKeyState(next *groupKeyState) *groupKeyState {
+	prev := &groupKeyState{
+		frost:                v.frost,
+		groupVP:              v.protocolStorage.store[GROUP_VP_STORE_KEY],
+		keyRanges:            v.protocolStorage.store[KEY_RANGE_STORE_KEY],
+		secretShares:         v.localStorage.store[SECRET_SHARES_STORE_KEY],
+		longTermSecretShares: v.localStorage.store[LONG_TERM_SECRET_SHARES_KEY],
+	}
+
+	v.frost = next.frost
+	v.protocolStorage.store[GROUP_VP_STORE_KEY] = next.groupVP
+	v.protocolStorage.store[KEY_RANGE_STORE_KEY] = next.keyRanges
+	v.localStorage.store[SECRET_SHARES_STORE_KEY] = next.secretShares
+	v.localStorage.store[LONG_TERM_SECRET_SHARES_KEY] = next.longTermSecretShares
+
+	return prev
+}
+
+// remember vp of all validators that the current group key is derived from
+func (v *MockValidator) snapshotGroupVP() {
+	group_vp := make(map[string][]byte)
+	for posi, vp := range v.protocolStorage.store[VP_STORE_KEY] {
+		group_vp[posi] = vp
+	}
+	v.protocolStorage.store[GROUP_VP_STORE_KEY] = group_vp
+}
+
+// check if the latest vp differs from the vp that the current group key is derived from
+func (v *MockValidator) IsVPChanged() bool {
+	group_vp := v.protocolStorage.store[GROUP_VP_STORE_KEY]
+	for i := int64(1); i <= v.partyNum; i++ {
+		posi := strconv.FormatInt(i, 10)
+		if !bytes.Equal(group_vp[posi], v.protocolStorage.store[VP_STORE_KEY][posi]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a vp change after DKG triggers a new DKG round on the provided frost participant
+// the old group key is kept on standby to sign the migration transaction
+//
+// return false if vp has not changed
+func (v *MockValidator) BeginVaultMigration(frost *testhelper.FrostParticipant) bool {
+	if v.migrating || !v.IsVPChanged() {
+		return false
+	}
+
+	v.logger.Printf("vp has changed, begin vault migration at checkpoint %d\n", v.btcCheckpointheight)
+	v.migrating = true
+	v.epoch++
+	// the vault keeps its recovery leaves under the new group key
+	frost.TapScriptRoot = v.frost.TapScriptRoot
+	v.standbyGroup = v.swapGroupKeyState(newGroupKeyState(frost))
+
+	return true
+}
+
+// both group keys are ready, the old one is active for signing the migration transaction
+func (v *MockValidator) isMigrationReady() bool {
+	return v.standbyGroup != nil && v.standbyGroup.frost.GroupPublicKey != nil && v.frost.GroupPublicKey != nil
+}
+
+// the new group key that the vault is migrating to, nil if it is not ready
+func (v *MockValidator) GetNextGroupPublicKey() *btcec.PublicKey {
+	if !v.migrating || !v.isMigrationReady() {
+		return nil
+	}
+
+	return v.standbyGroup.frost.GroupPublicKey
+}
+
+// migration transaction has been finalized, switch to the new group key
+// signing index restarts, so signing data of the old group key is removed
+func (v *MockValidator) completeVaultMigration() {
+	v.swapGroupKeyState(v.standbyGroup)
+	v.standbyGroup = nil
+	v.migrating = false
+	v.nextSigningIndex = 0
+
+	for substore_key := range v.protocolStorage.store {
+		if strings.HasPrefix(substore_key, NONCE_COMMITMENTS_STORE_KEY) ||
+			strings.HasPrefix(substore_key, PUBLIC_NONCE_COMMITMENTS_STORE_KEY) ||
+			strings.HasPrefix(substore_key, NONCE_CONSUMED_STORE_KEY) ||
+			strings.HasPrefix(substore_key, ADAPT_SIG_STORE_KEY) {
+			delete(v.protocolStorage.store, substore_key)
+		}
+	}
+	// secret nonces of the old group key can no longer sign
+	v.localStorage.store[SIGNING_NONCE_STORE_KEY] = make(map[string][]byte)
+
+	v.logger.Printf("vault migration completed, new group key: %v\n", v.frost.GroupPublicKey)
+}
+
+// go test -v -run ^TestVaultMigration$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestVaultMigration(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	n_keys := int64(10)
+	threshold := int64(7)
+	message_num := 10
+
+	validators := setupMockValidatorSet(t, &suite, n, n_keys, threshold)
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+	old_group_key := validators[0].frost.GroupPublicKey
+
+	// old group key signs one normal checkpoint and the migration checkpoint
+	sendNonces(validators, 2)
+
+	// checkpoint 1 is a normal checkpoint
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, message_num), Sequence: 1})
+	signCheckPoint(t, validators)
+	suite.MockMineTx(validators[0].GetSignedTxs(1)[0], 1)
+
+	// no migration without vp change
+	for _, validator := range validators {
+		assert.False(t, validator.IsVPChanged())
+	}
+
+	// vp changes after DKG
+	deriveValidatorvp(&suite, validators)
+	var wgGroup sync.WaitGroup
+	for _, validator := range validators {
+		wgGroup.Add(1)
+		go func(validator *MockValidator) {
+			validator.Do(validator.SendVPToAll)
+			wgGroup.Done()
+		}(validator)
+	}
+	wgGroup.Wait()
+	waitVPPropagated(validators)
+
+	// vp change triggers a new DKG round
+	for i, validator := range validators {
+		frost := testhelper.NewFrostParticipant(&suite, validator.logger, n_keys, threshold, int64(i+1), nil)
+		assert.True(t, query(validator, func() bool { return validator.BeginVaultMigration(frost) }))
+	}
+
+	// withdrawals submitted during migration are queued
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, message_num), Sequence: 2})
+	for _, validator := range validators {
+		err := query(validator, validator.DeriveTxAndSign)
+		assert.Error(t, err)
+	}
+
+	runDKG(t, validators)
+	for _, validator := range validators {
+		for query(validator, validator.GetNextGroupPublicKey) == nil {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	new_group_key := validators[0].GetNextGroupPublicKey()
+	assert.NotEqual(t, schnorr.SerializePubKey(old_group_key), schnorr.SerializePubKey(new_group_key))
+	for _, validator := range validators {
+		assert.Equal(t, new_group_key, validator.GetNextGroupPublicKey())
+	}
+
+	// old group key signs the migration transaction, moving the vault to the new group taproot address
+	signCheckPoint(t, validators)
+	migration_tx := validators[0].GetSignedTxs(2)[0]
+	new_trScript, err := txscript.PayToTaprootScript(new_group_key)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(migration_tx.TxOut))
+	assert.Equal(t, new_trScript, migration_tx.TxOut[0].PkScript)
+	suite.MockMineTx(migration_tx, 2)
+
+	for _, validator := range validators {
+		assert.False(t, validator.migrating)
+		assert.Equal(t, new_group_key, validator.frost.GroupPublicKey)
+		assert.Equal(t, message_num, validator.GetPendingTxsNum())
+		assert.False(t, validator.IsVPChanged())
+	}
+
+	// new group key signs queued withdrawals
+	sendNonces(validators, 1)
+	signCheckPoint(t, validators)
+	signed_tx := validators[0].GetSignedTxs(3)[0]
+	assert.Equal(t, migration_tx.TxHash(), signed_tx.TxIn[0].PreviousOutPoint.Hash)
+	assert.Equal(t, message_num+1, len(signed_tx.TxOut))
+	assert.Equal(t, new_trScript, signed_tx.TxOut[0].PkScript)
+	suite.MockMineTx(signed_tx, 3)
+
+	for _, validator := range validators {
+		assert.Equal(t, 0, validator.GetPendingTxsNum())
+		validator.Stop()
+	}
+}
+
+// probing to see if all validators have received vp of all other validators
+func waitVPPropagated(validators []*MockValidator) {
+	for _, validator := range validators {
+		for _, other := range validators {
+			posi := strconv.FormatInt(other.position, 10)
+			for !bytes.Equal(query(validator, func() []byte { return validator.protocolStorage.store[VP_STORE_KEY][posi] }), query(other, func() []byte { return other.protocolStorage.store[VP_STORE_KEY][posi] })) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package wsts
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
@@ -20,29 +25,42 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// a debugging aid, a validator logs when it has not received any message for this long
+var validatorIdleLog = flag.Duration("validator.idle", 0, "log validators that received no message for this long, 0 disables it")
+
 const (
 	// protocol storage
 	VP_STORE_KEY                       = "vp"
+	GROUP_VP_STORE_KEY                 = "group_vp"
 	KEY_RANGE_STORE_KEY                = "key_range"
 	NONCE_COMMITMENTS_STORE_KEY        = "nonce_commitments"
 	TRANSACTION_STORE_KEY              = "transactions"
 	CHECKPOINT_STORE_KEY               = "checkpoint"
 	PUBLIC_NONCE_COMMITMENTS_STORE_KEY = "public_nonce_commitments"
 	ADAPT_SIG_STORE_KEY                = "adapt_sig"
+	SIGNED_TX_STORE_KEY                = "signed_tx"
+	DEPOSIT_ATTEST_STORE_KEY           = "deposit_attest"
+	DEPOSIT_STORE_KEY                  = "deposit"
+	CONSOLIDATED_DEPOSIT_STORE_KEY     = "consolidated_deposit"
+	VALIDATOR_PUB_KEY_STORE_KEY        = "validator_pub_key"
+	PROOFS_ENVELOPE_STORE_KEY          = "proofs_envelope"
+	NONCE_ENVELOPE_STORE_KEY           = "nonce_envelope"
+	NONCE_CONSUMED_STORE_KEY           = "nonce_consumed"
+	RESERVES_PROOF_STORE_KEY           = "reserves_proof"
+	WITHDRAW_QUEUED_HEIGHT_STORE_KEY   = "withdraw_queued_height"
+	WITHDRAW_REJECTION_STORE_KEY       = "withdraw_rejection"
+	PAUSE_VOTE_STORE_KEY               = "pause_vote"
+	WITHDRAW_PAUSED_STORE_KEY          = "withdraw_paused"
+	PAID_WITHDRAWAL_STORE_KEY          = "paid_withdrawal"
+	PAYOUT_TX_STORE_KEY                = "payout_tx"
 
 	// local storage
 	SECRET_SHARES_STORE_KEY     = "secret_shares"
 	LONG_TERM_SECRET_SHARES_KEY = "long_term_secret_shares"
-)
-
-var (
-	MSG_VP_TYPE                  = byte(0)
-	MSG_PROOFS_TYPE              = byte(1)
-	MSG_SECRET_SHARES            = byte(2)
-	MSG_STOP                     = byte(3)
-	MSG_UPDATE_NONCE_COMMITMENTS = byte(4)
-	MSG_WITHDRAW_BATCH           = byte(5)
-	MSG_UPDATE_ADAPT_SIG         = byte(6)
+	DEPOSIT_CANDIDATE_STORE_KEY = "deposit_candidate"
+	EVIDENCE_STORE_KEY          = "evidence"
+	SIGNING_NONCE_STORE_KEY     = "signing_nonce"
+	USED_NONCE_STORE_KEY        = "used_nonce"
 )
 
 var (
@@ -55,8 +73,6 @@ type ReceivableValidator interface {
 	GetPosition() int64
 	SendMessageOnChain(msg []byte)
 	SendMessageOffChain(msg []byte)
-	ReceiveMessageOnChainLoop()
-	ReceiveMessageOffChainLoop()
 }
 
 // map index will start at 1
@@ -64,23 +80,143 @@ type ReceivableValidator interface {
 type MockValidator struct {
 	ReceivableValidator
 
-	suite               *testhelper.TestSuite
-	logger              *log.Logger
-	file                *os.File
-	keyPair             testhelper.KeyPair
-	position            int64
-	partyNum            int64
-	frost               *testhelper.FrostParticipant
-	otherVals           map[int64]ReceivableValidator
-	dishonestVals       map[int64]bool
-	btcGasFee           int64
+	suite         *testhelper.TestSuite
+	logger        *log.Logger
+	file          *os.File
+	keyPair       testhelper.KeyPair
+	position      int64
+	partyNum      int64
+	frost         *testhelper.FrostParticipant
+	otherVals     map[int64]ReceivableValidator
+	dishonestVals map[int64]bool
+	// fee rate in sat/vbyte
+	btcFeeRate          int64
 	btcCheckpointheight int64
+	// each signature consumes one nonce, signing index is the next unused nonce of the current group key
+	nextSigningIndex int64
+	// limits on how withdrawals are batched into vault transactions
+	batchingPolicy BatchingPolicy
+	// when and how far the nonce pool of this validator is replenished
+	noncePoolPolicy NoncePoolPolicy
+	// recovery leaves that the vault output commits to
+	recoveryPolicy RecoveryPolicy
+	// when withdrawals are paid through a payout tree
+	payoutPolicy PayoutPolicy
+	// rules that withdrawals must pass before being signed
+	withdrawalPolicy WithdrawalPolicy
+	// refuse key ranges that a coalition without more than 2/3 of vp can sign with
+	rejectUnsafeKeys bool
+	// message of the proof of reserves being signed, nil when the checkpoint is signed
+	reservesMessage []byte
+	// latest scanned btc block height, and confirmations needed before attesting a deposit
+	btcTipHeight         int64
+	depositConfirmations int64
+	// btc headers verified by this validator, and confirmations of the previous checkpoint needed before signing the next one
+	headerChain             *testhelper.HeaderChain
+	checkpointConfirmations int64
+	// confirmations after which a reorg can no longer reach a checkpoint, what is kept for its rollback is pruned
+	finalityConfirmations int64
+
+	// vault migration to a new group key when vp changes
+	// the group key state that is not active is kept in standbyGroup
+	migrating    bool
+	standbyGroup *groupKeyState
+	// group key epoch that messages of this validator belong to
+	epoch int64
+
+	// sequence of the last envelope sent by this validator
+	sequence atomic.Uint64
+	// sequences of envelopes received from each source
+	envelopeMu    sync.Mutex
+	seenSequences map[int64]map[uint64]bool
+	// responders of each state root while catching up with peers, nil when not catching up
+	stateSyncVotes map[string]map[int64]bool
 
 	localStorage    MockProtocolStorage
 	protocolStorage MockProtocolStorage
 
-	msgChanOnChain  chan []byte
-	msgChanOffChain chan []byte
+	// all state of this validator is only touched by events of its event loop
+	// messages, requeued envelopes and calls of the test driver are all events
+	mailbox *mailbox
+	// set while the event loop runs, stop cancels it and waits for the loop to return
+	running atomic.Bool
+	stop    context.CancelFunc
+	stopped chan struct{}
+	// messages are delivered by a transport instead of the event loop, such as a simulated network on a virtual clock
+	transport messageTransport
+	// inbound and outbound messages are recorded when a transcript is started
+	transcript *transcriptRecorder
+}
+
+// unbounded queue of events, so that a validator sending to a busy peer never blocks
+// otherwise two validators sending to each other from their event loops deadlock
+type mailbox struct {
+	mu     sync.Mutex
+	events []func()
+	notify chan struct{}
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (m *mailbox) push(event func()) {
+	m.mu.Lock()
+	m.events = append(m.events, event)
+	m.mu.Unlock()
+
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mailbox) drain() []func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := m.events
+	m.events = nil
+
+	return events
+}
+
+// messageTransport delivers messages of validators that do not run event loops
+type messageTransport interface {
+	send(from, to int64, msg []byte, on_chain bool)
+	requeue(posi int64, env *Envelope, on_chain bool, delay time.Duration)
+}
+
+// transportLink carries messages from one validator to a peer through a transport
+type transportLink struct {
+	ReceivableValidator
+
+	transport messageTransport
+	from      int64
+}
+
+func (l *transportLink) SendMessageOnChain(msg []byte) {
+	l.transport.send(l.from, l.GetPosition(), msg, true)
+}
+
+func (l *transportLink) SendMessageOffChain(msg []byte) {
+	l.transport.send(l.from, l.GetPosition(), msg, false)
+}
+
+// validators exchange messages only through the transport
+// they must not run event loops, messages are handled when the transport delivers them
+func joinTransport(transport messageTransport, validators []*MockValidator) {
+	for _, validator := range validators {
+		validator.transport = transport
+		for posi, peer := range validator.otherVals {
+			validator.otherVals[posi] = &transportLink{
+				ReceivableValidator: peer,
+				transport:           transport,
+				from:                validator.position,
+			}
+		}
+	}
 }
 
 func (v *MockValidator) GetPosition() int64 {
@@ -88,176 +224,449 @@ func (v *MockValidator) GetPosition() int64 {
 }
 
 func (v *MockValidator) SendMessageOnChain(msg []byte) {
-	v.msgChanOnChain <- msg
+	if v.transport != nil {
+		v.transport.send(v.position, v.position, msg, true)
+		return
+	}
+	v.mailbox.push(func() {
+		v.handleMessageOnChain(msg)
+	})
 }
 
 func (v *MockValidator) SendMessageOffChain(msg []byte) {
-	v.msgChanOffChain <- msg
+	if v.transport != nil {
+		v.transport.send(v.position, v.position, msg, false)
+		return
+	}
+	v.mailbox.push(func() {
+		v.handleMessageOffChain(msg)
+	})
+}
+
+// an envelope that arrives too early is handled again after delay
+func (v *MockValidator) requeueOnChain(env *Envelope, delay time.Duration) {
+	if v.transport != nil {
+		v.transport.requeue(v.position, env, true, delay)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		v.mailbox.push(func() {
+			v.handleRequeuedEnvelope(env, true)
+		})
+	})
+}
+
+func (v *MockValidator) requeueOffChain(env *Envelope, delay time.Duration) {
+	if v.transport != nil {
+		v.transport.requeue(v.position, env, false, delay)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		v.mailbox.push(func() {
+			v.handleRequeuedEnvelope(env, false)
+		})
+	})
+}
+
+// run the event loop of this validator until ctx is done or the validator is stopped
+func (v *MockValidator) Start(ctx context.Context) {
+	ctx, v.stop = context.WithCancel(ctx)
+	v.stopped = make(chan struct{})
+	v.running.Store(true)
+	go v.eventLoop(ctx)
 }
 
 func (v *MockValidator) Stop() {
-	v.SendMessageOnChain([]byte{MSG_STOP})
-	v.SendMessageOffChain([]byte{MSG_STOP})
+	// there is no event loop to stop with a transport
+	if v.stop != nil {
+		v.stop()
+		<-v.stopped
+	}
+	if v.transcript != nil {
+		v.transcript.Close()
+	}
 	v.file.Close()
 }
 
-// first bytes denote type of message
-// need to make this function thread safe
-func (v *MockValidator) ReceiveMessageOnChainLoop() {
+// events are handled one at a time, so that handlers never run concurrently
+func (v *MockValidator) eventLoop(ctx context.Context) {
+	defer close(v.stopped)
+	defer v.running.Store(false)
+
+	// one timer for the whole loop, restarted after every batch of events
+	// a nil idle channel never fires, so the idle log is off unless -validator.idle is set
+	var idle <-chan time.Time
+	var idle_timer *time.Timer
+	if *validatorIdleLog > 0 {
+		idle_timer = time.NewTimer(*validatorIdleLog)
+		defer idle_timer.Stop()
+		idle = idle_timer.C
+	}
+	resetIdle := func() {
+		if idle_timer == nil {
+			return
+		}
+		if !idle_timer.Stop() {
+			select {
+			case <-idle_timer.C:
+			default:
+			}
+		}
+		idle_timer.Reset(*validatorIdleLog)
+	}
+
 	for {
 		select {
-		case msg := <-v.msgChanOnChain:
-			msgType := msg[0]
-			msgBytes := msg[1:]
-			v.logger.Printf("Received on - chain message type: %d\n", msgType)
-			switch msgType {
-			case MSG_VP_TYPE:
-				msg := &MsgUpdateVP{}
-				err := proto.Unmarshal(msgBytes, msg)
-				assert.NoError(v.suite.T, err)
-				v.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(msg.Source, 10)] = msg.Vp
-			case MSG_PROOFS_TYPE:
-				msg := &MsgUpdateProofs{}
-				err := proto.Unmarshal(msgBytes, msg)
-				assert.NoError(v.suite.T, err)
-				// assert secret proofs
-				secretProofs, err := schnorr.ParseSignature(msg.SecretProofs)
-				assert.NoError(v.suite.T, err)
-				secretCommitments, err := btcec.ParsePubKey(msg.PolynomialCommitments[0])
-				assert.NoError(v.suite.T, err)
-				v.frost.VerifySecretProofs(CONTEXT_HASH, secretProofs, msg.Source, secretCommitments)
-				// store polynomial commitments
-				v.storePolyCommitments(msg.Source, msg.PolynomialCommitments)
-			case MSG_UPDATE_NONCE_COMMITMENTS:
-				msg := &MsgUpdateNonceCommitments{}
-				err := proto.Unmarshal(msgBytes, msg)
-				assert.NoError(v.suite.T, err)
-				v.logger.Printf("received nonce commitments from source: %d, with num of nonces: %d\n", msg.Source, len(msg.NonceCommitments))
-
-				// store nonce commitments
-				for i, nonceCommitment := range msg.NonceCommitments {
-					nonceStructBytes, err := proto.Marshal(nonceCommitment)
-					assert.NoError(v.suite.T, err)
-					v.storeNonceCommitments(msg.Source, int64(i), nonceStructBytes)
-				}
-			case MSG_WITHDRAW_BATCH:
-				msg := &MsgBatchWithdraw{}
-				err := proto.Unmarshal(msgBytes, msg)
-				assert.NoError(v.suite.T, err)
-				// store new transactions on - chain
-				v.storeTxs(msg.WithdrawBatch)
-			case MSG_UPDATE_ADAPT_SIG:
-				// MSG_UPDATE_ADAPT_SIG can be called when all nonces have not yet been added in the previous phase
-				// need to ensure that there are group public nonce commitments before entering this phase
-				signing_index := int64(0)
-				if v.frost.AggrNonceCommitment[signing_index] == nil {
-					v.logger.Println("received MSG_UPDATE_ADAPT_SIG but nil AggrNonceCommitment")
-					go func() {
-						time.Sleep(1000)
-						v.msgChanOnChain <- msg
-					}()
-					break
+		case <-ctx.Done():
+			return
+		case <-v.mailbox.notify:
+			for _, event := range v.mailbox.drain() {
+				if ctx.Err() != nil {
+					return
 				}
+				event()
+			}
+			resetIdle()
+		case <-idle:
+			v.logger.Printf("Validator %d: no new message after %s\n", v.position, *validatorIdleLog)
+			idle_timer.Reset(*validatorIdleLog)
+		}
+	}
+}
 
-				msg := &MsgUpdateAdaptSig{}
-				err := proto.Unmarshal(msgBytes, msg)
-				assert.NoError(v.suite.T, err)
-				enough_honest := v.partyNum - int64(len(v.dishonestVals))
-				// verify adapt sig
-				adapt_sig, err := schnorr.ParseSignature(msg.AdaptSig)
-				assert.NoError(v.suite.T, err)
-				if legit := v.verifyAdaptSig(msg.Source, 0, adapt_sig); !legit {
-					v.logger.Printf("validator %d is dishonest with adapt sig: %v\n", msg.Source, adapt_sig)
-					v.dishonestVals[msg.Source] = true
-					break
-				}
-				// save adapt sig
-				v.storeAdaptSig(0, msg.Source, msg.AdaptSig)
-				// check if enough adapt sigs have been received
-				// if enough, then verifiy and signal transaction ready to be broadcasted
-				if v.isEnoughAdaptSig(0, enough_honest) {
-					v.handleFinalizeTransaction(signing_index)
-				}
-			case MSG_STOP:
-				return
-			default:
-				v.logger.Printf("Unknown message type: %d\n", msgType)
+// run fn as an event of this validator, and wait until it has been handled
+// fn runs right away if there is no event loop, e.g. when a transport delivers messages on the caller goroutine
+// it must not be called from an event, that would wait for itself
+func (v *MockValidator) Do(fn func()) {
+	if !v.running.Load() {
+		fn()
+		return
+	}
+
+	done := make(chan struct{})
+	v.mailbox.push(func() {
+		defer close(done)
+		fn()
+	})
+	select {
+	case <-done:
+	case <-v.stopped:
+	}
+}
+
+// read state of a validator from the test driver
+func query[T any](v *MockValidator, fn func() T) T {
+	var result T
+	v.Do(func() {
+		result = fn()
+	})
+
+	return result
+}
+
+// messages are envelopes, rejected unless signed by their source
+func (v *MockValidator) handleMessageOnChain(msg []byte) {
+	v.transcribe(TRANSCRIPT_IN, msg, true, func() {
+		env, err := v.openEnvelope(msg)
+		if err != nil {
+			v.logger.Printf("Rejected on - chain message: %v\n", err)
+			return
+		}
+		v.handleEnvelopeOnChain(env)
+	})
+}
+
+func (v *MockValidator) handleEnvelopeOnChain(env *Envelope) {
+	v.logger.Printf("Received on - chain message type: %s, from source: %d\n", envelopeType(env), env.Source)
+	if !v.isCurrentEpoch(env, true) {
+		return
+	}
+	switch payload := env.Payload.(type) {
+	case *Envelope_UpdateVp:
+		msg := payload.UpdateVp
+		v.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(msg.Source, 10)] = msg.Vp
+	case *Envelope_UpdateProofs:
+		msg := payload.UpdateProofs
+		// assert secret proofs
+		secretProofs, err := schnorr.ParseSignature(msg.SecretProofs)
+		assert.NoError(v.suite.T, err)
+		secretCommitments, err := btcec.ParsePubKey(msg.PolynomialCommitments[0])
+		assert.NoError(v.suite.T, err)
+		v.frost.VerifySecretProofs(CONTEXT_HASH, secretProofs, msg.Source, secretCommitments)
+		// store polynomial commitments
+		v.storePolyCommitments(msg.Source, msg.PolynomialCommitments)
+		// the envelope is kept, so that the source cannot deny its polynomial commitments
+		v.storeProofsEnvelope(env)
+	case *Envelope_UpdateNonceCommitments:
+		msg := payload.UpdateNonceCommitments
+		v.logger.Printf("received nonce commitments from source: %d, with num of nonces: %d\n", msg.Source, len(msg.NonceCommitments))
+		if _, ok := v.dishonestVals[msg.Source]; ok {
+			break
+		}
+
+		// a reused nonce leaks the signing shares, so a validator that sends duplicated nonces is dishonest
+		if hasDuplicatedNonces(msg.NonceCommitments) {
+			v.excludeValidator(msg.Source, "duplicated nonce commitments")
+			break
+		}
+
+		// store nonce commitments
+		// replenished nonce commitments follow the ones already sent, a consumed nonce is never replaced
+		for i, nonceCommitment := range msg.NonceCommitments {
+			signing_index := msg.StartIndex + int64(i)
+			if v.isPeerNonceConsumed(msg.Source, signing_index) {
+				v.logger.Printf("drop nonce commitments from source %d for consumed signing index %d\n", msg.Source, signing_index)
+				continue
 			}
-		case <-time.After(3000 * time.Millisecond):
-			v.logger.Printf("Validator %d: no new on - chain message after 3s\n", v.position)
+			nonceStructBytes, err := proto.Marshal(nonceCommitment)
+			assert.NoError(v.suite.T, err)
+			v.storeNonceCommitments(msg.Source, signing_index, nonceStructBytes)
 		}
+		v.storeNonceEnvelope(env)
+	case *Envelope_BatchWithdraw:
+		v.handleWithdrawBatch(payload.BatchWithdraw)
+	case *Envelope_UpdateAdaptSig:
+		msgStruct := payload.UpdateAdaptSig
+
+		if _, ok := v.dishonestVals[msgStruct.Source]; ok {
+			v.logger.Printf("drop adapt sig from dishonest source %d\n", msgStruct.Source)
+			break
+		}
+
+		// adapt sig can arrive from a validator that has already moved to the next checkpoint
+		// keep it until this validator finalizes the current checkpoint
+		if msgStruct.CheckpointHeight > v.btcCheckpointheight {
+			v.logger.Printf("received adapt sig for checkpoint %d while at checkpoint %d\n", msgStruct.CheckpointHeight, v.btcCheckpointheight)
+			v.requeueOnChain(env, 1000*time.Millisecond)
+			break
+		}
+
+		// checkpoint has already been finalized, late adapt sig is no longer needed
+		// unless it conflicts with the adapt sig that finalized the checkpoint
+		if msgStruct.CheckpointHeight < v.btcCheckpointheight {
+			if v.isEquivocatedAdaptSig(msgStruct.SigningIndex, msgStruct.Source, msgStruct.AdaptSig) {
+				v.excludeValidator(msgStruct.Source, "equivocating adapt sigs")
+				break
+			}
+			v.logger.Printf("drop adapt sig from source %d for finalized checkpoint %d\n", msgStruct.Source, msgStruct.CheckpointHeight)
+			break
+		}
+
+		// each input of each vault transaction of the checkpoint, or of the proof of reserves, is signed with its own nonces
+		vault_txs := v.signingTxs(msgStruct.ReservesMessage)
+		if msgStruct.TxIndex < 0 || msgStruct.TxIndex >= int64(len(vault_txs)) ||
+			msgStruct.InputIndex < 0 || msgStruct.InputIndex >= int64(len(vault_txs[msgStruct.TxIndex].sigHashes)) {
+			v.excludeValidator(msgStruct.Source, fmt.Sprintf("adapt sig for unknown tx index %d, input index %d", msgStruct.TxIndex, msgStruct.InputIndex))
+			break
+		}
+		vault_tx := vault_txs[msgStruct.TxIndex]
+		signing_index := v.nextSigningIndex + vault_tx.sessionOffset + msgStruct.InputIndex
+
+		// signing session is aborted when a validator is excluded, and signed again with new nonces
+		// adapt sig of an aborted session is no longer needed, adapt sig of a next session is kept until this validator aborts too
+		if msgStruct.SigningIndex < signing_index {
+			v.logger.Printf("drop adapt sig from source %d for aborted signing index %d\n", msgStruct.Source, msgStruct.SigningIndex)
+			break
+		}
+		if msgStruct.SigningIndex > signing_index {
+			v.requeueOnChain(env, 1000*time.Millisecond)
+			break
+		}
+
+		// a checkpoint and a proof of reserves are never signed in the same signing session
+		// the first adapt sig of a session that this validator has not signed yet decides what it signs
+		if !bytes.Equal(msgStruct.ReservesMessage, v.reservesMessage) {
+			if v.hasStartedSigningSession() {
+				v.logger.Printf("drop adapt sig from source %d for another signing session at signing index %d\n", msgStruct.Source, signing_index)
+				break
+			}
+			v.reservesMessage = msgStruct.ReservesMessage
+		}
+
+		// adapt sig can be received when all nonces have not yet been added in the previous phase
+		// need to ensure that there are group public nonce commitments before entering this phase
+		if v.frost.AggrNonceCommitment[signing_index] == nil {
+			v.logger.Println("received adapt sig but nil AggrNonceCommitment")
+			v.requeueOnChain(env, 1000*time.Millisecond)
+			break
+		}
+
+		// a validator can only send one adapt sig for a signing session
+		if v.isEquivocatedAdaptSig(signing_index, msgStruct.Source, msgStruct.AdaptSig) {
+			v.excludeValidator(msgStruct.Source, "equivocating adapt sigs")
+			break
+		}
+
+		// verify adapt sig
+		adapt_sig, err := schnorr.ParseSignature(msgStruct.AdaptSig)
+		if err != nil {
+			v.recordEvidence(v.newInvalidAdaptSigEvidence(env))
+			v.excludeValidator(msgStruct.Source, fmt.Sprintf("malformed adapt sig: %v", err))
+			break
+		}
+		if legit := v.verifyAdaptSig(msgStruct.Source, signing_index, vault_tx.sigHashes[msgStruct.InputIndex], adapt_sig); !legit {
+			v.recordEvidence(v.newInvalidAdaptSigEvidence(env))
+			v.excludeValidator(msgStruct.Source, fmt.Sprintf("invalid adapt sig: %v", adapt_sig))
+			break
+		}
+		// save adapt sig
+		v.storeAdaptSig(signing_index, msgStruct.Source, msgStruct.AdaptSig)
+		v.markPeerNonceConsumed(msgStruct.Source, signing_index)
+		// check if enough adapt sigs have been received for all inputs of all vault transactions
+		// if enough, then verifiy and signal transactions ready to be broadcasted
+		enough_honest := v.partyNum - int64(len(v.dishonestVals))
+		enough := true
+		for i := int64(0); i < signingSessionsNum(vault_txs); i++ {
+			enough = enough && v.isEnoughAdaptSig(v.nextSigningIndex+i, enough_honest)
+		}
+		if enough {
+			if len(msgStruct.ReservesMessage) > 0 {
+				v.handleFinalizeReservesProof(msgStruct.ReservesMessage, vault_txs)
+				break
+			}
+			v.handleFinalizeTransaction(vault_txs)
+		}
+	case *Envelope_DepositAttest:
+		msg := payload.DepositAttest
+		for _, deposit := range msg.Deposits {
+			v.handleDepositAttest(msg.Source, deposit)
+		}
+	case *Envelope_PauseVote:
+		v.handlePauseVote(payload.PauseVote)
+	default:
+		v.logger.Printf("Unexpected on - chain message type: %s\n", envelopeType(env))
 	}
 }
 
-func (v *MockValidator) ReceiveMessageOffChainLoop() {
-	for {
-		select {
-		case msg := <-v.msgChanOffChain:
-			msgType := msg[0]
-			msgBytes := msg[1:]
-			v.logger.Printf("Received off - chain message type: %d\n", msgType)
-			switch msgType {
-			case MSG_SECRET_SHARES:
-				msgStruct := &MsgSecretShares{}
-				err := proto.Unmarshal(msgBytes, msgStruct)
-				assert.NoError(v.suite.T, err)
-				v.logger.Printf("received msg from source: %d, with num of keys: %d\n", msgStruct.Source, len(msgStruct.SecretShares))
-
-				// there is a case where a validator has not yet constructed its key range, but received msg too soon
-				if !v.protocolStorage.CheckKeyRangeExist(strconv.FormatInt(v.position, 10)) {
-					v.logger.Printf("Key range has not been set for validator %d\n", v.position)
-					go func() {
-						time.Sleep(1000 * time.Millisecond)
-						v.msgChanOffChain <- msg
-					}()
-					break
-				}
+func (v *MockValidator) handleMessageOffChain(msg []byte) {
+	v.transcribe(TRANSCRIPT_IN, msg, false, func() {
+		env, err := v.openEnvelope(msg)
+		if err != nil {
+			v.logger.Printf("Rejected off - chain message: %v\n", err)
+			return
+		}
+		v.handleEnvelopeOffChain(env)
+	})
+}
 
-				for _, secretShare := range msgStruct.SecretShares {
-					// TODO: figure out what to do if received secret share is not in range
-					if !v.protocolStorage.IsKeyInRange(strconv.FormatInt(v.position, 10), secretShare.Posi) {
-						v.logger.Printf("Secret share %d is not in range for validator %d, from source: %d, expected: %v\n", secretShare.Posi, v.position, msgStruct.Source, v.protocolStorage.GetKeyRange(strconv.FormatInt(v.position, 10)))
-						continue
-					}
+// an envelope that arrived too early has already been opened, it is handled again without being opened twice
+func (v *MockValidator) handleRequeuedEnvelope(env *Envelope, on_chain bool) {
+	// requeued envelopes are polled until they can be handled, they are only marshalled for a transcript
+	var envBytes []byte
+	if v.transcript != nil {
+		var err error
+		envBytes, err = proto.Marshal(env)
+		assert.NoError(v.suite.T, err)
+	}
+	v.transcribe(TRANSCRIPT_REQUEUE, envBytes, on_chain, func() {
+		if on_chain {
+			v.handleEnvelopeOnChain(env)
+			return
+		}
+		v.handleEnvelopeOffChain(env)
+	})
+}
 
-					// persist secret shares
-					v.localStorage.SetSecretShares(msgStruct.Source, secretShare.Posi, secretShare.SecretShares)
-				}
+func (v *MockValidator) handleEnvelopeOffChain(env *Envelope) {
+	v.logger.Printf("Received off - chain message type: %s, from source: %d\n", envelopeType(env), env.Source)
+	if !v.isCurrentEpoch(env, false) {
+		return
+	}
+	switch payload := env.Payload.(type) {
+	case *Envelope_SecretShares:
+		msgStruct := payload.SecretShares
+		v.logger.Printf("received msg from source: %d, with num of keys: %d\n", msgStruct.Source, len(msgStruct.SecretShares))
+
+		// there is a case where a validator has not yet constructed its key range, but received msg too soon
+		if !v.protocolStorage.CheckKeyRangeExist(strconv.FormatInt(v.position, 10)) {
+			v.logger.Printf("Key range has not been set for validator %d\n", v.position)
+			v.requeueOffChain(env, 1000*time.Millisecond)
+			break
+		}
 
-				// check if this validator has received all secret shares
-				// range_key * partyNum
-				range_key := v.protocolStorage.GetKeyRange(strconv.FormatInt(v.position, 10))
-				total_keys := (range_key[1] - range_key[0]) * v.partyNum
-				accumulated_keys := int64(len(v.localStorage.store[SECRET_SHARES_STORE_KEY]))
-				v.logger.Printf("validator %d needs more keys %d\n", v.position, total_keys-accumulated_keys)
-				if accumulated_keys == total_keys && v.frost.GroupPublicKey == nil {
-					v.logger.Printf("All secret shares have been received for validator %d\n", v.position)
-					v.verifySharesAndCalculateLongTermKey()
-				}
+		if _, ok := v.dishonestVals[msgStruct.Source]; ok {
+			break
+		}
 
-				// TODO: what will happen if never receive enough secret shares
-			case MSG_STOP:
-				return
-			default:
-				v.logger.Printf("Unknown message type: %d\n", msgType)
+		// secret shares are verified against polynomial commitments of the source
+		// the long term key also needs them, even from a source without keys for this validator
+		if v.getPolyCommitments(msgStruct.Source) == nil {
+			v.logger.Printf("Polynomial commitments of validator %d have not been received\n", msgStruct.Source)
+			v.requeueOffChain(env, 1000*time.Millisecond)
+			break
+		}
+
+		if key, ok := v.findInvalidSecretShare(msgStruct); ok {
+			v.recordEvidence(v.newInvalidSecretShareEvidence(env, key))
+			v.excludeValidator(msgStruct.Source, "secret shares do not match polynomial commitments")
+			break
+		}
+
+		for _, secretShare := range msgStruct.SecretShares {
+			// TODO: figure out what to do if received secret share is not in range
+			if !v.protocolStorage.IsKeyInRange(strconv.FormatInt(v.position, 10), secretShare.Posi) {
+				v.logger.Printf("Secret share %d is not in range for validator %d, from source: %d, expected: %v\n", secretShare.Posi, v.position, msgStruct.Source, v.protocolStorage.GetKeyRange(strconv.FormatInt(v.position, 10)))
+				continue
 			}
-		case <-time.After(3000 * time.Millisecond):
-			v.logger.Printf("Validator %d: no new off - chain message after 3s\n", v.position)
+
+			// persist secret shares
+			v.localStorage.SetSecretShares(msgStruct.Source, secretShare.Posi, secretShare.SecretShares)
+		}
+
+		// check if this validator has received all secret shares
+		// range_key * partyNum
+		range_key := v.protocolStorage.GetKeyRange(strconv.FormatInt(v.position, 10))
+		total_keys := (range_key[1] - range_key[0]) * v.partyNum
+		accumulated_keys := int64(len(v.localStorage.store[SECRET_SHARES_STORE_KEY]))
+		v.logger.Printf("validator %d needs more keys %d\n", v.position, total_keys-accumulated_keys)
+		if accumulated_keys == total_keys && v.hasAllPolyCommitments() && v.frost.GroupPublicKey == nil {
+			v.logger.Printf("All secret shares have been received for validator %d\n", v.position)
+			v.verifySharesAndCalculateLongTermKey()
 		}
+
+		// TODO: what will happen if never receive enough secret shares
+	case *Envelope_StateRequest:
+		v.handleStateRequest(payload.StateRequest)
+	case *Envelope_StateResponse:
+		v.handleStateResponse(payload.StateResponse)
+	default:
+		v.logger.Printf("Unexpected off - chain message type: %s\n", envelopeType(env))
 	}
 }
 
+// store new transactions on - chain
+func (v *MockValidator) handleWithdrawBatch(msg *MsgBatchWithdraw) {
+	v.storeTxs(msg.Sequence, v.admitWithdrawals(msg))
+}
+
+// an envelope of a later epoch is kept until this validator begins that epoch
+// an envelope of an earlier epoch is dropped
+func (v *MockValidator) isCurrentEpoch(env *Envelope, on_chain bool) bool {
+	if env.Epoch < v.epoch {
+		v.logger.Printf("drop %s message from source %d of epoch %d while at epoch %d\n", envelopeType(env), env.Source, env.Epoch, v.epoch)
+		return false
+	}
+	if env.Epoch > v.epoch {
+		if on_chain {
+			v.requeueOnChain(env, 1000*time.Millisecond)
+		} else {
+			v.requeueOffChain(env, 1000*time.Millisecond)
+		}
+		return false
+	}
+
+	return true
+}
+
 func (v *MockValidator) SendVPToAll() {
 	vp_bytes := v.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(v.position, 10)]
 	msg := MsgUpdateVP{
 		Source: v.position,
 		Vp:     vp_bytes,
 	}
-	msgBytes, err := proto.Marshal(&msg)
-	assert.NoError(v.suite.T, err)
-
+	envBytes := v.sealEnvelope(&Envelope_UpdateVp{UpdateVp: &msg})
 	for _, otherVal := range v.otherVals {
-		otherVal.SendMessageOnChain(append([]byte{MSG_VP_TYPE}, msgBytes...))
+		otherVal.SendMessageOnChain(envBytes)
 	}
 }
 
@@ -279,12 +688,13 @@ func (v *MockValidator) DeriveAndSendProofs() {
 		SecretProofs:          secret.Serialize(),
 		PolynomialCommitments: polynomialCommitmentsBytes,
 	}
-	msgBytes, err := proto.Marshal(&msg)
-	assert.NoError(v.suite.T, err)
-
+	envBytes := v.sealEnvelope(&Envelope_UpdateProofs{UpdateProofs: &msg})
 	for _, otherVal := range v.otherVals {
-		otherVal.SendMessageOnChain(append([]byte{MSG_PROOFS_TYPE}, msgBytes...))
+		otherVal.SendMessageOnChain(envBytes)
 	}
+
+	// self - sending so that polynomial commitments of this validator are committed on - chain, even without other validators
+	v.SendMessageOnChain(envBytes)
 }
 
 func (v *MockValidator) DeriveAndSendSecretShares() {
@@ -295,7 +705,11 @@ func (v *MockValidator) DeriveAndSendSecretShares() {
 
 	// determine how many keys to send to other validators
 	// based on the latest vp
-	range_keys := v.DeriveRangeOfKeys()
+	range_keys, err := v.DeriveRangeOfKeys()
+	if err != nil {
+		assert.NoError(v.suite.T, err)
+		return
+	}
 
 	// save range of keys
 	for i, range_key := range range_keys {
@@ -304,6 +718,9 @@ func (v *MockValidator) DeriveAndSendSecretShares() {
 
 	v.logger.Printf("Range of keys for validator %d: %v\n", v.position, range_keys)
 
+	// remember vp that the range of keys is derived from
+	v.snapshotGroupVP()
+
 	// save this validator secret shares
 	for i := range_keys[v.position][0]; i < range_keys[v.position][1]; i++ {
 		secretShare := v.frost.GetSecretShares(i)
@@ -312,13 +729,14 @@ func (v *MockValidator) DeriveAndSendSecretShares() {
 	}
 
 	// send secret shares to all other validators
-	for i, range_key := range range_keys {
+	// in order of position, so that envelope sequences do not depend on map iteration
+	for i := int64(1); i <= v.partyNum; i++ {
 		if i == v.position {
 			continue
 		}
 
-		start := range_key[0]
-		end := range_key[1]
+		start := range_keys[i][0]
+		end := range_keys[i][1]
 		secretShares := make([]*SecretShares, 0)
 		for j := start; j < end; j++ {
 			secretShare := v.frost.GetSecretShares(j)
@@ -335,10 +753,7 @@ func (v *MockValidator) DeriveAndSendSecretShares() {
 			Source:       v.position,
 			SecretShares: secretShares,
 		}
-		secretShareMsgBytes, err := proto.Marshal(&secretShareMsg)
-		assert.NoError(v.suite.T, err)
-
-		v.otherVals[i].SendMessageOffChain(append([]byte{MSG_SECRET_SHARES}, secretShareMsgBytes...))
+		v.otherVals[i].SendMessageOffChain(v.sealEnvelope(&Envelope_SecretShares{SecretShares: &secretShareMsg}))
 	}
 
 	// self - sending in case this validator has collected all secret shares before finishing itself thus unable to activate the check
@@ -347,51 +762,46 @@ func (v *MockValidator) DeriveAndSendSecretShares() {
 		Source:       v.position,
 		SecretShares: make([]*SecretShares, 0),
 	}
-	secretShareMsgBytes, err := proto.Marshal(&secretShareMsg)
-	assert.NoError(v.suite.T, err)
-	v.SendMessageOffChain(append([]byte{MSG_SECRET_SHARES}, secretShareMsgBytes...))
+	v.SendMessageOffChain(v.sealEnvelope(&Envelope_SecretShares{SecretShares: &secretShareMsg}))
 }
 
-func (v *MockValidator) DeriveAndSendNonces() {
+// signing_num is the number of checkpoints that these nonces can sign for
+// a new nonce pool, nonces of the previous pool that have not been used are dropped
+func (v *MockValidator) DeriveAndSendNonces(signing_num int64) {
 	// calculate nonce commitments
 	// send nonce commitments to all other validators
-	nonceCommitments := v.frost.GenerateSigningNonces(1)
-
-	// store this validator nonce commitments
-	nonceCommitmentsArr := make([]*NonceCommitments, len(nonceCommitments))
-	for i, nonceCommitment := range nonceCommitments {
-		nonceStruct := &NonceCommitments{
-			D: nonceCommitment[0].SerializeCompressed(),
-			E: nonceCommitment[1].SerializeCompressed(),
-		}
-		nonceCommitmentsArr[i] = nonceStruct
-		nonceStructBytes, err := proto.Marshal(nonceStruct)
-		assert.NoError(v.suite.T, err)
+	nonceCommitments := v.frost.GenerateSigningNonces(signing_num)
+	v.localStorage.store[SIGNING_NONCE_STORE_KEY] = make(map[string][]byte)
+	v.sendNonceCommitments(0, nonceCommitments)
+}
 
-		v.storeNonceCommitments(v.position, int64(i), nonceStructBytes)
-		_, err = v.getNonceCommitments(v.position, int64(i))
-		assert.NoError(v.suite.T, err)
+func (v *MockValidator) DeriveTxAndSign() error {
+	// vault migration can only be signed once the new group key is ready
+	if v.migrating && !v.isMigrationReady() {
+		return fmt.Errorf("validator %d: new group key is not ready for vault migration", v.position)
 	}
 
-	// send to all other validators
-	for _, otherVal := range v.otherVals {
-		msg := MsgUpdateNonceCommitments{
-			Source:           v.position,
-			NonceCommitments: nonceCommitmentsArr,
-		}
-
-		msgBytes, err := proto.Marshal(&msg)
-		assert.NoError(v.suite.T, err)
+	// a proof of reserves takes the signing sessions of the checkpoint until it is finalized
+	if len(v.reservesMessage) > 0 {
+		return fmt.Errorf("validator %d: proof of reserves is being signed", v.position)
+	}
 
-		otherVal.SendMessageOnChain(append([]byte{MSG_UPDATE_NONCE_COMMITMENTS}, msgBytes...))
+	if err := v.checkPrevCheckPointConfirmed(); err != nil {
+		return err
 	}
-}
 
-func (v *MockValidator) DeriveTxAndSign() error {
 	// derive bitcoin transactions
-	hType := txscript.SigHashDefault
-	sigHash, _ := v.handleTxs(hType)
+	vault_txs := v.handleTxs(txscript.SigHashDefault)
+	if len(vault_txs) == 0 {
+		return fmt.Errorf("validator %d: vault balance cannot cover fees, checkpoint is deferred", v.position)
+	}
+
+	return v.signVaultTxs(vault_txs, nil)
+}
 
+// sign each input of each vault transaction with its own nonces, and send adapt sigs to all validators
+// reserves message is set when the vault transactions are a proof of reserves over it
+func (v *MockValidator) signVaultTxs(vault_txs []*vaultTx, reserves_message []byte) error {
 	// derive honest validators
 	honest := make([]int64, 0)
 	honest_keys := make([]int64, 0)
@@ -405,50 +815,85 @@ func (v *MockValidator) DeriveTxAndSign() error {
 		}
 	}
 
-	// derive public nonce commitments
-	signing_index := int64(0)
-	public_nonces := make(map[int64][2]*btcec.PublicKey)
-	for _, i := range honest {
-		nonceCommitments, err := v.getNonceCommitments(i, signing_index)
-		if err != nil {
-			return err
-		}
-
-		public_nonces[i] = nonceCommitments
-	}
-
-	public_nonce_commitments := v.frost.CalculatePublicNonceCommitments(signing_index, honest, sigHash, public_nonces)
-
-	// derive signature adaptors
 	key_range := v.protocolStorage.GetKeyRange(strconv.FormatInt(v.position, 10))
 	signing_shares := make(map[int64]*btcec.ModNScalar)
 	for i := key_range[0]; i < key_range[1]; i++ {
 		signing_shares[i] = v.GetLongTermSecretShares(i)
 	}
 
-	adapt_sig := v.frost.WeightedPartialSign(v.position, signing_index, honest, honest_keys, sigHash, public_nonces, signing_shares)
-
-	// self - verified
-	if legit := v.verifyAdaptSig(v.position, signing_index, adapt_sig); !legit {
-		v.logger.Printf("self - verification failed")
-		return fmt.Errorf("validator %d: self - verification failed", v.position)
+	// nonce commitments of all inputs must have been received before any nonce is used
+	// otherwise a retry after missing nonce commitments finds nonces of earlier inputs already used
+	for _, vault_tx := range vault_txs {
+		for input_index := range vault_tx.sigHashes {
+			signing_index := v.nextSigningIndex + vault_tx.sessionOffset + int64(input_index)
+			for _, i := range honest {
+				if _, err := v.getNonceCommitments(i, signing_index); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
-	// store public nonce commitments and adapt sig
-	v.storePublicNonceCommitments(signing_index, public_nonce_commitments)
-	v.storeAdaptSig(signing_index, v.position, adapt_sig.Serialize())
+	// each input of each vault transaction is signed with its own nonces
+	msgs := make([][]byte, 0, signingSessionsNum(vault_txs))
+	for tx_index, vault_tx := range vault_txs {
+		for input_index, sigHash := range vault_tx.sigHashes {
+			// derive public nonce commitments
+			signing_index := v.nextSigningIndex + vault_tx.sessionOffset + int64(input_index)
+			public_nonces := make(map[int64][2]*btcec.PublicKey)
+			for _, i := range honest {
+				nonceCommitments, err := v.getNonceCommitments(i, signing_index)
+				if err != nil {
+					return err
+				}
+
+				public_nonces[i] = nonceCommitments
+			}
 
-	// send adapt sig to all other validators
-	for _, otherVal := range v.otherVals {
-		msg := MsgUpdateAdaptSig{
-			Source:   v.position,
-			AdaptSig: adapt_sig.Serialize(),
+			public_nonce_commitments := v.frost.CalculatePublicNonceCommitments(signing_index, honest, sigHash, public_nonces)
+
+			// nonce is marked as used before it signs, so that it never signs twice even across restarts
+			if err := v.useNonce(signing_index); err != nil {
+				return err
+			}
+
+			// derive signature adaptors
+			adapt_sig := v.frost.WeightedPartialSign(v.position, signing_index, honest, honest_keys, sigHash, public_nonces, signing_shares)
+			v.eraseNonce(signing_index)
+
+			// self - verified
+			if legit := v.verifyAdaptSig(v.position, signing_index, sigHash, adapt_sig); !legit {
+				v.logger.Printf("self - verification failed")
+				return fmt.Errorf("validator %d: self - verification failed", v.position)
+			}
+
+			// store public nonce commitments
+			v.storePublicNonceCommitments(signing_index, public_nonce_commitments)
+
+			msg := MsgUpdateAdaptSig{
+				Source:           v.position,
+				AdaptSig:         adapt_sig.Serialize(),
+				CheckpointHeight: v.btcCheckpointheight,
+				TxIndex:          int64(tx_index),
+				InputIndex:       int64(input_index),
+				SigningIndex:     signing_index,
+				SigHash:          sigHash[:],
+				Signers:          honest,
+				ReservesMessage:  reserves_message,
+			}
+			msgs = append(msgs, v.sealEnvelope(&Envelope_UpdateAdaptSig{UpdateAdaptSig: &msg}))
 		}
+	}
 
-		msgBytes, err := proto.Marshal(&msg)
-		assert.NoError(v.suite.T, err)
+	for _, envBytes := range msgs {
+		// send adapt sig to all other validators
+		for _, otherVal := range v.otherVals {
+			otherVal.SendMessageOnChain(envBytes)
+		}
 
-		otherVal.SendMessageOnChain(append([]byte{MSG_UPDATE_ADAPT_SIG}, msgBytes...))
+		// self - sending so that the adapt sig of this validator is stored in the same loop as others
+		// else the last received adapt sig might not see enough adapt sigs to finalize the transaction
+		v.SendMessageOnChain(envBytes)
 	}
 
 	return nil
@@ -456,57 +901,36 @@ func (v *MockValidator) DeriveTxAndSign() error {
 
 // determine how many keys a validator will produce
 // based on the latest vp
-func (v *MockValidator) DeriveRangeOfKeys() map[int64][2]int64 {
-	party_keys := make(map[int64]int64)
-	// include this validator party
-	total := int64(0)
-
-	// temporarily add this validator to otherVals for complete iteration over all validators
-	v.otherVals[v.position] = v
-	// each validator will produce an amount of keys based on their VP
-	for _, other := range v.otherVals {
-		if other.GetPosition() == 1 {
-			continue
-		}
-		vp_bytes := v.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(other.GetPosition(), 10)]
-		vp := bytesToVp(v.suite, vp_bytes)
-		expected_keys := vp.MulInt64(int64(v.frost.N)).RoundInt().Int64()
-		// expected keys cannot be 0
-		if expected_keys == 0 {
-			expected_keys += 1
-		}
-		party_keys[other.GetPosition()] = expected_keys
-		total += expected_keys
+func (v *MockValidator) DeriveRangeOfKeys() (map[int64][2]int64, error) {
+	allocation, coalition, err := v.KeyAllocation()
+	if err != nil {
+		return nil, err
 	}
-	party_keys[1] = v.frost.N - total
-	if party_keys[1] < 0 {
-		panic("party 1 has negative amount of keys")
+	if coalition != nil {
+		if v.rejectUnsafeKeys {
+			return nil, fmt.Errorf("validators %v can sign without more than 2/3 of vp", coalition)
+		}
+		v.logger.Printf("validators %v can sign without more than 2/3 of vp\n", coalition)
 	}
 
-	// remove temporary add
-	delete(v.otherVals, v.position)
-
 	// derive range of keys for each party
 	range_keys := make(map[int64][2]int64)
 	start := int64(1)
 	for i := int64(1); i <= v.partyNum; i++ {
-		end := start + party_keys[i]
+		end := start + allocation.Seats[i-1]
 		range_keys[i] = [2]int64{start, end}
 		start = end
 	}
 	assert.Equal(v.suite.T, v.partyNum, int64(len(range_keys)))
 
-	return range_keys
+	return range_keys, nil
 }
 
-func (v *MockValidator) verifyAdaptSig(posi, signing_index int64, adapt_sig *schnorr.Signature) bool {
+func (v *MockValidator) verifyAdaptSig(posi, signing_index int64, sigHash [32]byte, adapt_sig *schnorr.Signature) bool {
 	// verify adapt sig
 	// adapt sig is verified by all validators
 	// if all validators agree, then the transaction is ready to be broadcasted
 	// if not, then the transaction is invalid
-	hType := txscript.SigHashDefault
-	sigHash, _ := v.handleTxs(hType)
-
 	honest_keys := make([]int64, 0)
 	for i := int64(1); i <= v.partyNum; i++ {
 		if _, ok := v.dishonestVals[i]; !ok {
@@ -531,26 +955,26 @@ func (v *MockValidator) verifySharesAndCalculateLongTermKey() {
 	time_now := time.Now()
 	key_range := v.protocolStorage.GetKeyRange(strconv.FormatInt(v.position, 10))
 
-	v.frost.DerivePowerMap()
-
+	// shares were already verified one by one against the poly commitments of their source on receipt
+	// secret shares are read before keys are calculated in parallel, and long term shares are stored after
+	all_secret_shares := make(map[int64]map[int64]*btcec.ModNScalar)
+	for i := key_range[0]; i < key_range[1]; i++ {
+		all_secret_shares[i] = make(map[int64]*btcec.ModNScalar)
+		for j := int64(1); j <= v.partyNum; j++ {
+			all_secret_shares[i][j] = v.localStorage.GetSecretShares(j, i)
+		}
+	}
+	long_term_shares := make([]*btcec.ModNScalar, key_range[1]-key_range[0])
 	var wg sync.WaitGroup
 	for i := key_range[0]; i < key_range[1]; i++ {
 		wg.Add(1)
 		go func(i int64) {
-			all_secret_shares := make(map[int64]*btcec.ModNScalar)
-			for j := int64(1); j <= v.partyNum; j++ {
-				all_secret_shares[j] = v.localStorage.GetSecretShares(j, i)
-			}
-
-			v.frost.VerifyBatchPublicSecretShares(all_secret_shares, uint32(i))
-
 			longTermShares := new(btcec.ModNScalar)
 			longTermShares.SetInt(0)
 			for j := int64(1); j <= v.partyNum; j++ {
-				longTermShares.Add(all_secret_shares[j])
+				longTermShares.Add(all_secret_shares[i][j])
 			}
-			longTermSharesBytes := longTermShares.Bytes()
-			v.SetLongTermSecretShares(i, longTermSharesBytes[:])
+			long_term_shares[i-key_range[0]] = longTermShares
 
 			// calculate public signing shares
 			key := v.frost.CalculateInternalPublicSigningShares(longTermShares, i)
@@ -559,10 +983,15 @@ func (v *MockValidator) verifySharesAndCalculateLongTermKey() {
 		}(i)
 	}
 	wg.Wait()
+	for i := key_range[0]; i < key_range[1]; i++ {
+		longTermSharesBytes := long_term_shares[i-key_range[0]].Bytes()
+		v.SetLongTermSecretShares(i, longTermSharesBytes[:])
+	}
 	v.logger.Printf("Time to verify secret shares: %v\n", time.Since(time_now))
 
 	// calculate public signing shares of all others
 	time_now = time.Now()
+	aggr_poly_commitments := v.frost.AggregatePolyCommitments(v.partyNum)
 	for i := int64(1); i <= v.partyNum; i++ {
 		if i == v.position {
 			continue
@@ -572,7 +1001,7 @@ func (v *MockValidator) verifySharesAndCalculateLongTermKey() {
 		for j := key_range[0]; j < key_range[1]; j++ {
 			wg.Add(1)
 			go func(j int64) {
-				key := v.frost.CalculatePublicSigningShares(v.partyNum, j)
+				key := v.frost.CalculatePublicSigningSharesFromAggr(aggr_poly_commitments, j)
 				v.logger.Printf("for validator %d, key %d, long term key: %v\n", i, j, key)
 				wg.Done()
 			}(j)
@@ -584,14 +1013,38 @@ func (v *MockValidator) verifySharesAndCalculateLongTermKey() {
 	// calculate group public key
 	groupkey := v.frost.CalculateGroupPublicKey()
 	v.logger.Printf("group public key: %v\n", groupkey)
+
+	// new group key is ready, old group key comes back to sign the vault migration
+	if v.migrating {
+		v.standbyGroup = v.swapGroupKeyState(v.standbyGroup)
+	}
 }
 
-// txs will affect this network next inputs, and outputs
-// there should be one transaction for each check point
-// store it in the protocol storage for future sign
-//
-// return sighash for further signing process
-func (v *MockValidator) handleTxs(hType txscript.SigHashType) ([32]byte, *wire.MsgTx) {
+// a vault transaction of the current checkpoint
+// together with the outputs it spends and the sighash of each input for signing
+type vaultTx struct {
+	tx        *wire.MsgTx
+	prevOuts  []*wire.TxOut
+	sigHashes [][32]byte
+	// signing index of the first input, relative to the next signing index
+	sessionOffset int64
+	// fan-out transaction of a payout tree, it is not part of the chain of vault transactions
+	payout bool
+}
+
+// number of signing sessions of vault transactions, one per input
+func signingSessionsNum(vault_txs []*vaultTx) int64 {
+	num := int64(0)
+	for _, vault_tx := range vault_txs {
+		num += int64(len(vault_tx.sigHashes))
+	}
+
+	return num
+}
+
+// utxos of the vault, the previous checkpoint followed by credited deposits in the order they are consolidated
+// at most max_inputs utxos are returned, zero means all
+func (v *MockValidator) vaultUtxos(max_inputs int) ([]wire.OutPoint, []*wire.TxOut) {
 	// get previous checkpoint from storage
 	prev_checkpoint := v.getBtcCheckPoint(v.btcCheckpointheight - 1)
 	v.logger.Printf("prev checkpoint: %v\n", prev_checkpoint)
@@ -599,66 +1052,135 @@ func (v *MockValidator) handleTxs(hType txscript.SigHashType) ([32]byte, *wire.M
 	assert.NoError(v.suite.T, err)
 
 	// get prevout
-	prev_out := wire.OutPoint{
+	prev_outs := []wire.OutPoint{{
 		Hash:  *checkpoint_hash,
 		Index: prev_checkpoint.OutIndex,
+	}}
+	prev_tx_outs := []*wire.TxOut{v.fetchUtxo(prev_outs[0])}
+
+	for _, deposit := range v.getCreditedDeposits() {
+		if max_inputs > 0 && len(prev_outs) >= max_inputs {
+			break
+		}
+		deposit_out := depositOutPoint(v.suite, deposit)
+		deposit_tx_out := v.fetchUtxo(deposit_out)
+		assert.NotNil(v.suite.T, deposit_tx_out, "credited deposit %v is not found", deposit)
+		prev_outs = append(prev_outs, deposit_out)
+		prev_tx_outs = append(prev_tx_outs, deposit_tx_out)
 	}
-	prev_tx_out := v.suite.UtxoViewpoint.FetchPrevOutput(prev_out)
-	vault_balance := prev_tx_out.Value
 
-	// construct new tx for this checkpoint height
-	btc_tx := wire.NewMsgTx(2)
-	btc_tx.AddTxIn(&wire.TxIn{
-		PreviousOutPoint: prev_out,
-	})
+	return prev_outs, prev_tx_outs
+}
 
-	outputs := make([]*wire.TxOut, 0)
-	for _, tx := range v.getAllTxs() {
-		vault_balance -= tx.Amount
-		assert.GreaterOrEqual(v.suite.T, vault_balance, int64(0))
+// txs will affect this network next inputs, and outputs
+// withdrawals of a checkpoint can be split into a chain of vault transactions
+// the first transaction spends the previous checkpoint together with credited deposits, and each next one spends output 0 of the one before
+// output 0 of the last transaction is the next checkpoint
+//
+// return vault transactions with sighashes for further signing process
+func (v *MockValidator) handleTxs(hType txscript.SigHashType) []*vaultTx {
+	// credited deposits are consolidated into the first vault transaction, the earliest ones first
+	prev_outs, prev_tx_outs := v.vaultUtxos(v.batchingPolicy.MaxTxInputs)
+	vault_balance := int64(0)
+	for _, prev_tx_out := range prev_tx_outs {
+		vault_balance += prev_tx_out.Value
+	}
 
-		addr, err := btcutil.DecodeAddress(tx.Receiver, v.suite.BtcdChainConfig)
-		assert.NoError(v.suite.T, err)
+	// during vault migration, the vault is moved to the new group key
+	// and withdrawals are paused until the migration is finalized
+	next_group_key := v.frost.GroupPublicKey
+	v.stampQueuedHeights()
+	withdrawals := v.getQueuedTxs()
+	if v.migrating {
+		next_group_key = v.standbyGroup.frost.GroupPublicKey
+		withdrawals = nil
+	}
+	// checkpoints move on without withdrawals while they are paused
+	if v.IsWithdrawPaused() {
+		withdrawals = nil
+	}
 
-		txOut := &wire.TxOut{
-			Value:    tx.Amount,
-			PkScript: addr.ScriptAddress(),
+	// next checkpoint output script
+	trScript := v.vaultPkScript(next_group_key)
+
+	vault_txs := make([]*vaultTx, 0)
+	session_offset := int64(0)
+	// payout trees are signed after the vault transactions that create their roots
+	payout_roots := make([]*payoutNode, 0)
+	payout_out_points := make([]wire.OutPoint, 0)
+	// vault output value that a payout tree saves or costs, carried to the next vault transactions
+	carry := int64(0)
+	for _, outputs := range v.planVaultTxs(vault_balance, len(prev_outs), trScript, withdrawals) {
+		var root *payoutNode
+		if v.usePayoutTree(outputs[1:]) {
+			input_value := int64(0)
+			for _, prev_tx_out := range prev_tx_outs {
+				input_value += prev_tx_out.Value
+			}
+			planned_value := outputs[0].Value + carry
+			outputs, root = v.planPayoutTree(input_value, len(prev_outs), outputs)
+			if root != nil {
+				carry = outputs[0].Value - planned_value + carry
+			}
+		}
+		if root == nil && carry != 0 {
+			outputs = append([]*wire.TxOut{wire.NewTxOut(outputs[0].Value+carry, outputs[0].PkScript)}, outputs[1:]...)
 		}
-		outputs = append(outputs, txOut)
-	}
 
-	// add next checkpoint output
-	group_key := v.frost.GroupPublicKey
-	trScript, err := txscript.PayToTaprootScript(group_key)
-	assert.NoError(v.suite.T, err)
+		// construct new tx for this checkpoint height
+		btc_tx := wire.NewMsgTx(2)
+		inputFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		for i, prev_out := range prev_outs {
+			btc_tx.AddTxIn(&wire.TxIn{
+				PreviousOutPoint: prev_out,
+			})
+			inputFetcher.AddPrevOut(prev_out, prev_tx_outs[i])
+		}
+		for _, txOut := range outputs {
+			btc_tx.AddTxOut(txOut)
+		}
 
-	// include fees
-	vault_balance -= v.btcGasFee
-	assert.GreaterOrEqual(v.suite.T, vault_balance, int64(0))
+		// calculating sighash of each input
+		sigHashes := txscript.NewTxSigHashes(btc_tx, inputFetcher)
+		input_sig_hashes := make([][32]byte, len(prev_outs))
+		for i := range prev_outs {
+			sigHash, err := txscript.CalcTaprootSignatureHash(sigHashes, hType, btc_tx, i, inputFetcher)
+			assert.Nil(v.suite.T, err)
+			input_sig_hashes[i] = ([32]byte)(sigHash)
+		}
 
-	checkpoint_out := &wire.TxOut{
-		Value:    vault_balance,
-		PkScript: trScript,
-	}
-	outputs = append([]*wire.TxOut{checkpoint_out}, outputs...)
+		vault_txs = append(vault_txs, &vaultTx{
+			tx:            btc_tx,
+			prevOuts:      prev_tx_outs,
+			sigHashes:     input_sig_hashes,
+			sessionOffset: session_offset,
+		})
+		session_offset += int64(len(prev_outs))
+		if root != nil {
+			payout_roots = append(payout_roots, root)
+			payout_out_points = append(payout_out_points, wire.OutPoint{Hash: btc_tx.TxHash(), Index: 1})
+		}
 
-	for _, txOut := range outputs {
-		btc_tx.AddTxOut(txOut)
+		// next vault transaction spends the vault output of this one
+		prev_outs = []wire.OutPoint{{
+			Hash:  btc_tx.TxHash(),
+			Index: 0,
+		}}
+		prev_tx_outs = []*wire.TxOut{outputs[0]}
 	}
 
-	// calculating sighash
-	inputFetcher := txscript.NewCannedPrevOutputFetcher(
-		prev_tx_out.PkScript,
-		prev_tx_out.Value,
-	)
-	sigHashes := txscript.NewTxSigHashes(btc_tx, inputFetcher)
-	sigHash, err := txscript.CalcTaprootSignatureHash(sigHashes, hType, btc_tx, 0, inputFetcher)
-	assert.Nil(v.suite.T, err)
+	for i, root := range payout_roots {
+		payout_txs := v.payoutVaultTxs(root, payout_out_points[i], hType, session_offset)
+		vault_txs = append(vault_txs, payout_txs...)
+		session_offset += int64(len(payout_txs))
+	}
 
-	return ([32]byte)(sigHash), btc_tx
+	return vault_txs
 }
 
-func (v *MockValidator) handleFinalizeTransaction(signing_index int64) {
+// aggregate adapt sigs of honest validators into a witness of each input of each vault transaction
+// return false if any signature is invalid, then no witness is returned
+func (v *MockValidator) aggregateWitnesses(vault_txs []*vaultTx) ([][]wire.TxWitness, bool) {
 	honest := make([]int64, 0)
 	for i := int64(1); i <= v.partyNum; i++ {
 		if _, ok := v.dishonestVals[i]; !ok {
@@ -666,54 +1188,155 @@ func (v *MockValidator) handleFinalizeTransaction(signing_index int64) {
 		}
 	}
 
-	z := new(btcec.ModNScalar)
-	for _, party := range honest {
-		adapt_sig := v.getAdaptSig(0, party)
-		z_i := new(btcec.ModNScalar)
-		z_i.SetByteSlice(adapt_sig.Serialize()[32:64])
-		z.Add(z_i)
+	hType := txscript.SigHashDefault
+	witnesses := make([][]wire.TxWitness, len(vault_txs))
+	for tx_index, vault_tx := range vault_txs {
+		witnesses[tx_index] = make([]wire.TxWitness, len(vault_tx.sigHashes))
+		for input_index, sigHash := range vault_tx.sigHashes {
+			signing_index := v.nextSigningIndex + vault_tx.sessionOffset + int64(input_index)
+			z := new(btcec.ModNScalar)
+			for _, party := range honest {
+				adapt_sig := v.getAdaptSig(signing_index, party)
+				z_i := new(btcec.ModNScalar)
+				z_i.SetByteSlice(adapt_sig.Serialize()[32:64])
+				z.Add(z_i)
+			}
+			v.frost.TweakWeightedSignature(signing_index, sigHash, z)
+
+			sig := schnorr.NewSignature(&v.frost.AggrNonceCommitment[signing_index].X, z)
+
+			// pre-check
+			if ok := sig.Verify(sigHash[:], v.frost.OutputKey()); !ok {
+				assert.True(v.suite.T, ok, "aggregated signature of input %d of vault tx %d is invalid", input_index, tx_index)
+				return nil, false
+			}
+
+			schnorrSigBytes := sig.Serialize()
+			if hType != txscript.SigHashDefault {
+				schnorrSigBytes = append(schnorrSigBytes, byte(hType))
+			}
+			witnesses[tx_index][input_index] = wire.TxWitness{
+				schnorrSigBytes,
+			}
+		}
 	}
 
-	sig := schnorr.NewSignature(&v.frost.AggrNonceCommitment[signing_index].X, z)
+	return witnesses, true
+}
 
-	// sending the transaction with the final signature
-	prev_checkpoint := v.getBtcCheckPoint(v.btcCheckpointheight - 1)
-	checkpoint_hash, err := chainhash.NewHashFromStr(prev_checkpoint.OutHash)
-	assert.NoError(v.suite.T, err)
+func (v *MockValidator) handleFinalizeTransaction(vault_txs []*vaultTx) {
+	// every input of every vault transaction is aggregated and verified before any witness is set
+	// so that the checkpoint is either finalized as a whole or not at all
+	witnesses, ok := v.aggregateWitnesses(vault_txs)
+	if !ok {
+		return
+	}
 
-	// get prevout
-	prev_out := wire.OutPoint{
-		Hash:  *checkpoint_hash,
-		Index: prev_checkpoint.OutIndex,
+	signed_txs := make([]*wire.MsgTx, 0, len(vault_txs))
+	for tx_index, vault_tx := range vault_txs {
+		// sending the transaction with the final signatures
+		btc_tx := vault_tx.tx
+		inputFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		for input_index, witness := range witnesses[tx_index] {
+			btc_tx.TxIn[input_index].Witness = witness
+			inputFetcher.AddPrevOut(btc_tx.TxIn[input_index].PreviousOutPoint, vault_tx.prevOuts[input_index])
+		}
+		v.suite.HashCache.AddSigHashes(btc_tx, inputFetcher)
+
+		// the first transaction spends the checkpoint and deposits on chain
+		// the next ones spend the vault output of the previous transaction
+		var err error
+		// fan-out transactions spend outputs of vault transactions or of other fan-out transactions
+		if tx_index > 0 {
+			utxo_view := blockchain.NewUtxoViewpoint()
+			for _, signed_tx := range signed_txs {
+				utxo_view.AddTxOuts(btcutil.NewTx(signed_tx), int32(v.btcCheckpointheight))
+			}
+			err = blockchain.ValidateTransactionScripts(
+				btcutil.NewTx(btc_tx), utxo_view, txscript.StandardVerifyFlags, v.suite.SigCache, v.suite.HashCache,
+			)
+		} else {
+			v.suite.ReadUtxoViewpoint(func(utxo_view *blockchain.UtxoViewpoint) {
+				err = blockchain.ValidateTransactionScripts(
+					btcutil.NewTx(btc_tx), utxo_view, txscript.StandardVerifyFlags, v.suite.SigCache, v.suite.HashCache,
+				)
+			})
+		}
+		if err != nil {
+			assert.NoError(v.suite.T, err, "vault tx %d is invalid", tx_index)
+			return
+		}
+
+		signed_txs = append(signed_txs, btc_tx)
 	}
-	prev_tx_out := v.suite.UtxoViewpoint.FetchPrevOutput(prev_out)
-	inputFetcher := txscript.NewCannedPrevOutputFetcher(
-		prev_tx_out.PkScript,
-		prev_tx_out.Value,
-	)
 
-	hType := txscript.SigHashDefault
-	sigHash, btc_tx := v.handleTxs(hType)
+	// the signed transactions are ready to be broadcasted
+	// move on to the next checkpoint, which is the vault output of the last vault transaction
+	checkpoint_txs := make([]*wire.MsgTx, 0, len(signed_txs))
+	payout_txs := make([]*wire.MsgTx, 0)
+	for tx_index, signed_tx := range signed_txs {
+		if vault_txs[tx_index].payout {
+			payout_txs = append(payout_txs, signed_tx)
+		} else {
+			checkpoint_txs = append(checkpoint_txs, signed_tx)
+		}
+	}
+	v.storeSignedTxs(v.btcCheckpointheight, checkpoint_txs)
+	if len(payout_txs) > 0 {
+		v.storeTxList(PAYOUT_TX_STORE_KEY, v.btcCheckpointheight, payout_txs)
+	}
+	// broadcast in signing order, fan-out transactions come after the transactions they spend
+	for _, signed_tx := range signed_txs {
+		v.suite.BroadcastTx(signed_tx)
+	}
+	v.advanceCheckPoint(checkpoint_txs, payout_txs)
+	v.nextSigningIndex += signingSessionsNum(vault_txs)
 
-	// pre-check
-	ok := sig.Verify(sigHash[:], v.frost.GroupPublicKey)
-	assert.True(v.suite.T, ok)
+	// the new group key starts with a new nonce pool
+	if v.migrating {
+		v.completeVaultMigration()
+		return
+	}
+	v.ReplenishNonces()
+}
 
-	schnorrSigBytes := sig.Serialize()
-	if hType != txscript.SigHashDefault {
-		schnorrSigBytes = append(schnorrSigBytes, byte(hType))
+// new checkpoint is always at output 0 of the last signed transaction
+// withdrawals included in the signed transactions are cleared
+// the signed transactions are only broadcasted, the utxo viewpoint is updated once the chain mines them
+// and the next checkpoint waits for their confirmations through submitted headers
+func (v *MockValidator) advanceCheckPoint(signed_txs []*wire.MsgTx, payout_txs []*wire.MsgTx) {
+	last_tx := signed_txs[len(signed_txs)-1]
+	checkpoint := &BtcCheckPoint{
+		Height:   v.btcCheckpointheight,
+		OutHash:  last_tx.TxHash().String(),
+		OutIndex: 0,
+	}
+	v.storeBtcCheckPoint(v.btcCheckpointheight, checkpoint)
+	// every output pays a withdrawal, except vault outputs and node outputs spent by fan-out transactions
+	paid_num := -len(signed_txs) - len(payout_txs)
+	for _, signed_tx := range append(signed_txs, payout_txs...) {
+		paid_num += len(signed_tx.TxOut)
 	}
-	witness := wire.TxWitness{
-		schnorrSigBytes,
+	// the signed txs are final, a local queue without all of their withdrawals has diverged from the peers
+	if err := v.archivePaidTxs(v.btcCheckpointheight, paid_num); err != nil {
+		v.logger.Printf("checkpoint %d pays withdrawals that are not queued: %v\n", v.btcCheckpointheight, err)
+		v.RequestStateSync()
+	} else if err := v.clearTxs(paid_num); err != nil {
+		v.logger.Printf("checkpoint %d pays withdrawals that are not queued: %v\n", v.btcCheckpointheight, err)
+		v.RequestStateSync()
+	}
+	// deposits are spent by the first signed transaction
+	for _, txIn := range signed_txs[0].TxIn[1:] {
+		v.consolidateDeposit(txIn.PreviousOutPoint)
 	}
-	btc_tx.TxIn[0].Witness = witness
 
-	v.suite.HashCache.AddSigHashes(btc_tx, inputFetcher)
+	v.logger.Printf("checkpoint %d has been finalized with %d txs: %v\n", v.btcCheckpointheight, len(signed_txs), checkpoint)
+	v.btcCheckpointheight++
+}
 
-	err = blockchain.ValidateTransactionScripts(
-		btcutil.NewTx(btc_tx), v.suite.UtxoViewpoint, txscript.StandardVerifyFlags, v.suite.SigCache, v.suite.HashCache,
-	)
-	assert.Nil(v.suite.T, err)
+// the checkpoint height that this validator is currently signing for
+func (v *MockValidator) GetCheckPointHeight() int64 {
+	return v.btcCheckpointheight
 }
 
 func (v *MockValidator) SetLongTermSecretShares(key int64, scalar_bytes []byte) {
@@ -742,6 +1365,18 @@ func (v *MockValidator) getPolyCommitments(posi int64) []*btcec.PublicKey {
 	return v.frost.PolynomialCommitments[posi]
 }
 
+// a validator without keys has all secret shares from the start
+// its long term key still needs polynomial commitments of every validator
+func (v *MockValidator) hasAllPolyCommitments() bool {
+	for posi := int64(1); posi <= v.partyNum; posi++ {
+		if v.getPolyCommitments(posi) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (v *MockValidator) storeBtcCheckPoint(checkpoint_height int64, checkpoint *BtcCheckPoint) {
 	checkpointBytes, err := proto.Marshal(checkpoint)
 	assert.NoError(v.suite.T, err)
@@ -756,21 +1391,104 @@ func (v *MockValidator) getBtcCheckPoint(checkpoint_height int64) *BtcCheckPoint
 	return checkpoint
 }
 
-func (v *MockValidator) storeTxs(txs []*MsgWithdraw) {
+// withdrawals are queued by batch sequence, then by index in the batch
+// batch sequence is assigned on - chain, so all validators derive the same order regardless of arrival order
+func (v *MockValidator) storeTxs(sequence int64, txs []*MsgWithdraw) {
 	for i, tx := range txs {
 		txBytes, err := proto.Marshal(tx)
 		assert.NoError(v.suite.T, err)
-		v.protocolStorage.store[TRANSACTION_STORE_KEY][strconv.Itoa(i)] = txBytes
+		v.protocolStorage.store[TRANSACTION_STORE_KEY][withdrawQueueKey(sequence, int64(i))] = txBytes
+	}
+}
+
+// a withdrawal is queued at the checkpoint that the first vault transactions planned with it build on
+// the checkpoint a validator is at when a batch arrives depends on delivery, while at planning
+// validators sign the same vault transactions, so they agree on both the queue and the checkpoint
+func (v *MockValidator) stampQueuedHeights() {
+	for _, key := range v.sortedTxKeys() {
+		if _, ok := v.protocolStorage.store[WITHDRAW_QUEUED_HEIGHT_STORE_KEY][key]; !ok {
+			v.protocolStorage.store[WITHDRAW_QUEUED_HEIGHT_STORE_KEY][key] = []byte(strconv.FormatInt(v.btcCheckpointheight, 10))
+		}
+	}
+}
+
+// zero - padded so that lexical order of keys is the queue order
+func withdrawQueueKey(sequence, index int64) string {
+	return fmt.Sprintf("%020d/%010d", sequence, index)
+}
+
+func (v *MockValidator) sortedTxKeys() []string {
+	keys := make([]string, 0, len(v.protocolStorage.store[TRANSACTION_STORE_KEY]))
+	for key := range v.protocolStorage.store[TRANSACTION_STORE_KEY] {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// remove the first num txs in queue order, together with their queued heights
+func (v *MockValidator) clearTxs(num int) error {
+	keys, err := v.firstTxKeys(num)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		delete(v.protocolStorage.store[TRANSACTION_STORE_KEY], key)
+		delete(v.protocolStorage.store[WITHDRAW_QUEUED_HEIGHT_STORE_KEY], key)
+	}
+
+	return nil
+}
+
+// keep the first num txs in queue order as paid by the checkpoint at checkpoint_height
+// they are kept in a single entry of the checkpoint with their queue keys and queued heights,
+// so that a rollback queues them again as they were, and the entry is pruned once the checkpoint is final
+func (v *MockValidator) archivePaidTxs(checkpoint_height int64, num int) error {
+	keys, err := v.firstTxKeys(num)
+	if err != nil {
+		return err
+	}
+	paid := &StateSnapshot{CheckpointHeight: checkpoint_height}
+	for _, key := range keys {
+		paid.Entries = append(paid.Entries, &StateEntry{
+			Store: TRANSACTION_STORE_KEY,
+			Key:   key,
+			Value: v.protocolStorage.store[TRANSACTION_STORE_KEY][key],
+		})
+		if queued_height, ok := v.protocolStorage.store[WITHDRAW_QUEUED_HEIGHT_STORE_KEY][key]; ok {
+			paid.Entries = append(paid.Entries, &StateEntry{
+				Store: WITHDRAW_QUEUED_HEIGHT_STORE_KEY,
+				Key:   key,
+				Value: queued_height,
+			})
+		}
+	}
+	paidBytes, err := proto.Marshal(paid)
+	assert.NoError(v.suite.T, err)
+	v.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY][strconv.FormatInt(checkpoint_height, 10)] = paidBytes
+
+	return nil
+}
+
+// keys of the first num txs in queue order, the queue may be shorter on a validator that has missed a batch
+func (v *MockValidator) firstTxKeys(num int) ([]string, error) {
+	keys := v.sortedTxKeys()
+	if num > len(keys) {
+		return nil, fmt.Errorf("validator %d: %d withdrawals are paid, only %d are queued", v.position, num, len(keys))
+	}
+
+	return keys[:num], nil
+}
+
+func (v *MockValidator) GetPendingTxsNum() int {
+	return len(v.protocolStorage.store[TRANSACTION_STORE_KEY])
 }
 
 func (v *MockValidator) getAllTxs() []*MsgWithdraw {
 	txs := make([]*MsgWithdraw, 0)
-	for i := 0; ; i++ {
-		txBytes := v.protocolStorage.store[TRANSACTION_STORE_KEY][strconv.Itoa(i)]
-		if len(txBytes) == 0 {
-			break
-		}
+	for _, key := range v.sortedTxKeys() {
+		txBytes := v.protocolStorage.store[TRANSACTION_STORE_KEY][key]
 		tx := &MsgWithdraw{}
 		err := proto.Unmarshal(txBytes, tx)
 		assert.NoError(v.suite.T, err)
@@ -780,6 +1498,21 @@ func (v *MockValidator) getAllTxs() []*MsgWithdraw {
 	return txs
 }
 
+// pending withdrawals in queue order, with the checkpoint height each one is queued at
+func (v *MockValidator) getQueuedTxs() []*QueuedWithdrawal {
+	txs := make([]*QueuedWithdrawal, 0)
+	for _, key := range v.sortedTxKeys() {
+		tx := &MsgWithdraw{}
+		err := proto.Unmarshal(v.protocolStorage.store[TRANSACTION_STORE_KEY][key], tx)
+		assert.NoError(v.suite.T, err)
+		queued_height, err := strconv.ParseInt(string(v.protocolStorage.store[WITHDRAW_QUEUED_HEIGHT_STORE_KEY][key]), 10, 64)
+		assert.NoError(v.suite.T, err)
+		txs = append(txs, &QueuedWithdrawal{MsgWithdraw: tx, QueuedHeight: queued_height})
+	}
+
+	return txs
+}
+
 func (v *MockValidator) storeNonceCommitments(posi, signing_index int64, commitments []byte) {
 	substore_key := NONCE_COMMITMENTS_STORE_KEY + strconv.FormatInt(signing_index, 10)
 	// check if substore exists
@@ -837,10 +1570,24 @@ func (v *MockValidator) getPublicNonceCommitments(signing_index int64) map[int64
 
 func (v *MockValidator) storeAdaptSig(signing_index, posi int64, adapt_sig []byte) {
 	substore_key := ADAPT_SIG_STORE_KEY + strconv.FormatInt(signing_index, 10)
-	v.protocolStorage.store[substore_key] = make(map[string][]byte)
+	// check if substore exists
+	if _, ok := v.protocolStorage.store[substore_key]; !ok {
+		v.protocolStorage.store[substore_key] = make(map[string][]byte)
+	}
 	v.protocolStorage.store[substore_key][strconv.FormatInt(posi, 10)] = adapt_sig
 }
 
+func (v *MockValidator) hasAdaptSig(signing_index, posi int64) bool {
+	substore_key := ADAPT_SIG_STORE_KEY + strconv.FormatInt(signing_index, 10)
+	_, ok := v.protocolStorage.store[substore_key][strconv.FormatInt(posi, 10)]
+	return ok
+}
+
+// adapt sig differs from the one already stored for the same signing session
+func (v *MockValidator) isEquivocatedAdaptSig(signing_index, posi int64, adapt_sig []byte) bool {
+	return v.hasAdaptSig(signing_index, posi) && !bytes.Equal(v.getAdaptSig(signing_index, posi).Serialize(), adapt_sig)
+}
+
 func (v *MockValidator) getAdaptSig(signing_index, posi int64) *schnorr.Signature {
 	substore_key := ADAPT_SIG_STORE_KEY + strconv.FormatInt(signing_index, 10)
 	adapt_sig_bytes := v.protocolStorage.store[substore_key][strconv.FormatInt(posi, 10)]
@@ -850,6 +1597,43 @@ func (v *MockValidator) getAdaptSig(signing_index, posi int64) *schnorr.Signatur
 	return adapt_sig
 }
 
+func (v *MockValidator) storeSignedTxs(checkpoint_height int64, signed_txs []*wire.MsgTx) {
+	v.storeTxList(SIGNED_TX_STORE_KEY, checkpoint_height, signed_txs)
+}
+
+// signed transactions that create the checkpoint at checkpoint_height, in spending order
+func (v *MockValidator) GetSignedTxs(checkpoint_height int64) []*wire.MsgTx {
+	return v.getTxList(SIGNED_TX_STORE_KEY, checkpoint_height)
+}
+
+// signed transactions are serialized back to back
+func (v *MockValidator) storeTxList(store_key string, checkpoint_height int64, signed_txs []*wire.MsgTx) {
+	var buf bytes.Buffer
+	for _, signed_tx := range signed_txs {
+		err := signed_tx.Serialize(&buf)
+		assert.NoError(v.suite.T, err)
+	}
+	v.protocolStorage.store[store_key][strconv.FormatInt(checkpoint_height, 10)] = buf.Bytes()
+}
+
+func (v *MockValidator) getTxList(store_key string, checkpoint_height int64) []*wire.MsgTx {
+	tx_bytes, ok := v.protocolStorage.store[store_key][strconv.FormatInt(checkpoint_height, 10)]
+	if !ok {
+		return nil
+	}
+
+	signed_txs := make([]*wire.MsgTx, 0)
+	reader := bytes.NewReader(tx_bytes)
+	for reader.Len() > 0 {
+		signed_tx := wire.NewMsgTx(2)
+		err := signed_tx.Deserialize(reader)
+		assert.NoError(v.suite.T, err)
+		signed_txs = append(signed_txs, signed_tx)
+	}
+
+	return signed_txs
+}
+
 func (v *MockValidator) isEnoughAdaptSig(signing_index, honest_num int64) bool {
 	substore_key := ADAPT_SIG_STORE_KEY + strconv.FormatInt(signing_index, 10)
 	return int64(len(v.protocolStorage.store[substore_key])) == honest_num
@@ -0,0 +1,302 @@
+package wsts
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// nonce pool of a validator is replenished when its unused nonces fall below the low watermark
+// zero value means the nonce pool is never replenished, nonces are only sent by DeriveAndSendNonces
+type NoncePoolPolicy struct {
+	// number of unused nonces that the nonce pool is refilled to
+	Size int64
+	// nonce pool is refilled when its unused nonces fall below this
+	LowWatermark int64
+}
+
+func (v *MockValidator) SetNoncePoolPolicy(policy NoncePoolPolicy) {
+	v.noncePoolPolicy = policy
+}
+
+// nonces of this validator from the next signing index on
+func (v *MockValidator) UnusedNoncesNum() int64 {
+	unused := v.frost.SigningNoncesNum() - v.nextSigningIndex
+	if unused < 0 {
+		return 0
+	}
+
+	return unused
+}
+
+// nonce commitments of a validator from the next signing index on, that this validator can sign with
+func (v *MockValidator) PeerUnusedNoncesNum(posi int64) int64 {
+	unused := int64(0)
+	for {
+		if _, err := v.getNonceCommitments(posi, v.nextSigningIndex+unused); err != nil {
+			return unused
+		}
+		unused++
+	}
+}
+
+// new nonces follow the ones already sent, so that peers keep the nonces that have not been used
+func (v *MockValidator) ReplenishNonces() {
+	policy := v.noncePoolPolicy
+	unused := v.UnusedNoncesNum()
+	if policy.LowWatermark == 0 || unused >= policy.LowWatermark {
+		return
+	}
+
+	// signing index can run past the nonce pool when sessions are aborted
+	start_index := v.frost.SigningNoncesNum()
+	if start_index < v.nextSigningIndex {
+		v.frost.ExtendSigningNonces(v.nextSigningIndex - start_index)
+		for i := start_index; i < v.nextSigningIndex; i++ {
+			v.frost.EraseSigningNonce(i)
+		}
+		start_index = v.nextSigningIndex
+	}
+
+	v.logger.Printf("validator %d replenishes %d nonces from signing index %d\n", v.position, policy.Size-unused, start_index)
+	v.sendNonceCommitments(start_index, v.frost.ExtendSigningNonces(policy.Size-unused))
+}
+
+// secret nonces are kept in local storage until used, nonce commitments are sent on - chain
+func (v *MockValidator) sendNonceCommitments(start_index int64, nonceCommitments [][2]*btcec.PublicKey) {
+	// store this validator nonce commitments
+	nonceCommitmentsArr := make([]*NonceCommitments, len(nonceCommitments))
+	for i, nonceCommitment := range nonceCommitments {
+		signing_index := start_index + int64(i)
+		nonceStruct := &NonceCommitments{
+			D: nonceCommitment[0].SerializeCompressed(),
+			E: nonceCommitment[1].SerializeCompressed(),
+		}
+		nonceCommitmentsArr[i] = nonceStruct
+		nonceStructBytes, err := proto.Marshal(nonceStruct)
+		assert.NoError(v.suite.T, err)
+
+		v.storeNonceCommitments(v.position, signing_index, nonceStructBytes)
+		_, err = v.getNonceCommitments(v.position, signing_index)
+		assert.NoError(v.suite.T, err)
+		v.localStorage.store[SIGNING_NONCE_STORE_KEY][strconv.FormatInt(signing_index, 10)] = v.frost.SigningNonceBytes(signing_index)
+	}
+
+	// send to all other validators
+	msg := MsgUpdateNonceCommitments{
+		Source:           v.position,
+		NonceCommitments: nonceCommitmentsArr,
+		StartIndex:       start_index,
+	}
+	envBytes := v.sealEnvelope(&Envelope_UpdateNonceCommitments{UpdateNonceCommitments: &msg})
+	for _, otherVal := range v.otherVals {
+		otherVal.SendMessageOnChain(envBytes)
+	}
+
+	// nonce commitments of this validator are not self - sent, its envelope is kept for lagging peers
+	env := &Envelope{}
+	err := proto.Unmarshal(envBytes, env)
+	assert.NoError(v.suite.T, err)
+	v.storeNonceEnvelope(env)
+}
+
+// a nonce is identified by its commitment D, so that a mark outlives the signing index it was sent for
+func (v *MockValidator) nonceMarkKey(signing_index int64) (string, error) {
+	nonceCommitments, err := v.getNonceCommitments(v.position, signing_index)
+	if err != nil {
+		return "", fmt.Errorf("validator %d: no nonce commitments for signing index %d", v.position, signing_index)
+	}
+
+	return hex.EncodeToString(nonceCommitments[0].SerializeCompressed()), nil
+}
+
+// mark a nonce as used in local storage before it signs
+// a nonce that has already been marked, or has been erased, is refused
+func (v *MockValidator) useNonce(signing_index int64) error {
+	key, err := v.nonceMarkKey(signing_index)
+	if err != nil {
+		return err
+	}
+	if _, ok := v.localStorage.store[USED_NONCE_STORE_KEY][key]; ok {
+		return fmt.Errorf("validator %d: nonce of signing index %d has already been used", v.position, signing_index)
+	}
+	if !v.frost.HasSigningNonce(signing_index) {
+		return fmt.Errorf("validator %d: no secret nonce for signing index %d", v.position, signing_index)
+	}
+	v.localStorage.store[USED_NONCE_STORE_KEY][key] = []byte{1}
+
+	return nil
+}
+
+func (v *MockValidator) eraseNonce(signing_index int64) {
+	v.frost.EraseSigningNonce(signing_index)
+	delete(v.localStorage.store[SIGNING_NONCE_STORE_KEY], strconv.FormatInt(signing_index, 10))
+}
+
+// nonce of an aborted session might have signed over the previous honest set, it must never sign again
+func (v *MockValidator) invalidateNonce(signing_index int64) {
+	if key, err := v.nonceMarkKey(signing_index); err == nil {
+		v.localStorage.store[USED_NONCE_STORE_KEY][key] = []byte{1}
+	}
+	v.eraseNonce(signing_index)
+}
+
+// reload secret nonces from local storage after a restart, nonces that have been marked as used are dropped
+func (v *MockValidator) RestoreNonces() {
+	for key, nonceBytes := range v.localStorage.store[SIGNING_NONCE_STORE_KEY] {
+		signing_index, err := strconv.ParseInt(key, 10, 64)
+		assert.NoError(v.suite.T, err)
+		if _, err := v.frost.RestoreSigningNonce(signing_index, nonceBytes); err != nil {
+			v.logger.Printf("validator %d: cannot restore nonce of signing index %d: %v\n", v.position, signing_index, err)
+			continue
+		}
+
+		mark_key, err := v.nonceMarkKey(signing_index)
+		if _, used := v.localStorage.store[USED_NONCE_STORE_KEY][mark_key]; err != nil || used {
+			v.logger.Printf("validator %d: drop used nonce of signing index %d\n", v.position, signing_index)
+			v.eraseNonce(signing_index)
+		}
+	}
+}
+
+func (v *MockValidator) markPeerNonceConsumed(posi, signing_index int64) {
+	substore_key := NONCE_CONSUMED_STORE_KEY + strconv.FormatInt(signing_index, 10)
+	if _, ok := v.protocolStorage.store[substore_key]; !ok {
+		v.protocolStorage.store[substore_key] = make(map[string][]byte)
+	}
+	v.protocolStorage.store[substore_key][strconv.FormatInt(posi, 10)] = []byte{1}
+}
+
+// a validator has consumed its nonce of a signing index once its adapt sig has been accepted
+func (v *MockValidator) isPeerNonceConsumed(posi, signing_index int64) bool {
+	substore_key := NONCE_CONSUMED_STORE_KEY + strconv.FormatInt(signing_index, 10)
+	_, ok := v.protocolStorage.store[substore_key][strconv.FormatInt(posi, 10)]
+
+	return ok
+}
+
+// go test -v -run ^TestNoncePoolReplenishment$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestNoncePoolReplenishment(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+	network := NewSimNetwork(SimNetConfig{Seed: 19})
+	rng := rand.New(rand.NewSource(19))
+
+	validators := newSimValidatorSet(t, &suite, network, 4, 10, 5)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	assert.True(t, runSimDKG(t, network, validators), "DKG has not finished")
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+
+	// nonce pools are filled from empty, and are smaller than the number of checkpoints
+	policy := NoncePoolPolicy{Size: 3, LowWatermark: 2}
+	for _, validator := range validators {
+		validator := validator
+		validator.SetNoncePoolPolicy(policy)
+		network.At(network.Now(), validator.ReplenishNonces)
+	}
+	assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+	for _, validator := range validators {
+		assert.Equal(t, policy.Size, validator.UnusedNoncesNum())
+	}
+
+	checkpoints := int64(5)
+	for checkpoint := int64(1); checkpoint <= checkpoints; checkpoint++ {
+		batch := &MsgBatchWithdraw{WithdrawBatch: generateSimWithdrawList(&suite, rng, 10), Sequence: checkpoint}
+		for _, validator := range validators {
+			network.SubmitOnChain(validator.position, validator.sealEnvelope(&Envelope_BatchWithdraw{BatchWithdraw: batch}))
+		}
+		assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+		if !assert.True(t, runSimCheckPoint(t, network, validators), "checkpoint %d has not been finalized", checkpoint) {
+			return
+		}
+		assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+		for _, signed_tx := range validators[0].GetSignedTxs(checkpoint) {
+			suite.MockMineTx(signed_tx, int32(checkpoint))
+		}
+
+		// each validator keeps at least the low watermark of nonces of every validator
+		for _, validator := range validators {
+			assert.GreaterOrEqual(t, validator.UnusedNoncesNum(), policy.LowWatermark)
+			for posi := int64(1); posi <= validator.partyNum; posi++ {
+				assert.GreaterOrEqual(t, validator.PeerUnusedNoncesNum(posi), policy.LowWatermark, "validator %d, nonces of validator %d", validator.position, posi)
+			}
+		}
+	}
+
+	// consumed nonces are tracked for every signer, and their secret nonces are gone
+	for _, validator := range validators {
+		for signing_index := int64(0); signing_index < validator.nextSigningIndex; signing_index++ {
+			for posi := int64(1); posi <= validator.partyNum; posi++ {
+				assert.True(t, validator.isPeerNonceConsumed(posi, signing_index))
+			}
+			assert.False(t, validator.frost.HasSigningNonce(signing_index))
+		}
+	}
+}
+
+// go test -v -run ^TestNonceReuse$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestNonceReuse(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+	network := NewSimNetwork(SimNetConfig{Seed: 23})
+
+	validators := newSimValidatorSet(t, &suite, network, 4, 10, 5)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	assert.True(t, runSimDKG(t, network, validators), "DKG has not finished")
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+	for _, validator := range validators {
+		validator := validator
+		network.At(network.Now(), func() {
+			validator.DeriveAndSendNonces(3)
+		})
+	}
+	assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+
+	batch := &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 10), Sequence: 1}
+	for _, validator := range validators {
+		network.SubmitOnChain(validator.position, validator.sealEnvelope(&Envelope_BatchWithdraw{BatchWithdraw: batch}))
+	}
+	assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+
+	// validator signs, then restarts before the secret nonce is erased from local storage
+	validator := validators[0]
+	signing_index := validator.nextSigningIndex
+	nonce_bytes := validator.frost.SigningNonceBytes(signing_index)
+	assert.NoError(t, validator.DeriveTxAndSign())
+	assert.False(t, validator.frost.HasSigningNonce(signing_index))
+	validator.localStorage.store[SIGNING_NONCE_STORE_KEY][strconv.FormatInt(signing_index, 10)] = nonce_bytes
+
+	// used nonce is dropped on restart, nonces not yet used are restored
+	validator.RestoreNonces()
+	assert.False(t, validator.frost.HasSigningNonce(signing_index))
+	assert.True(t, validator.frost.HasSigningNonce(signing_index+1))
+	assert.ErrorContains(t, validator.DeriveTxAndSign(), "has already been used")
+
+	// even a used secret nonce that is still around never signs twice
+	_, err := validator.frost.RestoreSigningNonce(signing_index, nonce_bytes)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, validator.DeriveTxAndSign(), "has already been used")
+
+	// a validator is excluded after this validator has signed, nonces of the aborted session are invalidated
+	assert.True(t, validator.hasStartedSigningSession())
+	validator.excludeValidator(4, "test")
+	assert.Equal(t, signing_index+1, validator.nextSigningIndex)
+	assert.False(t, validator.frost.HasSigningNonce(signing_index))
+	assert.Equal(t, int64(2), validator.UnusedNoncesNum())
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package wsts
+
+const raceEnabled = false
@@ -0,0 +1,246 @@
+package wsts
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+// congestion controlled payouts
+// a vault transaction with at least min outputs withdrawals pays them through a tree of fan-out transactions instead
+// the vault transaction only creates the root output of the tree, and each fan-out transaction spends one node output into at most radix outputs
+// node outputs pay to the vault key, and all fan-out transactions are signed by the federation together with the checkpoint
+// so that anyone can later unroll the branch of a receiver, at the fee rate of the checkpoint
+//
+// the zero policy pays every withdrawal directly
+type PayoutPolicy struct {
+	MinOutputs int
+	Radix      int
+}
+
+func (v *MockValidator) SetPayoutPolicy(policy PayoutPolicy) {
+	v.payoutPolicy = policy
+}
+
+type payoutNode struct {
+	// output that creates this node, a withdrawal for a leaf
+	out *wire.TxOut
+	// outputs of the fan-out transaction that spends this node, nil for a leaf
+	children []*payoutNode
+}
+
+func (v *MockValidator) usePayoutTree(withdrawal_outs []*wire.TxOut) bool {
+	policy := v.payoutPolicy
+	return policy.MinOutputs > 0 && policy.Radix > 1 && len(withdrawal_outs) >= policy.MinOutputs
+}
+
+// tree over withdrawal outputs in queue order, levels are filled from the leaves up
+// each node output carries the value of its subtree and the fee of its fan-out transaction
+func (v *MockValidator) newPayoutTree(withdrawal_outs []*wire.TxOut, node_script []byte) *payoutNode {
+	level := make([]*payoutNode, len(withdrawal_outs))
+	for i, out := range withdrawal_outs {
+		level[i] = &payoutNode{out: out}
+	}
+
+	for {
+		next := make([]*payoutNode, 0)
+		for start := 0; start < len(level); start += v.payoutPolicy.Radix {
+			children := level[start:min(start+v.payoutPolicy.Radix, len(level))]
+			outs := make([]*wire.TxOut, len(children))
+			value := int64(0)
+			for i, child := range children {
+				outs[i] = child.out
+				value += child.out.Value
+			}
+			value += v.estimateVaultTxFee(1, outs[0], outs[1:])
+			next = append(next, &payoutNode{
+				out:      wire.NewTxOut(value, node_script),
+				children: children,
+			})
+		}
+		if len(next) == 1 {
+			return next[0]
+		}
+		level = next
+	}
+}
+
+// outputs of a vault transaction paying its withdrawals through a payout tree, and the root of the tree
+// vault transaction pays input value to the next checkpoint output, the root output and fees
+// withdrawals are paid directly if the vault cannot cover the fees of the tree
+func (v *MockValidator) planPayoutTree(input_value int64, input_num int, outputs []*wire.TxOut) ([]*wire.TxOut, *payoutNode) {
+	root := v.newPayoutTree(outputs[1:], v.vaultPkScript(v.frost.GroupPublicKey))
+	checkpoint_out := &wire.TxOut{
+		PkScript: outputs[0].PkScript,
+	}
+	checkpoint_out.Value = input_value - root.out.Value - v.estimateVaultTxFee(input_num, checkpoint_out, []*wire.TxOut{root.out})
+	if checkpoint_out.Value < 0 || mempool.IsDust(checkpoint_out, mempool.DefaultMinRelayTxFee) {
+		v.logger.Printf("vault balance cannot cover payout tree of %d withdrawals, pay them directly\n", len(outputs)-1)
+		return outputs, nil
+	}
+
+	return []*wire.TxOut{checkpoint_out, root.out}, root
+}
+
+// fan-out transactions of a payout tree whose root is at root_out_point, parents before children
+// signing sessions continue from session offset
+func (v *MockValidator) payoutVaultTxs(root *payoutNode, root_out_point wire.OutPoint, hType txscript.SigHashType, session_offset int64) []*vaultTx {
+	vault_txs := make([]*vaultTx, 0)
+	nodes := []*payoutNode{root}
+	out_points := []wire.OutPoint{root_out_point}
+	for len(nodes) > 0 {
+		node, out_point := nodes[0], out_points[0]
+		nodes, out_points = nodes[1:], out_points[1:]
+
+		btc_tx := wire.NewMsgTx(2)
+		btc_tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: out_point,
+		})
+		for _, child := range node.children {
+			btc_tx.AddTxOut(child.out)
+		}
+
+		inputFetcher := txscript.NewCannedPrevOutputFetcher(node.out.PkScript, node.out.Value)
+		sigHash, err := txscript.CalcTaprootSignatureHash(txscript.NewTxSigHashes(btc_tx, inputFetcher), hType, btc_tx, 0, inputFetcher)
+		assert.Nil(v.suite.T, err)
+		vault_txs = append(vault_txs, &vaultTx{
+			tx:            btc_tx,
+			prevOuts:      []*wire.TxOut{node.out},
+			sigHashes:     [][32]byte{([32]byte)(sigHash)},
+			sessionOffset: session_offset,
+			payout:        true,
+		})
+		session_offset++
+
+		for i, child := range node.children {
+			if child.children == nil {
+				continue
+			}
+			nodes = append(nodes, child)
+			out_points = append(out_points, wire.OutPoint{Hash: btc_tx.TxHash(), Index: uint32(i)})
+		}
+	}
+
+	return vault_txs
+}
+
+// signed fan-out transactions of payout trees of the checkpoint at checkpoint_height, parents before children
+func (v *MockValidator) GetPayoutTxs(checkpoint_height int64) []*wire.MsgTx {
+	return v.getTxList(PAYOUT_TX_STORE_KEY, checkpoint_height)
+}
+
+// fan-out transactions from the root of a payout tree down to the one paying the first withdrawal to receiver
+// nil if no payout tree of the checkpoint pays receiver
+func (v *MockValidator) GetPayoutBranch(checkpoint_height int64, receiver string) []*wire.MsgTx {
+	payout_txs := v.GetPayoutTxs(checkpoint_height)
+	by_hash := make(map[string]*wire.MsgTx, len(payout_txs))
+	for _, payout_tx := range payout_txs {
+		by_hash[payout_tx.TxHash().String()] = payout_tx
+	}
+
+	pkScript := v.withdrawPkScript(&MsgWithdraw{Receiver: receiver})
+	for _, payout_tx := range payout_txs {
+		for _, txOut := range payout_tx.TxOut {
+			if !bytes.Equal(txOut.PkScript, pkScript) {
+				continue
+			}
+			branch := []*wire.MsgTx{payout_tx}
+			for parent, ok := by_hash[payout_tx.TxIn[0].PreviousOutPoint.Hash.String()]; ok; parent, ok = by_hash[parent.TxIn[0].PreviousOutPoint.Hash.String()] {
+				branch = append([]*wire.MsgTx{parent}, branch...)
+			}
+			return branch
+		}
+	}
+
+	return nil
+}
+
+// go test -v -run ^TestPayoutTree$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestPayoutTree(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	for _, validator := range validators {
+		validator.SetPayoutPolicy(PayoutPolicy{MinOutputs: 4, Radix: 3})
+	}
+
+	checkpoint_amount := int64(1000000000)
+	mockGenesisCheckPoint(&suite, validators, checkpoint_amount)
+	sendNonces(validators, 10)
+
+	// 8 withdrawals are paid through a tree of 3 fan-out transactions under a root fan-out transaction
+	withdrawals := generateMsgWithdrawList(&suite, 8)
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: withdrawals, Sequence: 1})
+	signing_index := query(validators[0], func() int64 { return validators[0].nextSigningIndex })
+	signCheckPoint(t, validators)
+	signed_tx := validators[0].GetSignedTxs(1)[0]
+	payout_txs := validators[0].GetPayoutTxs(1)
+	assert.Equal(t, 2, len(signed_tx.TxOut))
+	assert.Equal(t, 4, len(payout_txs))
+	assert.Equal(t, signing_index+5, query(validators[0], func() int64 { return validators[0].nextSigningIndex }))
+	for _, validator := range validators {
+		assert.Equal(t, 0, query(validator, validator.GetPendingTxsNum))
+		assert.Equal(t, len(payout_txs), len(query(validator, func() []*wire.MsgTx { return validator.GetPayoutTxs(1) })))
+	}
+
+	// the checkpoint transaction stays as small as paying a single withdrawal
+	payout_value := int64(0)
+	withdrawal_outs := make([]*wire.TxOut, len(withdrawals))
+	for i, withdrawal := range withdrawals {
+		payout_value += withdrawal.Amount
+		withdrawal_outs[i] = wire.NewTxOut(withdrawal.Amount, validators[0].withdrawPkScript(withdrawal))
+	}
+	flat_fee := validators[0].estimateVaultTxFee(1, signed_tx.TxOut[0], withdrawal_outs)
+	tree_fee := validators[0].estimateVaultTxFee(1, signed_tx.TxOut[0], signed_tx.TxOut[1:])
+	assert.Less(t, tree_fee, flat_fee)
+
+	// the root output carries all withdrawals and fees of the tree
+	tree_fees := int64(0)
+	for _, payout_tx := range payout_txs {
+		tree_fees += validators[0].estimateVaultTxFee(1, payout_tx.TxOut[0], payout_tx.TxOut[1:])
+	}
+	assert.Equal(t, payout_value+tree_fees, signed_tx.TxOut[1].Value)
+	assert.Equal(t, checkpoint_amount-signed_tx.TxOut[1].Value-tree_fee, signed_tx.TxOut[0].Value)
+
+	// the branch of the last receiver is unrolled without the rest of the tree
+	suite.MockMineTx(signed_tx, 1)
+	branch := validators[0].GetPayoutBranch(1, withdrawals[7].Receiver)
+	assert.Equal(t, 2, len(branch))
+	assert.Equal(t, signed_tx.TxHash(), branch[0].TxIn[0].PreviousOutPoint.Hash)
+	for i, payout_tx := range branch {
+		suite.ReadUtxoViewpoint(func(utxo_view *blockchain.UtxoViewpoint) {
+			err := blockchain.ValidateTransactionScripts(
+				btcutil.NewTx(payout_tx), utxo_view, txscript.StandardVerifyFlags, suite.SigCache, suite.HashCache,
+			)
+			assert.NoError(t, err)
+		})
+		suite.MockMineTx(payout_tx, int32(2+i))
+	}
+	assert.Equal(t, withdrawal_outs[6:], branch[1].TxOut)
+
+	// the next checkpoint spends the vault output, fan-out transactions are not deposits
+	scanBlock(validators, 2, branch)
+	for _, validator := range validators {
+		assert.Equal(t, 0, len(validator.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY]))
+	}
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 2), Sequence: 2})
+	signCheckPoint(t, validators)
+	signed_tx = validators[0].GetSignedTxs(2)[0]
+	assert.Equal(t, 3, len(signed_tx.TxOut))
+	assert.Nil(t, validators[0].GetPayoutTxs(2))
+}
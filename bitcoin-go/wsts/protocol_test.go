@@ -1,6 +1,7 @@
 package wsts
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -11,13 +12,13 @@ import (
 	"time"
 
 	"cosmossdk.io/math"
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
-	"github.com/btcsuite/btcd/txscript"
-	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
 	"github.com/stretchr/testify/assert"
-	"google.golang.org/protobuf/proto"
 )
 
 // serving as mock on - chain storage in each mock validator
@@ -100,6 +101,119 @@ func TestNewMockValidatorSet(t *testing.T) {
 	n_keys := int64(10)
 	threshold := int64(7)
 	message_num := 10
+
+	validators := setupMockValidatorSet(t, &suite, n, n_keys, threshold)
+
+	// transition between two phases
+	// set mock genesis btc checkpoint for the protocol
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+
+	// signing phase
+	// each validator will prepare nonce commitments and send to all other validators
+	time_now := time.Now()
+	sendNonces(validators, 1)
+	t.Logf("Nonce commitments have been sent, finished in %v", time.Since(time_now))
+
+	// users will submit requests to validators
+	// for brevity, users will submit withdraw transactions to a bitcoin vault address
+	// validators will then sign these transactions, producing signature adaptors
+	time_now = time.Now()
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, message_num), Sequence: 1})
+	t.Logf("Withdraw messages have been sent, finished in %v", time.Since(time_now))
+
+	// each validator will derive and send signature adaptors to all other validators
+	// in a production environment, validators are honest all the time, except for some rare cases
+	// this scheme protects against those rare cases
+	time_now = time.Now()
+	signCheckPoint(t, validators)
+	t.Logf("Done signing in %v", time.Since(time_now))
+
+	// stop all validators
+	for i := int64(0); i < n; i++ {
+		validators[i].Stop()
+	}
+}
+
+// go test -v -run ^TestCheckPointProgression$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestCheckPointProgression(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	n_keys := int64(10)
+	threshold := int64(7)
+	message_num := 10
+	epochs := int64(10)
+
+	validators := setupMockValidatorSet(t, &suite, n, n_keys, threshold)
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+
+	// prepare nonces for all epochs at once
+	sendNonces(validators, epochs)
+
+	for epoch := int64(1); epoch <= epochs; epoch++ {
+		time_now := time.Now()
+		prev_checkpoint := validators[0].getBtcCheckPoint(epoch - 1)
+		prev_hash, err := chainhash.NewHashFromStr(prev_checkpoint.OutHash)
+		assert.NoError(t, err)
+		prev_out := wire.OutPoint{Hash: *prev_hash, Index: prev_checkpoint.OutIndex}
+		prev_balance := suite.UtxoViewpoint.FetchPrevOutput(prev_out).Value
+
+		message_list := generateMsgWithdrawList(&suite, message_num)
+		sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: message_list, Sequence: epoch})
+		signCheckPoint(t, validators)
+
+		// all validators must have derived the same signed transaction
+		signed_tx := validators[0].GetSignedTxs(epoch)[0]
+		assert.NotNil(t, signed_tx)
+		for i := int64(1); i < n; i++ {
+			assert.Equal(t, signed_tx.TxHash(), validators[i].GetSignedTxs(epoch)[0].TxHash())
+		}
+
+		// signed transaction spends the previous checkpoint, and pays all withdrawals
+		assert.Equal(t, prev_out, signed_tx.TxIn[0].PreviousOutPoint)
+		assert.Equal(t, message_num+1, len(signed_tx.TxOut))
+		withdrawn := int64(0)
+		for _, withdraw := range message_list {
+			withdrawn += withdraw.Amount
+		}
+		// fee is exactly the virtual size of the signed transaction times fee rate
+		fee := (blockchain.GetTransactionWeight(btcutil.NewTx(signed_tx)) + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor * validators[0].btcFeeRate
+		assert.Equal(t, prev_balance-withdrawn-fee, signed_tx.TxOut[0].Value)
+
+		// validators have broadcasted the signed transaction once, and the mock Bitcoin chain mines it
+		mempool_txs := suite.MempoolTxs()
+		assert.Equal(t, 1, len(mempool_txs))
+		assert.Equal(t, signed_tx.TxHash(), mempool_txs[0].TxHash())
+		block := suite.MockMineMempool()
+		assert.Equal(t, signed_tx.TxHash(), block.Transactions[1].TxHash())
+		assert.Empty(t, suite.MempoolTxs())
+
+		// new checkpoint points at output 0 and included withdrawals are cleared
+		for i := int64(0); i < n; i++ {
+			checkpoint := validators[i].getBtcCheckPoint(epoch)
+			assert.Equal(t, epoch, checkpoint.Height)
+			assert.Equal(t, signed_tx.TxHash().String(), checkpoint.OutHash)
+			assert.Equal(t, uint32(0), checkpoint.OutIndex)
+			assert.Equal(t, 0, validators[i].GetPendingTxsNum())
+		}
+		assert.True(t, suite.UtxoViewpoint.LookupEntry(prev_out).IsSpent())
+		checkpoint_entry := suite.UtxoViewpoint.LookupEntry(wire.OutPoint{Hash: signed_tx.TxHash(), Index: 0})
+		assert.NotNil(t, checkpoint_entry)
+		assert.Equal(t, signed_tx.TxOut[0].Value, checkpoint_entry.Amount())
+		assert.Equal(t, int32(suite.MockTipHeight()), checkpoint_entry.BlockHeight())
+
+		t.Logf("Checkpoint %d has been finalized in %v", epoch, time.Since(time_now))
+	}
+
+	for i := int64(0); i < n; i++ {
+		assert.Equal(t, epochs+1, validators[i].GetCheckPointHeight())
+		validators[i].Stop()
+	}
+}
+
+// setup validators with random vp, then run DKG until all validators have the group public key
+func setupMockValidatorSet(t *testing.T, suite *testhelper.TestSuite, n, n_keys, threshold int64) []*MockValidator {
 	validators := make([]*MockValidator, n)
 	for i := int64(0); i < n; i++ {
 		path := fmt.Sprintf("../debug/validator_%d.log", i+1)
@@ -107,22 +221,15 @@ func TestNewMockValidatorSet(t *testing.T) {
 		assert.NoError(suite.T, err)
 		logger := log.New(file, "", log.LstdFlags)
 
-		frost := testhelper.NewFrostParticipant(&suite, logger, n_keys, threshold, i+1, nil)
+		frost := testhelper.NewFrostParticipant(suite, logger, n_keys, threshold, i+1, nil)
 
-		validators[i] = NewMockValidator(&suite, logger, file, frost, n, i+1)
+		validators[i] = NewMockValidator(suite, logger, file, frost, n, i+1)
 	}
 
-	deriveValidatorvp(&suite, validators)
+	deriveValidatorvp(suite, validators)
 
-	// peer discovery phase
-	// validators will only exchange with one another through otherVals
-	for i := int64(0); i < n; i++ {
-		for j := int64(0); j < n; j++ {
-			if i != j {
-				validators[i].otherVals[j+1] = validators[j]
-			}
-		}
-	}
+	connectValidators(validators)
+	recordTranscripts(t, validators, wallClock())
 
 	time_now := time.Now()
 	var wgGroup sync.WaitGroup
@@ -130,37 +237,15 @@ func TestNewMockValidatorSet(t *testing.T) {
 	for i := int64(0); i < n; i++ {
 		wgGroup.Add(1)
 		go func(posi int64) {
-			validators[posi].SendVPToAll()
+			validators[posi].Do(validators[posi].SendVPToAll)
 			wgGroup.Done()
 		}(i)
 	}
 	wgGroup.Wait()
 	t.Logf("VPs have been updated, finished in %v", time.Since(time_now))
 
-	// key generation phase first round
-	// each validator i sends (A_i, R_i, \mu_i) to all other validators
 	time_now = time.Now()
-	for i := int64(0); i < n; i++ {
-		wgGroup.Add(1)
-		go func(posi int64) {
-			validators[posi].DeriveAndSendProofs()
-			wgGroup.Done()
-		}(i)
-	}
-	wgGroup.Wait()
-	t.Logf("Secret proofs have been sent, finished in %v", time.Since(time_now))
-
-	// key generation phase second round
-	// each validator then sends secret shares to all other validators through secure, private channel
-	time_now = time.Now()
-	for i := int64(0); i < n; i++ {
-		wgGroup.Add(1)
-		go func(posi int64) {
-			validators[posi].DeriveAndSendSecretShares()
-			wgGroup.Done()
-		}(i)
-	}
-	wgGroup.Wait()
+	runDKG(t, validators)
 
 	// probing to see if all validators have GroupPublicKey
 	validators_with_group_pubkey := make(map[int64]bool)
@@ -168,7 +253,7 @@ func TestNewMockValidatorSet(t *testing.T) {
 		missing_list := make([]int64, 0)
 		for i := int64(0); i < n; i++ {
 			if _, ok := validators_with_group_pubkey[i]; !ok {
-				if validators[i].frost.GroupPublicKey != nil {
+				if query(validators[i], func() bool { return validators[i].frost.GroupPublicKey != nil }) {
 					validators_with_group_pubkey[i] = true
 				} else {
 					missing_list = append(missing_list, i+1)
@@ -188,130 +273,236 @@ func TestNewMockValidatorSet(t *testing.T) {
 
 		time.Sleep(3 * time.Second)
 	}
-	t.Logf("Secret shares have been sent, finished in %v", time.Since(time_now))
+	t.Logf("DKG has finished in %v", time.Since(time_now))
 
-	// transition between two phases
-	// set mock genesis btc checkpoint for the protocol
-	trScript, err := txscript.PayToTaprootScript(validators[0].frost.GroupPublicKey)
-	assert.NoError(suite.T, err)
-	first_tx := suite.NewMockFirstTx(trScript, 1000000000)
-	tx_out_index := uint32(0)
-	suite.UtxoViewpoint.AddTxOut(btcutil.NewTx(first_tx), tx_out_index, 0)
-	for i := int64(0); i < n; i++ {
-		validators[i].MockSetGenesisCheckPoint(first_tx, tx_out_index)
+	return validators
+}
+
+// peer discovery phase
+// validators will only exchange with one another through otherVals, and know validator keys of one another
+func connectValidators(validators []*MockValidator) {
+	for _, validator := range validators {
+		for _, other := range validators {
+			validator.SetValidatorPubKey(other.position, other.keyPair.Pub)
+			if validator != other {
+				validator.otherVals[other.position] = other
+			}
+		}
 	}
+}
 
-	// signing phase
-	// each validator will prepare nonce commitments and send to all other validators
-	time_now = time.Now()
+// DKG rounds on the active frost participant of all validators
+func runDKG(t *testing.T, validators []*MockValidator) {
+	n := int64(len(validators))
+	var wgGroup sync.WaitGroup
+	// key generation phase first round
+	// each validator i sends (A_i, R_i, \mu_i) to all other validators
+	time_now := time.Now()
 	for i := int64(0); i < n; i++ {
 		wgGroup.Add(1)
 		go func(posi int64) {
-			validators[posi].DeriveAndSendNonces()
+			validators[posi].Do(validators[posi].DeriveAndSendProofs)
 			wgGroup.Done()
 		}(i)
 	}
 	wgGroup.Wait()
+	t.Logf("Secret proofs have been sent, finished in %v", time.Since(time_now))
 
-	t.Logf("Nonce commitments have been sent, finished in %v", time.Since(time_now))
-
-	// users will submit requests to validators
-	// for brevity, users will submit withdraw transactions to a bitcoin vault address
-	// validators will then sign these transactions, producing signature adaptors
+	// key generation phase second round
+	// each validator then sends secret shares to all other validators through secure, private channel
 	time_now = time.Now()
-	message_list := generateMsgWithdrawList(&suite, message_num)
 	for i := int64(0); i < n; i++ {
 		wgGroup.Add(1)
 		go func(posi int64) {
-			msgStruct := &MsgBatchWithdraw{
-				WithdrawBatch: message_list,
-			}
-			msgBytes, err := proto.Marshal(msgStruct)
-			assert.NoError(t, err)
-
-			validators[posi].SendMessageOnChain(append([]byte{MSG_WITHDRAW_BATCH}, msgBytes...))
+			validators[posi].Do(validators[posi].DeriveAndSendSecretShares)
 			wgGroup.Done()
 		}(i)
 	}
 	wgGroup.Wait()
+	t.Logf("Secret shares have been sent, finished in %v", time.Since(time_now))
+}
 
-	t.Logf("Withdraw messages have been sent, finished in %v", time.Since(time_now))
+// fund the group taproot address and set it as the genesis checkpoint of all validators
+func mockGenesisCheckPoint(suite *testhelper.TestSuite, validators []*MockValidator, amount int64) *wire.MsgTx {
+	trScript := query(validators[0], func() []byte {
+		return validators[0].vaultPkScript(validators[0].frost.GroupPublicKey)
+	})
+	first_tx := suite.NewMockFirstTx(trScript, amount)
+	tx_out_index := uint32(0)
+	suite.UtxoViewpoint.AddTxOut(btcutil.NewTx(first_tx), tx_out_index, 0)
+	for _, validator := range validators {
+		validator.Do(func() {
+			validator.MockSetGenesisCheckPoint(first_tx, tx_out_index)
+		})
+	}
 
-	// each validator will derive and send signature adaptors to all other validators
-	// in a production environment, validators are honest all the time, except for some rare cases
-	// this scheme protects against those rare cases
-	time_now = time.Now()
-	for i := int64(0); i < n; i++ {
+	return first_tx
+}
+
+func sendNonces(validators []*MockValidator, signing_num int64) {
+	var wgGroup sync.WaitGroup
+	for _, validator := range validators {
 		wgGroup.Add(1)
-		go func(posi int64) {
+		go func(validator *MockValidator) {
+			validator.Do(func() {
+				validator.DeriveAndSendNonces(signing_num)
+			})
+			wgGroup.Done()
+		}(validator)
+	}
+	wgGroup.Wait()
+}
+
+// submit the same withdraw batch to all validators, and wait until all validators have stored it
+func sendWithdrawBatch(t *testing.T, validators []*MockValidator, batch *MsgBatchWithdraw) {
+	var wgGroup sync.WaitGroup
+	expected_pending := make([]int, len(validators))
+	for i, validator := range validators {
+		expected_pending[i] = query(validator, validator.GetPendingTxsNum) + len(batch.WithdrawBatch)
+		wgGroup.Add(1)
+		go func(validator *MockValidator) {
+			// withdraw batch is read from chain by each validator itself
+			validator.SendMessageOnChain(validator.sealEnvelope(&Envelope_BatchWithdraw{BatchWithdraw: batch}))
+			wgGroup.Done()
+		}(validator)
+	}
+	wgGroup.Wait()
+
+	// probing to see if all validators have stored the withdraw batch
+	for i, validator := range validators {
+		for query(validator, validator.GetPendingTxsNum) != expected_pending[i] {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// all validators sign the current checkpoint, then wait until all validators have finalized it
+func signCheckPoint(t *testing.T, validators []*MockValidator) {
+	var wgGroup sync.WaitGroup
+	checkpoint_heights := make([]int64, len(validators))
+	for i, validator := range validators {
+		checkpoint_heights[i] = query(validator, validator.GetCheckPointHeight)
+		wgGroup.Add(1)
+		go func(validator *MockValidator) {
 			retry_time := 5
 			for retry_time > 0 {
-				err := validators[posi].DeriveTxAndSign()
+				err := query(validator, validator.DeriveTxAndSign)
 				if err == nil {
 					break
 				}
-				t.Logf("retry signing for validator %d", posi)
+				t.Logf("retry signing for validator %d", validator.GetPosition())
 				retry_time--
 				time.Sleep(3 * time.Second)
 			}
 			wgGroup.Done()
-		}(i)
+		}(validator)
 	}
 	wgGroup.Wait()
 
-	t.Logf("Done signing in %v", time.Since(time_now))
-
-	// stop all validators
-	for i := int64(0); i < n; i++ {
-		validators[i].Stop()
+	// probing to see if all validators have finalized the checkpoint
+	for i, validator := range validators {
+		timeout := time.After(30 * time.Second)
+		for query(validator, validator.GetCheckPointHeight) == checkpoint_heights[i] {
+			select {
+			case <-timeout:
+				t.Fatalf("validator %d has not finalized checkpoint %d", validator.GetPosition(), checkpoint_heights[i])
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
 	}
 }
 
 func NewMockValidator(suite *testhelper.TestSuite, logger *log.Logger, file *os.File, frost *testhelper.FrostParticipant, party_num, position int64) *MockValidator {
-	priv := secp256k1.GenPrivKey()
-	keyPair := suite.NewKeyPairFromBytes(priv.Bytes())
+	validator := newMockValidator(suite, logger, file, frost, party_num, position)
+	validator.Start(context.Background())
+
+	return validator
+}
+
+// validator without an event loop, messages are handled by whoever delivers them
+func newMockValidator(suite *testhelper.TestSuite, logger *log.Logger, file *os.File, frost *testhelper.FrostParticipant, party_num, position int64) *MockValidator {
+	// drawn from the suite, so a seeded suite gives the same validator keys
+	keyPair := suite.NewKeyPairFromBytes(nil)
 
 	validator := &MockValidator{
-		suite:         suite,
-		logger:        logger,
-		file:          file,
-		keyPair:       keyPair,
-		frost:         frost,
-		partyNum:      party_num,
-		btcGasFee:     1000,
-		otherVals:     make(map[int64]ReceivableValidator),
-		dishonestVals: make(map[int64]bool),
+		suite:                 suite,
+		logger:                logger,
+		file:                  file,
+		keyPair:               keyPair,
+		frost:                 frost,
+		partyNum:              party_num,
+		btcFeeRate:            DEFAULT_BTC_FEE_RATE,
+		depositConfirmations:  DEFAULT_DEPOSIT_CONFIRMATIONS,
+		finalityConfirmations: DEFAULT_FINALITY_CONFIRMATIONS,
+		headerChain:           testhelper.NewHeaderChain(suite.BtcdChainConfig),
+		otherVals:             make(map[int64]ReceivableValidator),
+		dishonestVals:         make(map[int64]bool),
 		localStorage: MockProtocolStorage{
 			store: make(map[string]map[string][]byte),
 		},
 		protocolStorage: MockProtocolStorage{
 			store: make(map[string]map[string][]byte),
 		},
-		position:        position,
-		msgChanOnChain:  make(chan []byte),
-		msgChanOffChain: make(chan []byte),
+		position:      position,
+		seenSequences: make(map[int64]map[uint64]bool),
+		mailbox:       newMailbox(),
 	}
 
 	// initialize protocol storage for vp
 	validator.protocolStorage.store[VP_STORE_KEY] = make(map[string][]byte)
 
+	// initialize protocol storage for validator keys that sign envelopes
+	validator.protocolStorage.store[VALIDATOR_PUB_KEY_STORE_KEY] = make(map[string][]byte)
+
 	// initialize protocol storage for key ranges
 	validator.protocolStorage.store[KEY_RANGE_STORE_KEY] = make(map[string][]byte)
 
-	// initialize protocol storage for transactions
+	// initialize protocol storage for transactions, the checkpoint height each one is queued at, rejected withdrawals and paid withdrawals
 	validator.protocolStorage.store[TRANSACTION_STORE_KEY] = make(map[string][]byte)
+	validator.protocolStorage.store[WITHDRAW_QUEUED_HEIGHT_STORE_KEY] = make(map[string][]byte)
+	validator.protocolStorage.store[WITHDRAW_REJECTION_STORE_KEY] = make(map[string][]byte)
+	validator.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY] = make(map[string][]byte)
+
+	// initialize protocol storage for emergency pause votes and the pause flag
+	validator.protocolStorage.store[PAUSE_VOTE_STORE_KEY] = make(map[string][]byte)
+	validator.protocolStorage.store[WITHDRAW_PAUSED_STORE_KEY] = make(map[string][]byte)
 
 	// initialize protocol storage for Bitcoin chain checkpoint
 	validator.protocolStorage.store[CHECKPOINT_STORE_KEY] = make(map[string][]byte)
 
+	// initialize protocol storage for signed transactions of each checkpoint
+	validator.protocolStorage.store[SIGNED_TX_STORE_KEY] = make(map[string][]byte)
+	validator.protocolStorage.store[PAYOUT_TX_STORE_KEY] = make(map[string][]byte)
+
+	// initialize protocol storage for credited and consolidated deposits
+	validator.protocolStorage.store[DEPOSIT_STORE_KEY] = make(map[string][]byte)
+	validator.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY] = make(map[string][]byte)
+
+	// initialize protocol storage for proofs of reserves
+	validator.protocolStorage.store[RESERVES_PROOF_STORE_KEY] = make(map[string][]byte)
+
+	// initialize local storage for deposits waiting for confirmations
+	validator.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY] = make(map[string][]byte)
+
+	// initialize local storage for vault txs proven included in btc blocks
+	validator.localStorage.store[VAULT_TX_INCLUSION_STORE_KEY] = make(map[string][]byte)
+
+	// initialize protocol storage for polynomial commitments and nonce commitments envelopes of each validator
+	validator.protocolStorage.store[PROOFS_ENVELOPE_STORE_KEY] = make(map[string][]byte)
+	validator.protocolStorage.store[NONCE_ENVELOPE_STORE_KEY] = make(map[string][]byte)
+
+	// initialize local storage for evidence of misbehaving validators
+	validator.localStorage.store[EVIDENCE_STORE_KEY] = make(map[string][]byte)
+
 	// initialize local storage for secret shares from each validator
 	validator.localStorage.store[SECRET_SHARES_STORE_KEY] = make(map[string][]byte)
 
 	// initialize local storage for long term secret shares
 	validator.localStorage.store[LONG_TERM_SECRET_SHARES_KEY] = make(map[string][]byte)
 
-	go validator.ReceiveMessageOnChainLoop()
-	go validator.ReceiveMessageOffChainLoop()
+	// initialize local storage for secret nonces that have not been used, and nonces that have been used
+	validator.localStorage.store[SIGNING_NONCE_STORE_KEY] = make(map[string][]byte)
+	validator.localStorage.store[USED_NONCE_STORE_KEY] = make(map[string][]byte)
 
 	return validator
 }
@@ -319,7 +510,11 @@ func NewMockValidator(suite *testhelper.TestSuite, logger *log.Logger, file *os.
 // assign vp to all validators
 // so that all validators have 100% voting power
 func deriveValidatorvp(suite *testhelper.TestSuite, validators []*MockValidator) {
-	randsource := rand.New(rand.NewSource(time.Now().UnixNano()))
+	deriveValidatorvpFromRand(suite, validators, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+// a seeded rand source derives the same vp when a run is replayed
+func deriveValidatorvpFromRand(suite *testhelper.TestSuite, validators []*MockValidator, randsource *rand.Rand) {
 	total := int64(0)
 	validators_vp := make([]math.LegacyDec, len(validators))
 
@@ -363,8 +558,8 @@ func vpToBytes(suite *testhelper.TestSuite, vp math.LegacyDec) []byte {
 func generateMsgWithdrawList(suite *testhelper.TestSuite, message_num int) []*MsgWithdraw {
 	msgList := make([]*MsgWithdraw, message_num)
 	for i := 0; i < message_num; i++ {
-		// random amount
-		amount := rand.Int63n(1000000)
+		// random amount above the dust limit, a dust withdrawal is rejected and never becomes pending
+		amount := 1000 + rand.Int63n(1000000)
 		// random address
 		_, key_pair := suite.NewHDKeyPairFromSeed("")
 		// p2tr pubkey
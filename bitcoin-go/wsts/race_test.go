@@ -0,0 +1,6 @@
+//go:build race
+
+package wsts
+
+// the race detector slows down large simulations by an order of magnitude
+const raceEnabled = true
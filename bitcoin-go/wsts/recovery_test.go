@@ -0,0 +1,337 @@
+package wsts
+
+import (
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+// relative locktime of BIP68 is at most 0xffff blocks
+const MAX_RECOVERY_DELAY = wire.SequenceLockTimeMask
+
+// tapscript leaves that the vault output commits to, so that funds are not stuck if validators never reach threshold again
+// the group key stays the internal key, and signs through key path as long as the federation is alive
+// zero value means the vault output commits to no leaf
+type RecoveryPolicy struct {
+	// emergency multisig held by a guardian set outside of the federation, spendable after guardian delay blocks
+	GuardianKeys      []*btcec.PublicKey
+	GuardianThreshold int64
+	GuardianDelay     uint32
+	// validator keys can spend with a lower threshold after each longer timeout
+	DegradingThresholds []DegradedThreshold
+}
+
+type DegradedThreshold struct {
+	// number of validator keys that must sign
+	Threshold int64
+	// relative locktime in blocks
+	Delay uint32
+}
+
+// a recovery leaf, threshold of keys can spend once the vault output is delay blocks deep
+type RecoveryLeaf struct {
+	Keys      []*btcec.PublicKey
+	Threshold int64
+	Delay     uint32
+}
+
+func (p *RecoveryPolicy) isZero() bool {
+	return len(p.GuardianKeys) == 0 && len(p.DegradingThresholds) == 0
+}
+
+func (p *RecoveryPolicy) validate(validator_num int64) error {
+	if len(p.GuardianKeys) > 0 {
+		if p.GuardianThreshold < 1 || p.GuardianThreshold > int64(len(p.GuardianKeys)) {
+			return fmt.Errorf("guardian threshold %d is out of range of %d guardian keys", p.GuardianThreshold, len(p.GuardianKeys))
+		}
+		if p.GuardianDelay < 1 || p.GuardianDelay > MAX_RECOVERY_DELAY {
+			return fmt.Errorf("guardian delay %d is out of range", p.GuardianDelay)
+		}
+	}
+
+	// each stage waits longer than the one before, for fewer validator keys
+	prev := DegradedThreshold{Threshold: validator_num + 1}
+	for i, stage := range p.DegradingThresholds {
+		if stage.Threshold < 1 || stage.Threshold >= prev.Threshold {
+			return fmt.Errorf("degraded threshold %d of stage %d must be positive and lower than %d", stage.Threshold, i, prev.Threshold)
+		}
+		if stage.Delay <= prev.Delay || stage.Delay > MAX_RECOVERY_DELAY {
+			return fmt.Errorf("delay %d of stage %d must be longer than %d and at most %d", stage.Delay, i, prev.Delay, MAX_RECOVERY_DELAY)
+		}
+		prev = stage
+	}
+
+	return nil
+}
+
+// all validators must set the same policy, otherwise they derive different vault outputs
+// validator keys of degraded thresholds are the keys of all validators, they must be known before
+func (v *MockValidator) SetRecoveryPolicy(policy RecoveryPolicy) error {
+	if err := policy.validate(v.partyNum); err != nil {
+		return err
+	}
+	for posi := int64(1); posi <= v.partyNum; posi++ {
+		if len(policy.DegradingThresholds) > 0 && v.getValidatorPubKey(posi) == nil {
+			return fmt.Errorf("public key of validator %d is unknown", posi)
+		}
+	}
+
+	v.recoveryPolicy = policy
+	var root []byte
+	if tree := v.recoveryTapTree(); tree != nil {
+		tree_root := tree.RootNode.TapHash()
+		root = tree_root[:]
+	}
+	v.frost.TapScriptRoot = root
+	if v.standbyGroup != nil {
+		v.standbyGroup.frost.TapScriptRoot = root
+	}
+
+	return nil
+}
+
+// guardian leaf first, then degraded thresholds in order of their delay
+func (v *MockValidator) recoveryLeaves() []*RecoveryLeaf {
+	policy := v.recoveryPolicy
+	leaves := make([]*RecoveryLeaf, 0)
+	if len(policy.GuardianKeys) > 0 {
+		leaves = append(leaves, &RecoveryLeaf{
+			Keys:      policy.GuardianKeys,
+			Threshold: policy.GuardianThreshold,
+			Delay:     policy.GuardianDelay,
+		})
+	}
+
+	validator_keys := make([]*btcec.PublicKey, 0, v.partyNum)
+	for posi := int64(1); posi <= v.partyNum; posi++ {
+		validator_keys = append(validator_keys, v.getValidatorPubKey(posi))
+	}
+	for _, stage := range policy.DegradingThresholds {
+		leaves = append(leaves, &RecoveryLeaf{
+			Keys:      validator_keys,
+			Threshold: stage.Threshold,
+			Delay:     stage.Delay,
+		})
+	}
+
+	return leaves
+}
+
+// nil if the vault output commits to no recovery leaf
+func (v *MockValidator) recoveryTapTree() *txscript.IndexedTapScriptTree {
+	if v.recoveryPolicy.isZero() {
+		return nil
+	}
+
+	tapLeaves := make([]txscript.TapLeaf, 0)
+	for _, leaf := range v.recoveryLeaves() {
+		script, err := leaf.Script()
+		assert.NoError(v.suite.T, err)
+		tapLeaves = append(tapLeaves, txscript.NewBaseTapLeaf(script))
+	}
+
+	return txscript.AssembleTaprootScriptTree(tapLeaves...)
+}
+
+// taproot output of the vault under a group key, committing to the recovery leaves of this validator
+func (v *MockValidator) vaultPkScript(group_key *btcec.PublicKey) []byte {
+	output_key := group_key
+	if v.frost.TapScriptRoot != nil {
+		output_key = txscript.ComputeTaprootOutputKey(group_key, v.frost.TapScriptRoot)
+	}
+	trScript, err := txscript.PayToTaprootScript(output_key)
+	assert.NoError(v.suite.T, err)
+
+	return trScript
+}
+
+// <delay> OP_CHECKSEQUENCEVERIFY OP_DROP <key_1> OP_CHECKSIG <key_2> OP_CHECKSIGADD ... <key_n> OP_CHECKSIGADD <threshold> OP_NUMEQUAL
+func (l *RecoveryLeaf) Script() ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	builder.AddInt64(int64(l.Delay))
+	builder.AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+	builder.AddOp(txscript.OP_DROP)
+	for i, key := range l.Keys {
+		builder.AddData(schnorr.SerializePubKey(key))
+		if i == 0 {
+			builder.AddOp(txscript.OP_CHECKSIG)
+		} else {
+			builder.AddOp(txscript.OP_CHECKSIGADD)
+		}
+	}
+	builder.AddInt64(l.Threshold)
+	builder.AddOp(txscript.OP_NUMEQUAL)
+
+	return builder.Script()
+}
+
+// spend a vault output through the recovery leaf at leaf index, to pkScript
+// signers are private keys by the index of their key in the leaf, and sequence is the relative locktime of the input
+func spendRecoveryLeaf(t *testing.T, tree *txscript.IndexedTapScriptTree, internal_key *btcec.PublicKey, leaf *RecoveryLeaf, leaf_index int, prev_out wire.OutPoint, prev_tx_out *wire.TxOut, signers map[int]*btcec.PrivateKey, sequence uint32, pkScript []byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: prev_out,
+		Sequence:         sequence,
+	})
+	tx.AddTxOut(wire.NewTxOut(prev_tx_out.Value-1000, pkScript))
+
+	inputFetcher := txscript.NewCannedPrevOutputFetcher(prev_tx_out.PkScript, prev_tx_out.Value)
+	sigHashes := txscript.NewTxSigHashes(tx, inputFetcher)
+	tapLeaf := tree.LeafMerkleProofs[leaf_index].TapLeaf
+
+	// the first key is checked first, so its signature is at the top of the stack
+	witness := wire.TxWitness{}
+	for i := len(leaf.Keys) - 1; i >= 0; i-- {
+		priv, ok := signers[i]
+		if !ok {
+			witness = append(witness, []byte{})
+			continue
+		}
+		sig, err := txscript.RawTxInTapscriptSignature(tx, sigHashes, 0, prev_tx_out.Value, prev_tx_out.PkScript, tapLeaf, txscript.SigHashDefault, priv)
+		assert.NoError(t, err)
+		witness = append(witness, sig)
+	}
+	ctrlBlock := tree.LeafMerkleProofs[leaf_index].ToControlBlock(internal_key)
+	ctrlBlockBytes, err := ctrlBlock.ToBytes()
+	assert.NoError(t, err)
+	witness = append(witness, tapLeaf.Script, ctrlBlockBytes)
+	tx.TxIn[0].Witness = witness
+
+	return tx
+}
+
+// validate scripts of a recovery spend against the static utxo viewpoint
+func validateRecoverySpend(suite *testhelper.TestSuite, tx *wire.MsgTx, prev_tx_out *wire.TxOut) error {
+	inputFetcher := txscript.NewCannedPrevOutputFetcher(prev_tx_out.PkScript, prev_tx_out.Value)
+	hashCache := txscript.NewHashCache(1)
+	hashCache.AddSigHashes(tx, inputFetcher)
+
+	var err error
+	suite.ReadUtxoViewpoint(func(utxo_view *blockchain.UtxoViewpoint) {
+		err = blockchain.ValidateTransactionScripts(
+			btcutil.NewTx(tx), utxo_view, txscript.StandardVerifyFlags, txscript.NewSigCache(1), hashCache,
+		)
+	})
+
+	return err
+}
+
+// go test -v -run ^TestRecoveryLeaves$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestRecoveryLeaves(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+
+	guardians := make([]testhelper.KeyPair, 3)
+	guardian_keys := make([]*btcec.PublicKey, 3)
+	for i := range guardians {
+		_, guardians[i] = suite.NewHDKeyPairFromSeed("")
+		guardian_keys[i] = guardians[i].Pub
+	}
+	policy := RecoveryPolicy{
+		GuardianKeys:      guardian_keys,
+		GuardianThreshold: 2,
+		GuardianDelay:     144,
+		DegradingThresholds: []DegradedThreshold{
+			{Threshold: 3, Delay: 1008},
+			{Threshold: 2, Delay: 4032},
+		},
+	}
+
+	// a degraded threshold is never raised, nor reached earlier
+	assert.Error(t, validators[0].SetRecoveryPolicy(RecoveryPolicy{DegradingThresholds: []DegradedThreshold{{Threshold: 2, Delay: 1008}, {Threshold: 3, Delay: 4032}}}))
+	assert.Error(t, validators[0].SetRecoveryPolicy(RecoveryPolicy{DegradingThresholds: []DegradedThreshold{{Threshold: 3, Delay: 1008}, {Threshold: 2, Delay: 144}}}))
+	assert.Error(t, validators[0].SetRecoveryPolicy(RecoveryPolicy{GuardianKeys: guardian_keys, GuardianThreshold: 4, GuardianDelay: 144}))
+	assert.Error(t, validators[0].SetRecoveryPolicy(RecoveryPolicy{GuardianKeys: guardian_keys, GuardianThreshold: 2, GuardianDelay: MAX_RECOVERY_DELAY + 1}))
+	for _, validator := range validators {
+		assert.NoError(t, query(validator, func() error { return validator.SetRecoveryPolicy(policy) }))
+	}
+
+	// vault output commits to the recovery leaves under the group key
+	group_key := query(validators[0], func() *btcec.PublicKey { return validators[0].frost.GroupPublicKey })
+	tree := query(validators[0], validators[0].recoveryTapTree)
+	leaves := query(validators[0], validators[0].recoveryLeaves)
+	assert.Equal(t, 3, len(leaves))
+	root := tree.RootNode.TapHash()
+	trScript, err := txscript.PayToTaprootScript(txscript.ComputeTaprootOutputKey(group_key, root[:]))
+	assert.NoError(t, err)
+	genesis_tx := mockGenesisCheckPoint(&suite, validators, 1000000000)
+	assert.Equal(t, trScript, genesis_tx.TxOut[0].PkScript)
+
+	// validators still sign through key path, with the tweaked output key
+	sendNonces(validators, 1)
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 5), Sequence: 1})
+	signCheckPoint(t, validators)
+	signed_tx := query(validators[0], func() *wire.MsgTx { return validators[0].GetSignedTxs(1)[0] })
+	assert.Equal(t, 1, len(signed_tx.TxIn[0].Witness))
+	assert.Equal(t, trScript, signed_tx.TxOut[0].PkScript)
+	checkpoint_height := int32(1)
+	suite.MockMineTx(signed_tx, checkpoint_height)
+
+	// the federation stalls, the checkpoint output is recovered through each leaf once it is deep enough
+	checkpoint_out := wire.OutPoint{Hash: signed_tx.TxHash(), Index: 0}
+	checkpoint_tx_out := signed_tx.TxOut[0]
+	_, recovery_key := suite.NewHDKeyPairFromSeed("")
+	recovery_script, err := txscript.PayToTaprootScript(recovery_key.Pub)
+	assert.NoError(t, err)
+	validator_privs := func(positions ...int) map[int]*btcec.PrivateKey {
+		privs := make(map[int]*btcec.PrivateKey)
+		for _, posi := range positions {
+			privs[posi-1] = validators[posi-1].keyPair.GetTestPriv()
+		}
+		return privs
+	}
+	testcases := []struct {
+		name    string
+		signers map[int]*btcec.PrivateKey
+	}{
+		{"guardians", map[int]*btcec.PrivateKey{0: guardians[0].GetTestPriv(), 2: guardians[2].GetTestPriv()}},
+		{"3 of 4 validators", validator_privs(1, 2, 4)},
+		{"2 of 4 validators", validator_privs(2, 3)},
+	}
+	for leaf_index, tc := range testcases {
+		leaf := leaves[leaf_index]
+
+		// recovery leaf cannot be spent before its relative locktime
+		early_tx := spendRecoveryLeaf(t, tree, group_key, leaf, leaf_index, checkpoint_out, checkpoint_tx_out, tc.signers, leaf.Delay-1, recovery_script)
+		assert.Error(t, validateRecoverySpend(&suite, early_tx, checkpoint_tx_out), tc.name)
+
+		recovery_tx := spendRecoveryLeaf(t, tree, group_key, leaf, leaf_index, checkpoint_out, checkpoint_tx_out, tc.signers, leaf.Delay, recovery_script)
+		assert.NoError(t, validateRecoverySpend(&suite, recovery_tx, checkpoint_tx_out), tc.name)
+		assert.Error(t, suite.CheckSequenceLocks(recovery_tx, checkpoint_height+int32(leaf.Delay)-1), tc.name)
+		assert.NoError(t, suite.CheckSequenceLocks(recovery_tx, checkpoint_height+int32(leaf.Delay)), tc.name)
+
+		// one signature short of the threshold of the leaf
+		short_signers := make(map[int]*btcec.PrivateKey)
+		for i, priv := range tc.signers {
+			if int64(len(short_signers)) < leaf.Threshold-1 {
+				short_signers[i] = priv
+			}
+		}
+		short_tx := spendRecoveryLeaf(t, tree, group_key, leaf, leaf_index, checkpoint_out, checkpoint_tx_out, short_signers, leaf.Delay, recovery_script)
+		assert.Error(t, validateRecoverySpend(&suite, short_tx, checkpoint_tx_out), tc.name)
+	}
+
+	// validators cannot take the degraded threshold with the delay of the guardians
+	degraded_tx := spendRecoveryLeaf(t, tree, group_key, leaves[2], 2, checkpoint_out, checkpoint_tx_out, validator_privs(2, 3), policy.GuardianDelay, recovery_script)
+	assert.Error(t, validateRecoverySpend(&suite, degraded_tx, checkpoint_tx_out))
+
+	// the recovered vault output is spent once
+	suite.MockMineTx(spendRecoveryLeaf(t, tree, group_key, leaves[0], 0, checkpoint_out, checkpoint_tx_out, testcases[0].signers, policy.GuardianDelay, recovery_script), checkpoint_height+int32(policy.GuardianDelay))
+}
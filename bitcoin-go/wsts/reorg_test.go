@@ -0,0 +1,351 @@
+package wsts
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cosmossdk.io/math"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// btc blocks, a reorg is assumed to never reach a block with as many confirmations as a coinbase needs to mature
+	DEFAULT_FINALITY_CONFIRMATIONS = int64(100)
+)
+
+func (v *MockValidator) SetFinalityConfirmations(confirmations int64) {
+	v.finalityConfirmations = confirmations
+}
+
+// withdrawals paid by a checkpoint are only kept for its rollback, which a reorg can no longer cause once it is final
+func (v *MockValidator) pruneFinalCheckPoints() {
+	for key := range v.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY] {
+		checkpoint_height, err := strconv.ParseInt(key, 10, 64)
+		assert.NoError(v.suite.T, err)
+		if confirmations := v.GetCheckPointConfirmations(checkpoint_height); confirmations > 0 && confirmations >= v.finalityConfirmations {
+			v.logger.Printf("checkpoint %d is final, its paid withdrawals are pruned\n", checkpoint_height)
+			delete(v.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY], key)
+		}
+	}
+}
+
+// btc blocks above fork height have been reorganized out of the best chain
+//
+// deposits in those blocks are no longer credited, and inclusions of vault txs in those blocks are forgotten
+// a vault tx that has only dropped out can be mined again as it is, and the next checkpoint waits for its confirmations
+// a checkpoint that consolidated a deposit which dropped out can never be mined, so it is rolled back together with all checkpoints after it
+// its replacement is signed again from the same previous checkpoint, so that at most one of them can ever be mined
+// a checkpoint under a group key that has been migrated away cannot be replaced, the vault no longer matches the chain
+// so this validator votes to pause withdrawals, and the error is returned for the operator
+func (v *MockValidator) handleBtcReorg(fork_height int64) error {
+	v.logger.Printf("btc reorg: blocks above %d have been disconnected\n", fork_height)
+
+	for tx_hash, heightBytes := range v.localStorage.store[VAULT_TX_INCLUSION_STORE_KEY] {
+		if parseBlockHeight(v.suite, heightBytes) > fork_height {
+			delete(v.localStorage.store[VAULT_TX_INCLUSION_STORE_KEY], tx_hash)
+		}
+	}
+
+	// deposits that are being confirmed or attested
+	for key, depositBytes := range v.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY] {
+		if depositBlockHeight(v.suite, depositBytes) > fork_height {
+			delete(v.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY], key)
+		}
+	}
+	for substore_key, attests := range v.protocolStorage.store {
+		if !strings.HasPrefix(substore_key, DEPOSIT_ATTEST_STORE_KEY) {
+			continue
+		}
+		for _, depositBytes := range attests {
+			if depositBlockHeight(v.suite, depositBytes) > fork_height {
+				delete(v.protocolStorage.store, substore_key)
+			}
+			break
+		}
+	}
+
+	// checkpoints are rolled back first, since they return their still valid deposits to credited deposits
+	var err error
+	if rollback_height := v.firstInvalidCheckPoint(fork_height); rollback_height > 0 {
+		if err = v.rollbackCheckPoints(rollback_height, fork_height); err != nil {
+			v.logger.Printf("vault state has diverged from btc, vote to pause withdrawals: %v\n", err)
+			v.SendPauseVote(true)
+		}
+	}
+	for key, depositBytes := range v.protocolStorage.store[DEPOSIT_STORE_KEY] {
+		if depositBlockHeight(v.suite, depositBytes) > fork_height {
+			v.logger.Printf("deposit %s is no longer credited\n", key)
+			delete(v.protocolStorage.store[DEPOSIT_STORE_KEY], key)
+		}
+	}
+
+	v.btcTipHeight = v.headerChain.Height()
+	return err
+}
+
+// earliest signed checkpoint that consolidated a deposit above fork height, 0 if there is none
+func (v *MockValidator) firstInvalidCheckPoint(fork_height int64) int64 {
+	for checkpoint_height := int64(1); checkpoint_height < v.btcCheckpointheight; checkpoint_height++ {
+		signed_txs := v.GetSignedTxs(checkpoint_height)
+		if len(signed_txs) == 0 {
+			continue
+		}
+		for _, txIn := range signed_txs[0].TxIn[1:] {
+			depositBytes, ok := v.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY][txIn.PreviousOutPoint.String()]
+			if ok && depositBlockHeight(v.suite, depositBytes) > fork_height {
+				return checkpoint_height
+			}
+		}
+	}
+
+	return 0
+}
+
+// roll back signed checkpoints down to rollback height, the last one first
+// paid withdrawals are queued again, and consolidated deposits that are still in the best chain are credited again
+// signing indexes are not rolled back, so that a replacement never reuses a nonce
+// nothing is rolled back if a checkpoint is under another group key
+func (v *MockValidator) rollbackCheckPoints(rollback_height, fork_height int64) error {
+	// the group key that signed a checkpoint before a vault migration can no longer sign its replacement
+	vault_script := v.vaultPkScript(v.frost.GroupPublicKey)
+	for checkpoint_height := rollback_height; checkpoint_height < v.btcCheckpointheight; checkpoint_height++ {
+		signed_txs := v.GetSignedTxs(checkpoint_height)
+		if !bytes.Equal(signed_txs[len(signed_txs)-1].TxOut[0].PkScript, vault_script) {
+			return fmt.Errorf("validator %d: checkpoint %d spends deposits above btc block %d, but it is under another group key and cannot be rolled back", v.position, rollback_height, fork_height)
+		}
+	}
+
+	for checkpoint_height := v.btcCheckpointheight - 1; checkpoint_height >= rollback_height; checkpoint_height-- {
+		if paidBytes, ok := v.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY][strconv.FormatInt(checkpoint_height, 10)]; ok {
+			paid := &StateSnapshot{}
+			err := proto.Unmarshal(paidBytes, paid)
+			assert.NoError(v.suite.T, err)
+			for _, entry := range paid.Entries {
+				v.protocolStorage.store[entry.Store][entry.Key] = entry.Value
+			}
+			delete(v.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY], strconv.FormatInt(checkpoint_height, 10))
+		}
+
+		for _, txIn := range v.GetSignedTxs(checkpoint_height)[0].TxIn[1:] {
+			key := txIn.PreviousOutPoint.String()
+			depositBytes := v.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY][key]
+			delete(v.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY], key)
+			if depositBlockHeight(v.suite, depositBytes) <= fork_height {
+				v.protocolStorage.store[DEPOSIT_STORE_KEY][key] = depositBytes
+			}
+		}
+
+		delete(v.protocolStorage.store[SIGNED_TX_STORE_KEY], strconv.FormatInt(checkpoint_height, 10))
+		delete(v.protocolStorage.store[PAYOUT_TX_STORE_KEY], strconv.FormatInt(checkpoint_height, 10))
+		delete(v.protocolStorage.store[CHECKPOINT_STORE_KEY], strconv.FormatInt(checkpoint_height, 10))
+		v.logger.Printf("checkpoint %d has been rolled back\n", checkpoint_height)
+	}
+	v.btcCheckpointheight = rollback_height
+
+	return nil
+}
+
+func depositBlockHeight(suite *testhelper.TestSuite, depositBytes []byte) int64 {
+	deposit := &Deposit{}
+	err := proto.Unmarshal(depositBytes, deposit)
+	assert.NoError(suite.T, err)
+	return deposit.BlockHeight
+}
+
+func parseBlockHeight(suite *testhelper.TestSuite, heightBytes []byte) int64 {
+	block_height, err := strconv.ParseInt(string(heightBytes), 10, 64)
+	assert.NoError(suite.T, err)
+	return block_height
+}
+
+// go test -v -run ^TestBtcReorg$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestBtcReorg(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	for _, validator := range validators {
+		validator.SetDepositConfirmations(1)
+		validator.SetCheckpointConfirmations(1)
+	}
+
+	checkpoint_amount := int64(1000000000)
+	mockGenesisCheckPoint(&suite, validators, checkpoint_amount)
+	sendNonces(validators, 10)
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 2), Sequence: 1})
+
+	// checkpoint 1 consolidates a deposit and pays withdrawals
+	trScript, err := txscript.PayToTaprootScript(validators[0].frost.GroupPublicKey)
+	assert.NoError(t, err)
+	deposit_tx := suite.NewMockFirstTx(trScript, 25000000)
+	mineSpvBlock(t, &suite, validators, []*wire.MsgTx{deposit_tx})
+	for _, validator := range validators {
+		for query(validator, validator.GetCreditedDepositsNum) != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	signCheckPoint(t, validators)
+	dropped := validators[0].GetSignedTxs(1)[0]
+	assert.Equal(t, 2, len(dropped.TxIn))
+	assert.Equal(t, 3, len(dropped.TxOut))
+	mineSpvBlock(t, &suite, validators, []*wire.MsgTx{dropped})
+	signing_index := query(validators[0], func() int64 { return validators[0].nextSigningIndex })
+
+	// a longer branch without the deposit takes over, checkpoint 1 can never be mined
+	suite.MockReorg(0)
+	fork_blocks := []*wire.MsgBlock{suite.MockMineBlock(nil), suite.MockMineBlock(nil), suite.MockMineBlock(nil)}
+	relaySpvBlocks(t, validators, 1, fork_blocks)
+	for _, validator := range validators {
+		assert.Equal(t, int64(1), query(validator, validator.GetCheckPointHeight))
+		assert.Nil(t, query(validator, func() []*wire.MsgTx { return validator.GetSignedTxs(1) }))
+		assert.Equal(t, 0, query(validator, validator.GetCreditedDepositsNum))
+		assert.Equal(t, 0, len(validator.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY]))
+		assert.Equal(t, 2, query(validator, validator.GetPendingTxsNum))
+	}
+
+	// the replacement spends the same checkpoint with new nonces, and pays the same withdrawals
+	signCheckPoint(t, validators)
+	replacement := validators[0].GetSignedTxs(1)[0]
+	assert.NotEqual(t, dropped.TxHash(), replacement.TxHash())
+	assert.Equal(t, 1, len(replacement.TxIn))
+	assert.Equal(t, dropped.TxIn[0].PreviousOutPoint, replacement.TxIn[0].PreviousOutPoint)
+	assert.Equal(t, dropped.TxOut[1:], replacement.TxOut[1:])
+	assert.Equal(t, signing_index+1, query(validators[0], func() int64 { return validators[0].nextSigningIndex }))
+	for _, validator := range validators[1:] {
+		assert.Equal(t, replacement.TxHash(), query(validator, func() *wire.MsgTx { return validator.GetSignedTxs(1)[0] }).TxHash())
+	}
+
+	// once the replacement is mined, the dropped checkpoint would double spend the vault
+	mineSpvBlock(t, &suite, validators, []*wire.MsgTx{replacement})
+	suite.ReadUtxoViewpoint(func(utxo_view *blockchain.UtxoViewpoint) {
+		assert.True(t, utxo_view.LookupEntry(dropped.TxIn[0].PreviousOutPoint).IsSpent())
+	})
+
+	// a reorg that only drops the replacement does not roll it back, it waits to be mined again
+	suite.MockReorg(3)
+	fork_blocks = []*wire.MsgBlock{suite.MockMineBlock(nil), suite.MockMineBlock(nil)}
+	relaySpvBlocks(t, validators, 4, fork_blocks)
+	for _, validator := range validators {
+		assert.Equal(t, int64(2), query(validator, validator.GetCheckPointHeight))
+		assert.Equal(t, int64(0), query(validator, func() int64 { return validator.GetCheckPointConfirmations(1) }))
+		assert.Error(t, query(validator, validator.DeriveTxAndSign))
+	}
+	mineSpvBlock(t, &suite, validators, []*wire.MsgTx{replacement})
+	signCheckPoint(t, validators)
+	assert.Equal(t, replacement.TxHash(), validators[0].GetSignedTxs(2)[0].TxIn[0].PreviousOutPoint.Hash)
+}
+
+// go test -v -run ^TestPruneFinalCheckPoint$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestPruneFinalCheckPoint(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	for _, validator := range validators {
+		validator.SetCheckpointConfirmations(1)
+		validator.SetFinalityConfirmations(3)
+	}
+
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+	sendNonces(validators, 10)
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 2), Sequence: 1})
+
+	// paid withdrawals are kept in one entry of the checkpoint, with their queued heights, while a reorg can reach it
+	signCheckPoint(t, validators)
+	mineSpvBlock(t, &suite, validators, []*wire.MsgTx{validators[0].GetSignedTxs(1)[0]})
+	mineSpvBlock(t, &suite, validators, nil)
+	for _, validator := range validators {
+		paid := query(validator, func() *StateSnapshot {
+			paidBytes, ok := validator.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY]["1"]
+			if !ok {
+				return nil
+			}
+			paid := &StateSnapshot{}
+			assert.NoError(t, proto.Unmarshal(paidBytes, paid))
+			return paid
+		})
+		assert.Equal(t, 4, len(paid.Entries))
+		assert.Equal(t, 0, query(validator, func() int { return len(validator.protocolStorage.store[WITHDRAW_QUEUED_HEIGHT_STORE_KEY]) }))
+	}
+
+	// the third confirmation makes the checkpoint final
+	mineSpvBlock(t, &suite, validators, nil)
+	for _, validator := range validators {
+		assert.Equal(t, int64(3), query(validator, func() int64 { return validator.GetCheckPointConfirmations(1) }))
+		assert.Equal(t, 0, query(validator, func() int { return len(validator.protocolStorage.store[PAID_WITHDRAWAL_STORE_KEY]) }))
+	}
+}
+
+// go test -v -run ^TestBtcReorgUnderMigratedGroupKey$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestBtcReorgUnderMigratedGroupKey(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+	validator.Do(func() {
+		validator.SetValidatorPubKey(validator.position, validator.keyPair.Pub)
+		validator.protocolStorage.store[VP_STORE_KEY]["1"] = vpToBytes(&suite, math.LegacyOneDec())
+	})
+
+	// checkpoint 1 consolidates a deposit mined in btc block 5, and pays to the vault under the current group key
+	genesis := query(validator, func() *BtcCheckPoint { return validator.getBtcCheckPoint(0) })
+	genesis_hash, err := chainhash.NewHashFromStr(genesis.OutHash)
+	assert.NoError(t, err)
+	deposit_outpoint := wire.NewOutPoint(&chainhash.Hash{1}, 0)
+	signed_tx := wire.NewMsgTx(2)
+	signed_tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(genesis_hash, genesis.OutIndex), nil, nil))
+	signed_tx.AddTxIn(wire.NewTxIn(deposit_outpoint, nil, nil))
+	signed_tx.AddTxOut(wire.NewTxOut(1000000000, validator.vaultPkScript(validator.frost.GroupPublicKey)))
+	credited_key := wire.NewOutPoint(&chainhash.Hash{2}, 0).String()
+	consolidatedBytes, err := proto.Marshal(&Deposit{BlockHeight: 5})
+	assert.NoError(t, err)
+	creditedBytes, err := proto.Marshal(&Deposit{BlockHeight: 6})
+	assert.NoError(t, err)
+	validator.Do(func() {
+		validator.storeSignedTxs(1, []*wire.MsgTx{signed_tx})
+		validator.btcCheckpointheight = 2
+		validator.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY][deposit_outpoint.String()] = consolidatedBytes
+		validator.protocolStorage.store[DEPOSIT_STORE_KEY][credited_key] = creditedBytes
+	})
+
+	// the vault has since migrated, so checkpoint 1 can no longer be signed again
+	_, migrated_key := suite.NewHDKeyPairFromSeed("")
+	validator.Do(func() {
+		validator.frost.GroupPublicKey = migrated_key.Pub
+	})
+
+	assert.Error(t, query(validator, func() error { return validator.handleBtcReorg(2) }))
+	assert.Equal(t, int64(2), query(validator, validator.GetCheckPointHeight))
+	assert.Equal(t, signed_tx.TxHash(), query(validator, func() []*wire.MsgTx { return validator.GetSignedTxs(1) })[0].TxHash())
+	assert.Equal(t, 1, query(validator, func() int { return len(validator.protocolStorage.store[CONSOLIDATED_DEPOSIT_STORE_KEY]) }))
+	assert.Equal(t, 0, query(validator, validator.GetCreditedDepositsNum))
+
+	// the vault no longer matches btc, so no withdrawal is paid until the validators resume them
+	for !query(validator, validator.IsWithdrawPaused) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
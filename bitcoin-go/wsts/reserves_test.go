@@ -0,0 +1,235 @@
+package wsts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+// signing sessions of the checkpoint, or of the proof of reserves over reserves message when it is set
+func (v *MockValidator) signingTxs(reserves_message []byte) []*vaultTx {
+	if len(reserves_message) > 0 {
+		return v.reservesProofTxs(reserves_message)
+	}
+
+	return v.handleTxs(txscript.SigHashDefault)
+}
+
+// proof of reserves over all utxos of the vault, the checkpoint and credited deposits that have not been consolidated
+// the commitment input is signed in a session of its own like any reserve
+func (v *MockValidator) reservesProofTxs(message []byte) []*vaultTx {
+	prev_outs, prev_tx_outs := v.vaultUtxos(0)
+	btc_tx := testhelper.NewReservesProofTx(message, prev_outs, prev_tx_outs)
+	inputFetcher := testhelper.ReservesPrevOutFetcher(btc_tx, prev_tx_outs)
+
+	sigHashes := txscript.NewTxSigHashes(btc_tx, inputFetcher)
+	input_sig_hashes := make([][32]byte, len(btc_tx.TxIn))
+	input_prev_outs := make([]*wire.TxOut, len(btc_tx.TxIn))
+	for i, txIn := range btc_tx.TxIn {
+		sigHash, err := txscript.CalcTaprootSignatureHash(sigHashes, txscript.SigHashDefault, btc_tx, i, inputFetcher)
+		assert.Nil(v.suite.T, err)
+		input_sig_hashes[i] = ([32]byte)(sigHash)
+		input_prev_outs[i] = inputFetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+	}
+
+	return []*vaultTx{{
+		tx:        btc_tx,
+		prevOuts:  input_prev_outs,
+		sigHashes: input_sig_hashes,
+	}}
+}
+
+// sign a proof of reserves over message with the next signing sessions
+// checkpoint signing waits until the proof is finalized
+func (v *MockValidator) DeriveReservesProofAndSign(message []byte) error {
+	if len(message) == 0 {
+		return fmt.Errorf("validator %d: proof of reserves needs a message", v.position)
+	}
+	if v.btcCheckpointheight == 0 {
+		return fmt.Errorf("validator %d: vault has no checkpoint yet", v.position)
+	}
+	// reserves move to the new group key during vault migration
+	if v.migrating {
+		return fmt.Errorf("validator %d: vault migration is in progress", v.position)
+	}
+	if len(v.reservesMessage) > 0 && !bytes.Equal(v.reservesMessage, message) {
+		return fmt.Errorf("validator %d: proof of reserves over %q is being signed", v.position, v.reservesMessage)
+	}
+	// a session already signed for the checkpoint cannot be taken over
+	if len(v.reservesMessage) == 0 && v.hasStartedSigningSession() {
+		return fmt.Errorf("validator %d: checkpoint %d is being signed", v.position, v.btcCheckpointheight)
+	}
+
+	v.reservesMessage = message
+	return v.signVaultTxs(v.reservesProofTxs(message), message)
+}
+
+func (v *MockValidator) handleFinalizeReservesProof(message []byte, vault_txs []*vaultTx) {
+	witnesses, ok := v.aggregateWitnesses(vault_txs)
+	if !ok {
+		return
+	}
+
+	proof := vault_txs[0].tx
+	for input_index, witness := range witnesses[0] {
+		proof.TxIn[input_index].Witness = witness
+	}
+	total, err := v.suite.VerifyReservesProof(proof, message)
+	if err != nil {
+		assert.NoError(v.suite.T, err, "proof of reserves over %q is invalid", message)
+		return
+	}
+	v.logger.Printf("proof of reserves over %q proves %d sats at checkpoint %d\n", message, total, v.btcCheckpointheight)
+
+	var buf bytes.Buffer
+	err = proof.Serialize(&buf)
+	assert.NoError(v.suite.T, err)
+	v.protocolStorage.store[RESERVES_PROOF_STORE_KEY][hex.EncodeToString(message)] = buf.Bytes()
+
+	v.nextSigningIndex += signingSessionsNum(vault_txs)
+	v.reservesMessage = nil
+	v.ReplenishNonces()
+}
+
+// finalized proof of reserves over message, nil if there is none
+func (v *MockValidator) GetReservesProof(message []byte) *wire.MsgTx {
+	proofBytes, ok := v.protocolStorage.store[RESERVES_PROOF_STORE_KEY][hex.EncodeToString(message)]
+	if !ok {
+		return nil
+	}
+	proof := wire.NewMsgTx(2)
+	err := proof.Deserialize(bytes.NewReader(proofBytes))
+	assert.NoError(v.suite.T, err)
+
+	return proof
+}
+
+func signReservesProof(t *testing.T, validators []*MockValidator, message []byte) *wire.MsgTx {
+	for _, validator := range validators {
+		// nonce commitments replenished after the previous session might not have arrived yet
+		for retry_time := 5; retry_time > 0; retry_time-- {
+			err := query(validator, func() error {
+				return validator.DeriveReservesProofAndSign(message)
+			})
+			if err == nil {
+				break
+			}
+			t.Logf("retry signing proof of reserves for validator %d: %v", validator.GetPosition(), err)
+			time.Sleep(3 * time.Second)
+		}
+	}
+
+	// probing to see if all validators have finalized the proof
+	for _, validator := range validators {
+		timeout := time.After(30 * time.Second)
+		for query(validator, func() *wire.MsgTx { return validator.GetReservesProof(message) }) == nil {
+			select {
+			case <-timeout:
+				t.Fatalf("validator %d has not finalized proof of reserves over %q", validator.GetPosition(), message)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	return query(validators[0], func() *wire.MsgTx { return validators[0].GetReservesProof(message) })
+}
+
+// go test -v -run ^TestReservesProof$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestReservesProof(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	for _, validator := range validators {
+		validator.SetDepositConfirmations(1)
+	}
+
+	checkpoint_amount := int64(1000000000)
+	mockGenesisCheckPoint(&suite, validators, checkpoint_amount)
+	sendNonces(validators, 10)
+
+	// a credited deposit is part of the reserves before it is consolidated
+	trScript, err := txscript.PayToTaprootScript(validators[0].frost.GroupPublicKey)
+	assert.NoError(t, err)
+	deposit_amount := int64(25000000)
+	deposit_tx := suite.NewMockFirstTx(trScript, deposit_amount)
+	suite.UtxoViewpoint.AddTxOuts(btcutil.NewTx(deposit_tx), 1)
+	scanBlock(validators, 1, []*wire.MsgTx{deposit_tx})
+	for _, validator := range validators {
+		for query(validator, validator.GetCreditedDepositsNum) != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the commitment input, the checkpoint and the deposit are each signed in their own session
+	message := []byte("reserves at checkpoint 1")
+	signing_index := query(validators[0], func() int64 { return validators[0].nextSigningIndex })
+	proof := signReservesProof(t, validators, message)
+	assert.Equal(t, 3, len(proof.TxIn))
+	assert.Equal(t, testhelper.ReservesCommitment(message), proof.TxIn[0].PreviousOutPoint)
+	assert.Equal(t, deposit_tx.TxHash(), proof.TxIn[2].PreviousOutPoint.Hash)
+	assert.Equal(t, signing_index+3, query(validators[0], func() int64 { return validators[0].nextSigningIndex }))
+	for _, validator := range validators[1:] {
+		assert.Equal(t, proof.TxHash(), query(validator, func() *wire.MsgTx { return validator.GetReservesProof(message) }).TxHash())
+	}
+	total, err := suite.VerifyReservesProof(proof, message)
+	assert.NoError(t, err)
+	assert.Equal(t, checkpoint_amount+deposit_amount, total)
+
+	// the proof only holds for its own message
+	_, err = suite.VerifyReservesProof(proof, []byte("reserves at checkpoint 2"))
+	assert.Error(t, err)
+
+	// the proof cannot claim more than the reserves hold
+	tampered := proof.Copy()
+	tampered.TxOut[0].Value++
+	_, err = suite.VerifyReservesProof(tampered, message)
+	assert.Error(t, err)
+
+	// a reserve cannot be counted twice, nor can the commitment input be repeated
+	tampered = proof.Copy()
+	tampered.TxIn = append(tampered.TxIn, tampered.TxIn[2])
+	tampered.TxOut[0].Value += deposit_amount
+	_, err = suite.VerifyReservesProof(tampered, message)
+	assert.ErrorContains(t, err, "duplicate inputs")
+	tampered = proof.Copy()
+	tampered.TxIn = append(tampered.TxIn, tampered.TxIn[0])
+	_, err = suite.VerifyReservesProof(tampered, message)
+	assert.ErrorContains(t, err, "duplicate inputs")
+
+	// every input is signed, including the commitment input
+	tampered = proof.Copy()
+	tampered.TxIn[0].Witness = nil
+	_, err = suite.VerifyReservesProof(tampered, message)
+	assert.Error(t, err)
+
+	// the checkpoint is signed with the sessions after the proof
+	signCheckPoint(t, validators)
+	signed_tx := validators[0].GetSignedTxs(1)[0]
+	suite.MockMineTx(signed_tx, 2)
+
+	// reserves have been spent, a next proof is over the new checkpoint only
+	_, err = suite.VerifyReservesProof(proof, message)
+	assert.Error(t, err)
+	message = []byte("reserves at checkpoint 2")
+	proof = signReservesProof(t, validators, message)
+	assert.Equal(t, 2, len(proof.TxIn))
+	total, err = suite.VerifyReservesProof(proof, message)
+	assert.NoError(t, err)
+	assert.Equal(t, signed_tx.TxOut[0].Value, total)
+}
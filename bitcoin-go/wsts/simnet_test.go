@@ -0,0 +1,528 @@
+package wsts
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// a failing seed can be replayed exactly with -args -simnet.seed=<seed>
+var simNetSeed = flag.Int64("simnet.seed", 0, "replay simulated network tests with this seed")
+
+const (
+	// default one way latency of a message between two validators
+	SIM_MIN_LATENCY = 5 * time.Millisecond
+	SIM_MAX_LATENCY = 150 * time.Millisecond
+	// requeued messages wait at least this long on the virtual clock
+	// otherwise a message polling for a missing state floods the event queue
+	SIM_MIN_REQUEUE_DELAY = 10 * time.Millisecond
+	// virtual time for a protocol phase to finish before a simulated run gives up
+	SIM_PHASE_TIMEOUT = 10 * time.Minute
+)
+
+// latency of one message from one validator to another
+// rng is owned by the link, so that draws do not depend on the order messages to other validators are sent in
+type LatencyModel func(rng *rand.Rand, from, to int64) time.Duration
+
+// latency is drawn uniformly from [min, max], messages on the same link overtake each other when latencies differ
+func UniformLatency(min, max time.Duration) LatencyModel {
+	return func(rng *rand.Rand, from, to int64) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rng.Int63n(int64(max-min)+1))
+	}
+}
+
+// validators in different groups cannot reach each other from Start until End on the virtual clock
+// validators that are not listed in any group form one more group
+type SimPartition struct {
+	Start  time.Duration
+	End    time.Duration
+	Groups [][]int64
+	// messages across the partition are lost, otherwise they are held until the partition heals
+	Drop bool
+}
+
+func (p *SimPartition) group(posi int64) int {
+	for i, group := range p.Groups {
+		for _, member := range group {
+			if member == posi {
+				return i
+			}
+		}
+	}
+
+	return len(p.Groups)
+}
+
+func (p *SimPartition) separates(at time.Duration, from, to int64) bool {
+	return at >= p.Start && at < p.End && p.group(from) != p.group(to)
+}
+
+type SimNetConfig struct {
+	Seed       int64
+	Latency    LatencyModel
+	Partitions []SimPartition
+}
+
+type simEvent struct {
+	at time.Duration
+	// events at the same virtual time are ordered by link and by send order on the link
+	// so that goroutine scheduling and map iteration never decide the order
+	to   int64
+	from int64
+	seq  uint64
+	run  func()
+}
+
+type simEventQueue []*simEvent
+
+func (q simEventQueue) Len() int { return len(q) }
+
+func (q simEventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	if q[i].to != q[j].to {
+		return q[i].to < q[j].to
+	}
+	if q[i].from != q[j].from {
+		return q[i].from < q[j].from
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q simEventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *simEventQueue) Push(x any) { *q = append(*q, x.(*simEvent)) }
+
+func (q *simEventQueue) Pop() any {
+	old := *q
+	event := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return event
+}
+
+type simLinkState struct {
+	rng *rand.Rand
+	seq uint64
+}
+
+// SimNetwork delivers messages between validators on a virtual clock
+// all events run on the calling goroutine one at a time, so a run only depends on the seed
+//
+// position 0 is the test driver, it schedules protocol phases and submits user messages
+type SimNetwork struct {
+	config     SimNetConfig
+	now        time.Duration
+	queue      simEventQueue
+	validators map[int64]*MockValidator
+	links      map[[2]int64]*simLinkState
+	trace      hash.Hash
+	delivered  int
+	dropped    int
+}
+
+func NewSimNetwork(config SimNetConfig) *SimNetwork {
+	if config.Latency == nil {
+		config.Latency = UniformLatency(SIM_MIN_LATENCY, SIM_MAX_LATENCY)
+	}
+
+	return &SimNetwork{
+		config:     config,
+		validators: make(map[int64]*MockValidator),
+		links:      make(map[[2]int64]*simLinkState),
+		trace:      sha256.New(),
+	}
+}
+
+// validators exchange messages only through the simulated network
+// they must not run event loops, messages are handled when the network delivers them
+func (n *SimNetwork) Join(validators []*MockValidator) {
+	for _, validator := range validators {
+		n.validators[validator.position] = validator
+	}
+	joinTransport(n, validators)
+}
+
+func (n *SimNetwork) Now() time.Duration {
+	return n.now
+}
+
+func (n *SimNetwork) Delivered() int {
+	return n.delivered
+}
+
+func (n *SimNetwork) Dropped() int {
+	return n.dropped
+}
+
+// digest of every delivery so far, with the hash of each delivered message
+// two runs with the same seed have the same digest, as keys, nonces and withdrawals are drawn from the seed too
+func (n *SimNetwork) TraceDigest() string {
+	return hex.EncodeToString(n.trace.Sum(nil))
+}
+
+func (n *SimNetwork) link(from, to int64) *simLinkState {
+	key := [2]int64{from, to}
+	if link, ok := n.links[key]; ok {
+		return link
+	}
+
+	seed_hash := fnv.New64a()
+	fmt.Fprintf(seed_hash, "%d/%d/%d", n.config.Seed, from, to)
+	link := &simLinkState{
+		rng: rand.New(rand.NewSource(int64(seed_hash.Sum64()))),
+	}
+	n.links[key] = link
+
+	return link
+}
+
+func (n *SimNetwork) schedule(at time.Duration, from, to int64, run func()) {
+	link := n.link(from, to)
+	link.seq++
+	heap.Push(&n.queue, &simEvent{
+		at:   at,
+		to:   to,
+		from: from,
+		seq:  link.seq,
+		run:  run,
+	})
+}
+
+// run fn on the test driver at virtual time at
+func (n *SimNetwork) At(at time.Duration, fn func()) {
+	n.schedule(at, 0, 0, fn)
+}
+
+func (n *SimNetwork) send(from, to int64, msg []byte, on_chain bool) {
+	at := n.now
+	if from != to {
+		at += n.config.Latency(n.link(from, to).rng, from, to)
+		for _, partition := range n.config.Partitions {
+			if !partition.separates(n.now, from, to) {
+				continue
+			}
+			if partition.Drop {
+				n.dropped++
+				return
+			}
+			if heal := partition.End + (at - n.now); heal > at {
+				at = heal
+			}
+		}
+	}
+
+	n.schedule(at, from, to, func() {
+		n.deliver(from, to, msg, on_chain)
+	})
+}
+
+// a user message, such as a withdraw batch, submitted to a validator
+func (n *SimNetwork) SubmitOnChain(to int64, msg []byte) {
+	n.send(0, to, msg, true)
+}
+
+// requeued envelope has already been opened, it is handled again without being delivered
+func (n *SimNetwork) requeue(posi int64, env *Envelope, on_chain bool, delay time.Duration) {
+	if delay < SIM_MIN_REQUEUE_DELAY {
+		delay = SIM_MIN_REQUEUE_DELAY
+	}
+	n.schedule(n.now+delay, posi, posi, func() {
+		fmt.Fprintf(n.trace, "%d %d requeue %t %s\n", n.now, posi, on_chain, envelopeType(env))
+
+		n.validators[posi].handleRequeuedEnvelope(env, on_chain)
+	})
+}
+
+func (n *SimNetwork) deliver(from, to int64, msg []byte, on_chain bool) {
+	n.delivered++
+	env := &Envelope{}
+	if err := proto.Unmarshal(msg, env); err == nil {
+		// the message hash covers keys, nonces, signatures and transactions, not only the timing of the run
+		fmt.Fprintf(n.trace, "%d %d>%d %t %s %x\n", n.now, from, to, on_chain, envelopeType(env), sha256.Sum256(msg))
+	}
+
+	validator := n.validators[to]
+	if on_chain {
+		validator.handleMessageOnChain(msg)
+		return
+	}
+	validator.handleMessageOffChain(msg)
+}
+
+// run events until done returns true
+// return false if no event is left or done is still false after timeout on the virtual clock
+func (n *SimNetwork) Run(done func() bool, timeout time.Duration) bool {
+	deadline := n.now + timeout
+	for !done() {
+		if n.queue.Len() == 0 || n.queue[0].at > deadline {
+			return false
+		}
+		event := heap.Pop(&n.queue).(*simEvent)
+		n.now = event.at
+		event.run()
+	}
+
+	return true
+}
+
+// run events until there is no event left
+func (n *SimNetwork) RunUntilIdle(timeout time.Duration) bool {
+	return n.Run(func() bool { return n.queue.Len() == 0 }, timeout)
+}
+
+// seeds of a simulated test, or only the replayed seed
+func simNetSeeds(defaults ...int64) []int64 {
+	if *simNetSeed != 0 {
+		return []int64{*simNetSeed}
+	}
+
+	return defaults
+}
+
+// validators with vp derived from the seed, joined to the simulated network, with vp propagated
+func newSimValidatorSet(t *testing.T, suite *testhelper.TestSuite, network *SimNetwork, n, n_keys, threshold int64) []*MockValidator {
+	validators := make([]*MockValidator, n)
+	for i := int64(0); i < n; i++ {
+		path := fmt.Sprintf("../debug/validator_%d.log", i+1)
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		logger := log.New(file, "", log.LstdFlags)
+
+		frost := testhelper.NewFrostParticipant(suite, logger, n_keys, threshold, i+1, nil)
+
+		validators[i] = newMockValidator(suite, logger, file, frost, n, i+1)
+	}
+
+	deriveValidatorvpFromRand(suite, validators, rand.New(rand.NewSource(network.config.Seed)))
+
+	connectValidators(validators)
+	network.Join(validators)
+	recordTranscripts(t, validators, network.Now)
+
+	for _, validator := range validators {
+		network.At(network.Now(), validator.SendVPToAll)
+	}
+	vp_propagated := network.Run(func() bool {
+		for _, validator := range validators {
+			if int64(len(validator.protocolStorage.store[VP_STORE_KEY])) != n {
+				return false
+			}
+		}
+		return true
+	}, SIM_PHASE_TIMEOUT)
+	assert.True(t, vp_propagated, "vp has not propagated to all validators")
+
+	return validators
+}
+
+// DKG rounds are scheduled at the current virtual time, secret shares right after secret proofs
+func runSimDKG(t *testing.T, network *SimNetwork, validators []*MockValidator) bool {
+	for _, validator := range validators {
+		network.At(network.Now(), validator.DeriveAndSendProofs)
+	}
+	for _, validator := range validators {
+		network.At(network.Now(), validator.DeriveAndSendSecretShares)
+	}
+
+	return network.Run(func() bool {
+		for _, validator := range validators {
+			if validator.frost.GroupPublicKey == nil {
+				return false
+			}
+		}
+		return true
+	}, SIM_PHASE_TIMEOUT)
+}
+
+// all validators sign the current checkpoint at the same virtual time
+// return false if a validator has not finalized the checkpoint
+func runSimCheckPoint(t *testing.T, network *SimNetwork, validators []*MockValidator) bool {
+	checkpoint_heights := make([]int64, len(validators))
+	for i, validator := range validators {
+		checkpoint_heights[i] = validator.GetCheckPointHeight()
+		validator := validator
+		network.At(network.Now(), func() {
+			assert.NoError(t, validator.DeriveTxAndSign())
+		})
+	}
+
+	return network.Run(func() bool {
+		for i, validator := range validators {
+			if validator.GetCheckPointHeight() == checkpoint_heights[i] {
+				return false
+			}
+		}
+		return true
+	}, SIM_PHASE_TIMEOUT)
+}
+
+// withdrawals with amounts drawn from the seed
+func generateSimWithdrawList(suite *testhelper.TestSuite, rng *rand.Rand, message_num int) []*MsgWithdraw {
+	msgList := generateMsgWithdrawList(suite, message_num)
+	for _, msg := range msgList {
+		msg.Amount = 1000 + rng.Int63n(1000000)
+	}
+
+	return msgList
+}
+
+// DKG, then checkpoints signed on the simulated network
+// return the trace digest of the run
+func runSimValidatorSet(t *testing.T, seed, n, n_keys, threshold, checkpoints int64) string {
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("replay with: go test -v -run ^%s$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts -args -simnet.seed=%d", t.Name(), seed)
+		}
+	})
+
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+	network := NewSimNetwork(SimNetConfig{Seed: seed})
+	rng := rand.New(rand.NewSource(seed))
+	// validator keys, polynomials, nonces and withdrawal receivers are drawn from the seed
+	// simulated validators run on the calling goroutine, so the seeded source is never read concurrently
+	suite.Rand = rng
+
+	time_now := time.Now()
+	validators := newSimValidatorSet(t, &suite, network, n, n_keys, threshold)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+
+	if !assert.True(t, runSimDKG(t, network, validators), "DKG has not finished") {
+		return ""
+	}
+	t.Logf("DKG has finished at virtual time %v", network.Now())
+
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+	for _, validator := range validators {
+		validator := validator
+		network.At(network.Now(), func() {
+			validator.DeriveAndSendNonces(checkpoints)
+		})
+	}
+	assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+
+	for checkpoint := int64(1); checkpoint <= checkpoints; checkpoint++ {
+		batch := &MsgBatchWithdraw{WithdrawBatch: generateSimWithdrawList(&suite, rng, 10), Sequence: checkpoint}
+		for _, validator := range validators {
+			network.SubmitOnChain(validator.position, validator.sealEnvelope(&Envelope_BatchWithdraw{BatchWithdraw: batch}))
+		}
+		assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+
+		if !assert.True(t, runSimCheckPoint(t, network, validators), "checkpoint %d has not been finalized", checkpoint) {
+			return ""
+		}
+
+		signed_txs := validators[0].GetSignedTxs(checkpoint)
+		for _, validator := range validators[1:] {
+			assert.Equal(t, signed_txs[0].TxHash(), validator.GetSignedTxs(checkpoint)[0].TxHash())
+		}
+		for _, signed_tx := range signed_txs {
+			suite.MockMineTx(signed_tx, int32(checkpoint))
+		}
+		t.Logf("checkpoint %d has been finalized at virtual time %v", checkpoint, network.Now())
+	}
+
+	t.Logf("%d validators, %d messages delivered, virtual time %v, finished in %v", n, network.Delivered(), network.Now(), time.Since(time_now))
+
+	return network.TraceDigest()
+}
+
+// go test -v -run ^TestSimNetValidatorSet$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestSimNetValidatorSet(t *testing.T) {
+	for _, seed := range simNetSeeds(1, 2, 3) {
+		t.Run(strconv.FormatInt(seed, 10), func(t *testing.T) {
+			runSimValidatorSet(t, seed, 10, 50, 7, 2)
+		})
+	}
+}
+
+// go test -v -run ^TestSimNetLargeValidatorSet$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestSimNetLargeValidatorSet(t *testing.T) {
+	// simulated validators run on a single goroutine, there is nothing for the race detector to find
+	if raceEnabled {
+		t.Skip("large simulation is skipped under the race detector")
+	}
+	for _, seed := range simNetSeeds(1) {
+		runSimValidatorSet(t, seed, 100, 500, 10, 1)
+	}
+}
+
+// go test -v -run ^TestSimNetReplay$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestSimNetReplay(t *testing.T) {
+	seeds := simNetSeeds(7)
+	seed := seeds[0]
+
+	digest := runSimValidatorSet(t, seed, 4, 10, 5, 1)
+	replayed := runSimValidatorSet(t, seed, 4, 10, 5, 1)
+	assert.NotEmpty(t, digest)
+	assert.Equal(t, digest, replayed, "seed %d is not replayed exactly", seed)
+
+	other := runSimValidatorSet(t, seed+1, 4, 10, 5, 1)
+	assert.NotEqual(t, digest, other)
+}
+
+// go test -v -run ^TestSimNetPartition$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestSimNetPartition(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		t.Run(fmt.Sprintf("drop_%t", drop), func(t *testing.T) {
+			suite := testhelper.TestSuite{}
+			suite.SetupStaticSimNetSuite(t, log.Default())
+
+			// validator 1 is cut off from the others during DKG
+			heal := 30 * time.Second
+			network := NewSimNetwork(SimNetConfig{
+				Seed: 11,
+				Partitions: []SimPartition{{
+					Start:  time.Second,
+					End:    heal,
+					Groups: [][]int64{{1}},
+					Drop:   drop,
+				}},
+			})
+			validators := newSimValidatorSet(t, &suite, network, 4, 10, 5)
+			defer func() {
+				for _, validator := range validators {
+					validator.Stop()
+				}
+			}()
+
+			network.At(time.Second, func() {})
+			network.RunUntilIdle(SIM_PHASE_TIMEOUT)
+			finished := runSimDKG(t, network, validators)
+
+			// held messages are delivered once the partition heals, lost messages never are
+			if drop {
+				assert.False(t, finished)
+				assert.Greater(t, network.Dropped(), 0)
+				return
+			}
+			assert.True(t, finished)
+			assert.GreaterOrEqual(t, network.Now(), heal)
+			for _, validator := range validators[1:] {
+				assert.True(t, validator.frost.GroupPublicKey.IsEqual(validators[0].frost.GroupPublicKey))
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package wsts
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// btc block of each vault tx that has been proven included in the header chain
+	VAULT_TX_INCLUSION_STORE_KEY = "vault_tx_inclusion"
+)
+
+// confirmations needed by vault txs of the previous checkpoint before the next checkpoint is signed
+// 0 signs the next checkpoint without waiting for the previous one to be mined
+func (v *MockValidator) SetCheckpointConfirmations(confirmations int64) {
+	v.checkpointConfirmations = confirmations
+}
+
+// extend the btc header chain of this validator, headers are only accepted with valid proof of work and difficulty
+// deposits are attested once the header chain gives them enough confirmations
+// a reorg is detected once the previous tip is no longer in the best chain
+// a reorg that cannot be handled is returned after all headers have been added
+func (v *MockValidator) SubmitBtcHeaders(headers []*wire.BlockHeader) error {
+	var reorg_err error
+	for _, header := range headers {
+		prev_tip, prev_height := v.headerChain.TipHash(), v.headerChain.Height()
+		if err := v.headerChain.AddHeader(header); err != nil {
+			return fmt.Errorf("validator %d: %v", v.position, err)
+		}
+		if fork_height := v.headerChain.ForkHeight(prev_tip); fork_height < prev_height {
+			if err := v.handleBtcReorg(fork_height); err != nil {
+				reorg_err = err
+			}
+		}
+	}
+	if v.headerChain.Height() > v.btcTipHeight {
+		v.btcTipHeight = v.headerChain.Height()
+	}
+
+	v.attestConfirmedDeposits()
+	v.pruneFinalCheckPoints()
+	return reorg_err
+}
+
+// scan txs of a btc block whose header is in the header chain, each tx with a merkle proof of its inclusion
+// no tx of the block is scanned unless all proofs are valid
+func (v *MockValidator) ScanSpvBlock(block_height int64, txs []*wire.MsgTx, proofs []*testhelper.MerkleProof) error {
+	if len(txs) != len(proofs) {
+		return fmt.Errorf("validator %d: %d txs with %d merkle proofs", v.position, len(txs), len(proofs))
+	}
+	for i, tx := range txs {
+		if err := v.headerChain.VerifyTxInclusion(tx.TxHash(), block_height, proofs[i]); err != nil {
+			return fmt.Errorf("validator %d: %v", v.position, err)
+		}
+	}
+
+	for _, tx := range txs {
+		if v.isVaultTx(tx) {
+			v.localStorage.store[VAULT_TX_INCLUSION_STORE_KEY][tx.TxHash().String()] = []byte(strconv.FormatInt(block_height, 10))
+		}
+	}
+	v.ScanBlock(block_height, txs)
+	v.pruneFinalCheckPoints()
+
+	return nil
+}
+
+// confirmations of a signed checkpoint are the least confirmations of its vault txs
+func (v *MockValidator) GetCheckPointConfirmations(checkpoint_height int64) int64 {
+	signed_txs := v.GetSignedTxs(checkpoint_height)
+	if len(signed_txs) == 0 {
+		return 0
+	}
+
+	confirmations := int64(math.MaxInt64)
+	for _, signed_tx := range signed_txs {
+		heightBytes, ok := v.localStorage.store[VAULT_TX_INCLUSION_STORE_KEY][signed_tx.TxHash().String()]
+		if !ok {
+			return 0
+		}
+		block_height, err := strconv.ParseInt(string(heightBytes), 10, 64)
+		assert.NoError(v.suite.T, err)
+		confirmations = min(confirmations, v.headerChain.Confirmations(block_height))
+	}
+
+	return confirmations
+}
+
+// the next checkpoint spends the vault output of the previous one, which must be confirmed first
+// the genesis checkpoint is set without being signed
+func (v *MockValidator) checkPrevCheckPointConfirmed() error {
+	prev_height := v.btcCheckpointheight - 1
+	if v.checkpointConfirmations == 0 || prev_height == 0 {
+		return nil
+	}
+	if confirmations := v.GetCheckPointConfirmations(prev_height); confirmations < v.checkpointConfirmations {
+		return fmt.Errorf("validator %d: checkpoint %d has %d confirmations, needs %d", v.position, prev_height, confirmations, v.checkpointConfirmations)
+	}
+
+	return nil
+}
+
+// mock mine a block of txs, and relay its header and txs with merkle proofs to all validators
+func mineSpvBlock(t *testing.T, suite *testhelper.TestSuite, validators []*MockValidator, txs []*wire.MsgTx) *wire.MsgBlock {
+	block := suite.MockMineBlock(txs)
+	relaySpvBlocks(t, validators, suite.MockTipHeight(), []*wire.MsgBlock{block})
+
+	return block
+}
+
+// relay headers of consecutive blocks from first height to all validators, then their txs with merkle proofs
+// all headers go first, so that txs of a competing branch are scanned once it has become the best chain
+func relaySpvBlocks(t *testing.T, validators []*MockValidator, first_height int64, blocks []*wire.MsgBlock) {
+	headers := make([]*wire.BlockHeader, len(blocks))
+	for i, block := range blocks {
+		headers[i] = &block.Header
+	}
+
+	for _, validator := range validators {
+		err := query(validator, func() error {
+			if err := validator.SubmitBtcHeaders(headers); err != nil {
+				return err
+			}
+			for i, block := range blocks {
+				proofs := make([]*testhelper.MerkleProof, len(block.Transactions))
+				for j := range block.Transactions {
+					proofs[j] = testhelper.NewMerkleProof(block.Transactions, j)
+				}
+				if err := validator.ScanSpvBlock(first_height+int64(i), block.Transactions, proofs); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+}
+
+// go test -v -run ^TestHeaderChain$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestHeaderChain(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	// a light client follows the headers of mined blocks
+	light_client := testhelper.NewHeaderChain(&chaincfg.SimNetParams)
+	blocks := make([]*wire.MsgBlock, 0)
+	for i := 0; i < 3; i++ {
+		txs := make([]*wire.MsgTx, i+2)
+		for j := range txs {
+			txs[j] = suite.NewMockFirstTx([]byte{txscript.OP_TRUE}, int64(1000*(j+1)))
+		}
+		block := suite.MockMineBlock(txs)
+		assert.NoError(t, light_client.AddHeader(&block.Header))
+		blocks = append(blocks, block)
+	}
+	assert.Equal(t, int64(3), light_client.Height())
+	assert.Equal(t, suite.HeaderChain.TipHash(), light_client.TipHash())
+	assert.Equal(t, int64(2), light_client.Confirmations(2))
+	assert.Equal(t, int64(0), light_client.Confirmations(4))
+
+	// every tx of a block is proven against the merkle root, including an odd last tx
+	for height, block := range blocks {
+		for i, tx := range block.Transactions {
+			proof := testhelper.NewMerkleProof(block.Transactions, i)
+			assert.NoError(t, light_client.VerifyTxInclusion(tx.TxHash(), int64(height+1), proof))
+			// the proof only holds at its own index and in its own block
+			// an odd last tx is paired with itself, so its index is ambiguous at the first level
+			if proof.Hashes[0] != tx.TxHash() {
+				proof.Index ^= 1
+				assert.Error(t, light_client.VerifyTxInclusion(tx.TxHash(), int64(height+1), proof))
+				proof.Index ^= 1
+			}
+			assert.Error(t, light_client.VerifyTxInclusion(tx.TxHash(), int64(height), proof))
+		}
+	}
+	other_tx := suite.NewMockFirstTx([]byte{txscript.OP_TRUE}, 1)
+	proof := testhelper.NewMerkleProof(blocks[2].Transactions, 1)
+	assert.Error(t, light_client.VerifyTxInclusion(other_tx.TxHash(), 3, proof))
+	assert.Error(t, light_client.VerifyTxInclusion(blocks[2].Transactions[1].TxHash(), 4, proof))
+
+	next := suite.MockMineBlock(nil).Header
+
+	// header must extend a known header
+	header := next
+	header.PrevBlock = other_tx.TxHash()
+	assert.Error(t, light_client.AddHeader(&header))
+
+	// header must carry the required difficulty
+	header = next
+	header.Bits = blockchain.BigToCompact(blockchain.CompactToBig(next.Bits).Rsh(blockchain.CompactToBig(next.Bits), 1))
+	assert.Error(t, light_client.AddHeader(&header))
+
+	// header hash must meet its target
+	header = next
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) > 0 {
+			break
+		}
+		header.Nonce++
+	}
+	assert.Error(t, light_client.AddHeader(&header))
+
+	// header timestamp must be after the median time of previous headers
+	header = next
+	header.Timestamp = blocks[0].Header.Timestamp
+	assert.Error(t, light_client.AddHeader(&header))
+
+	assert.NoError(t, light_client.AddHeader(&next))
+	assert.Equal(t, int64(4), light_client.Height())
+
+	// a competing branch is kept aside until it has more work
+	tip := light_client.TipHash()
+	suite.MockReorg(2)
+	fork := []*wire.MsgBlock{suite.MockMineBlock(nil), suite.MockMineBlock(nil)}
+	for _, block := range fork {
+		assert.NoError(t, light_client.AddHeader(&block.Header))
+	}
+	assert.Equal(t, tip, light_client.TipHash())
+	assert.Equal(t, int64(4), light_client.ForkHeight(tip))
+	assert.Equal(t, int64(2), light_client.ForkHeight(fork[1].Header.BlockHash()))
+
+	// the best chain switches to the branch with the most work
+	fork = append(fork, suite.MockMineBlock(nil))
+	assert.NoError(t, light_client.AddHeader(&fork[2].Header))
+	assert.Equal(t, int64(5), light_client.Height())
+	assert.Equal(t, fork[2].Header.BlockHash(), light_client.TipHash())
+	assert.Equal(t, int64(2), light_client.ForkHeight(tip))
+	assert.Equal(t, blocks[1].Header.BlockHash(), light_client.Header(2).BlockHash())
+	assert.Equal(t, fork[0].Header.BlockHash(), light_client.Header(3).BlockHash())
+	assert.Error(t, light_client.VerifyTxInclusion(blocks[2].Transactions[1].TxHash(), 3, proof))
+}
+
+// go test -v -run ^TestSpvCheckPointConfirmations$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestSpvCheckPointConfirmations(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	for _, validator := range validators {
+		validator.SetDepositConfirmations(2)
+		validator.SetCheckpointConfirmations(2)
+	}
+
+	checkpoint_amount := int64(1000000000)
+	mockGenesisCheckPoint(&suite, validators, checkpoint_amount)
+	sendNonces(validators, 10)
+
+	// a deposit is not scanned without a valid merkle proof
+	trScript, err := txscript.PayToTaprootScript(validators[0].frost.GroupPublicKey)
+	assert.NoError(t, err)
+	deposit_tx := suite.NewMockFirstTx(trScript, 25000000)
+	block := mineSpvBlock(t, &suite, validators, nil)
+	forged_proof := testhelper.NewMerkleProof(block.Transactions, 0)
+	for _, validator := range validators {
+		err := query(validator, func() error {
+			return validator.ScanSpvBlock(1, []*wire.MsgTx{deposit_tx}, []*testhelper.MerkleProof{forged_proof})
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 0, len(validator.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY]))
+	}
+
+	// a deposit is credited once the header chain confirms it
+	mineSpvBlock(t, &suite, validators, []*wire.MsgTx{deposit_tx})
+	for _, validator := range validators {
+		assert.Equal(t, 1, len(validator.localStorage.store[DEPOSIT_CANDIDATE_STORE_KEY]))
+	}
+	mineSpvBlock(t, &suite, validators, nil)
+	for _, validator := range validators {
+		for query(validator, validator.GetCreditedDepositsNum) != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the genesis checkpoint is not signed, so signing does not wait for it
+	signCheckPoint(t, validators)
+	signed_tx := validators[0].GetSignedTxs(1)[0]
+
+	// the next checkpoint waits for the signed checkpoint to be confirmed
+	for _, validator := range validators {
+		assert.Error(t, query(validator, validator.DeriveTxAndSign))
+	}
+	mineSpvBlock(t, &suite, validators, []*wire.MsgTx{signed_tx})
+	for _, validator := range validators {
+		assert.Equal(t, int64(1), query(validator, func() int64 { return validator.GetCheckPointConfirmations(1) }))
+		assert.Error(t, query(validator, validator.DeriveTxAndSign))
+	}
+	mineSpvBlock(t, &suite, validators, nil)
+	for _, validator := range validators {
+		assert.Equal(t, int64(2), query(validator, func() int64 { return validator.GetCheckPointConfirmations(1) }))
+	}
+	signCheckPoint(t, validators)
+	assert.Equal(t, signed_tx.TxHash(), validators[0].GetSignedTxs(2)[0].TxIn[0].PreviousOutPoint.Hash)
+}
@@ -0,0 +1,395 @@
+package wsts
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	STATE_ROOT_TAG = []byte("WSTS/state")
+
+	// protocol state that a lagging validator takes from a quorum of its peers
+	STATE_SYNC_STORE_KEYS = []string{
+		VP_STORE_KEY,
+		KEY_RANGE_STORE_KEY,
+		CHECKPOINT_STORE_KEY,
+		TRANSACTION_STORE_KEY,
+		WITHDRAW_QUEUED_HEIGHT_STORE_KEY,
+		WITHDRAW_REJECTION_STORE_KEY,
+		PAID_WITHDRAWAL_STORE_KEY,
+		PAUSE_VOTE_STORE_KEY,
+		WITHDRAW_PAUSED_STORE_KEY,
+		SIGNED_TX_STORE_KEY,
+		PAYOUT_TX_STORE_KEY,
+		DEPOSIT_STORE_KEY,
+		CONSOLIDATED_DEPOSIT_STORE_KEY,
+	}
+	// on - chain envelopes that a lagging validator takes from any peer, since they are signed by their source
+	STATE_SYNC_ENVELOPE_STORE_KEYS = []string{
+		PROOFS_ENVELOPE_STORE_KEY,
+		NONCE_ENVELOPE_STORE_KEY,
+	}
+)
+
+// a validator that has missed on - chain messages, e.g. it joined late or restarted, asks its peers for protocol state
+// it catches up once enough peers agree on the state root, and rejoins at the phase its peers are in
+// secret shares are private to their receiver, a validator that has missed them needs them resent by their dealer
+func (v *MockValidator) RequestStateSync() {
+	v.logger.Printf("validator %d requests state sync at checkpoint %d\n", v.position, v.btcCheckpointheight)
+	v.stateSyncVotes = make(map[string]map[int64]bool)
+
+	msg := MsgStateRequest{
+		Source: v.position,
+	}
+	envBytes := v.sealEnvelope(&Envelope_StateRequest{StateRequest: &msg})
+	for _, otherVal := range v.otherVals {
+		otherVal.SendMessageOffChain(envBytes)
+	}
+}
+
+// whether this validator is still waiting for enough peers to agree on a state root
+func (v *MockValidator) IsStateSyncing() bool {
+	return v.stateSyncVotes != nil
+}
+
+func (v *MockValidator) handleStateRequest(msg *MsgStateRequest) {
+	otherVal, ok := v.otherVals[msg.Source]
+	if !ok {
+		return
+	}
+
+	envelopes := make([][]byte, 0)
+	for _, store := range STATE_SYNC_ENVELOPE_STORE_KEYS {
+		for _, key := range sortedStoreKeys(v.protocolStorage.store[store]) {
+			envelopes = append(envelopes, v.protocolStorage.store[store][key])
+		}
+	}
+
+	response := MsgStateResponse{
+		Source:    v.position,
+		Envelopes: envelopes,
+		Snapshot:  v.stateSnapshot(),
+	}
+	otherVal.SendMessageOffChain(v.sealEnvelope(&Envelope_StateResponse{StateResponse: &response}))
+}
+
+// envelopes are handled as if they were received on - chain, snapshot is applied once a quorum of peers agree on it
+// responses that arrive after the quorum are dropped, the validator follows the network again
+func (v *MockValidator) handleStateResponse(msg *MsgStateResponse) {
+	if !v.IsStateSyncing() {
+		v.logger.Printf("drop state response from validator %d, state sync is over\n", msg.Source)
+		return
+	}
+
+	for _, envBytes := range msg.Envelopes {
+		env, err := v.verifyEnvelope(envBytes)
+		if err != nil {
+			v.logger.Printf("Rejected envelope in state response from validator %d: %v\n", msg.Source, err)
+			continue
+		}
+		if env.Source == v.position {
+			continue
+		}
+		if !isStateSyncEnvelope(env) {
+			v.logger.Printf("Rejected %s envelope in state response from validator %d\n", envelopeType(env), msg.Source)
+			continue
+		}
+		// an envelope that has already been received directly, or relayed by another peer, is not handled again
+		if err := v.markSequenceSeen(env); err != nil {
+			continue
+		}
+		v.handleEnvelopeOnChain(env)
+	}
+
+	root := stateRoot(msg.Snapshot)
+	if _, ok := v.stateSyncVotes[root]; !ok {
+		v.stateSyncVotes[root] = make(map[int64]bool)
+	}
+	v.stateSyncVotes[root][msg.Source] = true
+	if int64(len(v.stateSyncVotes[root])) < v.stateSyncQuorum() {
+		return
+	}
+
+	v.logger.Printf("validator %d applies state root %s agreed by %d peers\n", v.position, root, len(v.stateSyncVotes[root]))
+	v.applyStateSnapshot(msg.Snapshot)
+	v.stateSyncVotes = nil
+	v.resendOwnEnvelopes()
+}
+
+// peers that finished their own state sync before this validator responded have missed its envelopes
+// peers that already have them drop them as replayed
+func (v *MockValidator) resendOwnEnvelopes() {
+	for _, store := range STATE_SYNC_ENVELOPE_STORE_KEYS {
+		for _, key := range sortedStoreKeys(v.protocolStorage.store[store]) {
+			envBytes := v.protocolStorage.store[store][key]
+			env := &Envelope{}
+			if err := proto.Unmarshal(envBytes, env); err != nil || env.Source != v.position {
+				continue
+			}
+			for posi := int64(1); posi <= v.partyNum; posi++ {
+				if otherVal, ok := v.otherVals[posi]; ok {
+					otherVal.SendMessageOnChain(envBytes)
+				}
+			}
+		}
+	}
+}
+
+// only envelopes of STATE_SYNC_ENVELOPE_STORE_KEYS are relayed in a state response
+func isStateSyncEnvelope(env *Envelope) bool {
+	switch env.Payload.(type) {
+	case *Envelope_UpdateProofs, *Envelope_UpdateNonceCommitments:
+		return true
+	}
+
+	return false
+}
+
+// at least two thirds of peers
+func (v *MockValidator) stateSyncQuorum() int64 {
+	return (2*(v.partyNum-1) + 2) / 3
+}
+
+func (v *MockValidator) stateSnapshot() *StateSnapshot {
+	snapshot := &StateSnapshot{
+		CheckpointHeight: v.btcCheckpointheight,
+		NextSigningIndex: v.nextSigningIndex,
+	}
+	for _, store := range STATE_SYNC_STORE_KEYS {
+		for _, key := range sortedStoreKeys(v.protocolStorage.store[store]) {
+			snapshot.Entries = append(snapshot.Entries, &StateEntry{
+				Store: store,
+				Key:   key,
+				Value: v.protocolStorage.store[store][key],
+			})
+		}
+	}
+
+	return snapshot
+}
+
+// state of the same or a later checkpoint replaces the local state, as the quorum agrees on it and the local state may diverge
+// state of an earlier checkpoint is ignored
+func (v *MockValidator) applyStateSnapshot(snapshot *StateSnapshot) {
+	if snapshot.CheckpointHeight < v.btcCheckpointheight {
+		v.logger.Printf("state snapshot of checkpoint %d is behind checkpoint %d\n", snapshot.CheckpointHeight, v.btcCheckpointheight)
+		return
+	}
+
+	for _, store := range STATE_SYNC_STORE_KEYS {
+		v.protocolStorage.store[store] = make(map[string][]byte)
+	}
+	v.btcCheckpointheight = snapshot.CheckpointHeight
+	for _, entry := range snapshot.Entries {
+		v.protocolStorage.store[entry.Store][entry.Key] = entry.Value
+	}
+	if snapshot.NextSigningIndex > v.nextSigningIndex {
+		v.nextSigningIndex = snapshot.NextSigningIndex
+	}
+}
+
+// snapshots are built in store and key order, so that equal states have equal roots
+func stateRoot(snapshot *StateSnapshot) string {
+	snapshotBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(snapshot)
+	if err != nil {
+		return ""
+	}
+
+	return chainhash.TaggedHash(STATE_ROOT_TAG, snapshotBytes).String()
+}
+
+func sortedStoreKeys(store map[string][]byte) []string {
+	keys := make([]string, 0, len(store))
+	for key := range store {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (v *MockValidator) storeNonceEnvelope(env *Envelope) {
+	envBytes, err := proto.Marshal(env)
+	assert.NoError(v.suite.T, err)
+	// a source sends more nonce commitments when it replenishes its nonce pool
+	key := fmt.Sprintf("%d/%d", env.Source, env.GetUpdateNonceCommitments().GetStartIndex())
+	v.protocolStorage.store[NONCE_ENVELOPE_STORE_KEY][key] = envBytes
+}
+
+// validators request state sync at the current virtual time, and run until all of them have applied a state root
+// requests are sent right away, otherwise no validator is syncing yet when the run begins
+func runSimStateSync(t *testing.T, network *SimNetwork, validators []*MockValidator) bool {
+	for _, validator := range validators {
+		validator.RequestStateSync()
+	}
+
+	return network.Run(func() bool {
+		for _, validator := range validators {
+			if validator.IsStateSyncing() {
+				return false
+			}
+		}
+		return true
+	}, SIM_PHASE_TIMEOUT)
+}
+
+// go test -v -run ^TestStateSyncDKG$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestStateSyncDKG(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	// validator 1 is offline while polynomial commitments are sent, then comes back for secret shares
+	network := NewSimNetwork(SimNetConfig{
+		Seed: 13,
+		Partitions: []SimPartition{{
+			Start:  time.Second,
+			End:    2 * time.Second,
+			Groups: [][]int64{{1}},
+			Drop:   true,
+		}},
+	})
+	validators := newSimValidatorSet(t, &suite, network, 4, 10, 5)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+
+	for _, validator := range validators {
+		network.At(time.Second, validator.DeriveAndSendProofs)
+		network.At(2*time.Second, validator.DeriveAndSendSecretShares)
+	}
+	dkg_finished := func() bool {
+		for _, validator := range validators {
+			if validator.frost.GroupPublicKey == nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	// secret shares wait for polynomial commitments that never arrive
+	assert.False(t, network.Run(dkg_finished, time.Minute))
+	assert.Greater(t, network.Dropped(), 0)
+	assert.Nil(t, validators[0].getPolyCommitments(2))
+	assert.Nil(t, validators[1].getPolyCommitments(1))
+
+	assert.True(t, runSimStateSync(t, network, validators), "state sync has not finished")
+	assert.True(t, network.Run(dkg_finished, SIM_PHASE_TIMEOUT), "DKG has not finished after state sync")
+	for _, validator := range validators[1:] {
+		assert.True(t, validator.frost.GroupPublicKey.IsEqual(validators[0].frost.GroupPublicKey))
+	}
+}
+
+// go test -v -run ^TestStateSyncSigning$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestStateSyncSigning(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	// validator 4 is offline while nonce commitments and a withdraw batch are sent
+	offline := time.Hour
+	network := NewSimNetwork(SimNetConfig{
+		Seed: 17,
+		Partitions: []SimPartition{{
+			Start:  offline,
+			End:    offline + time.Second,
+			Groups: [][]int64{{4}},
+			Drop:   true,
+		}},
+	})
+	validators := newSimValidatorSet(t, &suite, network, 4, 10, 5)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	assert.True(t, runSimDKG(t, network, validators), "DKG has not finished")
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+
+	batch := &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 10), Sequence: 1}
+	network.At(offline, func() {
+		for _, validator := range validators {
+			validator.DeriveAndSendNonces(2)
+			network.SubmitOnChain(validator.position, validator.sealEnvelope(&Envelope_BatchWithdraw{BatchWithdraw: batch}))
+		}
+	})
+	// the partition heals before anything is resent
+	healed := offline + 2*time.Second
+	network.At(healed, func() {})
+	assert.True(t, network.Run(func() bool { return network.Now() >= healed }, healed))
+	lagging := validators[3]
+	assert.Equal(t, 0, lagging.GetPendingTxsNum())
+	_, err := lagging.getNonceCommitments(1, 0)
+	assert.Error(t, err)
+
+	// lagging validator catches up on withdrawals and nonces, and peers on its nonces
+	assert.True(t, runSimStateSync(t, network, validators), "state sync has not finished")
+	for _, validator := range validators {
+		assert.Equal(t, validators[0].stateSnapshot(), validator.stateSnapshot(), "validator %d", validator.position)
+		for posi := int64(1); posi <= validator.partyNum; posi++ {
+			_, err := validator.getNonceCommitments(posi, 0)
+			assert.NoError(t, err, fmt.Sprintf("validator %d has no nonces of validator %d", validator.position, posi))
+		}
+	}
+
+	// all validators sign the checkpoint together
+	assert.True(t, runSimCheckPoint(t, network, validators), "checkpoint has not been finalized")
+	for _, validator := range validators[1:] {
+		assert.Equal(t, validators[0].GetSignedTxs(1)[0].TxHash(), validator.GetSignedTxs(1)[0].TxHash())
+	}
+}
+
+// go test -v -run ^TestStateResponseFilter$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestStateResponseFilter(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validators := newEnvelopeTestValidators(t, &suite, 3)
+	receiver := validators[0]
+	dealer := validators[1]
+	peer := validators[2]
+
+	// dealer stores its own proofs envelope, the receiver misses it
+	dealer.DeriveAndSendProofs()
+	for _, event := range dealer.mailbox.drain() {
+		event()
+	}
+	receiver.mailbox.drain()
+	proofsEnvBytes := dealer.protocolStorage.store[PROOFS_ENVELOPE_STORE_KEY][strconv.FormatInt(dealer.position, 10)]
+	assert.NotNil(t, proofsEnvBytes)
+	vpEnvBytes := dealer.sealEnvelope(&Envelope_UpdateVp{UpdateVp: &MsgUpdateVP{Source: dealer.position, Vp: []byte{1}}})
+	response := func(source *MockValidator, envelopes ...[]byte) *MsgStateResponse {
+		return &MsgStateResponse{Source: source.position, Envelopes: envelopes, Snapshot: peer.stateSnapshot()}
+	}
+
+	// a response is dropped when the receiver is not syncing
+	receiver.handleStateResponse(response(peer, proofsEnvBytes))
+	assert.Nil(t, receiver.getPolyCommitments(dealer.position))
+
+	// only envelopes of state sync payloads are handled
+	receiver.RequestStateSync()
+	receiver.protocolStorage.store[VP_STORE_KEY]["9"] = []byte{9}
+	receiver.handleStateResponse(response(peer, vpEnvBytes, proofsEnvBytes))
+	assert.Nil(t, receiver.protocolStorage.store[VP_STORE_KEY][strconv.FormatInt(dealer.position, 10)])
+	assert.NotNil(t, receiver.getPolyCommitments(dealer.position))
+	assert.True(t, receiver.IsStateSyncing())
+
+	// an envelope relayed again is not handled twice
+	delete(receiver.protocolStorage.store[PROOFS_ENVELOPE_STORE_KEY], strconv.FormatInt(dealer.position, 10))
+	receiver.handleStateResponse(response(dealer, proofsEnvBytes))
+	assert.Nil(t, receiver.protocolStorage.store[PROOFS_ENVELOPE_STORE_KEY][strconv.FormatInt(dealer.position, 10)])
+
+	// state of the same checkpoint replaces the diverged local state once the quorum agrees
+	assert.False(t, receiver.IsStateSyncing())
+	assert.Nil(t, receiver.protocolStorage.store[VP_STORE_KEY]["9"])
+	assert.Equal(t, peer.stateSnapshot(), receiver.stateSnapshot())
+}
@@ -0,0 +1,944 @@
+package wsts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	transcriptDir    = flag.String("transcript.dir", "", "record a transcript of every validator into this directory")
+	transcriptReplay = flag.String("transcript.replay", "", "replay this transcript into a fresh validator")
+	transcriptDiff   = flag.String("transcript.diff", "", "show where the views of the replayed validator and the validator of this transcript first diverged")
+
+	TRANSCRIPT_ROOT_TAG = []byte("WSTS/transcript")
+)
+
+const (
+	// first record of a transcript, the validator it is recorded from
+	TRANSCRIPT_HEADER = "header"
+	// full view of the validator, recorded when its view has changed outside of message handling, e.g. by the test driver
+	TRANSCRIPT_STATE = "state"
+	// message received by the validator, with the changes its handling made to the view
+	TRANSCRIPT_IN = "in"
+	// envelope handled again after it was requeued
+	TRANSCRIPT_REQUEUE = "requeue"
+	// message sent by the validator to a peer
+	TRANSCRIPT_OUT = "out"
+	// prevout read from the mock chain, recorded once
+	TRANSCRIPT_UTXO = "utxo"
+)
+
+type TranscriptHeader struct {
+	Position  int64 `json:"position"`
+	PartyNum  int64 `json:"party_num"`
+	NKeys     int64 `json:"n_keys"`
+	Threshold int64 `json:"threshold"`
+}
+
+// one line of a transcript
+type TranscriptRecord struct {
+	// time since the transcript started, on the clock of the transport
+	At      time.Duration `json:"at"`
+	Kind    string        `json:"kind"`
+	OnChain bool          `json:"on_chain,omitempty"`
+	// destination of an outbound message
+	Peer    int64  `json:"peer,omitempty"`
+	Message []byte `json:"message,omitempty"`
+	// root of the view after the record
+	Root    string        `json:"root,omitempty"`
+	Changes []*ViewChange `json:"changes,omitempty"`
+	// nonces were drawn while handling the message, e.g. when the nonce pool is replenished
+	// they cannot be drawn again on replay, so the recorded changes are taken instead
+	Random bool              `json:"random,omitempty"`
+	Header *TranscriptHeader `json:"header,omitempty"`
+	View   ValidatorView     `json:"view,omitempty"`
+	Utxo   *TranscriptUtxo   `json:"utxo,omitempty"`
+}
+
+type TranscriptUtxo struct {
+	OutPoint string `json:"outpoint"`
+	Value    int64  `json:"value"`
+	PkScript []byte `json:"pk_script"`
+}
+
+// flattened view of a validator
+// protocol/<store>/<key> and local/<store>/<key> are storage entries, validator/<field> are fields of the validator
+// a transcript holds the secret shares of its validator, it is only meant for debugging mock runs
+type ValidatorView map[string][]byte
+
+// value is nil if the entry has been deleted
+type ViewChange struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type transcriptRecorder struct {
+	t assert.TestingT
+	w io.Writer
+	// outbound messages can be sent from goroutines of byzantine peers
+	mu    sync.Mutex
+	enc   *json.Encoder
+	clock func() time.Duration
+	// view and its root as of the last record
+	view ValidatorView
+	root string
+	// prevouts that have already been recorded
+	utxos map[wire.OutPoint]bool
+}
+
+func (r *transcriptRecorder) record(rec *TranscriptRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec.At = r.clock()
+	assert.NoError(r.t, r.enc.Encode(rec))
+}
+
+func (r *transcriptRecorder) Close() {
+	if closer, ok := r.w.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// time elapsed since the clock is created
+func wallClock() func() time.Duration {
+	start := time.Now()
+	return func() time.Duration {
+		return time.Since(start)
+	}
+}
+
+// outbound messages of a validator are recorded on the way to its peer
+type transcriptLink struct {
+	ReceivableValidator
+
+	transcript *transcriptRecorder
+}
+
+func (l *transcriptLink) SendMessageOnChain(msg []byte) {
+	l.transcript.record(&TranscriptRecord{Kind: TRANSCRIPT_OUT, OnChain: true, Peer: l.GetPosition(), Message: msg})
+	l.ReceivableValidator.SendMessageOnChain(msg)
+}
+
+func (l *transcriptLink) SendMessageOffChain(msg []byte) {
+	l.transcript.record(&TranscriptRecord{Kind: TRANSCRIPT_OUT, Peer: l.GetPosition(), Message: msg})
+	l.ReceivableValidator.SendMessageOffChain(msg)
+}
+
+// validator behind a peer, which might be a transcript link
+func unwrapValidator(peer ReceivableValidator) (*MockValidator, bool) {
+	if link, ok := peer.(*transcriptLink); ok {
+		peer = link.ReceivableValidator
+	}
+	validator, ok := peer.(*MockValidator)
+
+	return validator, ok
+}
+
+// record every message this validator receives and sends into w, with the time on clock
+// the transcript starts with the full view of this validator, peers must be connected before
+func (v *MockValidator) StartTranscript(w io.Writer, clock func() time.Duration) {
+	v.transcript = &transcriptRecorder{
+		t:     v.suite.T,
+		w:     w,
+		enc:   json.NewEncoder(w),
+		clock: clock,
+		utxos: make(map[wire.OutPoint]bool),
+	}
+	v.transcript.record(&TranscriptRecord{
+		Kind: TRANSCRIPT_HEADER,
+		Header: &TranscriptHeader{
+			Position:  v.position,
+			PartyNum:  v.partyNum,
+			NKeys:     v.frost.N,
+			Threshold: v.frost.Threshold,
+		},
+	})
+	v.recordViewChange()
+
+	for posi, peer := range v.otherVals {
+		v.otherVals[posi] = &transcriptLink{
+			ReceivableValidator: peer,
+			transcript:          v.transcript,
+		}
+	}
+}
+
+// the view changed since the last record, it is recorded in full
+func (v *MockValidator) recordViewChange() ValidatorView {
+	view := v.view()
+	root := viewRoot(view)
+	if root != v.transcript.root {
+		v.transcript.record(&TranscriptRecord{Kind: TRANSCRIPT_STATE, Root: root, View: view})
+		v.transcript.view = view
+		v.transcript.root = root
+	}
+
+	return view
+}
+
+// handle a message, and record it with the changes its handling made to the view
+func (v *MockValidator) transcribe(kind string, msg []byte, on_chain bool, handle func()) {
+	if v.transcript == nil {
+		handle()
+		return
+	}
+
+	before := v.recordViewChange()
+	nonces := v.frost.SigningNoncesNum()
+	handle()
+	after := v.view()
+	root := viewRoot(after)
+	v.transcript.record(&TranscriptRecord{
+		Kind:    kind,
+		OnChain: on_chain,
+		Message: msg,
+		Root:    root,
+		Changes: diffViews(before, after),
+		Random:  v.frost.SigningNoncesNum() != nonces,
+	})
+	v.transcript.view = after
+	v.transcript.root = root
+}
+
+// prevout from the mock chain, it is recorded so that a replay reads the same chain
+func (v *MockValidator) fetchUtxo(outpoint wire.OutPoint) *wire.TxOut {
+	txOut := v.suite.FetchUtxo(outpoint)
+	if v.transcript == nil || txOut == nil || v.transcript.utxos[outpoint] {
+		return txOut
+	}
+
+	v.transcript.utxos[outpoint] = true
+	v.transcript.record(&TranscriptRecord{
+		Kind: TRANSCRIPT_UTXO,
+		Utxo: &TranscriptUtxo{
+			OutPoint: outpoint.String(),
+			Value:    txOut.Value,
+			PkScript: txOut.PkScript,
+		},
+	})
+
+	return txOut
+}
+
+func (v *MockValidator) view() ValidatorView {
+	view := make(ValidatorView)
+	for storage, stores := range map[string]map[string]map[string][]byte{
+		"protocol": v.protocolStorage.store,
+		"local":    v.localStorage.store,
+	} {
+		for store, entries := range stores {
+			for key, value := range entries {
+				view[storage+"/"+store+"/"+key] = bytes.Clone(value)
+			}
+		}
+	}
+
+	view["validator/checkpoint_height"] = []byte(strconv.FormatInt(v.btcCheckpointheight, 10))
+	view["validator/next_signing_index"] = []byte(strconv.FormatInt(v.nextSigningIndex, 10))
+	view["validator/btc_tip_height"] = []byte(strconv.FormatInt(v.btcTipHeight, 10))
+	view["validator/epoch"] = []byte(strconv.FormatInt(v.epoch, 10))
+	view["validator/sequence"] = []byte(strconv.FormatUint(v.sequence.Load(), 10))
+	view["validator/migrating"] = []byte(strconv.FormatBool(v.migrating))
+	if len(v.reservesMessage) > 0 {
+		view["validator/reserves_message"] = bytes.Clone(v.reservesMessage)
+	}
+	for posi := range v.dishonestVals {
+		view["validator/dishonest/"+strconv.FormatInt(posi, 10)] = []byte{1}
+	}
+
+	v.envelopeMu.Lock()
+	defer v.envelopeMu.Unlock()
+	for source, seen := range v.seenSequences {
+		sequences := make([]string, 0, len(seen))
+		for sequence := range seen {
+			sequences = append(sequences, strconv.FormatUint(sequence, 10))
+		}
+		sort.Strings(sequences)
+		view["validator/seen/"+strconv.FormatInt(source, 10)] = []byte(strings.Join(sequences, ","))
+	}
+
+	return view
+}
+
+// replace the view of this validator, frost state is rebuilt by handling messages
+func (v *MockValidator) applyView(view ValidatorView) {
+	for _, stores := range []map[string]map[string][]byte{v.protocolStorage.store, v.localStorage.store} {
+		for store := range stores {
+			stores[store] = make(map[string][]byte)
+		}
+	}
+	v.dishonestVals = make(map[int64]bool)
+	v.reservesMessage = nil
+	seenSequences := make(map[int64]map[uint64]bool)
+
+	for entry, value := range view {
+		parts := strings.SplitN(entry, "/", 3)
+		switch parts[0] {
+		case "protocol", "local":
+			stores := v.protocolStorage.store
+			if parts[0] == "local" {
+				stores = v.localStorage.store
+			}
+			if _, ok := stores[parts[1]]; !ok {
+				stores[parts[1]] = make(map[string][]byte)
+			}
+			stores[parts[1]][parts[2]] = bytes.Clone(value)
+		case "validator":
+			v.applyViewField(parts[1:], string(value), seenSequences)
+		}
+	}
+
+	v.envelopeMu.Lock()
+	v.seenSequences = seenSequences
+	v.envelopeMu.Unlock()
+
+	// aggregated nonce commitments are calculated when signing, which is not replayed
+	for substore_key := range v.protocolStorage.store {
+		if !strings.HasPrefix(substore_key, PUBLIC_NONCE_COMMITMENTS_STORE_KEY) {
+			continue
+		}
+		signing_index, err := strconv.ParseInt(strings.TrimPrefix(substore_key, PUBLIC_NONCE_COMMITMENTS_STORE_KEY), 10, 64)
+		assert.NoError(v.suite.T, err)
+		aggrNonceCommitment := new(btcec.JacobianPoint)
+		for _, commitment := range v.getPublicNonceCommitments(signing_index) {
+			R_i := new(btcec.JacobianPoint)
+			commitment.AsJacobian(R_i)
+			btcec.AddNonConst(aggrNonceCommitment, R_i, aggrNonceCommitment)
+		}
+		aggrNonceCommitment.ToAffine()
+		v.frost.AggrNonceCommitment[signing_index] = aggrNonceCommitment
+	}
+}
+
+func (v *MockValidator) applyViewField(field []string, value string, seenSequences map[int64]map[uint64]bool) {
+	parseInt := func(s string) int64 {
+		i, err := strconv.ParseInt(s, 10, 64)
+		assert.NoError(v.suite.T, err)
+		return i
+	}
+
+	switch field[0] {
+	case "checkpoint_height":
+		v.btcCheckpointheight = parseInt(value)
+	case "next_signing_index":
+		v.nextSigningIndex = parseInt(value)
+	case "btc_tip_height":
+		v.btcTipHeight = parseInt(value)
+	case "epoch":
+		v.epoch = parseInt(value)
+	case "sequence":
+		v.sequence.Store(uint64(parseInt(value)))
+	case "migrating":
+		v.migrating = value == "true"
+	case "reserves_message":
+		v.reservesMessage = []byte(value)
+	case "dishonest":
+		v.dishonestVals[parseInt(field[1])] = true
+	case "seen":
+		seen := make(map[uint64]bool)
+		for _, sequence := range strings.Split(value, ",") {
+			seen[uint64(parseInt(sequence))] = true
+		}
+		seenSequences[parseInt(field[1])] = seen
+	}
+}
+
+// encoding/json writes map keys in order, so that equal views have equal roots
+func viewRoot(view ValidatorView) string {
+	viewBytes, err := json.Marshal(view)
+	if err != nil {
+		return ""
+	}
+
+	return chainhash.TaggedHash(TRANSCRIPT_ROOT_TAG, viewBytes).String()
+}
+
+// changes from one view to another, in key order
+func diffViews(before, after ValidatorView) []*ViewChange {
+	changes := make([]*ViewChange, 0)
+	for key, value := range after {
+		if prev, ok := before[key]; !ok || !bytes.Equal(prev, value) {
+			changes = append(changes, &ViewChange{Key: key, Value: value})
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, &ViewChange{Key: key})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return changes
+}
+
+func applyChanges(view ValidatorView, changes []*ViewChange) ValidatorView {
+	next := make(ValidatorView, len(view))
+	for key, value := range view {
+		next[key] = value
+	}
+	for _, change := range changes {
+		if change.Value == nil {
+			delete(next, change.Key)
+			continue
+		}
+		next[change.Key] = change.Value
+	}
+
+	return next
+}
+
+func readTranscript(r io.Reader) ([]*TranscriptRecord, error) {
+	records := make([]*TranscriptRecord, 0)
+	scanner := bufio.NewScanner(r)
+	// a state record holds the full view of a validator
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		rec := &TranscriptRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			return nil, fmt.Errorf("malformed transcript record %d: %v", len(records), err)
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
+
+// kind, time and envelope of a record, for reports
+func describeRecord(rec *TranscriptRecord) string {
+	if rec == nil {
+		return "none"
+	}
+	desc := fmt.Sprintf("%s at %v", rec.Kind, rec.At)
+	if rec.Kind != TRANSCRIPT_IN && rec.Kind != TRANSCRIPT_REQUEUE && rec.Kind != TRANSCRIPT_OUT {
+		return desc
+	}
+
+	channel := "off - chain"
+	if rec.OnChain {
+		channel = "on - chain"
+	}
+	env := &Envelope{}
+	if err := proto.Unmarshal(rec.Message, env); err != nil {
+		return fmt.Sprintf("%s, malformed %s message", desc, channel)
+	}
+	desc = fmt.Sprintf("%s, %s %s message from source %d, sequence %d", desc, channel, envelopeType(env), env.Source, env.Sequence)
+	if rec.Kind == TRANSCRIPT_OUT {
+		desc = fmt.Sprintf("%s, to %d", desc, rec.Peer)
+	}
+
+	return desc
+}
+
+// peer of a replayed validator, messages to it are taken by the replay transport
+type replayPeer struct {
+	position int64
+}
+
+func (p *replayPeer) GetPosition() int64 {
+	return p.position
+}
+
+func (p *replayPeer) SendMessageOnChain(msg []byte) {}
+
+func (p *replayPeer) SendMessageOffChain(msg []byte) {}
+
+// messages of a replayed validator are kept to be compared with the recorded ones
+// requeued envelopes are dropped, they are replayed from their own records
+type replayTransport struct {
+	sent []*TranscriptRecord
+}
+
+func (r *replayTransport) send(from, to int64, msg []byte, on_chain bool) {
+	// self - sent messages are recorded when they are received
+	if from == to {
+		return
+	}
+	r.sent = append(r.sent, &TranscriptRecord{Kind: TRANSCRIPT_OUT, OnChain: on_chain, Peer: to, Message: msg})
+}
+
+func (r *replayTransport) requeue(posi int64, env *Envelope, on_chain bool, delay time.Duration) {}
+
+// first record of a transcript that a replay does not reproduce
+type ReplayDivergence struct {
+	Index  int
+	Record *TranscriptRecord
+	Reason string
+}
+
+func (d *ReplayDivergence) String() string {
+	return fmt.Sprintf("record %d (%s): %s", d.Index, describeRecord(d.Record), d.Reason)
+}
+
+// feed a transcript into a fresh validator, which handles every recorded message again
+// the view of the fresh validator is checked against the recorded changes after each message
+// and messages it sends are checked against the recorded ones, up to the signature, as it has another validator key
+// calls of the test driver are not replayed, their effect is taken from state records
+func ReplayTranscript(suite *testhelper.TestSuite, logger *log.Logger, records []*TranscriptRecord) (*MockValidator, *ReplayDivergence, error) {
+	if len(records) == 0 || records[0].Kind != TRANSCRIPT_HEADER {
+		return nil, nil, fmt.Errorf("transcript does not start with a header")
+	}
+	header := records[0].Header
+	frost := testhelper.NewFrostParticipant(suite, logger, header.NKeys, header.Threshold, header.Position, nil)
+	v := newMockValidator(suite, logger, nil, frost, header.PartyNum, header.Position)
+	for posi := int64(1); posi <= header.PartyNum; posi++ {
+		if posi != header.Position {
+			v.otherVals[posi] = &replayPeer{position: posi}
+		}
+	}
+	transport := &replayTransport{}
+	joinTransport(transport, []*MockValidator{v})
+
+	expected := make([]*TranscriptRecord, 0)
+	for index := 1; index < len(records); index++ {
+		rec := records[index]
+		switch rec.Kind {
+		case TRANSCRIPT_STATE:
+			// messages sent by calls of the test driver are not replayed
+			v.applyView(rec.View)
+			expected = expected[:0]
+			transport.sent = nil
+		case TRANSCRIPT_OUT:
+			expected = append(expected, rec)
+		case TRANSCRIPT_UTXO:
+			if err := addReplayUtxo(suite, rec.Utxo); err != nil {
+				return nil, nil, fmt.Errorf("record %d: %v", index, err)
+			}
+		case TRANSCRIPT_IN, TRANSCRIPT_REQUEUE:
+			before := v.view()
+			if err := v.replayRecord(rec); err != nil {
+				return nil, nil, fmt.Errorf("record %d: %v", index, err)
+			}
+			if rec.Random {
+				v.applyView(applyChanges(before, rec.Changes))
+			} else {
+				if reason := compareChanges(rec.Changes, diffViews(before, v.view())); reason != "" {
+					return v, &ReplayDivergence{Index: index, Record: rec, Reason: reason}, nil
+				}
+				if reason := compareOutbound(expected, transport.sent); reason != "" {
+					return v, &ReplayDivergence{Index: index, Record: rec, Reason: reason}, nil
+				}
+			}
+			expected = expected[:0]
+			transport.sent = nil
+		default:
+			return nil, nil, fmt.Errorf("record %d: unknown kind %s", index, rec.Kind)
+		}
+	}
+
+	return v, nil, nil
+}
+
+func (v *MockValidator) replayRecord(rec *TranscriptRecord) error {
+	if rec.Kind == TRANSCRIPT_REQUEUE {
+		env := &Envelope{}
+		if err := proto.Unmarshal(rec.Message, env); err != nil {
+			return fmt.Errorf("malformed requeued envelope: %v", err)
+		}
+		v.handleRequeuedEnvelope(env, rec.OnChain)
+		return nil
+	}
+
+	if rec.OnChain {
+		v.handleMessageOnChain(rec.Message)
+	} else {
+		v.handleMessageOffChain(rec.Message)
+	}
+
+	return nil
+}
+
+func addReplayUtxo(suite *testhelper.TestSuite, utxo *TranscriptUtxo) error {
+	outpoint, err := wire.NewOutPointFromString(utxo.OutPoint)
+	if err != nil {
+		return err
+	}
+	if suite.FetchUtxo(*outpoint) != nil {
+		return nil
+	}
+	entry := blockchain.NewUtxoEntry(wire.NewTxOut(utxo.Value, utxo.PkScript), 0, false)
+	suite.UtxoViewpoint.Entries()[*outpoint] = entry
+
+	return nil
+}
+
+func compareChanges(recorded, replayed []*ViewChange) string {
+	for i := 0; i < len(recorded) || i < len(replayed); i++ {
+		switch {
+		case i >= len(replayed):
+			return fmt.Sprintf("entry %s is not changed on replay", recorded[i].Key)
+		case i >= len(recorded):
+			return fmt.Sprintf("entry %s is only changed on replay", replayed[i].Key)
+		case recorded[i].Key != replayed[i].Key:
+			first := recorded[i].Key
+			if replayed[i].Key < first {
+				first = replayed[i].Key
+			}
+			return fmt.Sprintf("entry %s is changed in only one of recording and replay", first)
+		case !bytes.Equal(recorded[i].Value, replayed[i].Value):
+			return fmt.Sprintf("entry %s is %s on replay, recorded %s", recorded[i].Key, shortHex(replayed[i].Value), shortHex(recorded[i].Value))
+		}
+	}
+
+	return ""
+}
+
+// outbound messages are compared by destination, channel, type and sequence
+// validators send to their peers in map order, so the order of messages is not compared
+func compareOutbound(recorded, replayed []*TranscriptRecord) string {
+	keys := func(records []*TranscriptRecord) []string {
+		keys := make([]string, 0, len(records))
+		for _, rec := range records {
+			env := &Envelope{}
+			proto.Unmarshal(rec.Message, env)
+			keys = append(keys, fmt.Sprintf("%s message, sequence %d, to %d, on - chain %t", envelopeType(env), env.Sequence, rec.Peer, rec.OnChain))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	recordedKeys := keys(recorded)
+	replayedKeys := keys(replayed)
+	for i := 0; i < len(recordedKeys) || i < len(replayedKeys); i++ {
+		switch {
+		case i >= len(replayedKeys):
+			return fmt.Sprintf("%s is not sent on replay", recordedKeys[i])
+		case i >= len(recordedKeys):
+			return fmt.Sprintf("%s is only sent on replay", replayedKeys[i])
+		case recordedKeys[i] != replayedKeys[i]:
+			return fmt.Sprintf("%s is sent on replay, recorded %s", replayedKeys[i], recordedKeys[i])
+		}
+	}
+
+	return ""
+}
+
+func shortHex(value []byte) string {
+	if value == nil {
+		return "deleted"
+	}
+	if len(value) > 16 {
+		return hex.EncodeToString(value[:16]) + "..."
+	}
+
+	return hex.EncodeToString(value)
+}
+
+// a write of a transcript to an entry of the view
+type viewWrite struct {
+	index int
+	rec   *TranscriptRecord
+	value []byte
+}
+
+// entry that two validators first disagreed on
+type ViewDivergence struct {
+	Key string
+	// index of the record of each transcript that wrote the diverging value, -1 if the transcript has no such write
+	IndexA, IndexB int
+	RecordA        *TranscriptRecord
+	RecordB        *TranscriptRecord
+	ValueA, ValueB []byte
+}
+
+func (d *ViewDivergence) String() string {
+	return fmt.Sprintf("entry %s: %s by record %d (%s), %s by record %d (%s)",
+		d.Key, shortHex(d.ValueA), d.IndexA, describeRecord(d.RecordA), shortHex(d.ValueB), d.IndexB, describeRecord(d.RecordB))
+}
+
+// protocol state that all honest validators agree on, see STATE_SYNC_STORE_KEYS
+func isAgreedEntry(key string) bool {
+	for _, store := range STATE_SYNC_STORE_KEYS {
+		if strings.HasPrefix(key, "protocol/"+store+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// values that a transcript wrote to each agreed entry, in order
+func agreedWrites(records []*TranscriptRecord) map[string][]*viewWrite {
+	writes := make(map[string][]*viewWrite)
+	view := make(ValidatorView)
+	for index, rec := range records {
+		var changes []*ViewChange
+		switch rec.Kind {
+		case TRANSCRIPT_STATE:
+			changes = diffViews(view, rec.View)
+			view = rec.View
+		case TRANSCRIPT_IN, TRANSCRIPT_REQUEUE:
+			changes = rec.Changes
+			view = applyChanges(view, changes)
+		}
+		for _, change := range changes {
+			if isAgreedEntry(change.Key) {
+				writes[change.Key] = append(writes[change.Key], &viewWrite{index: index, rec: rec, value: change.Value})
+			}
+		}
+	}
+
+	return writes
+}
+
+// where the views of the validators of two transcripts first diverged, nil if they agree
+// each agreed entry must go through the same values in the same order on both, whenever each one writes it
+// of the entries that do not, the one written earliest is reported
+func DiffTranscripts(a, b []*TranscriptRecord) *ViewDivergence {
+	writesA := agreedWrites(a)
+	writesB := agreedWrites(b)
+	keys := make(map[string]bool)
+	for key := range writesA {
+		keys[key] = true
+	}
+	for key := range writesB {
+		keys[key] = true
+	}
+
+	var first *ViewDivergence
+	var firstAt time.Duration
+	for key := range keys {
+		historyA := writesA[key]
+		historyB := writesB[key]
+		for i := 0; i < len(historyA) || i < len(historyB); i++ {
+			var writeA, writeB *viewWrite
+			if i < len(historyA) {
+				writeA = historyA[i]
+			}
+			if i < len(historyB) {
+				writeB = historyB[i]
+			}
+			if writeA != nil && writeB != nil && bytes.Equal(writeA.value, writeB.value) {
+				continue
+			}
+
+			divergence := &ViewDivergence{Key: key, IndexA: -1, IndexB: -1}
+			at := time.Duration(1<<63 - 1)
+			if writeA != nil {
+				divergence.IndexA, divergence.RecordA, divergence.ValueA = writeA.index, writeA.rec, writeA.value
+				at = writeA.rec.At
+			}
+			if writeB != nil {
+				divergence.IndexB, divergence.RecordB, divergence.ValueB = writeB.index, writeB.rec, writeB.value
+				if writeB.rec.At < at {
+					at = writeB.rec.At
+				}
+			}
+			if first == nil || at < firstAt || (at == firstAt && key < first.Key) {
+				first = divergence
+				firstAt = at
+			}
+			break
+		}
+	}
+
+	return first
+}
+
+// record a transcript of each validator into -transcript.dir, nothing is recorded without it
+func recordTranscripts(t *testing.T, validators []*MockValidator, clock func() time.Duration) {
+	if *transcriptDir == "" {
+		return
+	}
+
+	for _, validator := range validators {
+		path := filepath.Join(*transcriptDir, fmt.Sprintf("validator_%d.transcript.jsonl", validator.position))
+		file, err := os.Create(path)
+		assert.NoError(t, err)
+		validator.Do(func() {
+			validator.StartTranscript(file, clock)
+		})
+	}
+}
+
+func readTranscriptFile(t *testing.T, path string) []*TranscriptRecord {
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	records, err := readTranscript(file)
+	assert.NoError(t, err)
+
+	return records
+}
+
+// transcripts of all validators into memory
+func startMemoryTranscripts(validators []*MockValidator, clock func() time.Duration) []*bytes.Buffer {
+	transcripts := make([]*bytes.Buffer, len(validators))
+	for i, validator := range validators {
+		transcripts[i] = new(bytes.Buffer)
+		validator.StartTranscript(transcripts[i], clock)
+	}
+
+	return transcripts
+}
+
+// DKG and one checkpoint on the simulated network, with transcripts of all validators from before DKG
+func runSimTranscripts(t *testing.T, suite *testhelper.TestSuite, seed int64) ([]*MockValidator, *SimNetwork, []*bytes.Buffer) {
+	network := NewSimNetwork(SimNetConfig{Seed: seed})
+	validators := newSimValidatorSet(t, suite, network, 4, 10, 5)
+	transcripts := startMemoryTranscripts(validators, network.Now)
+
+	assert.True(t, runSimDKG(t, network, validators), "DKG has not finished")
+	mockGenesisCheckPoint(suite, validators, 1000000000)
+	for _, validator := range validators {
+		validator := validator
+		network.At(network.Now(), func() {
+			validator.DeriveAndSendNonces(2)
+		})
+	}
+	batch := &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(suite, 5), Sequence: 1}
+	for _, validator := range validators {
+		network.SubmitOnChain(validator.position, validator.sealEnvelope(&Envelope_BatchWithdraw{BatchWithdraw: batch}))
+	}
+	assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+	assert.True(t, runSimCheckPoint(t, network, validators), "checkpoint has not been finalized")
+	assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+
+	return validators, network, transcripts
+}
+
+// go test -v -run ^TestTranscriptReplay$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestTranscriptReplay(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validators, _, transcripts := runSimTranscripts(t, &suite, 23)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+
+	// each transcript is replayed on a fresh chain, from the prevouts it has recorded
+	for i, validator := range validators {
+		records, err := readTranscript(bytes.NewReader(transcripts[i].Bytes()))
+		assert.NoError(t, err)
+
+		replay_suite := testhelper.TestSuite{}
+		replay_suite.SetupStaticSimNetSuite(t, log.Default())
+		replayed, divergence, err := ReplayTranscript(&replay_suite, log.New(io.Discard, "", 0), records)
+		assert.NoError(t, err)
+		assert.Nil(t, divergence, "validator %d: %v", validator.position, divergence)
+		assert.Equal(t, viewRoot(validator.view()), viewRoot(replayed.view()), "validator %d", validator.position)
+		assert.Equal(t, validator.GetCheckPointHeight(), replayed.GetCheckPointHeight())
+		assert.True(t, validator.frost.GroupPublicKey.IsEqual(replayed.frost.GroupPublicKey))
+	}
+
+	// an adapt sig that no longer verifies is rejected on replay, where it was accepted on recording
+	records, err := readTranscript(bytes.NewReader(transcripts[0].Bytes()))
+	assert.NoError(t, err)
+	tampered := -1
+	for index, rec := range records {
+		env := &Envelope{}
+		if rec.Kind == TRANSCRIPT_IN && len(rec.Changes) > 0 && proto.Unmarshal(rec.Message, env) == nil && env.GetUpdateAdaptSig() != nil {
+			rec.Message[len(rec.Message)-1] ^= 1
+			tampered = index
+			break
+		}
+	}
+	assert.NotEqual(t, -1, tampered)
+
+	replay_suite := testhelper.TestSuite{}
+	replay_suite.SetupStaticSimNetSuite(t, log.Default())
+	_, divergence, err := ReplayTranscript(&replay_suite, log.New(io.Discard, "", 0), records)
+	assert.NoError(t, err)
+	if assert.NotNil(t, divergence) {
+		assert.Equal(t, tampered, divergence.Index)
+		t.Logf("replay diverged at %v", divergence)
+	}
+}
+
+// go test -v -run ^TestTranscriptDiff$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestTranscriptDiff(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validators, network, transcripts := runSimTranscripts(t, &suite, 29)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	read := func(i int) []*TranscriptRecord {
+		records, err := readTranscript(bytes.NewReader(transcripts[i].Bytes()))
+		assert.NoError(t, err)
+		return records
+	}
+
+	// honest validators go through the same protocol state, whatever order messages arrive in
+	for i := 1; i < len(validators); i++ {
+		divergence := DiffTranscripts(read(0), read(i))
+		assert.Nil(t, divergence, "validator 1 and validator %d: %v", i+1, divergence)
+	}
+
+	// a withdraw batch that only validator 1 has read from chain
+	batch := &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 3), Sequence: 2}
+	network.SubmitOnChain(1, validators[0].sealEnvelope(&Envelope_BatchWithdraw{BatchWithdraw: batch}))
+	assert.True(t, network.RunUntilIdle(SIM_PHASE_TIMEOUT))
+
+	records := read(0)
+	divergence := DiffTranscripts(records, read(1))
+	if assert.NotNil(t, divergence) {
+		assert.True(t, strings.HasPrefix(divergence.Key, "protocol/"+TRANSACTION_STORE_KEY+"/"), divergence.Key)
+		assert.Equal(t, len(records)-1, divergence.IndexA)
+		assert.Equal(t, -1, divergence.IndexB)
+		assert.Equal(t, "withdraw_batch", envelopeType(mustUnmarshalEnvelope(t, divergence.RecordA.Message)))
+		t.Logf("views first diverged at %v", divergence)
+	}
+}
+
+func mustUnmarshalEnvelope(t *testing.T, msg []byte) *Envelope {
+	env := &Envelope{}
+	assert.NoError(t, proto.Unmarshal(msg, env))
+
+	return env
+}
+
+// replay a recorded transcript, and diff it against another one
+// go test -v -run ^TestReplayTranscript$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts -args -transcript.replay=../debug/validator_1.transcript.jsonl -transcript.diff=../debug/validator_2.transcript.jsonl
+func TestReplayTranscript(t *testing.T) {
+	if *transcriptReplay == "" {
+		t.Skip("no transcript to replay, record one with -transcript.dir")
+	}
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	records := readTranscriptFile(t, *transcriptReplay)
+	_, divergence, err := ReplayTranscript(&suite, log.New(io.Discard, "", 0), records)
+	assert.NoError(t, err)
+	if divergence != nil {
+		t.Errorf("replay diverged at %v", divergence)
+	} else {
+		t.Logf("%d records have been replayed", len(records))
+	}
+
+	if *transcriptDiff == "" {
+		return
+	}
+	if divergence := DiffTranscripts(records, readTranscriptFile(t, *transcriptDiff)); divergence != nil {
+		t.Logf("views first diverged at %v", divergence)
+	} else {
+		t.Logf("views have not diverged")
+	}
+}
@@ -0,0 +1,473 @@
+package wsts
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"cosmossdk.io/math"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// withdrawal policy that every validator evaluates identically before a withdrawal is signed
+// all validators must be configured with the same rules, as with the batching policy
+//
+// a rule can reject a withdrawal for good when its batch is read from chain, rejections are stored in batch order
+// a rule can hold a queued withdrawal when vault transactions of a checkpoint are planned
+// a held withdrawal and all after it are carried over to the next checkpoint, so that the queue is always paid in order
+type WithdrawalRule interface {
+	// non nil error rejects the withdrawal when it is queued
+	Admit(tx *MsgWithdraw) error
+	// non nil error holds the withdrawal at the checkpoint being planned
+	Hold(w *QueuedWithdrawal, outflow *CheckpointOutflow) error
+}
+
+type WithdrawalPolicy struct {
+	Rules []WithdrawalRule
+}
+
+func (v *MockValidator) SetWithdrawalPolicy(policy WithdrawalPolicy) {
+	v.withdrawalPolicy = policy
+}
+
+type QueuedWithdrawal struct {
+	*MsgWithdraw
+	// checkpoint height that the first vault transactions planned with the withdrawal build on
+	QueuedHeight int64
+}
+
+// withdrawals planned into the checkpoint so far
+type CheckpointOutflow struct {
+	Height     int64
+	Value      int64
+	ByReceiver map[string]int64
+}
+
+func (o *CheckpointOutflow) add(tx *MsgWithdraw) {
+	o.Value += tx.Amount
+	o.ByReceiver[tx.Receiver] += tx.Amount
+}
+
+// max total withdrawal value of a checkpoint
+type OutflowCap struct {
+	MaxValue int64
+}
+
+func (r OutflowCap) Admit(tx *MsgWithdraw) error {
+	if tx.Amount > r.MaxValue {
+		return fmt.Errorf("amount exceeds outflow cap of %d sats per checkpoint", r.MaxValue)
+	}
+	return nil
+}
+
+func (r OutflowCap) Hold(w *QueuedWithdrawal, outflow *CheckpointOutflow) error {
+	if outflow.Value+w.Amount > r.MaxValue {
+		return fmt.Errorf("outflow cap of %d sats is reached at checkpoint %d", r.MaxValue, outflow.Height)
+	}
+	return nil
+}
+
+// max total withdrawal value to a receiver in a checkpoint
+type ReceiverLimit struct {
+	MaxValue int64
+}
+
+func (r ReceiverLimit) Admit(tx *MsgWithdraw) error {
+	if tx.Amount > r.MaxValue {
+		return fmt.Errorf("amount exceeds receiver limit of %d sats per checkpoint", r.MaxValue)
+	}
+	return nil
+}
+
+func (r ReceiverLimit) Hold(w *QueuedWithdrawal, outflow *CheckpointOutflow) error {
+	if outflow.ByReceiver[w.Receiver]+w.Amount > r.MaxValue {
+		return fmt.Errorf("receiver limit of %d sats is reached at checkpoint %d", r.MaxValue, outflow.Height)
+	}
+	return nil
+}
+
+// only these receivers can be paid
+type AllowList struct {
+	Receivers []string
+}
+
+func (r AllowList) Admit(tx *MsgWithdraw) error {
+	for _, receiver := range r.Receivers {
+		if receiver == tx.Receiver {
+			return nil
+		}
+	}
+	return fmt.Errorf("receiver is not allowed")
+}
+
+func (r AllowList) Hold(w *QueuedWithdrawal, outflow *CheckpointOutflow) error {
+	return nil
+}
+
+// these receivers are never paid
+type DenyList struct {
+	Receivers []string
+}
+
+func (r DenyList) Admit(tx *MsgWithdraw) error {
+	for _, receiver := range r.Receivers {
+		if receiver == tx.Receiver {
+			return fmt.Errorf("receiver is denied")
+		}
+	}
+	return nil
+}
+
+func (r DenyList) Hold(w *QueuedWithdrawal, outflow *CheckpointOutflow) error {
+	return nil
+}
+
+// withdrawals of at least min value are paid no earlier than delay checkpoints after they are queued
+// so that there is time to pause withdrawals before a large one leaves the vault
+type LargeWithdrawalDelay struct {
+	MinValue int64
+	Delay    int64
+}
+
+func (r LargeWithdrawalDelay) Admit(tx *MsgWithdraw) error {
+	return nil
+}
+
+func (r LargeWithdrawalDelay) Hold(w *QueuedWithdrawal, outflow *CheckpointOutflow) error {
+	if w.Amount >= r.MinValue && outflow.Height < w.QueuedHeight+r.Delay {
+		return fmt.Errorf("large withdrawal is delayed until checkpoint %d", w.QueuedHeight+r.Delay)
+	}
+	return nil
+}
+
+// withdrawals of a batch that pass all rules, the others are stored as rejections at their index in the batch
+func (v *MockValidator) admitWithdrawals(msg *MsgBatchWithdraw) []*MsgWithdraw {
+	admitted := make([]*MsgWithdraw, 0, len(msg.WithdrawBatch))
+	for i, tx := range msg.WithdrawBatch {
+		var err error
+		if v.isDustWithdrawal(tx) {
+			err = fmt.Errorf("amount is dust")
+		}
+		if err == nil {
+			err = v.exceedsBatchingPolicy(tx)
+		}
+		for _, rule := range v.withdrawalPolicy.Rules {
+			if err != nil {
+				break
+			}
+			err = rule.Admit(tx)
+		}
+		if err != nil {
+			v.logger.Printf("reject withdrawal %v: %v\n", tx, err)
+			v.storeWithdrawRejection(&WithdrawRejection{
+				Sequence: msg.Sequence,
+				Index:    int64(i),
+				Withdraw: tx,
+				Reason:   err.Error(),
+			})
+			continue
+		}
+		admitted = append(admitted, tx)
+	}
+
+	return admitted
+}
+
+func (v *MockValidator) holdWithdrawal(w *QueuedWithdrawal, outflow *CheckpointOutflow) error {
+	for _, rule := range v.withdrawalPolicy.Rules {
+		if err := rule.Hold(w, outflow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *MockValidator) storeWithdrawRejection(rejection *WithdrawRejection) {
+	rejectionBytes, err := proto.Marshal(rejection)
+	assert.NoError(v.suite.T, err)
+	v.protocolStorage.store[WITHDRAW_REJECTION_STORE_KEY][withdrawQueueKey(rejection.Sequence, rejection.Index)] = rejectionBytes
+}
+
+// rejected withdrawals in batch order, the same on every validator
+func (v *MockValidator) GetWithdrawRejections() []*WithdrawRejection {
+	keys := make([]string, 0, len(v.protocolStorage.store[WITHDRAW_REJECTION_STORE_KEY]))
+	for key := range v.protocolStorage.store[WITHDRAW_REJECTION_STORE_KEY] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rejections := make([]*WithdrawRejection, 0, len(keys))
+	for _, key := range keys {
+		rejection := &WithdrawRejection{}
+		err := proto.Unmarshal(v.protocolStorage.store[WITHDRAW_REJECTION_STORE_KEY][key], rejection)
+		assert.NoError(v.suite.T, err)
+		rejections = append(rejections, rejection)
+	}
+
+	return rejections
+}
+
+// vote to pause or resume withdrawals in an emergency
+func (v *MockValidator) SendPauseVote(pause bool) {
+	msg := &MsgPauseVote{
+		Source: v.position,
+		Pause:  pause,
+	}
+	envBytes := v.sealEnvelope(&Envelope_PauseVote{PauseVote: msg})
+	for _, otherVal := range v.otherVals {
+		otherVal.SendMessageOnChain(envBytes)
+	}
+
+	// self - sending so that the vote of this validator is counted in the same loop as others
+	v.SendMessageOnChain(envBytes)
+}
+
+// withdrawals are paused once honest validators with more than 2/3 vp vote to pause
+// and resumed once honest validators with more than 2/3 vp vote to resume, otherwise the flag stays as it is
+func (v *MockValidator) handlePauseVote(msg *MsgPauseVote) {
+	vote := []byte{0}
+	if msg.Pause {
+		vote = []byte{1}
+	}
+	v.protocolStorage.store[PAUSE_VOTE_STORE_KEY][strconv.FormatInt(msg.Source, 10)] = vote
+
+	voted_vp := math.LegacyZeroDec()
+	for posi, other_vote := range v.protocolStorage.store[PAUSE_VOTE_STORE_KEY] {
+		voter, err := strconv.ParseInt(posi, 10, 64)
+		assert.NoError(v.suite.T, err)
+		if _, ok := v.dishonestVals[voter]; ok {
+			continue
+		}
+		if other_vote[0] != vote[0] {
+			continue
+		}
+		vp := bytesToVp(v.suite, v.protocolStorage.store[VP_STORE_KEY][posi])
+		voted_vp = voted_vp.Add(*vp)
+	}
+
+	if voted_vp.MulInt64(3).GT(math.LegacyNewDec(2)) && v.IsWithdrawPaused() != msg.Pause {
+		v.logger.Printf("withdrawals are paused: %v\n", msg.Pause)
+		v.protocolStorage.store[WITHDRAW_PAUSED_STORE_KEY]["paused"] = vote
+	}
+}
+
+func (v *MockValidator) IsWithdrawPaused() bool {
+	paused, ok := v.protocolStorage.store[WITHDRAW_PAUSED_STORE_KEY]["paused"]
+	return ok && paused[0] == 1
+}
+
+// go test -v -run ^TestWithdrawalAdmission$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestWithdrawalAdmission(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	withdrawals := generateMsgWithdrawList(&suite, 6)
+	withdrawals[1].Amount = 100
+	withdrawals[3].Amount = 2000000
+	validator.SetWithdrawalPolicy(WithdrawalPolicy{Rules: []WithdrawalRule{
+		DenyList{Receivers: []string{withdrawals[2].Receiver}},
+		AllowList{Receivers: []string{withdrawals[0].Receiver, withdrawals[2].Receiver, withdrawals[3].Receiver, withdrawals[5].Receiver}},
+		OutflowCap{MaxValue: 1500000},
+	}})
+	validator.handleWithdrawBatch(&MsgBatchWithdraw{WithdrawBatch: withdrawals, Sequence: 7})
+
+	// rejections keep their index in the batch, and the first rule that fails is the reason
+	pending := validator.getAllTxs()
+	assert.Equal(t, 2, len(pending))
+	assert.True(t, proto.Equal(withdrawals[0], pending[0]))
+	assert.True(t, proto.Equal(withdrawals[5], pending[1]))
+	rejections := validator.GetWithdrawRejections()
+	assert.Equal(t, 4, len(rejections))
+	for i, expected := range []struct {
+		index  int64
+		reason string
+	}{
+		{1, "amount is dust"},
+		{2, "receiver is denied"},
+		{3, "amount exceeds outflow cap of 1500000 sats per checkpoint"},
+		{4, "receiver is not allowed"},
+	} {
+		assert.Equal(t, int64(7), rejections[i].Sequence)
+		assert.Equal(t, expected.index, rejections[i].Index)
+		assert.Equal(t, expected.reason, rejections[i].Reason)
+		assert.True(t, proto.Equal(withdrawals[expected.index], rejections[i].Withdraw))
+	}
+}
+
+// go test -v -run ^TestWithdrawalHold$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestWithdrawalHold(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	withdrawals := generateMsgWithdrawList(&suite, 5)
+	for _, withdrawal := range withdrawals {
+		withdrawal.Amount = 100000
+	}
+	withdrawals[1].Receiver = withdrawals[0].Receiver
+	withdrawals[2].Receiver = withdrawals[0].Receiver
+	validator.storeTxs(1, withdrawals)
+
+	// the third withdrawal to the same receiver is held, and all after it are carried over
+	validator.SetWithdrawalPolicy(WithdrawalPolicy{Rules: []WithdrawalRule{ReceiverLimit{MaxValue: 250000}}})
+	vault_txs := validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 1, len(vault_txs))
+	assert.Equal(t, 3, len(vault_txs[0].tx.TxOut))
+
+	// outflow cap counts all withdrawals of the checkpoint
+	validator.SetWithdrawalPolicy(WithdrawalPolicy{Rules: []WithdrawalRule{OutflowCap{MaxValue: 400000}}})
+	vault_txs = validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 5, len(vault_txs[0].tx.TxOut))
+
+	// a large withdrawal at the head of the queue holds the queue until its delay has passed
+	withdrawals[0].Amount = 5000000
+	assert.NoError(t, validator.clearTxs(len(withdrawals)))
+	validator.storeTxs(2, withdrawals)
+	validator.SetWithdrawalPolicy(WithdrawalPolicy{Rules: []WithdrawalRule{LargeWithdrawalDelay{MinValue: 1000000, Delay: 2}}})
+	vault_txs = validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 1, len(vault_txs[0].tx.TxOut))
+
+	// checkpoints move on while the withdrawal waits
+	checkpoint := validator.getBtcCheckPoint(0)
+	for height := int64(1); height <= 2; height++ {
+		checkpoint.Height = height
+		validator.storeBtcCheckPoint(height, checkpoint)
+		validator.btcCheckpointheight = height + 1
+		vault_txs = validator.handleTxs(txscript.SigHashDefault)
+		if height < 2 {
+			assert.Equal(t, 1, len(vault_txs[0].tx.TxOut))
+			continue
+		}
+		assert.Equal(t, 6, len(vault_txs[0].tx.TxOut))
+		assert.Equal(t, int64(5000000), vault_txs[0].tx.TxOut[1].Value)
+	}
+}
+
+// go test -v -run ^TestQueuedHeightAcrossCheckpoint$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestQueuedHeightAcrossCheckpoint(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	advance := func(validator *MockValidator, height int64) {
+		checkpoint := validator.getBtcCheckPoint(0)
+		checkpoint.Height = height
+		validator.storeBtcCheckPoint(height, checkpoint)
+		validator.btcCheckpointheight = height + 1
+	}
+
+	// the batch arrives at the first validator before it finalizes checkpoint 1, and at the second one after
+	withdrawals := generateMsgWithdrawList(&suite, 3)
+	withdrawals[0].Amount = 5000000
+	early := newMockVaultValidator(&suite, 1000000000)
+	defer early.Stop()
+	late := newMockVaultValidator(&suite, 1000000000)
+	defer late.Stop()
+	for _, validator := range []*MockValidator{early, late} {
+		validator.SetWithdrawalPolicy(WithdrawalPolicy{Rules: []WithdrawalRule{LargeWithdrawalDelay{MinValue: 1000000, Delay: 2}}})
+	}
+	early.storeTxs(1, withdrawals)
+	advance(early, 1)
+	advance(late, 1)
+	late.storeTxs(1, withdrawals)
+
+	// both plan checkpoint 2 with the batch, so they queue it at the same height and hold it for as long
+	for height := int64(2); height <= 4; height++ {
+		early_txs := early.handleTxs(txscript.SigHashDefault)
+		late_txs := late.handleTxs(txscript.SigHashDefault)
+		for i, queued := range early.getQueuedTxs() {
+			assert.Equal(t, int64(2), queued.QueuedHeight)
+			assert.Equal(t, queued.QueuedHeight, late.getQueuedTxs()[i].QueuedHeight)
+		}
+		assert.Equal(t, len(early_txs[0].tx.TxOut), len(late_txs[0].tx.TxOut))
+		if height < 4 {
+			assert.Equal(t, 1, len(early_txs[0].tx.TxOut))
+			advance(early, height)
+			advance(late, height)
+			continue
+		}
+		assert.Equal(t, 4, len(early_txs[0].tx.TxOut))
+	}
+}
+
+// go test -v -run ^TestWithdrawalPause$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestWithdrawalPause(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	mockGenesisCheckPoint(&suite, validators, 1000000000)
+	sendNonces(validators, 10)
+
+	sendPauseVotes := func(voters []*MockValidator, pause bool) {
+		for _, validator := range voters {
+			validator.Do(func() {
+				validator.SendPauseVote(pause)
+			})
+		}
+		// probing to see if all votes have been counted
+		for _, validator := range validators {
+			for query(validator, func() int {
+				counted := 0
+				for _, voter := range voters {
+					vote, ok := validator.protocolStorage.store[PAUSE_VOTE_STORE_KEY][strconv.FormatInt(voter.GetPosition(), 10)]
+					if ok && (vote[0] == 1) == pause {
+						counted++
+					}
+				}
+				return counted
+			}) != len(voters) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	// all validators vote to pause, checkpoint moves on without withdrawals
+	sendPauseVotes(validators, true)
+	for _, validator := range validators {
+		assert.True(t, query(validator, validator.IsWithdrawPaused))
+	}
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: generateMsgWithdrawList(&suite, 3), Sequence: 1})
+	signCheckPoint(t, validators)
+	signed_tx := validators[0].GetSignedTxs(1)[0]
+	assert.Equal(t, 1, len(signed_tx.TxOut))
+	suite.MockMineTx(signed_tx, 1)
+	for _, validator := range validators {
+		assert.Equal(t, 3, query(validator, validator.GetPendingTxsNum))
+	}
+
+	// a validator alone cannot resume withdrawals, unless its vp is a supermajority
+	vp := query(validators[0], func() *math.LegacyDec {
+		return bytesToVp(&suite, validators[0].protocolStorage.store[VP_STORE_KEY]["1"])
+	})
+	sendPauseVotes(validators[:1], false)
+	assert.Equal(t, !vp.MulInt64(3).GT(math.LegacyNewDec(2)), query(validators[0], validators[0].IsWithdrawPaused))
+
+	// withdrawals are paid once all validators vote to resume
+	sendPauseVotes(validators, false)
+	for _, validator := range validators {
+		assert.False(t, query(validator, validator.IsWithdrawPaused))
+	}
+	signCheckPoint(t, validators)
+	signed_tx = validators[0].GetSignedTxs(2)[0]
+	assert.Equal(t, 4, len(signed_tx.TxOut))
+	for _, validator := range validators {
+		assert.Equal(t, 0, query(validator, validator.GetPendingTxsNum))
+	}
+}
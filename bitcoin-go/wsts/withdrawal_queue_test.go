@@ -0,0 +1,450 @@
+package wsts
+
+import (
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/nghuyenthevinh2000/bitcoin-playground/testhelper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// limits on how queued withdrawals are batched into vault transactions of a checkpoint
+// zero value of a limit means unlimited
+type BatchingPolicy struct {
+	// max inputs of the first vault transaction, which spends the checkpoint and consolidates credited deposits
+	// the rest of credited deposits are consolidated at the next checkpoints
+	MaxTxInputs int
+	// max withdrawal outputs of a vault transaction, a full transaction is split into a next one
+	MaxTxOutputs int
+	// max weight of a vault transaction, a full transaction is split into a next one
+	MaxTxWeight int64
+	// max withdrawal outputs of a checkpoint, the rest are carried over to the next checkpoint
+	MaxEpochOutputs int
+	// max total weight of vault transactions of a checkpoint
+	MaxEpochWeight int64
+	// max total withdrawal value of a checkpoint
+	MaxEpochValue int64
+}
+
+func (v *MockValidator) SetBatchingPolicy(policy BatchingPolicy) {
+	v.batchingPolicy = policy
+}
+
+// a withdrawal that exceeds checkpoint limits on its own can never be paid
+// it is rejected at admission, otherwise it would block all withdrawals queued after it, since the queue is paid in order
+func (v *MockValidator) exceedsBatchingPolicy(tx *MsgWithdraw) error {
+	policy := v.batchingPolicy
+	if policy.MaxEpochValue > 0 && tx.Amount > policy.MaxEpochValue {
+		return fmt.Errorf("amount exceeds max checkpoint value %d", policy.MaxEpochValue)
+	}
+
+	// a vault transaction after the first one spends a single vault output
+	// the group key may not be known yet, only the size of the taproot vault output matters: OP_1 <32 bytes key>
+	checkpoint_out := &wire.TxOut{PkScript: make([]byte, 34)}
+	txOut := &wire.TxOut{Value: tx.Amount, PkScript: v.withdrawPkScript(tx)}
+	weight := v.estimateVaultTxWeight(1, checkpoint_out, []*wire.TxOut{txOut})
+	if policy.MaxTxWeight > 0 && weight > policy.MaxTxWeight {
+		return fmt.Errorf("weight %d of a vault transaction paying it exceeds max tx weight %d", weight, policy.MaxTxWeight)
+	}
+	if policy.MaxEpochWeight > 0 && weight > policy.MaxEpochWeight {
+		return fmt.Errorf("weight %d of a vault transaction paying it exceeds max checkpoint weight %d", weight, policy.MaxEpochWeight)
+	}
+
+	return nil
+}
+
+func (v *MockValidator) estimateVaultTxWeight(input_num int, checkpoint_out *wire.TxOut, outputs []*wire.TxOut) int64 {
+	tx := wire.NewMsgTx(2)
+	for i := 0; i < input_num; i++ {
+		tx.AddTxIn(&wire.TxIn{
+			Witness: wire.TxWitness{make([]byte, 64)},
+		})
+	}
+	tx.AddTxOut(checkpoint_out)
+	for _, txOut := range outputs {
+		tx.AddTxOut(txOut)
+	}
+
+	return blockchain.GetTransactionWeight(btcutil.NewTx(tx))
+}
+
+// plan outputs of vault transactions of a checkpoint, output 0 of each transaction is the vault output
+// withdrawals are taken in queue order, and a transaction is split into a next one when it reaches policy limits
+// the first withdrawal that cannot be covered, exceeds checkpoint limits or is held by the withdrawal policy, and all after it, are carried over to the next checkpoint
+// so that all validators derive the same batch from the same queue
+//
+// there is always at least one vault transaction to move the vault to the next checkpoint
+// the first transaction spends input_num vault outputs, and each next one spends the vault output of the one before
+// no transaction is planned if the vault balance cannot cover the fee of the first one
+func (v *MockValidator) planVaultTxs(vault_balance int64, input_num int, checkpoint_script []byte, withdrawals []*QueuedWithdrawal) [][]*wire.TxOut {
+	policy := v.batchingPolicy
+	planned := make([][]*wire.TxOut, 0)
+	epoch_outputs := 0
+	epoch_weight := int64(0)
+	epoch_value := int64(0)
+	next := 0
+	carry_over := false
+	outflow := &CheckpointOutflow{
+		Height:     v.btcCheckpointheight,
+		ByReceiver: make(map[string]int64),
+	}
+
+	for {
+		checkpoint_out := &wire.TxOut{
+			PkScript: checkpoint_script,
+		}
+
+		if len(planned) > 0 {
+			input_num = 1
+		}
+
+		// fees are paid from the vault based on the transaction virtual size
+		fee := v.estimateVaultTxFee(input_num, checkpoint_out, nil)
+		if len(planned) == 0 {
+			// a vault output that is negative or dust cannot be signed, the checkpoint waits for more deposits
+			checkpoint_out.Value = vault_balance - fee
+			if checkpoint_out.Value < 0 || mempool.IsDust(checkpoint_out, mempool.DefaultMinRelayTxFee) {
+				v.logger.Printf("vault balance %d cannot cover fee %d of the checkpoint, defer the checkpoint\n", vault_balance, fee)
+				return nil
+			}
+		}
+
+		outputs := make([]*wire.TxOut, 0)
+		for ; next < len(withdrawals); next++ {
+			tx := withdrawals[next]
+			if policy.MaxTxOutputs > 0 && len(outputs) >= policy.MaxTxOutputs {
+				break
+			}
+			if policy.MaxEpochOutputs > 0 && epoch_outputs >= policy.MaxEpochOutputs {
+				carry_over = true
+				break
+			}
+			if policy.MaxEpochValue > 0 && epoch_value+tx.Amount > policy.MaxEpochValue {
+				carry_over = true
+				break
+			}
+			if err := v.holdWithdrawal(tx, outflow); err != nil {
+				v.logger.Printf("hold withdrawal %v: %v, carry over to next checkpoint\n", tx.MsgWithdraw, err)
+				carry_over = true
+				break
+			}
+
+			txOut := &wire.TxOut{
+				Value:    tx.Amount,
+				PkScript: v.withdrawPkScript(tx.MsgWithdraw),
+			}
+			weight := v.estimateVaultTxWeight(input_num, checkpoint_out, append(outputs, txOut))
+			if policy.MaxEpochWeight > 0 && epoch_weight+weight > policy.MaxEpochWeight {
+				carry_over = true
+				break
+			}
+			if policy.MaxTxWeight > 0 && weight > policy.MaxTxWeight {
+				// withdrawals that do not fit into a one input transaction are rejected at admission
+				// but the first transaction also consolidates deposits, then the withdrawal moves on to a next transaction
+				// only a policy tightened after admission can leave a withdrawal that never fits
+				if len(outputs) == 0 && input_num == 1 {
+					v.logger.Printf("withdrawal %v exceeds max tx weight, carry over to next checkpoint\n", tx.MsgWithdraw)
+					carry_over = true
+				}
+				break
+			}
+
+			next_fee := v.estimateVaultTxFee(input_num, checkpoint_out, append(outputs, txOut))
+			remaining := vault_balance - tx.Amount - next_fee
+			checkpoint_out.Value = remaining
+			if remaining < 0 || mempool.IsDust(checkpoint_out, mempool.DefaultMinRelayTxFee) {
+				v.logger.Printf("vault balance %d cannot cover withdrawal %v, defer to next checkpoint\n", vault_balance-fee, tx.MsgWithdraw)
+				carry_over = true
+				break
+			}
+
+			vault_balance -= tx.Amount
+			fee = next_fee
+			epoch_value += tx.Amount
+			epoch_outputs++
+			outflow.add(tx.MsgWithdraw)
+			outputs = append(outputs, txOut)
+		}
+
+		// a next transaction is only created when it processes withdrawals
+		if len(planned) > 0 && len(outputs) == 0 {
+			break
+		}
+
+		checkpoint_out.Value = vault_balance - fee
+		vault_balance = checkpoint_out.Value
+		epoch_weight += v.estimateVaultTxWeight(input_num, checkpoint_out, outputs)
+		planned = append(planned, append([]*wire.TxOut{checkpoint_out}, outputs...))
+
+		if carry_over || next == len(withdrawals) {
+			break
+		}
+	}
+
+	return planned
+}
+
+// go test -v -run ^TestWithdrawalQueueOrder$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestWithdrawalQueueOrder(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	// batches are ordered by sequence, regardless of the order they are stored in
+	batch_1 := generateMsgWithdrawList(&suite, 3)
+	batch_2 := generateMsgWithdrawList(&suite, 12)
+	validator.storeTxs(2, batch_2)
+	validator.storeTxs(1, batch_1)
+
+	expected := append(append([]*MsgWithdraw{}, batch_1...), batch_2...)
+	pending := validator.getAllTxs()
+	assert.Equal(t, len(expected), len(pending))
+	for i := range expected {
+		assert.True(t, proto.Equal(expected[i], pending[i]))
+	}
+
+	// clearing removes the head of the queue
+	assert.NoError(t, validator.clearTxs(5))
+	pending = validator.getAllTxs()
+	assert.Equal(t, len(expected)-5, len(pending))
+	for i := range pending {
+		assert.True(t, proto.Equal(expected[i+5], pending[i]))
+	}
+
+	// the queue is left as it is when there are fewer withdrawals than asked for
+	assert.Error(t, validator.clearTxs(len(pending)+1))
+	assert.Equal(t, len(pending), validator.GetPendingTxsNum())
+}
+
+// go test -v -run ^TestAdvanceCheckPointShortQueue$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestAdvanceCheckPointShortQueue(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	// the signed checkpoint pays 4 withdrawals, while this validator only has 2 of them queued
+	validator.storeTxs(1, generateMsgWithdrawList(&suite, 4))
+	signed_tx := validator.handleTxs(txscript.SigHashDefault)[0].tx
+	assert.Equal(t, 5, len(signed_tx.TxOut))
+	assert.NoError(t, validator.clearTxs(2))
+
+	// the checkpoint still advances, and the validator catches up with its peers instead of panicking
+	validator.advanceCheckPoint([]*wire.MsgTx{signed_tx}, nil)
+	assert.Equal(t, int64(2), validator.GetCheckPointHeight())
+	assert.Equal(t, 2, validator.GetPendingTxsNum())
+	assert.True(t, validator.IsStateSyncing())
+}
+
+// go test -v -run ^TestSplitVaultTxs$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestSplitVaultTxs(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	withdrawals := generateMsgWithdrawList(&suite, 7)
+	validator.storeTxs(1, withdrawals)
+	validator.SetBatchingPolicy(BatchingPolicy{MaxTxOutputs: 3})
+
+	// 7 withdrawals are split into 3 + 3 + 1
+	vault_txs := validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 3, len(vault_txs))
+	assert.Equal(t, 4, len(vault_txs[0].tx.TxOut))
+	assert.Equal(t, 4, len(vault_txs[1].tx.TxOut))
+	assert.Equal(t, 2, len(vault_txs[2].tx.TxOut))
+
+	// vault transactions are chained through output 0, and each pays its own fee
+	balance := vault_txs[0].prevOuts[0].Value
+	included := 0
+	for i, vault_tx := range vault_txs {
+		if i > 0 {
+			assert.Equal(t, vault_txs[i-1].tx.TxHash(), vault_tx.tx.TxIn[0].PreviousOutPoint.Hash)
+			assert.Equal(t, uint32(0), vault_tx.tx.TxIn[0].PreviousOutPoint.Index)
+			assert.Equal(t, vault_txs[i-1].tx.TxOut[0], vault_tx.prevOuts[0])
+		}
+
+		out_value := int64(0)
+		for j, txOut := range vault_tx.tx.TxOut {
+			out_value += txOut.Value
+			if j > 0 {
+				assert.Equal(t, withdrawals[included].Amount, txOut.Value)
+				included++
+			}
+		}
+		fee := validator.estimateVaultTxFee(1, vault_tx.tx.TxOut[0], vault_tx.tx.TxOut[1:])
+		assert.Equal(t, balance-fee, out_value)
+		balance = vault_tx.tx.TxOut[0].Value
+	}
+	assert.Equal(t, len(withdrawals), included)
+
+	// max tx weight splits the same way as max outputs
+	checkpoint_out := vault_txs[0].tx.TxOut[0]
+	validator.SetBatchingPolicy(BatchingPolicy{
+		MaxTxWeight: validator.estimateVaultTxWeight(1, checkpoint_out, vault_txs[0].tx.TxOut[1:]),
+	})
+	vault_txs = validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 3, len(vault_txs))
+	assert.Equal(t, 2, len(vault_txs[2].tx.TxOut))
+}
+
+// go test -v -run ^TestCarryOverWithdrawals$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestCarryOverWithdrawals(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	withdrawals := generateMsgWithdrawList(&suite, 6)
+	for _, withdrawal := range withdrawals {
+		withdrawal.Amount = 100000
+	}
+	validator.storeTxs(1, withdrawals)
+
+	// max epoch value only allows 4 withdrawals
+	validator.SetBatchingPolicy(BatchingPolicy{MaxTxOutputs: 3, MaxEpochValue: 450000})
+	vault_txs := validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 2, len(vault_txs))
+	assert.Equal(t, 4, len(vault_txs[0].tx.TxOut))
+	assert.Equal(t, 2, len(vault_txs[1].tx.TxOut))
+
+	// max epoch outputs only allows 2 withdrawals
+	validator.SetBatchingPolicy(BatchingPolicy{MaxEpochOutputs: 2})
+	vault_txs = validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 1, len(vault_txs))
+	assert.Equal(t, 3, len(vault_txs[0].tx.TxOut))
+
+	// max epoch weight only allows the first vault transaction
+	validator.SetBatchingPolicy(BatchingPolicy{
+		MaxTxOutputs:   3,
+		MaxEpochWeight: validator.estimateVaultTxWeight(1, vault_txs[0].tx.TxOut[0], vault_txs[0].tx.TxOut[1:]) + 200,
+	})
+	vault_txs = validator.handleTxs(txscript.SigHashDefault)
+	assert.Equal(t, 1, len(vault_txs))
+	assert.Equal(t, 4, len(vault_txs[0].tx.TxOut))
+
+	// carried over withdrawals stay at the head of the queue
+	assert.NoError(t, validator.clearTxs(len(vault_txs[0].tx.TxOut)-1))
+	pending := validator.getAllTxs()
+	assert.Equal(t, 3, len(pending))
+	for i := range pending {
+		assert.True(t, proto.Equal(withdrawals[i+3], pending[i]))
+	}
+
+}
+
+// go test -v -run ^TestRejectUnpayableWithdrawals$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestRejectUnpayableWithdrawals(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	validator := newMockVaultValidator(&suite, 1000000000)
+	defer validator.Stop()
+
+	// p2wpkh withdrawals fit into a one input vault transaction, but a p2tr withdrawal, with a larger output, does not
+	withdrawals := generateMsgWithdrawList(&suite, 4)
+	for i, withdrawal := range withdrawals {
+		withdrawal.Amount = 100000
+		if i == 1 {
+			continue
+		}
+		_, key_pair := suite.NewHDKeyPairFromSeed("")
+		address, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(key_pair.Pub.SerializeCompressed()), suite.BtcdChainConfig)
+		assert.NoError(t, err)
+		withdrawal.Receiver = address.EncodeAddress()
+	}
+	// a withdrawal above max checkpoint value is never paid either
+	withdrawals[2].Amount = 500001
+
+	checkpoint_out := &wire.TxOut{PkScript: validator.vaultPkScript(validator.frost.GroupPublicKey)}
+	p2wpkh_out := &wire.TxOut{Value: withdrawals[0].Amount, PkScript: validator.withdrawPkScript(withdrawals[0])}
+	validator.SetBatchingPolicy(BatchingPolicy{
+		MaxTxWeight:   validator.estimateVaultTxWeight(1, checkpoint_out, []*wire.TxOut{p2wpkh_out}),
+		MaxEpochValue: 500000,
+	})
+	validator.handleWithdrawBatch(&MsgBatchWithdraw{WithdrawBatch: withdrawals, Sequence: 1})
+
+	rejections := validator.GetWithdrawRejections()
+	assert.Equal(t, 2, len(rejections))
+	assert.Equal(t, int64(1), rejections[0].Index)
+	assert.Contains(t, rejections[0].Reason, "max tx weight")
+	assert.Equal(t, int64(2), rejections[1].Index)
+	assert.Contains(t, rejections[1].Reason, "max checkpoint value")
+
+	// later withdrawals are paid, one per vault transaction
+	vault_txs := validator.handleTxs(txscript.SigHashDefault)
+	paid := make([]int64, 0)
+	for _, vault_tx := range vault_txs {
+		for _, txOut := range vault_tx.tx.TxOut[1:] {
+			paid = append(paid, txOut.Value)
+		}
+	}
+	assert.Equal(t, []int64{withdrawals[0].Amount, withdrawals[3].Amount}, paid)
+	assert.Equal(t, 2, len(vault_txs))
+	assert.Equal(t, 2, len(validator.getAllTxs()))
+}
+
+// go test -v -run ^TestMultiTxCheckPoint$ github.com/nghuyenthevinh2000/bitcoin-playground/wsts
+func TestMultiTxCheckPoint(t *testing.T) {
+	suite := testhelper.TestSuite{}
+	suite.SetupStaticSimNetSuite(t, log.Default())
+
+	n := int64(4)
+	validators := setupMockValidatorSet(t, &suite, n, 10, 7)
+	defer func() {
+		for _, validator := range validators {
+			validator.Stop()
+		}
+	}()
+	for _, validator := range validators {
+		validator.SetBatchingPolicy(BatchingPolicy{MaxTxOutputs: 4, MaxEpochOutputs: 10})
+	}
+
+	first_tx := mockGenesisCheckPoint(&suite, validators, 1000000000)
+	sendNonces(validators, 20)
+
+	// batches are delivered to each validator in a different order
+	batch_1 := generateMsgWithdrawList(&suite, 6)
+	batch_2 := generateMsgWithdrawList(&suite, 6)
+	sendWithdrawBatch(t, validators[:2], &MsgBatchWithdraw{WithdrawBatch: batch_1, Sequence: 1})
+	sendWithdrawBatch(t, validators, &MsgBatchWithdraw{WithdrawBatch: batch_2, Sequence: 2})
+	sendWithdrawBatch(t, validators[2:], &MsgBatchWithdraw{WithdrawBatch: batch_1, Sequence: 1})
+
+	// first checkpoint: 10 withdrawals in 3 vault transactions, 2 carried over
+	signCheckPoint(t, validators)
+	signed_txs := validators[0].GetSignedTxs(1)
+	assert.Equal(t, 3, len(signed_txs))
+	for i := int64(1); i < n; i++ {
+		other_txs := validators[i].GetSignedTxs(1)
+		assert.Equal(t, len(signed_txs), len(other_txs))
+		for j := range signed_txs {
+			assert.Equal(t, signed_txs[j].TxHash(), other_txs[j].TxHash())
+		}
+		assert.Equal(t, 2, validators[i].GetPendingTxsNum())
+	}
+	assert.Equal(t, first_tx.TxHash(), signed_txs[0].TxIn[0].PreviousOutPoint.Hash)
+	for _, signed_tx := range signed_txs {
+		suite.MockMineTx(signed_tx, 1)
+	}
+
+	// second checkpoint: carried over withdrawals in 1 vault transaction
+	signCheckPoint(t, validators)
+	signed_txs = validators[0].GetSignedTxs(2)
+	assert.Equal(t, 1, len(signed_txs))
+	assert.Equal(t, 3, len(signed_txs[0].TxOut))
+	assert.Equal(t, batch_2[4].Amount, signed_txs[0].TxOut[1].Value)
+	assert.Equal(t, batch_2[5].Amount, signed_txs[0].TxOut[2].Value)
+	assert.Equal(t, 0, validators[0].GetPendingTxsNum())
+}
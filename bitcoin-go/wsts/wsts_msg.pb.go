@@ -20,6 +20,242 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// every validator message is wrapped in an envelope signed by the validator key of its source
+type Envelope struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Source  int64  `protobuf:"varint,2,opt,name=source,proto3" json:"source,omitempty"`
+	// group key epoch, increased on every vault migration
+	Epoch int64 `protobuf:"varint,3,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	// increased on every envelope of a source, a replayed envelope is rejected
+	Sequence uint64 `protobuf:"varint,4,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Types that are assignable to Payload:
+	//	*Envelope_UpdateVp
+	//	*Envelope_UpdateProofs
+	//	*Envelope_SecretShares
+	//	*Envelope_UpdateNonceCommitments
+	//	*Envelope_BatchWithdraw
+	//	*Envelope_UpdateAdaptSig
+	//	*Envelope_DepositAttest
+	//	*Envelope_StateRequest
+	//	*Envelope_StateResponse
+	//	*Envelope_PauseVote
+	Payload isEnvelope_Payload `protobuf_oneof:"payload"`
+	// schnorr signature over the envelope without signature
+	Signature []byte `protobuf:"bytes,15,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Envelope) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Envelope) GetSource() int64 {
+	if x != nil {
+		return x.Source
+	}
+	return 0
+}
+
+func (x *Envelope) GetEpoch() int64 {
+	if x != nil {
+		return x.Epoch
+	}
+	return 0
+}
+
+func (x *Envelope) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (m *Envelope) GetPayload() isEnvelope_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (x *Envelope) GetUpdateVp() *MsgUpdateVP {
+	if x, ok := x.GetPayload().(*Envelope_UpdateVp); ok {
+		return x.UpdateVp
+	}
+	return nil
+}
+
+func (x *Envelope) GetUpdateProofs() *MsgUpdateProofs {
+	if x, ok := x.GetPayload().(*Envelope_UpdateProofs); ok {
+		return x.UpdateProofs
+	}
+	return nil
+}
+
+func (x *Envelope) GetSecretShares() *MsgSecretShares {
+	if x, ok := x.GetPayload().(*Envelope_SecretShares); ok {
+		return x.SecretShares
+	}
+	return nil
+}
+
+func (x *Envelope) GetUpdateNonceCommitments() *MsgUpdateNonceCommitments {
+	if x, ok := x.GetPayload().(*Envelope_UpdateNonceCommitments); ok {
+		return x.UpdateNonceCommitments
+	}
+	return nil
+}
+
+func (x *Envelope) GetBatchWithdraw() *MsgBatchWithdraw {
+	if x, ok := x.GetPayload().(*Envelope_BatchWithdraw); ok {
+		return x.BatchWithdraw
+	}
+	return nil
+}
+
+func (x *Envelope) GetUpdateAdaptSig() *MsgUpdateAdaptSig {
+	if x, ok := x.GetPayload().(*Envelope_UpdateAdaptSig); ok {
+		return x.UpdateAdaptSig
+	}
+	return nil
+}
+
+func (x *Envelope) GetDepositAttest() *MsgDepositAttest {
+	if x, ok := x.GetPayload().(*Envelope_DepositAttest); ok {
+		return x.DepositAttest
+	}
+	return nil
+}
+
+func (x *Envelope) GetStateRequest() *MsgStateRequest {
+	if x, ok := x.GetPayload().(*Envelope_StateRequest); ok {
+		return x.StateRequest
+	}
+	return nil
+}
+
+func (x *Envelope) GetStateResponse() *MsgStateResponse {
+	if x, ok := x.GetPayload().(*Envelope_StateResponse); ok {
+		return x.StateResponse
+	}
+	return nil
+}
+
+func (x *Envelope) GetPauseVote() *MsgPauseVote {
+	if x, ok := x.GetPayload().(*Envelope_PauseVote); ok {
+		return x.PauseVote
+	}
+	return nil
+}
+
+func (x *Envelope) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+type isEnvelope_Payload interface {
+	isEnvelope_Payload()
+}
+
+type Envelope_UpdateVp struct {
+	UpdateVp *MsgUpdateVP `protobuf:"bytes,5,opt,name=update_vp,json=updateVp,proto3,oneof"`
+}
+
+type Envelope_UpdateProofs struct {
+	UpdateProofs *MsgUpdateProofs `protobuf:"bytes,6,opt,name=update_proofs,json=updateProofs,proto3,oneof"`
+}
+
+type Envelope_SecretShares struct {
+	SecretShares *MsgSecretShares `protobuf:"bytes,7,opt,name=secret_shares,json=secretShares,proto3,oneof"`
+}
+
+type Envelope_UpdateNonceCommitments struct {
+	UpdateNonceCommitments *MsgUpdateNonceCommitments `protobuf:"bytes,8,opt,name=update_nonce_commitments,json=updateNonceCommitments,proto3,oneof"`
+}
+
+type Envelope_BatchWithdraw struct {
+	BatchWithdraw *MsgBatchWithdraw `protobuf:"bytes,9,opt,name=batch_withdraw,json=batchWithdraw,proto3,oneof"`
+}
+
+type Envelope_UpdateAdaptSig struct {
+	UpdateAdaptSig *MsgUpdateAdaptSig `protobuf:"bytes,10,opt,name=update_adapt_sig,json=updateAdaptSig,proto3,oneof"`
+}
+
+type Envelope_DepositAttest struct {
+	DepositAttest *MsgDepositAttest `protobuf:"bytes,11,opt,name=deposit_attest,json=depositAttest,proto3,oneof"`
+}
+
+type Envelope_StateRequest struct {
+	StateRequest *MsgStateRequest `protobuf:"bytes,12,opt,name=state_request,json=stateRequest,proto3,oneof"`
+}
+
+type Envelope_StateResponse struct {
+	StateResponse *MsgStateResponse `protobuf:"bytes,13,opt,name=state_response,json=stateResponse,proto3,oneof"`
+}
+
+type Envelope_PauseVote struct {
+	PauseVote *MsgPauseVote `protobuf:"bytes,14,opt,name=pause_vote,json=pauseVote,proto3,oneof"`
+}
+
+func (*Envelope_UpdateVp) isEnvelope_Payload() {}
+
+func (*Envelope_UpdateProofs) isEnvelope_Payload() {}
+
+func (*Envelope_SecretShares) isEnvelope_Payload() {}
+
+func (*Envelope_UpdateNonceCommitments) isEnvelope_Payload() {}
+
+func (*Envelope_BatchWithdraw) isEnvelope_Payload() {}
+
+func (*Envelope_UpdateAdaptSig) isEnvelope_Payload() {}
+
+func (*Envelope_DepositAttest) isEnvelope_Payload() {}
+
+func (*Envelope_StateRequest) isEnvelope_Payload() {}
+
+func (*Envelope_StateResponse) isEnvelope_Payload() {}
+
+func (*Envelope_PauseVote) isEnvelope_Payload() {}
+
 type MsgUpdateVP struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -32,7 +268,7 @@ type MsgUpdateVP struct {
 func (x *MsgUpdateVP) Reset() {
 	*x = MsgUpdateVP{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[0]
+		mi := &file_proto_wsts_msg_proto_msgTypes[1]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -45,7 +281,7 @@ func (x *MsgUpdateVP) String() string {
 func (*MsgUpdateVP) ProtoMessage() {}
 
 func (x *MsgUpdateVP) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[0]
+	mi := &file_proto_wsts_msg_proto_msgTypes[1]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -58,7 +294,7 @@ func (x *MsgUpdateVP) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MsgUpdateVP.ProtoReflect.Descriptor instead.
 func (*MsgUpdateVP) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{0}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{1}
 }
 
 func (x *MsgUpdateVP) GetSource() int64 {
@@ -88,7 +324,7 @@ type MsgUpdateProofs struct {
 func (x *MsgUpdateProofs) Reset() {
 	*x = MsgUpdateProofs{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[1]
+		mi := &file_proto_wsts_msg_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -101,7 +337,7 @@ func (x *MsgUpdateProofs) String() string {
 func (*MsgUpdateProofs) ProtoMessage() {}
 
 func (x *MsgUpdateProofs) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[1]
+	mi := &file_proto_wsts_msg_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -114,7 +350,7 @@ func (x *MsgUpdateProofs) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MsgUpdateProofs.ProtoReflect.Descriptor instead.
 func (*MsgUpdateProofs) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{1}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *MsgUpdateProofs) GetSource() int64 {
@@ -150,7 +386,7 @@ type MsgSecretShares struct {
 func (x *MsgSecretShares) Reset() {
 	*x = MsgSecretShares{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[2]
+		mi := &file_proto_wsts_msg_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -163,7 +399,7 @@ func (x *MsgSecretShares) String() string {
 func (*MsgSecretShares) ProtoMessage() {}
 
 func (x *MsgSecretShares) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[2]
+	mi := &file_proto_wsts_msg_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -176,7 +412,7 @@ func (x *MsgSecretShares) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MsgSecretShares.ProtoReflect.Descriptor instead.
 func (*MsgSecretShares) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{2}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *MsgSecretShares) GetSource() int64 {
@@ -205,7 +441,7 @@ type SecretShares struct {
 func (x *SecretShares) Reset() {
 	*x = SecretShares{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[3]
+		mi := &file_proto_wsts_msg_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -218,7 +454,7 @@ func (x *SecretShares) String() string {
 func (*SecretShares) ProtoMessage() {}
 
 func (x *SecretShares) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[3]
+	mi := &file_proto_wsts_msg_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -231,7 +467,7 @@ func (x *SecretShares) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SecretShares.ProtoReflect.Descriptor instead.
 func (*SecretShares) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{3}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *SecretShares) GetPosi() int64 {
@@ -255,12 +491,14 @@ type MsgUpdateNonceCommitments struct {
 
 	Source           int64               `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
 	NonceCommitments []*NonceCommitments `protobuf:"bytes,2,rep,name=nonce_commitments,json=nonceCommitments,proto3" json:"nonce_commitments,omitempty"`
+	// signing index of the first nonce commitments, replenished nonces follow the ones already sent
+	StartIndex int64 `protobuf:"varint,3,opt,name=start_index,json=startIndex,proto3" json:"start_index,omitempty"`
 }
 
 func (x *MsgUpdateNonceCommitments) Reset() {
 	*x = MsgUpdateNonceCommitments{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[4]
+		mi := &file_proto_wsts_msg_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -273,7 +511,7 @@ func (x *MsgUpdateNonceCommitments) String() string {
 func (*MsgUpdateNonceCommitments) ProtoMessage() {}
 
 func (x *MsgUpdateNonceCommitments) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[4]
+	mi := &file_proto_wsts_msg_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -286,7 +524,7 @@ func (x *MsgUpdateNonceCommitments) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MsgUpdateNonceCommitments.ProtoReflect.Descriptor instead.
 func (*MsgUpdateNonceCommitments) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{4}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *MsgUpdateNonceCommitments) GetSource() int64 {
@@ -303,6 +541,13 @@ func (x *MsgUpdateNonceCommitments) GetNonceCommitments() []*NonceCommitments {
 	return nil
 }
 
+func (x *MsgUpdateNonceCommitments) GetStartIndex() int64 {
+	if x != nil {
+		return x.StartIndex
+	}
+	return 0
+}
+
 type NonceCommitments struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -315,7 +560,7 @@ type NonceCommitments struct {
 func (x *NonceCommitments) Reset() {
 	*x = NonceCommitments{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[5]
+		mi := &file_proto_wsts_msg_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -328,7 +573,7 @@ func (x *NonceCommitments) String() string {
 func (*NonceCommitments) ProtoMessage() {}
 
 func (x *NonceCommitments) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[5]
+	mi := &file_proto_wsts_msg_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -341,7 +586,7 @@ func (x *NonceCommitments) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NonceCommitments.ProtoReflect.Descriptor instead.
 func (*NonceCommitments) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{5}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *NonceCommitments) GetD() []byte {
@@ -370,7 +615,7 @@ type MsgWithdraw struct {
 func (x *MsgWithdraw) Reset() {
 	*x = MsgWithdraw{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[6]
+		mi := &file_proto_wsts_msg_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -383,7 +628,7 @@ func (x *MsgWithdraw) String() string {
 func (*MsgWithdraw) ProtoMessage() {}
 
 func (x *MsgWithdraw) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[6]
+	mi := &file_proto_wsts_msg_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -396,7 +641,7 @@ func (x *MsgWithdraw) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MsgWithdraw.ProtoReflect.Descriptor instead.
 func (*MsgWithdraw) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{6}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *MsgWithdraw) GetReceiver() string {
@@ -419,12 +664,13 @@ type MsgBatchWithdraw struct {
 	unknownFields protoimpl.UnknownFields
 
 	WithdrawBatch []*MsgWithdraw `protobuf:"bytes,1,rep,name=withdraw_batch,json=withdrawBatch,proto3" json:"withdraw_batch,omitempty"`
+	Sequence      int64          `protobuf:"varint,2,opt,name=sequence,proto3" json:"sequence,omitempty"`
 }
 
 func (x *MsgBatchWithdraw) Reset() {
 	*x = MsgBatchWithdraw{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[7]
+		mi := &file_proto_wsts_msg_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -437,7 +683,7 @@ func (x *MsgBatchWithdraw) String() string {
 func (*MsgBatchWithdraw) ProtoMessage() {}
 
 func (x *MsgBatchWithdraw) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[7]
+	mi := &file_proto_wsts_msg_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -450,7 +696,7 @@ func (x *MsgBatchWithdraw) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MsgBatchWithdraw.ProtoReflect.Descriptor instead.
 func (*MsgBatchWithdraw) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{7}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *MsgBatchWithdraw) GetWithdrawBatch() []*MsgWithdraw {
@@ -460,6 +706,13 @@ func (x *MsgBatchWithdraw) GetWithdrawBatch() []*MsgWithdraw {
 	return nil
 }
 
+func (x *MsgBatchWithdraw) GetSequence() int64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
 type BtcCheckPoint struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -473,7 +726,7 @@ type BtcCheckPoint struct {
 func (x *BtcCheckPoint) Reset() {
 	*x = BtcCheckPoint{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[8]
+		mi := &file_proto_wsts_msg_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -486,7 +739,7 @@ func (x *BtcCheckPoint) String() string {
 func (*BtcCheckPoint) ProtoMessage() {}
 
 func (x *BtcCheckPoint) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[8]
+	mi := &file_proto_wsts_msg_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -499,7 +752,7 @@ func (x *BtcCheckPoint) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BtcCheckPoint.ProtoReflect.Descriptor instead.
 func (*BtcCheckPoint) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{8}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *BtcCheckPoint) GetHeight() int64 {
@@ -528,14 +781,23 @@ type MsgUpdateAdaptSig struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Source   int64  `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
-	AdaptSig []byte `protobuf:"bytes,2,opt,name=adapt_sig,json=adaptSig,proto3" json:"adapt_sig,omitempty"`
+	Source           int64  `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
+	AdaptSig         []byte `protobuf:"bytes,2,opt,name=adapt_sig,json=adaptSig,proto3" json:"adapt_sig,omitempty"`
+	CheckpointHeight int64  `protobuf:"varint,3,opt,name=checkpoint_height,json=checkpointHeight,proto3" json:"checkpoint_height,omitempty"`
+	TxIndex          int64  `protobuf:"varint,4,opt,name=tx_index,json=txIndex,proto3" json:"tx_index,omitempty"`
+	InputIndex       int64  `protobuf:"varint,5,opt,name=input_index,json=inputIndex,proto3" json:"input_index,omitempty"`
+	SigningIndex     int64  `protobuf:"varint,6,opt,name=signing_index,json=signingIndex,proto3" json:"signing_index,omitempty"`
+	// message and signers that the adapt sig is computed over, so that an invalid adapt sig is attributable
+	SigHash []byte  `protobuf:"bytes,7,opt,name=sig_hash,json=sigHash,proto3" json:"sig_hash,omitempty"`
+	Signers []int64 `protobuf:"varint,8,rep,packed,name=signers,proto3" json:"signers,omitempty"`
+	// set when the adapt sig signs a proof of reserves over this message instead of the checkpoint
+	ReservesMessage []byte `protobuf:"bytes,9,opt,name=reserves_message,json=reservesMessage,proto3" json:"reserves_message,omitempty"`
 }
 
 func (x *MsgUpdateAdaptSig) Reset() {
 	*x = MsgUpdateAdaptSig{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_wsts_msg_proto_msgTypes[9]
+		mi := &file_proto_wsts_msg_proto_msgTypes[10]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -548,7 +810,7 @@ func (x *MsgUpdateAdaptSig) String() string {
 func (*MsgUpdateAdaptSig) ProtoMessage() {}
 
 func (x *MsgUpdateAdaptSig) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_wsts_msg_proto_msgTypes[9]
+	mi := &file_proto_wsts_msg_proto_msgTypes[10]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -561,7 +823,7 @@ func (x *MsgUpdateAdaptSig) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MsgUpdateAdaptSig.ProtoReflect.Descriptor instead.
 func (*MsgUpdateAdaptSig) Descriptor() ([]byte, []int) {
-	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{9}
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *MsgUpdateAdaptSig) GetSource() int64 {
@@ -578,128 +840,1354 @@ func (x *MsgUpdateAdaptSig) GetAdaptSig() []byte {
 	return nil
 }
 
-var File_proto_wsts_msg_proto protoreflect.FileDescriptor
+func (x *MsgUpdateAdaptSig) GetCheckpointHeight() int64 {
+	if x != nil {
+		return x.CheckpointHeight
+	}
+	return 0
+}
 
-var file_proto_wsts_msg_proto_rawDesc = []byte{
-	0x0a, 0x14, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x77, 0x73, 0x74, 0x73, 0x5f, 0x6d, 0x73, 0x67,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x35, 0x0a,
-	0x0b, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x56, 0x50, 0x12, 0x16, 0x0a, 0x06,
-	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f,
-	0x75, 0x72, 0x63, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x76, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c,
-	0x52, 0x02, 0x76, 0x70, 0x22, 0x85, 0x01, 0x0a, 0x0f, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64, 0x61,
-	0x74, 0x65, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72,
-	0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65,
-	0x12, 0x23, 0x0a, 0x0d, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x70, 0x72, 0x6f, 0x6f, 0x66,
-	0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x50,
-	0x72, 0x6f, 0x6f, 0x66, 0x73, 0x12, 0x35, 0x0a, 0x16, 0x70, 0x6f, 0x6c, 0x79, 0x6e, 0x6f, 0x6d,
-	0x69, 0x61, 0x6c, 0x5f, 0x63, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x18,
-	0x03, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x15, 0x70, 0x6f, 0x6c, 0x79, 0x6e, 0x6f, 0x6d, 0x69, 0x61,
-	0x6c, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x22, 0x63, 0x0a, 0x0f,
-	0x4d, 0x73, 0x67, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x12,
-	0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x38, 0x0a, 0x0d, 0x73, 0x65, 0x63, 0x72, 0x65,
-	0x74, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61,
-	0x72, 0x65, 0x73, 0x52, 0x0c, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65,
-	0x73, 0x22, 0x47, 0x0a, 0x0c, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65,
-	0x73, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x73, 0x69, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x04, 0x70, 0x6f, 0x73, 0x69, 0x12, 0x23, 0x0a, 0x0d, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f,
-	0x73, 0x68, 0x61, 0x72, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x73, 0x65,
-	0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x22, 0x79, 0x0a, 0x19, 0x4d, 0x73,
-	0x67, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d,
-	0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63,
-	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12,
-	0x44, 0x0a, 0x11, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x5f, 0x63, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d,
-	0x65, 0x6e, 0x74, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x2e, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65,
-	0x6e, 0x74, 0x73, 0x52, 0x10, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74,
-	0x6d, 0x65, 0x6e, 0x74, 0x73, 0x22, 0x2e, 0x0a, 0x10, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f,
-	0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x0c, 0x0a, 0x01, 0x64, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x0c, 0x52, 0x01, 0x64, 0x12, 0x0c, 0x0a, 0x01, 0x65, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x01, 0x65, 0x22, 0x41, 0x0a, 0x0b, 0x4d, 0x73, 0x67, 0x57, 0x69, 0x74, 0x68,
-	0x64, 0x72, 0x61, 0x77, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72,
-	0x12, 0x16, 0x0a, 0x06, 0x61, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
-	0x52, 0x06, 0x61, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0x4d, 0x0a, 0x10, 0x4d, 0x73, 0x67, 0x42,
-	0x61, 0x74, 0x63, 0x68, 0x57, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x12, 0x39, 0x0a, 0x0e,
-	0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x5f, 0x62, 0x61, 0x74, 0x63, 0x68, 0x18, 0x01,
-	0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67,
-	0x57, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x52, 0x0d, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72,
-	0x61, 0x77, 0x42, 0x61, 0x74, 0x63, 0x68, 0x22, 0x5f, 0x0a, 0x0d, 0x42, 0x74, 0x63, 0x43, 0x68,
-	0x65, 0x63, 0x6b, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x65, 0x69, 0x67,
-	0x68, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74,
-	0x12, 0x19, 0x0a, 0x08, 0x6f, 0x75, 0x74, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x07, 0x6f, 0x75, 0x74, 0x48, 0x61, 0x73, 0x68, 0x12, 0x1b, 0x0a, 0x09, 0x6f,
-	0x75, 0x74, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08,
-	0x6f, 0x75, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x22, 0x48, 0x0a, 0x11, 0x4d, 0x73, 0x67, 0x55,
-	0x70, 0x64, 0x61, 0x74, 0x65, 0x41, 0x64, 0x61, 0x70, 0x74, 0x53, 0x69, 0x67, 0x12, 0x16, 0x0a,
-	0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73,
-	0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x61, 0x64, 0x61, 0x70, 0x74, 0x5f, 0x73,
-	0x69, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x61, 0x64, 0x61, 0x70, 0x74, 0x53,
-	0x69, 0x67, 0x42, 0x37, 0x5a, 0x35, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
-	0x2f, 0x6e, 0x67, 0x68, 0x75, 0x79, 0x65, 0x6e, 0x74, 0x68, 0x65, 0x76, 0x69, 0x6e, 0x68, 0x32,
-	0x30, 0x30, 0x30, 0x2f, 0x62, 0x69, 0x74, 0x63, 0x6f, 0x69, 0x6e, 0x2d, 0x70, 0x6c, 0x61, 0x79,
-	0x67, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x2f, 0x77, 0x73, 0x74, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x33,
+func (x *MsgUpdateAdaptSig) GetTxIndex() int64 {
+	if x != nil {
+		return x.TxIndex
+	}
+	return 0
 }
 
-var (
-	file_proto_wsts_msg_proto_rawDescOnce sync.Once
-	file_proto_wsts_msg_proto_rawDescData = file_proto_wsts_msg_proto_rawDesc
-)
+func (x *MsgUpdateAdaptSig) GetInputIndex() int64 {
+	if x != nil {
+		return x.InputIndex
+	}
+	return 0
+}
 
-func file_proto_wsts_msg_proto_rawDescGZIP() []byte {
-	file_proto_wsts_msg_proto_rawDescOnce.Do(func() {
-		file_proto_wsts_msg_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_wsts_msg_proto_rawDescData)
-	})
-	return file_proto_wsts_msg_proto_rawDescData
+func (x *MsgUpdateAdaptSig) GetSigningIndex() int64 {
+	if x != nil {
+		return x.SigningIndex
+	}
+	return 0
 }
 
-var file_proto_wsts_msg_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
-var file_proto_wsts_msg_proto_goTypes = []interface{}{
-	(*MsgUpdateVP)(nil),               // 0: proto.MsgUpdateVP
-	(*MsgUpdateProofs)(nil),           // 1: proto.MsgUpdateProofs
-	(*MsgSecretShares)(nil),           // 2: proto.MsgSecretShares
-	(*SecretShares)(nil),              // 3: proto.SecretShares
-	(*MsgUpdateNonceCommitments)(nil), // 4: proto.MsgUpdateNonceCommitments
-	(*NonceCommitments)(nil),          // 5: proto.NonceCommitments
-	(*MsgWithdraw)(nil),               // 6: proto.MsgWithdraw
-	(*MsgBatchWithdraw)(nil),          // 7: proto.MsgBatchWithdraw
-	(*BtcCheckPoint)(nil),             // 8: proto.BtcCheckPoint
-	(*MsgUpdateAdaptSig)(nil),         // 9: proto.MsgUpdateAdaptSig
+func (x *MsgUpdateAdaptSig) GetSigHash() []byte {
+	if x != nil {
+		return x.SigHash
+	}
+	return nil
 }
-var file_proto_wsts_msg_proto_depIdxs = []int32{
-	3, // 0: proto.MsgSecretShares.secret_shares:type_name -> proto.SecretShares
-	5, // 1: proto.MsgUpdateNonceCommitments.nonce_commitments:type_name -> proto.NonceCommitments
-	6, // 2: proto.MsgBatchWithdraw.withdraw_batch:type_name -> proto.MsgWithdraw
-	3, // [3:3] is the sub-list for method output_type
-	3, // [3:3] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+
+func (x *MsgUpdateAdaptSig) GetSigners() []int64 {
+	if x != nil {
+		return x.Signers
+	}
+	return nil
 }
 
-func init() { file_proto_wsts_msg_proto_init() }
-func file_proto_wsts_msg_proto_init() {
-	if File_proto_wsts_msg_proto != nil {
-		return
+func (x *MsgUpdateAdaptSig) GetReservesMessage() []byte {
+	if x != nil {
+		return x.ReservesMessage
 	}
-	if !protoimpl.UnsafeEnabled {
-		file_proto_wsts_msg_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MsgUpdateVP); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_proto_wsts_msg_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MsgUpdateProofs); i {
-			case 0:
+	return nil
+}
+
+type Deposit struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TxHash      string `protobuf:"bytes,1,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	OutIndex    uint32 `protobuf:"varint,2,opt,name=out_index,json=outIndex,proto3" json:"out_index,omitempty"`
+	Amount      int64  `protobuf:"varint,3,opt,name=amount,proto3" json:"amount,omitempty"`
+	BlockHeight int64  `protobuf:"varint,4,opt,name=block_height,json=blockHeight,proto3" json:"block_height,omitempty"`
+}
+
+func (x *Deposit) Reset() {
+	*x = Deposit{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Deposit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Deposit) ProtoMessage() {}
+
+func (x *Deposit) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Deposit.ProtoReflect.Descriptor instead.
+func (*Deposit) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Deposit) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *Deposit) GetOutIndex() uint32 {
+	if x != nil {
+		return x.OutIndex
+	}
+	return 0
+}
+
+func (x *Deposit) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *Deposit) GetBlockHeight() int64 {
+	if x != nil {
+		return x.BlockHeight
+	}
+	return 0
+}
+
+type MsgDepositAttest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Source   int64      `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
+	Deposits []*Deposit `protobuf:"bytes,2,rep,name=deposits,proto3" json:"deposits,omitempty"`
+}
+
+func (x *MsgDepositAttest) Reset() {
+	*x = MsgDepositAttest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MsgDepositAttest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MsgDepositAttest) ProtoMessage() {}
+
+func (x *MsgDepositAttest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MsgDepositAttest.ProtoReflect.Descriptor instead.
+func (*MsgDepositAttest) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *MsgDepositAttest) GetSource() int64 {
+	if x != nil {
+		return x.Source
+	}
+	return 0
+}
+
+func (x *MsgDepositAttest) GetDeposits() []*Deposit {
+	if x != nil {
+		return x.Deposits
+	}
+	return nil
+}
+
+// a lagging validator requests protocol state from its peers
+type MsgStateRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Source int64 `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
+}
+
+func (x *MsgStateRequest) Reset() {
+	*x = MsgStateRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MsgStateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MsgStateRequest) ProtoMessage() {}
+
+func (x *MsgStateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MsgStateRequest.ProtoReflect.Descriptor instead.
+func (*MsgStateRequest) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MsgStateRequest) GetSource() int64 {
+	if x != nil {
+		return x.Source
+	}
+	return 0
+}
+
+type MsgStateResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Source int64 `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
+	// on - chain envelopes kept by the responder, each one is verified against the validator key of its source
+	Envelopes [][]byte `protobuf:"bytes,2,rep,name=envelopes,proto3" json:"envelopes,omitempty"`
+	// protocol state that is applied once enough responders agree on its root
+	Snapshot *StateSnapshot `protobuf:"bytes,3,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+}
+
+func (x *MsgStateResponse) Reset() {
+	*x = MsgStateResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MsgStateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MsgStateResponse) ProtoMessage() {}
+
+func (x *MsgStateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MsgStateResponse.ProtoReflect.Descriptor instead.
+func (*MsgStateResponse) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *MsgStateResponse) GetSource() int64 {
+	if x != nil {
+		return x.Source
+	}
+	return 0
+}
+
+func (x *MsgStateResponse) GetEnvelopes() [][]byte {
+	if x != nil {
+		return x.Envelopes
+	}
+	return nil
+}
+
+func (x *MsgStateResponse) GetSnapshot() *StateSnapshot {
+	if x != nil {
+		return x.Snapshot
+	}
+	return nil
+}
+
+type StateSnapshot struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CheckpointHeight int64 `protobuf:"varint,1,opt,name=checkpoint_height,json=checkpointHeight,proto3" json:"checkpoint_height,omitempty"`
+	NextSigningIndex int64 `protobuf:"varint,2,opt,name=next_signing_index,json=nextSigningIndex,proto3" json:"next_signing_index,omitempty"`
+	// sorted by store, then by key
+	Entries []*StateEntry `protobuf:"bytes,3,rep,name=entries,proto3" json:"entries,omitempty"`
+}
+
+func (x *StateSnapshot) Reset() {
+	*x = StateSnapshot{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StateSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateSnapshot) ProtoMessage() {}
+
+func (x *StateSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateSnapshot.ProtoReflect.Descriptor instead.
+func (*StateSnapshot) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *StateSnapshot) GetCheckpointHeight() int64 {
+	if x != nil {
+		return x.CheckpointHeight
+	}
+	return 0
+}
+
+func (x *StateSnapshot) GetNextSigningIndex() int64 {
+	if x != nil {
+		return x.NextSigningIndex
+	}
+	return 0
+}
+
+func (x *StateSnapshot) GetEntries() []*StateEntry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+type StateEntry struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Store string `protobuf:"bytes,1,opt,name=store,proto3" json:"store,omitempty"`
+	Key   string `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *StateEntry) Reset() {
+	*x = StateEntry{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StateEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateEntry) ProtoMessage() {}
+
+func (x *StateEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateEntry.ProtoReflect.Descriptor instead.
+func (*StateEntry) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *StateEntry) GetStore() string {
+	if x != nil {
+		return x.Store
+	}
+	return ""
+}
+
+func (x *StateEntry) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *StateEntry) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+// on - chain messages of a validator carried in an ABCI vote extension
+type VoteExtension struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Height int64 `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"`
+	// envelopes sealed by the validator that extends the vote
+	Envelopes [][]byte `protobuf:"bytes,2,rep,name=envelopes,proto3" json:"envelopes,omitempty"`
+}
+
+func (x *VoteExtension) Reset() {
+	*x = VoteExtension{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VoteExtension) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VoteExtension) ProtoMessage() {}
+
+func (x *VoteExtension) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VoteExtension.ProtoReflect.Descriptor instead.
+func (*VoteExtension) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *VoteExtension) GetHeight() int64 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *VoteExtension) GetEnvelopes() [][]byte {
+	if x != nil {
+		return x.Envelopes
+	}
+	return nil
+}
+
+// genesis state of the wsts cosmos module
+type GenesisState struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	NKeys     int64 `protobuf:"varint,1,opt,name=n_keys,json=nKeys,proto3" json:"n_keys,omitempty"`
+	Threshold int64 `protobuf:"varint,2,opt,name=threshold,proto3" json:"threshold,omitempty"`
+}
+
+func (x *GenesisState) Reset() {
+	*x = GenesisState{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GenesisState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenesisState) ProtoMessage() {}
+
+func (x *GenesisState) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenesisState.ProtoReflect.Descriptor instead.
+func (*GenesisState) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GenesisState) GetNKeys() int64 {
+	if x != nil {
+		return x.NKeys
+	}
+	return 0
+}
+
+func (x *GenesisState) GetThreshold() int64 {
+	if x != nil {
+		return x.Threshold
+	}
+	return 0
+}
+
+// validator of the wsts cosmos module, positions follow the order of the validator set at genesis
+type ModuleValidator struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Position int64  `protobuf:"varint,1,opt,name=position,proto3" json:"position,omitempty"`
+	PubKey   []byte `protobuf:"bytes,2,opt,name=pub_key,json=pubKey,proto3" json:"pub_key,omitempty"`
+	Power    int64  `protobuf:"varint,3,opt,name=power,proto3" json:"power,omitempty"`
+}
+
+func (x *ModuleValidator) Reset() {
+	*x = ModuleValidator{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ModuleValidator) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModuleValidator) ProtoMessage() {}
+
+func (x *ModuleValidator) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModuleValidator.ProtoReflect.Descriptor instead.
+func (*ModuleValidator) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ModuleValidator) GetPosition() int64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+func (x *ModuleValidator) GetPubKey() []byte {
+	if x != nil {
+		return x.PubKey
+	}
+	return nil
+}
+
+func (x *ModuleValidator) GetPower() int64 {
+	if x != nil {
+		return x.Power
+	}
+	return 0
+}
+
+// self - contained proof of validator misbehaviour, verifiable with the public protocol state
+type Evidence struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Accused  int64 `protobuf:"varint,1,opt,name=accused,proto3" json:"accused,omitempty"`
+	Reporter int64 `protobuf:"varint,2,opt,name=reporter,proto3" json:"reporter,omitempty"`
+	// Types that are assignable to Kind:
+	//	*Evidence_InvalidAdaptSig
+	//	*Evidence_InvalidSecretShare
+	Kind isEvidence_Kind `protobuf_oneof:"kind"`
+}
+
+func (x *Evidence) Reset() {
+	*x = Evidence{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[20]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Evidence) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Evidence) ProtoMessage() {}
+
+func (x *Evidence) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[20]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Evidence.ProtoReflect.Descriptor instead.
+func (*Evidence) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *Evidence) GetAccused() int64 {
+	if x != nil {
+		return x.Accused
+	}
+	return 0
+}
+
+func (x *Evidence) GetReporter() int64 {
+	if x != nil {
+		return x.Reporter
+	}
+	return 0
+}
+
+func (m *Evidence) GetKind() isEvidence_Kind {
+	if m != nil {
+		return m.Kind
+	}
+	return nil
+}
+
+func (x *Evidence) GetInvalidAdaptSig() *InvalidAdaptSigEvidence {
+	if x, ok := x.GetKind().(*Evidence_InvalidAdaptSig); ok {
+		return x.InvalidAdaptSig
+	}
+	return nil
+}
+
+func (x *Evidence) GetInvalidSecretShare() *InvalidSecretShareEvidence {
+	if x, ok := x.GetKind().(*Evidence_InvalidSecretShare); ok {
+		return x.InvalidSecretShare
+	}
+	return nil
+}
+
+type isEvidence_Kind interface {
+	isEvidence_Kind()
+}
+
+type Evidence_InvalidAdaptSig struct {
+	InvalidAdaptSig *InvalidAdaptSigEvidence `protobuf:"bytes,3,opt,name=invalid_adapt_sig,json=invalidAdaptSig,proto3,oneof"`
+}
+
+type Evidence_InvalidSecretShare struct {
+	InvalidSecretShare *InvalidSecretShareEvidence `protobuf:"bytes,4,opt,name=invalid_secret_share,json=invalidSecretShare,proto3,oneof"`
+}
+
+func (*Evidence_InvalidAdaptSig) isEvidence_Kind() {}
+
+func (*Evidence_InvalidSecretShare) isEvidence_Kind() {}
+
+// adapt sig that does not verify over the message, nonces and public signing shares it is computed with
+type InvalidAdaptSigEvidence struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// adapt sig envelope sealed by the accused
+	AdaptSigEnvelope []byte `protobuf:"bytes,1,opt,name=adapt_sig_envelope,json=adaptSigEnvelope,proto3" json:"adapt_sig_envelope,omitempty"`
+	// nonce commitments of each signer at the signing index, in signer order
+	NonceCommitments []*NonceCommitments `protobuf:"bytes,2,rep,name=nonce_commitments,json=nonceCommitments,proto3" json:"nonce_commitments,omitempty"`
+	// public signing shares of the keys of the accused
+	PublicSigningShares []*PublicSigningShare `protobuf:"bytes,3,rep,name=public_signing_shares,json=publicSigningShares,proto3" json:"public_signing_shares,omitempty"`
+	GroupPublicKey      []byte                `protobuf:"bytes,4,opt,name=group_public_key,json=groupPublicKey,proto3" json:"group_public_key,omitempty"`
+}
+
+func (x *InvalidAdaptSigEvidence) Reset() {
+	*x = InvalidAdaptSigEvidence{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[21]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InvalidAdaptSigEvidence) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidAdaptSigEvidence) ProtoMessage() {}
+
+func (x *InvalidAdaptSigEvidence) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[21]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidAdaptSigEvidence.ProtoReflect.Descriptor instead.
+func (*InvalidAdaptSigEvidence) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *InvalidAdaptSigEvidence) GetAdaptSigEnvelope() []byte {
+	if x != nil {
+		return x.AdaptSigEnvelope
+	}
+	return nil
+}
+
+func (x *InvalidAdaptSigEvidence) GetNonceCommitments() []*NonceCommitments {
+	if x != nil {
+		return x.NonceCommitments
+	}
+	return nil
+}
+
+func (x *InvalidAdaptSigEvidence) GetPublicSigningShares() []*PublicSigningShare {
+	if x != nil {
+		return x.PublicSigningShares
+	}
+	return nil
+}
+
+func (x *InvalidAdaptSigEvidence) GetGroupPublicKey() []byte {
+	if x != nil {
+		return x.GroupPublicKey
+	}
+	return nil
+}
+
+type PublicSigningShare struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key   int64  `protobuf:"varint,1,opt,name=key,proto3" json:"key,omitempty"`
+	Share []byte `protobuf:"bytes,2,opt,name=share,proto3" json:"share,omitempty"`
+}
+
+func (x *PublicSigningShare) Reset() {
+	*x = PublicSigningShare{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[22]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PublicSigningShare) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublicSigningShare) ProtoMessage() {}
+
+func (x *PublicSigningShare) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[22]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublicSigningShare.ProtoReflect.Descriptor instead.
+func (*PublicSigningShare) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *PublicSigningShare) GetKey() int64 {
+	if x != nil {
+		return x.Key
+	}
+	return 0
+}
+
+func (x *PublicSigningShare) GetShare() []byte {
+	if x != nil {
+		return x.Share
+	}
+	return nil
+}
+
+// secret share that does not match the polynomial commitments of its dealer
+type InvalidSecretShareEvidence struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// secret shares envelope sealed by the dealer
+	SecretSharesEnvelope []byte `protobuf:"bytes,1,opt,name=secret_shares_envelope,json=secretSharesEnvelope,proto3" json:"secret_shares_envelope,omitempty"`
+	// polynomial commitments envelope sealed by the dealer
+	ProofsEnvelope []byte `protobuf:"bytes,2,opt,name=proofs_envelope,json=proofsEnvelope,proto3" json:"proofs_envelope,omitempty"`
+	Key            int64  `protobuf:"varint,3,opt,name=key,proto3" json:"key,omitempty"`
+}
+
+func (x *InvalidSecretShareEvidence) Reset() {
+	*x = InvalidSecretShareEvidence{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[23]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InvalidSecretShareEvidence) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidSecretShareEvidence) ProtoMessage() {}
+
+func (x *InvalidSecretShareEvidence) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[23]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidSecretShareEvidence.ProtoReflect.Descriptor instead.
+func (*InvalidSecretShareEvidence) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *InvalidSecretShareEvidence) GetSecretSharesEnvelope() []byte {
+	if x != nil {
+		return x.SecretSharesEnvelope
+	}
+	return nil
+}
+
+func (x *InvalidSecretShareEvidence) GetProofsEnvelope() []byte {
+	if x != nil {
+		return x.ProofsEnvelope
+	}
+	return nil
+}
+
+func (x *InvalidSecretShareEvidence) GetKey() int64 {
+	if x != nil {
+		return x.Key
+	}
+	return 0
+}
+
+// a validator votes to pause or resume withdrawals in an emergency
+type MsgPauseVote struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Source int64 `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
+	Pause  bool  `protobuf:"varint,2,opt,name=pause,proto3" json:"pause,omitempty"`
+}
+
+func (x *MsgPauseVote) Reset() {
+	*x = MsgPauseVote{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[24]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MsgPauseVote) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MsgPauseVote) ProtoMessage() {}
+
+func (x *MsgPauseVote) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[24]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MsgPauseVote.ProtoReflect.Descriptor instead.
+func (*MsgPauseVote) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *MsgPauseVote) GetSource() int64 {
+	if x != nil {
+		return x.Source
+	}
+	return 0
+}
+
+func (x *MsgPauseVote) GetPause() bool {
+	if x != nil {
+		return x.Pause
+	}
+	return false
+}
+
+// withdrawal rejected by the withdrawal policy, at its index in the withdraw batch of sequence
+type WithdrawRejection struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Sequence int64        `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Index    int64        `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	Withdraw *MsgWithdraw `protobuf:"bytes,3,opt,name=withdraw,proto3" json:"withdraw,omitempty"`
+	Reason   string       `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (x *WithdrawRejection) Reset() {
+	*x = WithdrawRejection{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_wsts_msg_proto_msgTypes[25]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WithdrawRejection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WithdrawRejection) ProtoMessage() {}
+
+func (x *WithdrawRejection) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_wsts_msg_proto_msgTypes[25]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WithdrawRejection.ProtoReflect.Descriptor instead.
+func (*WithdrawRejection) Descriptor() ([]byte, []int) {
+	return file_proto_wsts_msg_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *WithdrawRejection) GetSequence() int64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *WithdrawRejection) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *WithdrawRejection) GetWithdraw() *MsgWithdraw {
+	if x != nil {
+		return x.Withdraw
+	}
+	return nil
+}
+
+func (x *WithdrawRejection) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_proto_wsts_msg_proto protoreflect.FileDescriptor
+
+var file_proto_wsts_msg_proto_rawDesc = []byte{
+	0x0a, 0x14, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x77, 0x73, 0x74, 0x73, 0x5f, 0x6d, 0x73, 0x67,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xa7, 0x06,
+	0x0a, 0x08, 0x45, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x14, 0x0a, 0x05,
+	0x65, 0x70, 0x6f, 0x63, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x65, 0x70, 0x6f,
+	0x63, 0x68, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x31,
+	0x0a, 0x09, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x5f, 0x76, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64,
+	0x61, 0x74, 0x65, 0x56, 0x50, 0x48, 0x00, 0x52, 0x08, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x56,
+	0x70, 0x12, 0x3d, 0x0a, 0x0d, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x5f, 0x70, 0x72, 0x6f, 0x6f,
+	0x66, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x2e, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x73,
+	0x48, 0x00, 0x52, 0x0c, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x73,
+	0x12, 0x3d, 0x0a, 0x0d, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x65,
+	0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e,
+	0x4d, 0x73, 0x67, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x48,
+	0x00, 0x52, 0x0c, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x12,
+	0x5c, 0x0a, 0x18, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x5f, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x5f,
+	0x63, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x20, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64,
+	0x61, 0x74, 0x65, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65,
+	0x6e, 0x74, 0x73, 0x48, 0x00, 0x52, 0x16, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x6e,
+	0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x40, 0x0a,
+	0x0e, 0x62, 0x61, 0x74, 0x63, 0x68, 0x5f, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x18,
+	0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73,
+	0x67, 0x42, 0x61, 0x74, 0x63, 0x68, 0x57, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x48, 0x00,
+	0x52, 0x0d, 0x62, 0x61, 0x74, 0x63, 0x68, 0x57, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x12,
+	0x44, 0x0a, 0x10, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x5f, 0x61, 0x64, 0x61, 0x70, 0x74, 0x5f,
+	0x73, 0x69, 0x67, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x41, 0x64, 0x61, 0x70, 0x74,
+	0x53, 0x69, 0x67, 0x48, 0x00, 0x52, 0x0e, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x41, 0x64, 0x61,
+	0x70, 0x74, 0x53, 0x69, 0x67, 0x12, 0x40, 0x0a, 0x0e, 0x64, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74,
+	0x5f, 0x61, 0x74, 0x74, 0x65, 0x73, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x44, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74,
+	0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x48, 0x00, 0x52, 0x0d, 0x64, 0x65, 0x70, 0x6f, 0x73, 0x69,
+	0x74, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x12, 0x3d, 0x0a, 0x0d, 0x73, 0x74, 0x61, 0x74, 0x65,
+	0x5f, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x48, 0x00, 0x52, 0x0c, 0x73, 0x74, 0x61, 0x74, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x40, 0x0a, 0x0e, 0x73, 0x74, 0x61, 0x74, 0x65, 0x5f,
+	0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x48, 0x00, 0x52, 0x0d, 0x73, 0x74, 0x61, 0x74, 0x65,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x34, 0x0a, 0x0a, 0x70, 0x61, 0x75, 0x73,
+	0x65, 0x5f, 0x76, 0x6f, 0x74, 0x65, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x50, 0x61, 0x75, 0x73, 0x65, 0x56, 0x6f, 0x74,
+	0x65, 0x48, 0x00, 0x52, 0x09, 0x70, 0x61, 0x75, 0x73, 0x65, 0x56, 0x6f, 0x74, 0x65, 0x12, 0x1c,
+	0x0a, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x42, 0x09, 0x0a, 0x07,
+	0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x22, 0x35, 0x0a, 0x0b, 0x4d, 0x73, 0x67, 0x55, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x56, 0x50, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x0e,
+	0x0a, 0x02, 0x76, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x76, 0x70, 0x22, 0x85,
+	0x01, 0x0a, 0x0f, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x6f,
+	0x66, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x73, 0x65,
+	0x63, 0x72, 0x65, 0x74, 0x5f, 0x70, 0x72, 0x6f, 0x6f, 0x66, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x0c, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x73, 0x12,
+	0x35, 0x0a, 0x16, 0x70, 0x6f, 0x6c, 0x79, 0x6e, 0x6f, 0x6d, 0x69, 0x61, 0x6c, 0x5f, 0x63, 0x6f,
+	0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0c, 0x52,
+	0x15, 0x70, 0x6f, 0x6c, 0x79, 0x6e, 0x6f, 0x6d, 0x69, 0x61, 0x6c, 0x43, 0x6f, 0x6d, 0x6d, 0x69,
+	0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x22, 0x63, 0x0a, 0x0f, 0x4d, 0x73, 0x67, 0x53, 0x65, 0x63,
+	0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75,
+	0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63,
+	0x65, 0x12, 0x38, 0x0a, 0x0d, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x73, 0x68, 0x61, 0x72,
+	0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x2e, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x52, 0x0c, 0x73,
+	0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x22, 0x47, 0x0a, 0x0c, 0x53,
+	0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x70,
+	0x6f, 0x73, 0x69, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x70, 0x6f, 0x73, 0x69, 0x12,
+	0x23, 0x0a, 0x0d, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x65, 0x73,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68,
+	0x61, 0x72, 0x65, 0x73, 0x22, 0x9a, 0x01, 0x0a, 0x19, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64, 0x61,
+	0x74, 0x65, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e,
+	0x74, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x44, 0x0a, 0x11, 0x6e, 0x6f,
+	0x6e, 0x63, 0x65, 0x5f, 0x63, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x6f,
+	0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x52, 0x10,
+	0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73,
+	0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x74, 0x61, 0x72, 0x74, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x73, 0x74, 0x61, 0x72, 0x74, 0x49, 0x6e, 0x64, 0x65,
+	0x78, 0x22, 0x2e, 0x0a, 0x10, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74,
+	0x6d, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x0c, 0x0a, 0x01, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x01, 0x64, 0x12, 0x0c, 0x0a, 0x01, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x01,
+	0x65, 0x22, 0x41, 0x0a, 0x0b, 0x4d, 0x73, 0x67, 0x57, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77,
+	0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72, 0x12, 0x16, 0x0a, 0x06,
+	0x61, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x61, 0x6d,
+	0x6f, 0x75, 0x6e, 0x74, 0x22, 0x69, 0x0a, 0x10, 0x4d, 0x73, 0x67, 0x42, 0x61, 0x74, 0x63, 0x68,
+	0x57, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x12, 0x39, 0x0a, 0x0e, 0x77, 0x69, 0x74, 0x68,
+	0x64, 0x72, 0x61, 0x77, 0x5f, 0x62, 0x61, 0x74, 0x63, 0x68, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x57, 0x69, 0x74, 0x68,
+	0x64, 0x72, 0x61, 0x77, 0x52, 0x0d, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x42, 0x61,
+	0x74, 0x63, 0x68, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x22,
+	0x5f, 0x0a, 0x0d, 0x42, 0x74, 0x63, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x50, 0x6f, 0x69, 0x6e, 0x74,
+	0x12, 0x16, 0x0a, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x6f, 0x75, 0x74, 0x5f,
+	0x68, 0x61, 0x73, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6f, 0x75, 0x74, 0x48,
+	0x61, 0x73, 0x68, 0x12, 0x1b, 0x0a, 0x09, 0x6f, 0x75, 0x74, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x6f, 0x75, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78,
+	0x22, 0xb6, 0x02, 0x0a, 0x11, 0x4d, 0x73, 0x67, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x41, 0x64,
+	0x61, 0x70, 0x74, 0x53, 0x69, 0x67, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x1b,
+	0x0a, 0x09, 0x61, 0x64, 0x61, 0x70, 0x74, 0x5f, 0x73, 0x69, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x08, 0x61, 0x64, 0x61, 0x70, 0x74, 0x53, 0x69, 0x67, 0x12, 0x2b, 0x0a, 0x11, 0x63,
+	0x68, 0x65, 0x63, 0x6b, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x5f, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x70, 0x6f, 0x69,
+	0x6e, 0x74, 0x48, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x74, 0x78, 0x5f, 0x69,
+	0x6e, 0x64, 0x65, 0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x74, 0x78, 0x49, 0x6e,
+	0x64, 0x65, 0x78, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x5f, 0x69, 0x6e, 0x64,
+	0x65, 0x78, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x49,
+	0x6e, 0x64, 0x65, 0x78, 0x12, 0x23, 0x0a, 0x0d, 0x73, 0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x5f,
+	0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x73, 0x69, 0x67,
+	0x6e, 0x69, 0x6e, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x19, 0x0a, 0x08, 0x73, 0x69, 0x67,
+	0x5f, 0x68, 0x61, 0x73, 0x68, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x73, 0x69, 0x67,
+	0x48, 0x61, 0x73, 0x68, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x72, 0x73, 0x18,
+	0x08, 0x20, 0x03, 0x28, 0x03, 0x52, 0x07, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x72, 0x73, 0x12, 0x29,
+	0x0a, 0x10, 0x72, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x73, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0f, 0x72, 0x65, 0x73, 0x65, 0x72, 0x76,
+	0x65, 0x73, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x7a, 0x0a, 0x07, 0x44, 0x65, 0x70,
+	0x6f, 0x73, 0x69, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x74, 0x78, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x78, 0x48, 0x61, 0x73, 0x68, 0x12, 0x1b, 0x0a,
+	0x09, 0x6f, 0x75, 0x74, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x08, 0x6f, 0x75, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x16, 0x0a, 0x06, 0x61, 0x6d,
+	0x6f, 0x75, 0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x61, 0x6d, 0x6f, 0x75,
+	0x6e, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x68, 0x65, 0x69, 0x67,
+	0x68, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x48,
+	0x65, 0x69, 0x67, 0x68, 0x74, 0x22, 0x56, 0x0a, 0x10, 0x4d, 0x73, 0x67, 0x44, 0x65, 0x70, 0x6f,
+	0x73, 0x69, 0x74, 0x41, 0x74, 0x74, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75,
+	0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63,
+	0x65, 0x12, 0x2a, 0x0a, 0x08, 0x64, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x44, 0x65, 0x70, 0x6f,
+	0x73, 0x69, 0x74, 0x52, 0x08, 0x64, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x73, 0x22, 0x29, 0x0a,
+	0x0f, 0x4d, 0x73, 0x67, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x22, 0x7a, 0x0a, 0x10, 0x4d, 0x73, 0x67, 0x53,
+	0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06,
+	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f,
+	0x75, 0x72, 0x63, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x65, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x09, 0x65, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70,
+	0x65, 0x73, 0x12, 0x30, 0x0a, 0x08, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x74, 0x61,
+	0x74, 0x65, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x52, 0x08, 0x73, 0x6e, 0x61, 0x70,
+	0x73, 0x68, 0x6f, 0x74, 0x22, 0x97, 0x01, 0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x53, 0x6e,
+	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x2b, 0x0a, 0x11, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x70,
+	0x6f, 0x69, 0x6e, 0x74, 0x5f, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x10, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x48, 0x65, 0x69,
+	0x67, 0x68, 0x74, 0x12, 0x2c, 0x0a, 0x12, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x73, 0x69, 0x67, 0x6e,
+	0x69, 0x6e, 0x67, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x10, 0x6e, 0x65, 0x78, 0x74, 0x53, 0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x49, 0x6e, 0x64, 0x65,
+	0x78, 0x12, 0x2b, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x22, 0x4a,
+	0x0a, 0x0a, 0x53, 0x74, 0x61, 0x74, 0x65, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x14, 0x0a, 0x05,
+	0x73, 0x74, 0x6f, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x73, 0x74, 0x6f,
+	0x72, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x45, 0x0a, 0x0d, 0x56, 0x6f,
+	0x74, 0x65, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x68,
+	0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x68, 0x65, 0x69,
+	0x67, 0x68, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x65, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65, 0x73,
+	0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x09, 0x65, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65,
+	0x73, 0x22, 0x43, 0x0a, 0x0c, 0x47, 0x65, 0x6e, 0x65, 0x73, 0x69, 0x73, 0x53, 0x74, 0x61, 0x74,
+	0x65, 0x12, 0x15, 0x0a, 0x06, 0x6e, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x05, 0x6e, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x68, 0x72, 0x65,
+	0x73, 0x68, 0x6f, 0x6c, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x68, 0x72,
+	0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x22, 0x5c, 0x0a, 0x0f, 0x4d, 0x6f, 0x64, 0x75, 0x6c, 0x65,
+	0x56, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x6f, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x6f, 0x73,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x70, 0x6f, 0x73,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x75, 0x62, 0x5f, 0x6b, 0x65, 0x79,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x75, 0x62, 0x4b, 0x65, 0x79, 0x12, 0x14,
+	0x0a, 0x05, 0x70, 0x6f, 0x77, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x70,
+	0x6f, 0x77, 0x65, 0x72, 0x22, 0xed, 0x01, 0x0a, 0x08, 0x45, 0x76, 0x69, 0x64, 0x65, 0x6e, 0x63,
+	0x65, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x63, 0x63, 0x75, 0x73, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x07, 0x61, 0x63, 0x63, 0x75, 0x73, 0x65, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x72,
+	0x65, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x72,
+	0x65, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72, 0x12, 0x4c, 0x0a, 0x11, 0x69, 0x6e, 0x76, 0x61, 0x6c,
+	0x69, 0x64, 0x5f, 0x61, 0x64, 0x61, 0x70, 0x74, 0x5f, 0x73, 0x69, 0x67, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x49, 0x6e, 0x76, 0x61, 0x6c,
+	0x69, 0x64, 0x41, 0x64, 0x61, 0x70, 0x74, 0x53, 0x69, 0x67, 0x45, 0x76, 0x69, 0x64, 0x65, 0x6e,
+	0x63, 0x65, 0x48, 0x00, 0x52, 0x0f, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x41, 0x64, 0x61,
+	0x70, 0x74, 0x53, 0x69, 0x67, 0x12, 0x55, 0x0a, 0x14, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64,
+	0x5f, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x49, 0x6e, 0x76, 0x61,
+	0x6c, 0x69, 0x64, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x45, 0x76,
+	0x69, 0x64, 0x65, 0x6e, 0x63, 0x65, 0x48, 0x00, 0x52, 0x12, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69,
+	0x64, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x42, 0x06, 0x0a, 0x04,
+	0x6b, 0x69, 0x6e, 0x64, 0x22, 0x86, 0x02, 0x0a, 0x17, 0x49, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64,
+	0x41, 0x64, 0x61, 0x70, 0x74, 0x53, 0x69, 0x67, 0x45, 0x76, 0x69, 0x64, 0x65, 0x6e, 0x63, 0x65,
+	0x12, 0x2c, 0x0a, 0x12, 0x61, 0x64, 0x61, 0x70, 0x74, 0x5f, 0x73, 0x69, 0x67, 0x5f, 0x65, 0x6e,
+	0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x10, 0x61, 0x64,
+	0x61, 0x70, 0x74, 0x53, 0x69, 0x67, 0x45, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65, 0x12, 0x44,
+	0x0a, 0x11, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x5f, 0x63, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65,
+	0x6e, 0x74, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2e, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d, 0x65, 0x6e,
+	0x74, 0x73, 0x52, 0x10, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x6d,
+	0x65, 0x6e, 0x74, 0x73, 0x12, 0x4d, 0x0a, 0x15, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x5f, 0x73,
+	0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x65, 0x73, 0x18, 0x03, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x75, 0x62, 0x6c,
+	0x69, 0x63, 0x53, 0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x53, 0x68, 0x61, 0x72, 0x65, 0x52, 0x13,
+	0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x53, 0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x53, 0x68, 0x61,
+	0x72, 0x65, 0x73, 0x12, 0x28, 0x0a, 0x10, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x5f, 0x70, 0x75, 0x62,
+	0x6c, 0x69, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0e, 0x67,
+	0x72, 0x6f, 0x75, 0x70, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79, 0x22, 0x3c, 0x0a,
+	0x12, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x53, 0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x53, 0x68,
+	0x61, 0x72, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x68, 0x61, 0x72, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x73, 0x68, 0x61, 0x72, 0x65, 0x22, 0x8d, 0x01, 0x0a, 0x1a,
+	0x49, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x53, 0x68, 0x61,
+	0x72, 0x65, 0x45, 0x76, 0x69, 0x64, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x34, 0x0a, 0x16, 0x73, 0x65,
+	0x63, 0x72, 0x65, 0x74, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x65, 0x73, 0x5f, 0x65, 0x6e, 0x76, 0x65,
+	0x6c, 0x6f, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x14, 0x73, 0x65, 0x63, 0x72,
+	0x65, 0x74, 0x53, 0x68, 0x61, 0x72, 0x65, 0x73, 0x45, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65,
+	0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x6f, 0x66, 0x73, 0x5f, 0x65, 0x6e, 0x76, 0x65, 0x6c,
+	0x6f, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0e, 0x70, 0x72, 0x6f, 0x6f, 0x66,
+	0x73, 0x45, 0x6e, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x3c, 0x0a, 0x0c, 0x4d,
+	0x73, 0x67, 0x50, 0x61, 0x75, 0x73, 0x65, 0x56, 0x6f, 0x74, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73,
+	0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x73, 0x6f, 0x75,
+	0x72, 0x63, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x61, 0x75, 0x73, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x05, 0x70, 0x61, 0x75, 0x73, 0x65, 0x22, 0x8d, 0x01, 0x0a, 0x11, 0x57, 0x69,
+	0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x69,
+	0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65,
+	0x78, 0x12, 0x2e, 0x0a, 0x08, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x73, 0x67, 0x57,
+	0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x52, 0x08, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61,
+	0x77, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x42, 0x37, 0x5a, 0x35, 0x67, 0x69, 0x74,
+	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x67, 0x68, 0x75, 0x79, 0x65, 0x6e, 0x74,
+	0x68, 0x65, 0x76, 0x69, 0x6e, 0x68, 0x32, 0x30, 0x30, 0x30, 0x2f, 0x62, 0x69, 0x74, 0x63, 0x6f,
+	0x69, 0x6e, 0x2d, 0x70, 0x6c, 0x61, 0x79, 0x67, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x2f, 0x77, 0x73,
+	0x74, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_proto_wsts_msg_proto_rawDescOnce sync.Once
+	file_proto_wsts_msg_proto_rawDescData = file_proto_wsts_msg_proto_rawDesc
+)
+
+func file_proto_wsts_msg_proto_rawDescGZIP() []byte {
+	file_proto_wsts_msg_proto_rawDescOnce.Do(func() {
+		file_proto_wsts_msg_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_wsts_msg_proto_rawDescData)
+	})
+	return file_proto_wsts_msg_proto_rawDescData
+}
+
+var file_proto_wsts_msg_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_proto_wsts_msg_proto_goTypes = []interface{}{
+	(*Envelope)(nil),                   // 0: proto.Envelope
+	(*MsgUpdateVP)(nil),                // 1: proto.MsgUpdateVP
+	(*MsgUpdateProofs)(nil),            // 2: proto.MsgUpdateProofs
+	(*MsgSecretShares)(nil),            // 3: proto.MsgSecretShares
+	(*SecretShares)(nil),               // 4: proto.SecretShares
+	(*MsgUpdateNonceCommitments)(nil),  // 5: proto.MsgUpdateNonceCommitments
+	(*NonceCommitments)(nil),           // 6: proto.NonceCommitments
+	(*MsgWithdraw)(nil),                // 7: proto.MsgWithdraw
+	(*MsgBatchWithdraw)(nil),           // 8: proto.MsgBatchWithdraw
+	(*BtcCheckPoint)(nil),              // 9: proto.BtcCheckPoint
+	(*MsgUpdateAdaptSig)(nil),          // 10: proto.MsgUpdateAdaptSig
+	(*Deposit)(nil),                    // 11: proto.Deposit
+	(*MsgDepositAttest)(nil),           // 12: proto.MsgDepositAttest
+	(*MsgStateRequest)(nil),            // 13: proto.MsgStateRequest
+	(*MsgStateResponse)(nil),           // 14: proto.MsgStateResponse
+	(*StateSnapshot)(nil),              // 15: proto.StateSnapshot
+	(*StateEntry)(nil),                 // 16: proto.StateEntry
+	(*VoteExtension)(nil),              // 17: proto.VoteExtension
+	(*GenesisState)(nil),               // 18: proto.GenesisState
+	(*ModuleValidator)(nil),            // 19: proto.ModuleValidator
+	(*Evidence)(nil),                   // 20: proto.Evidence
+	(*InvalidAdaptSigEvidence)(nil),    // 21: proto.InvalidAdaptSigEvidence
+	(*PublicSigningShare)(nil),         // 22: proto.PublicSigningShare
+	(*InvalidSecretShareEvidence)(nil), // 23: proto.InvalidSecretShareEvidence
+	(*MsgPauseVote)(nil),               // 24: proto.MsgPauseVote
+	(*WithdrawRejection)(nil),          // 25: proto.WithdrawRejection
+}
+var file_proto_wsts_msg_proto_depIdxs = []int32{
+	1,  // 0: proto.Envelope.update_vp:type_name -> proto.MsgUpdateVP
+	2,  // 1: proto.Envelope.update_proofs:type_name -> proto.MsgUpdateProofs
+	3,  // 2: proto.Envelope.secret_shares:type_name -> proto.MsgSecretShares
+	5,  // 3: proto.Envelope.update_nonce_commitments:type_name -> proto.MsgUpdateNonceCommitments
+	8,  // 4: proto.Envelope.batch_withdraw:type_name -> proto.MsgBatchWithdraw
+	10, // 5: proto.Envelope.update_adapt_sig:type_name -> proto.MsgUpdateAdaptSig
+	12, // 6: proto.Envelope.deposit_attest:type_name -> proto.MsgDepositAttest
+	13, // 7: proto.Envelope.state_request:type_name -> proto.MsgStateRequest
+	14, // 8: proto.Envelope.state_response:type_name -> proto.MsgStateResponse
+	24, // 9: proto.Envelope.pause_vote:type_name -> proto.MsgPauseVote
+	4,  // 10: proto.MsgSecretShares.secret_shares:type_name -> proto.SecretShares
+	6,  // 11: proto.MsgUpdateNonceCommitments.nonce_commitments:type_name -> proto.NonceCommitments
+	7,  // 12: proto.MsgBatchWithdraw.withdraw_batch:type_name -> proto.MsgWithdraw
+	11, // 13: proto.MsgDepositAttest.deposits:type_name -> proto.Deposit
+	15, // 14: proto.MsgStateResponse.snapshot:type_name -> proto.StateSnapshot
+	16, // 15: proto.StateSnapshot.entries:type_name -> proto.StateEntry
+	21, // 16: proto.Evidence.invalid_adapt_sig:type_name -> proto.InvalidAdaptSigEvidence
+	23, // 17: proto.Evidence.invalid_secret_share:type_name -> proto.InvalidSecretShareEvidence
+	6,  // 18: proto.InvalidAdaptSigEvidence.nonce_commitments:type_name -> proto.NonceCommitments
+	22, // 19: proto.InvalidAdaptSigEvidence.public_signing_shares:type_name -> proto.PublicSigningShare
+	7,  // 20: proto.WithdrawRejection.withdraw:type_name -> proto.MsgWithdraw
+	21, // [21:21] is the sub-list for method output_type
+	21, // [21:21] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
+}
+
+func init() { file_proto_wsts_msg_proto_init() }
+func file_proto_wsts_msg_proto_init() {
+	if File_proto_wsts_msg_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_proto_wsts_msg_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Envelope); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MsgUpdateVP); i {
+			case 0:
 				return &v.state
 			case 1:
 				return &v.sizeCache
@@ -710,7 +2198,7 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MsgSecretShares); i {
+			switch v := v.(*MsgUpdateProofs); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -722,7 +2210,7 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*SecretShares); i {
+			switch v := v.(*MsgSecretShares); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -734,7 +2222,7 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MsgUpdateNonceCommitments); i {
+			switch v := v.(*SecretShares); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -746,7 +2234,7 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*NonceCommitments); i {
+			switch v := v.(*MsgUpdateNonceCommitments); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -758,7 +2246,7 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MsgWithdraw); i {
+			switch v := v.(*NonceCommitments); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -770,7 +2258,7 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MsgBatchWithdraw); i {
+			switch v := v.(*MsgWithdraw); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -782,7 +2270,7 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*BtcCheckPoint); i {
+			switch v := v.(*MsgBatchWithdraw); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -794,6 +2282,18 @@ func file_proto_wsts_msg_proto_init() {
 			}
 		}
 		file_proto_wsts_msg_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BtcCheckPoint); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*MsgUpdateAdaptSig); i {
 			case 0:
 				return &v.state
@@ -805,6 +2305,202 @@ func file_proto_wsts_msg_proto_init() {
 				return nil
 			}
 		}
+		file_proto_wsts_msg_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Deposit); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MsgDepositAttest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MsgStateRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MsgStateResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StateSnapshot); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StateEntry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VoteExtension); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GenesisState); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ModuleValidator); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Evidence); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[21].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InvalidAdaptSigEvidence); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[22].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PublicSigningShare); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[23].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InvalidSecretShareEvidence); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[24].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MsgPauseVote); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_wsts_msg_proto_msgTypes[25].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WithdrawRejection); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_proto_wsts_msg_proto_msgTypes[0].OneofWrappers = []interface{}{
+		(*Envelope_UpdateVp)(nil),
+		(*Envelope_UpdateProofs)(nil),
+		(*Envelope_SecretShares)(nil),
+		(*Envelope_UpdateNonceCommitments)(nil),
+		(*Envelope_BatchWithdraw)(nil),
+		(*Envelope_UpdateAdaptSig)(nil),
+		(*Envelope_DepositAttest)(nil),
+		(*Envelope_StateRequest)(nil),
+		(*Envelope_StateResponse)(nil),
+		(*Envelope_PauseVote)(nil),
+	}
+	file_proto_wsts_msg_proto_msgTypes[20].OneofWrappers = []interface{}{
+		(*Evidence_InvalidAdaptSig)(nil),
+		(*Evidence_InvalidSecretShare)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -812,7 +2508,7 @@ func file_proto_wsts_msg_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_wsts_msg_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   10,
+			NumMessages:   26,
 			NumExtensions: 0,
 			NumServices:   0,
 		},